@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// orderParty возвращает роль пользователя в заказе: "client", "contractor" или ""
+func orderParty(order *Order, user *User) string {
+	if order.ClientID == user.ID {
+		return "client"
+	}
+	if order.ContractorID != nil && *order.ContractorID == user.ID {
+		return "contractor"
+	}
+	return ""
+}
+
+// isAdmin - пользователь указан в ADMIN_TELEGRAM_IDS (через запятую)
+func isAdmin(user *User) bool {
+	if user == nil {
+		return false
+	}
+	for _, id := range strings.Split(os.Getenv("ADMIN_TELEGRAM_IDS"), ",") {
+		if parsed, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64); err == nil && parsed == user.TelegramID {
+			return true
+		}
+	}
+	return false
+}
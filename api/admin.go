@@ -0,0 +1,781 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Админ-API (/api/admin/...) доступно по API-ключу в заголовке X-API-Key или
+// администратору из ADMIN_TELEGRAM_IDS с подписанными initData Telegram
+// (X-Telegram-Init-Data). Ключи хранятся только в виде SHA-256. Первый ключ
+// можно выпустить, войдя через Telegram, либо задать хеши ключей в
+// ADMIN_API_KEY_HASHES (через запятую).
+
+const (
+	adminDefaultPageSize = 50
+	adminMaxPageSize     = 200
+)
+
+type adminActorKey struct{}
+
+// adminActor - кто выполняет действие: "key:<имя ключа>" или "tg:<telegram id>"
+func adminActor(ctx context.Context) string {
+	actor, _ := ctx.Value(adminActorKey{}).(string)
+	return actor
+}
+
+type AdminAPIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	CreatedBy  string     `json:"created_by"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type AdminAuditEntry struct {
+	ID         int64     `json:"id"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type"`
+	TargetID   int64     `json:"target_id"`
+	Reason     *string   `json:"reason"`
+	Details    *string   `json:"details"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticateAPIKey возвращает имя ключа или "", если ключ не подошел
+func (app *App) authenticateAPIKey(key string) (string, error) {
+	hash := hashAPIKey(key)
+	for _, allowed := range strings.Split(os.Getenv("ADMIN_API_KEY_HASHES"), ",") {
+		if allowed = strings.TrimSpace(strings.ToLower(allowed)); allowed != "" && subtle.ConstantTimeCompare([]byte(allowed), []byte(hash)) == 1 {
+			return "env", nil
+		}
+	}
+	var id int64
+	var name string
+	err := app.db.QueryRow(`SELECT id, name FROM admin_api_keys WHERE key_hash = ? AND revoked_at IS NULL`, hash).Scan(&id, &name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := app.db.Exec(`UPDATE admin_api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?`, id); err != nil {
+		log.Printf("Не удалось отметить использование ключа %d: %v", id, err)
+	}
+	return name, nil
+}
+
+// adminAuth - middleware подроутера /api/admin: API-ключ (X-API-Key) или
+// пользователь из ADMIN_TELEGRAM_IDS, подтвержденный подписью initData
+func (app *App) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var actor string
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			name, err := app.authenticateAPIKey(key)
+			if err != nil {
+				internalError(w, err)
+				return
+			}
+			if name == "" {
+				writeError(w, http.StatusUnauthorized, CodeInvalidAPIKey, "Неверный API-ключ")
+				return
+			}
+			actor = "key:" + name
+		} else if c := requestCaller(r); c.verified {
+			// Администратор из ADMIN_TELEGRAM_IDS - только с подписью Telegram,
+			// даже в режиме TELEGRAM_AUTH=insecure
+			if !isAdmin(c.user) {
+				writeError(w, http.StatusForbidden, CodeAdminRequired, "Недостаточно прав")
+				return
+			}
+			actor = fmt.Sprintf("tg:%d", c.telegramID)
+		} else {
+			writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Требуется API-ключ или подпись Telegram администратора")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminActorKey{}, actor)))
+	})
+}
+
+// audit записывает действие администратора. Ошибка записи не отменяет действие.
+func (app *App) audit(ctx context.Context, action, targetType string, targetID int64, reason *string, details interface{}) {
+	var detailsJSON *string
+	if details != nil {
+		data, _ := json.Marshal(details)
+		s := string(data)
+		detailsJSON = &s
+	}
+	if _, err := app.db.Exec(`INSERT INTO admin_audit_log (actor, action, target_type, target_id, reason, details) VALUES (?, ?, ?, ?, ?, ?)`,
+		adminActor(ctx), action, targetType, targetID, reason, detailsJSON); err != nil {
+		log.Printf("Не удалось записать действие %s в журнал: %v", action, err)
+	}
+}
+
+// pageParams - limit и offset из строки запроса
+func pageParams(r *http.Request) (int, int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = adminDefaultPageSize
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return min(limit, adminMaxPageSize), offset
+}
+
+// adminFilter собирает условие WHERE из необязательных фильтров
+type adminFilter struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (f *adminFilter) add(condition string, args ...interface{}) {
+	f.conditions = append(f.conditions, condition)
+	f.args = append(f.args, args...)
+}
+
+// addParam добавляет условие, если параметр запроса задан
+func (f *adminFilter) addParam(r *http.Request, param, condition string) {
+	if value := r.URL.Query().Get(param); value != "" {
+		f.add(condition, value)
+	}
+}
+
+func (f *adminFilter) where() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conditions, " AND ")
+}
+
+func userIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный user ID")
+		return 0, false
+	}
+	return userID, true
+}
+
+// handleAdminListUsers - поиск пользователей: q (имя, телефон или telegram id), role, region, status
+func (app *App) handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
+	var filter adminFilter
+	if q := r.URL.Query().Get("q"); q != "" {
+		filter.add("(name LIKE ? OR phone LIKE ? OR CAST(telegram_id AS TEXT) = ?)", "%"+q+"%", "%"+q+"%", q)
+	}
+	filter.addParam(r, "role", "id IN (SELECT user_id FROM user_roles WHERE role = ?)")
+	filter.addParam(r, "region", "region = ?")
+	filter.addParam(r, "status", "status = ?")
+	limit, offset := pageParams(r)
+	rows, err := app.db.Query("SELECT "+userColumns+" FROM users"+filter.where()+" ORDER BY id DESC LIMIT ? OFFSET ?", append(filter.args, limit, offset)...)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer rows.Close()
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		users = append(users, *user)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"users": users, "limit": limit, "offset": offset})
+}
+
+// handleAdminGetUser - пользователь с профилем бригадира и журналом действий над ним
+func (app *App) handleAdminGetUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	user, err := app.getUserByID(userID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if user == nil {
+		writeError(w, http.StatusNotFound, CodeUserNotFound, "Пользователь не найден")
+		return
+	}
+	profile, err := app.getContractorProfile(user.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	audit, err := app.getAuditLog("user", user.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": user, "contractor_profile": profile, "audit": audit})
+}
+
+// handleAdminUpdateUser - правка профиля пользователя и профиля бригадира
+func (app *App) handleAdminUpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name       *string `json:"name"`
+		Phone      *string `json:"phone"`
+		Role       *string `json:"role"`
+		Region     *string `json:"region"`
+		Reason     *string `json:"reason"`
+		Contractor *struct {
+			ExperienceYears *int     `json:"experience_years"`
+			Categories      []string `json:"categories"`
+			IsActive        *bool    `json:"is_active"`
+			Rating          *float64 `json:"rating"`
+		} `json:"contractor"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	// Все поля проверяются до первой записи, чтобы ошибка не оставила профиль измененным наполовину
+	if req.Role != nil && !validRole(*req.Role) {
+		writeError(w, http.StatusBadRequest, CodeInvalidRole, errInvalidRole.Error())
+		return
+	}
+	if c := req.Contractor; c != nil && c.Rating != nil && (*c.Rating < 0 || *c.Rating > 5) {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Рейтинг должен быть от 0 до 5")
+		return
+	}
+	if req.Region != nil {
+		if _, err := app.resolveRegion(req.Region); err != nil {
+			writeUserError(w, http.StatusBadRequest, CodeInvalidRegion, err)
+			return
+		}
+	}
+	user, err := app.getUserByID(userID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if user == nil {
+		writeError(w, http.StatusNotFound, CodeUserNotFound, "Пользователь не найден")
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		updates["name"] = req.Name
+	}
+	if req.Phone != nil {
+		updates["phone"] = req.Phone
+	}
+	if req.Region != nil {
+		updates["region"] = req.Region
+	}
+	if err := app.updateUser(user.TelegramID, updates); err != nil {
+		internalError(w, err)
+		return
+	}
+	// Администратор может сменить активную роль и при незавершенных заказах
+	if req.Role != nil {
+		if err := app.setActiveRole(user, *req.Role, true); err != nil {
+			roleError(w, err)
+			return
+		}
+	}
+
+	if c := req.Contractor; c != nil {
+		profile, err := app.getContractorProfile(user.ID)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		experience, isActive := c.ExperienceYears, true
+		categories := []string{}
+		if profile != nil {
+			if experience == nil {
+				experience = profile.ExperienceYears
+			}
+			isActive = profile.IsActive
+			json.Unmarshal([]byte(profile.Categories), &categories)
+		}
+		if c.Categories != nil {
+			categories = c.Categories
+		}
+		if c.IsActive != nil {
+			isActive = *c.IsActive
+		}
+		if err := app.createOrUpdateContractorProfile(user.ID, experience, categories, isActive); err != nil {
+			internalError(w, err)
+			return
+		}
+		if c.Rating != nil {
+			if _, err := app.db.Exec(`UPDATE contractor_profiles SET rating = ? WHERE user_id = ?`, *c.Rating, user.ID); err != nil {
+				internalError(w, err)
+				return
+			}
+		}
+	}
+	app.audit(r.Context(), "user.update", "user", user.ID, req.Reason, req)
+
+	if user, err = app.getUserByID(userID); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": user})
+}
+
+// handleAdminListOrders - поиск заказов: status, category, region, mode, client_id,
+// contractor_id, from/to (дата создания YYYY-MM-DD), q (адрес)
+func (app *App) handleAdminListOrders(w http.ResponseWriter, r *http.Request) {
+	var filter adminFilter
+	filter.addParam(r, "status", "o.status = ?")
+	filter.addParam(r, "category", "o.category = ?")
+	filter.addParam(r, "region", "o.region = ?")
+	filter.addParam(r, "mode", "o.mode = ?")
+	filter.addParam(r, "client_id", "o.client_id = ?")
+	filter.addParam(r, "contractor_id", "o.contractor_id = ?")
+	filter.addParam(r, "from", "o.created_at >= ?")
+	if to := r.URL.Query().Get("to"); to != "" {
+		filter.add("o.created_at < date(?, '+1 day')", to)
+	}
+	if q := r.URL.Query().Get("q"); q != "" {
+		filter.add("o.address LIKE ?", "%"+q+"%")
+	}
+	limit, offset := pageParams(r)
+	rows, err := app.db.Query(`SELECT o.id, o.client_id, o.contractor_id, o.category, o.area, o.address, o.status, o.mode, o.region, o.created_at, o.accepted_at, o.completed_at, uc.name, uct.name
+		FROM orders o LEFT JOIN users uc ON o.client_id = uc.id LEFT JOIN users uct ON o.contractor_id = uct.id`+filter.where()+` ORDER BY o.id DESC LIMIT ? OFFSET ?`,
+		append(filter.args, limit, offset)...)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer rows.Close()
+	orders := []Order{}
+	for rows.Next() {
+		var order Order
+		var createdAt, acceptedAt, completedAt sql.NullString
+		if err := rows.Scan(&order.ID, &order.ClientID, &order.ContractorID, &order.Category, &order.Area, &order.Address, &order.Status, &order.Mode, &order.Region,
+			&createdAt, &acceptedAt, &completedAt, &order.ClientName, &order.ContractorName); err != nil {
+			internalError(w, err)
+			return
+		}
+		if createdAt.Valid {
+			order.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt.String)
+		}
+		if acceptedAt.Valid {
+			t, _ := time.Parse("2006-01-02 15:04:05", acceptedAt.String)
+			order.AcceptedAt = &t
+		}
+		if completedAt.Valid {
+			t, _ := time.Parse("2006-01-02 15:04:05", completedAt.String)
+			order.CompletedAt = &t
+		}
+		orders = append(orders, order)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"orders": orders, "limit": limit, "offset": offset})
+}
+
+func (app *App) handleAdminGetOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.ParseInt(mux.Vars(r)["orderId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
+		return
+	}
+	order, err := app.getOrder(orderID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if order == nil {
+		writeError(w, http.StatusNotFound, CodeOrderNotFound, "Заказ не найден")
+		return
+	}
+	audit, err := app.getAuditLog("order", order.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"order": order, "audit": audit})
+}
+
+// Ошибки принудительной смены статуса
+var (
+	errOrderNoContractor  = errors.New("у заказа нет бригадира")
+	errUnknownOrderStatus = errors.New("неизвестный статус заказа")
+	errOrderTransition    = errors.New("недопустимая смена статуса заказа")
+)
+
+// adminOrderTransitions - куда администратор может перевести заказ из каждого статуса
+var adminOrderTransitions = map[string][]string{
+	"pending":     {"cancelled"},
+	"accepted":    {"pending", "completed", "cancelled"},
+	"in_progress": {"pending", "completed", "cancelled"},
+	"completed":   {"pending", "accepted", "cancelled"},
+	"cancelled":   {"pending", "accepted"},
+}
+
+// forceOrderStatus переводит заказ в другой статус в обход обычного порядка.
+// Побочные эффекты те же, что у обычных действий: бригадир занимается или
+// освобождается, подменный номер возвращается, предоплата при отмене
+// возвращается клиенту. Из выполненного заказа начисление бригадиру
+// отменяется, а счетчик выполненных заказов уменьшается. Изменения в базе
+// выполняются одной транзакцией, внешние сервисы вызываются после нее.
+func (app *App) forceOrderStatus(ctx context.Context, order *Order, status string) error {
+	allowed, ok := adminOrderTransitions[order.Status]
+	if _, known := adminOrderTransitions[status]; !known {
+		return errUnknownOrderStatus
+	}
+	if !ok || !slices.Contains(allowed, status) {
+		return errOrderTransition
+	}
+	if order.ContractorID == nil && (status == "accepted" || status == "completed") {
+		return errOrderNoContractor
+	}
+
+	tx, err := app.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := map[string]string{
+		"pending":   `UPDATE orders SET status = 'pending', contractor_id = NULL, accepted_at = NULL, completed_at = NULL WHERE id = ? AND status = ?`,
+		"cancelled": `UPDATE orders SET status = 'cancelled' WHERE id = ? AND status = ?`,
+		"accepted":  `UPDATE orders SET status = 'accepted', accepted_at = COALESCE(accepted_at, CURRENT_TIMESTAMP), completed_at = NULL WHERE id = ? AND status = ?`,
+		"completed": `UPDATE orders SET status = 'completed', completed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+	}[status]
+	result, err := tx.Exec(query, order.ID, order.Status)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		// Статус успели изменить другим запросом
+		return errOrderTransition
+	}
+	if order.Status == "completed" {
+		if err := cancelAccrualTx(tx, order.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE contractor_profiles SET completed_orders = MAX(completed_orders - 1, 0) WHERE user_id = ?`, *order.ContractorID); err != nil {
+			return err
+		}
+	}
+	if order.ContractorID != nil {
+		switch status {
+		case "accepted":
+			// Бригадир снова занят этим заказом и не может принять другой
+			result, err := tx.Exec(`UPDATE contractor_profiles SET current_order_id = ? WHERE user_id = ? AND (current_order_id IS NULL OR current_order_id = 0 OR current_order_id = ?)`,
+				order.ID, *order.ContractorID, order.ID)
+			if err != nil {
+				return err
+			}
+			if affected, err := result.RowsAffected(); err != nil {
+				return err
+			} else if affected == 0 {
+				return errContractorUnavailable
+			}
+		case "completed":
+			if _, err := tx.Exec(`UPDATE contractor_profiles SET current_order_id = NULL, completed_orders = completed_orders + 1 WHERE user_id = ?`, *order.ContractorID); err != nil {
+				return err
+			}
+		default:
+			if _, err := tx.Exec(`UPDATE contractor_profiles SET current_order_id = NULL WHERE user_id = ? AND current_order_id = ?`, *order.ContractorID, order.ID); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	switch status {
+	case "pending", "cancelled":
+		if err := app.releaseProxyPhone(ctx, order.ID); err != nil {
+			log.Printf("Не удалось освободить подменный номер заказа %d: %v", order.ID, err)
+		}
+		if err := app.refundEscrow(ctx, order.ID); err != nil {
+			log.Printf("Не удалось вернуть предоплату по заказу %d: %v", order.ID, err)
+		}
+	case "completed":
+		if err := app.accrueOrder(order.ID); err != nil {
+			log.Printf("Не удалось начислить вознаграждение по заказу %d: %v", order.ID, err)
+		}
+		if err := app.scheduleEscrowConfirm(order.ID); err != nil {
+			log.Printf("Не удалось запустить автоподтверждение по заказу %d: %v", order.ID, err)
+		}
+		if err := app.releaseProxyPhone(ctx, order.ID); err != nil {
+			log.Printf("Не удалось освободить подменный номер заказа %d: %v", order.ID, err)
+		}
+	}
+	return nil
+}
+
+// handleAdminSetOrderStatus - принудительная смена статуса заказа с указанием причины
+func (app *App) handleAdminSetOrderStatus(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.ParseInt(mux.Vars(r)["orderId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
+		return
+	}
+	var req struct {
+		Status string  `json:"status"`
+		Reason *string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	if req.Reason == nil || strings.TrimSpace(*req.Reason) == "" {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Укажите причину смены статуса")
+		return
+	}
+	order, err := app.getOrder(orderID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if order == nil {
+		writeError(w, http.StatusNotFound, CodeOrderNotFound, "Заказ не найден")
+		return
+	}
+	if order.Status == req.Status {
+		writeError(w, http.StatusConflict, CodeOrderState, "Заказ уже в этом статусе")
+		return
+	}
+	switch err := app.forceOrderStatus(r.Context(), order, req.Status); {
+	case err == nil:
+	case errors.Is(err, errOrderNoContractor):
+		writeError(w, http.StatusConflict, CodeOrderState, "У заказа нет бригадира")
+		return
+	case errors.Is(err, errUnknownOrderStatus):
+		writeError(w, http.StatusBadRequest, CodeValidation, "Неизвестный статус заказа")
+		return
+	case errors.Is(err, errOrderTransition):
+		writeErrorDetails(w, http.StatusConflict, CodeOrderState, "Недопустимая смена статуса заказа",
+			map[string]interface{}{"from": order.Status, "allowed": adminOrderTransitions[order.Status]})
+		return
+	case errors.Is(err, errContractorUnavailable):
+		writeError(w, http.StatusConflict, CodeConflict, "Бригадир занят другим заказом")
+		return
+	case errors.Is(err, errAccrualInPayout):
+		writeError(w, http.StatusConflict, CodeConflict, "Вознаграждение по заказу уже в реестре выплат")
+		return
+	default:
+		internalError(w, err)
+		return
+	}
+	app.audit(r.Context(), "order.status", "order", order.ID, req.Reason, map[string]string{"from": order.Status, "to": req.Status})
+
+	if order, err = app.getOrder(orderID); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"order": order})
+}
+
+// handleAdminListContractors - поиск бригадиров: category, region, active, min_rating, verification, status, q
+func (app *App) handleAdminListContractors(w http.ResponseWriter, r *http.Request) {
+	var filter adminFilter
+	query := r.URL.Query()
+	if category := query.Get("category"); category != "" {
+		filter.add("cp.categories LIKE ?", `%"`+category+`"%`)
+	}
+	filter.addParam(r, "region", "u.region = ?")
+	filter.addParam(r, "min_rating", "cp.rating >= ?")
+	filter.addParam(r, "verification", "cp.verification_status = ?")
+	if active := query.Get("active"); active != "" {
+		filter.add("cp.is_active = ?", active == "true")
+	}
+	filter.addParam(r, "status", "u.status = ?")
+	if q := query.Get("q"); q != "" {
+		filter.add("(u.name LIKE ? OR u.phone LIKE ?)", "%"+q+"%", "%"+q+"%")
+	}
+	limit, offset := pageParams(r)
+	rows, err := app.db.Query(`SELECT cp.id, cp.user_id, cp.experience_years, cp.rating, cp.completed_orders, cp.categories, cp.is_active, cp.current_order_id, cp.verification_status, u.name, u.phone, u.avatar_url, u.telegram_id, u.region, u.status
+		FROM contractor_profiles cp JOIN users u ON cp.user_id = u.id`+filter.where()+` ORDER BY cp.rating DESC, cp.id LIMIT ? OFFSET ?`,
+		append(filter.args, limit, offset)...)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer rows.Close()
+	type adminContractor struct {
+		ContractorProfile
+		Region *string `json:"region"`
+		Status string  `json:"status"`
+	}
+	contractors := []adminContractor{}
+	for rows.Next() {
+		var c adminContractor
+		if err := rows.Scan(&c.ID, &c.UserID, &c.ExperienceYears, &c.Rating, &c.CompletedOrders, &c.Categories, &c.IsActive, &c.CurrentOrderID, &c.Verification,
+			&c.Name, &c.Phone, &c.AvatarURL, &c.TelegramID, &c.Region, &c.Status); err != nil {
+			internalError(w, err)
+			return
+		}
+		contractors = append(contractors, c)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"contractors": contractors, "limit": limit, "offset": offset})
+}
+
+func (app *App) getAuditLog(targetType string, targetID int64) ([]AdminAuditEntry, error) {
+	rows, err := app.db.Query(`SELECT id, actor, action, target_type, target_id, reason, details, created_at FROM admin_audit_log WHERE target_type = ? AND target_id = ? ORDER BY id DESC`, targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []AdminAuditEntry{}
+	for rows.Next() {
+		var e AdminAuditEntry
+		var createdAt string
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.TargetType, &e.TargetID, &e.Reason, &e.Details, &createdAt); err != nil {
+			return nil, err
+		}
+		e.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (app *App) handleAdminListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	rows, err := app.db.Query(`SELECT id, name, prefix, created_by, last_used_at, revoked_at, created_at FROM admin_api_keys ORDER BY id`)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer rows.Close()
+	keys := []AdminAPIKey{}
+	for rows.Next() {
+		var key AdminAPIKey
+		var lastUsedAt, revokedAt sql.NullString
+		var createdAt string
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, &key.CreatedBy, &lastUsedAt, &revokedAt, &createdAt); err != nil {
+			internalError(w, err)
+			return
+		}
+		if lastUsedAt.Valid {
+			t, _ := time.Parse("2006-01-02 15:04:05", lastUsedAt.String)
+			key.LastUsedAt = &t
+		}
+		if revokedAt.Valid {
+			t, _ := time.Parse("2006-01-02 15:04:05", revokedAt.String)
+			key.RevokedAt = &t
+		}
+		key.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt)
+		keys = append(keys, key)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+// handleAdminCreateAPIKey выпускает ключ. Сам ключ возвращается только в этом ответе.
+func (app *App) handleAdminCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Укажите название ключа")
+		return
+	}
+	key := "psk_" + randomHex(24)
+	result, err := app.db.Exec(`INSERT INTO admin_api_keys (name, key_hash, prefix, created_by) VALUES (?, ?, ?, ?)`,
+		req.Name, hashAPIKey(key), key[:8], adminActor(r.Context()))
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	id, _ := result.LastInsertId()
+	app.audit(r.Context(), "api_key.create", "api_key", id, nil, map[string]string{"name": req.Name})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "name": req.Name, "key": key})
+}
+
+func (app *App) handleAdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := strconv.ParseInt(mux.Vars(r)["keyId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный ID ключа")
+		return
+	}
+	result, err := app.db.Exec(`UPDATE admin_api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL`, keyID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Ключ не найден или уже отозван")
+		return
+	}
+	app.audit(r.Context(), "api_key.revoke", "api_key", keyID, nil, nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// registerAdminRoutes подключает подроутер /api/admin
+func (app *App) registerAdminRoutes(api *mux.Router) {
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(app.adminAuth)
+	admin.HandleFunc("/users", app.handleAdminListUsers).Methods("GET")
+	admin.HandleFunc("/users/{userId}", app.handleAdminGetUser).Methods("GET")
+	admin.HandleFunc("/users/{userId}", app.handleAdminUpdateUser).Methods("POST")
+	admin.HandleFunc("/users/{userId}/status", app.handleAdminSetUserStatus).Methods("POST")
+	admin.HandleFunc("/flags", app.handleAdminListFlags).Methods("GET")
+	admin.HandleFunc("/flags/{flagId}/resolve", app.handleAdminResolveFlag).Methods("POST")
+	admin.HandleFunc("/fraud-rules", app.handleAdminGetFraudRules).Methods("GET")
+	admin.HandleFunc("/fraud-rules", app.handleAdminSaveFraudRule).Methods("POST")
+	admin.HandleFunc("/orders", app.handleAdminListOrders).Methods("GET")
+	admin.HandleFunc("/orders/{orderId}", app.handleAdminGetOrder).Methods("GET")
+	admin.HandleFunc("/orders/{orderId}/status", app.handleAdminSetOrderStatus).Methods("POST")
+	admin.HandleFunc("/contractors", app.handleAdminListContractors).Methods("GET")
+	admin.HandleFunc("/contractors/{userId}/verification", app.handleAdminGetVerification).Methods("GET")
+	admin.HandleFunc("/contractors/{userId}/verification", app.handleAdminDecideVerification).Methods("POST")
+	admin.HandleFunc("/verifications", app.handleAdminListVerifications).Methods("GET")
+	admin.HandleFunc("/api-keys", app.handleAdminListAPIKeys).Methods("GET")
+	admin.HandleFunc("/api-keys", app.handleAdminCreateAPIKey).Methods("POST")
+	admin.HandleFunc("/api-keys/{keyId}/revoke", app.handleAdminRevokeAPIKey).Methods("POST")
+	admin.HandleFunc("/migrate", app.handleMigrate).Methods("POST")
+	admin.HandleFunc("/promo", app.handleSavePromo).Methods("POST")
+	admin.HandleFunc("/promo", app.handleGetPromoReport).Methods("GET")
+	admin.HandleFunc("/regions", app.handleSaveRegion).Methods("POST")
+	admin.HandleFunc("/commission/rules", app.handleGetCommissionRules).Methods("GET")
+	admin.HandleFunc("/commission/rules", app.handleSetCommissionRule).Methods("POST")
+	admin.HandleFunc("/payouts/batches", app.handleCreatePayoutBatch).Methods("POST")
+	admin.HandleFunc("/payouts/batches/{batchId}/export", app.handleExportPayoutBatch).Methods("GET")
+	admin.HandleFunc("/payouts/batches/{batchId}/status", app.handleSetPayoutBatchStatus).Methods("POST")
+	admin.HandleFunc("/payments/{paymentId}/refund", app.handleRefundPayment).Methods("POST")
+	admin.HandleFunc("/ledger/balances", app.handleLedgerBalances).Methods("GET")
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestForceOrderStatus(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	clientID := testUser(t, a, 111, "client")
+	contractorID := testUser(t, a, 333, "contractor")
+	if _, err := a.db.Exec(`INSERT INTO contractor_profiles (user_id, categories, verification_status) VALUES (?, '[]', ?)`, contractorID, VerificationVerified); err != nil {
+		t.Fatal(err)
+	}
+	order := testOrder(t, a, clientID, 20)
+	if err := a.acceptOrder(order.ID, contractorID, nil); err != nil {
+		t.Fatal(err)
+	}
+	force := func(status string) error {
+		t.Helper()
+		order, err := a.getOrder(order.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a.forceOrderStatus(ctx, order, status)
+	}
+	profile := func() (completed int, current *int64) {
+		t.Helper()
+		if err := a.db.QueryRow(`SELECT completed_orders, current_order_id FROM contractor_profiles WHERE user_id = ?`, contractorID).Scan(&completed, &current); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	accruals := func() (n int) {
+		a.db.QueryRow(`SELECT COUNT(*) FROM contractor_accruals WHERE order_id = ?`, order.ID).Scan(&n)
+		return
+	}
+
+	// Выполненный заказ возвращается в работу и снова завершается: счетчик и
+	// начисление не удваиваются, комиссия проведена один раз
+	for _, status := range []string{"completed", "accepted", "completed"} {
+		if err := force(status); err != nil {
+			t.Fatalf("%s: %v", status, err)
+		}
+	}
+	if completed, current := profile(); completed != 1 || current != nil {
+		t.Errorf("после повторного завершения: выполнено %d, текущий заказ %v; want 1 и нет", completed, current)
+	}
+	if n := accruals(); n != 1 {
+		t.Errorf("начислений %d, want 1", n)
+	}
+	var commission, revenue int64
+	a.db.QueryRow(`SELECT commission FROM contractor_accruals WHERE order_id = ?`, order.ID).Scan(&commission)
+	if revenue, _ = a.accountBalance(commissionRevenueAccount); revenue != commission || commission <= 0 {
+		t.Errorf("комиссия на счете %d, в начислении %d", revenue, commission)
+	}
+
+	// Возврат в работу занимает бригадира, пока он свободен
+	if err := force("accepted"); err != nil {
+		t.Fatal(err)
+	}
+	if _, current := profile(); current == nil || *current != order.ID {
+		t.Errorf("текущий заказ бригадира %v, want %d", current, order.ID)
+	}
+	if err := force("completed"); err != nil {
+		t.Fatal(err)
+	}
+	other := testOrder(t, a, clientID, 10)
+	if err := a.acceptOrder(other.ID, contractorID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := force("accepted"); !errors.Is(err, errContractorUnavailable) {
+		t.Errorf("возврат в работу занятому бригадиру: %v, want errContractorUnavailable", err)
+	}
+
+	// Отмена выполненного заказа отменяет начисление и сторнирует комиссию
+	if err := force("cancelled"); err != nil {
+		t.Fatal(err)
+	}
+	if n := accruals(); n != 0 {
+		t.Errorf("после отмены осталось начислений: %d", n)
+	}
+	if revenue, _ = a.accountBalance(commissionRevenueAccount); revenue != 0 {
+		t.Errorf("комиссия после отмены %d, want 0", revenue)
+	}
+	if completed, _ := profile(); completed != 0 {
+		t.Errorf("выполнено после отмены %d, want 0", completed)
+	}
+	if err := force("completed"); !errors.Is(err, errOrderTransition) {
+		t.Errorf("cancelled -> completed: %v, want errOrderTransition", err)
+	}
+}
+
+func TestAdminUpdateUserValidatesBeforeWrite(t *testing.T) {
+	a := newTestApp(t)
+	t.Setenv("ADMIN_API_KEY_HASHES", hashAPIKey("test-admin-key"))
+	userID := testUser(t, a, 333, "contractor")
+	target := fmt.Sprintf("/api/admin/users/%d", userID)
+
+	body := map[string]interface{}{"name": "Новое имя", "role": "client", "contractor": map[string]interface{}{"categories": []string{"econom"}, "rating": 7}}
+	if code := doJSON(t, "POST", target, body, nil, "X-API-Key", "test-admin-key"); code != http.StatusBadRequest {
+		t.Fatalf("рейтинг 7: статус %d, want 400", code)
+	}
+	user, _ := a.getUserByID(userID)
+	if user.Name != nil || user.Role != "contractor" {
+		t.Errorf("после отказа изменены имя %v или роль %s", user.Name, user.Role)
+	}
+	if profile, _ := a.getContractorProfile(userID); profile != nil {
+		t.Errorf("после отказа создан профиль бригадира: %+v", profile)
+	}
+}
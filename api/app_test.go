@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// libsql отдает DATETIME текстом, как он хранится в SQLite, а go-sqlite3 -
+// значением time.Time, которое Scan в строку переводит в RFC 3339. Драйвер
+// sqlite3-text возвращает тестам такой же текст, как в продакшене.
+func init() {
+	sql.Register("sqlite3-text", textTimeDriver{&sqlite3.SQLiteDriver{}})
+}
+
+type textTimeDriver struct {
+	*sqlite3.SQLiteDriver
+}
+
+func (d textTimeDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return textTimeConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+type textTimeConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c textTimeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return textTimeRows{rows}, nil
+}
+
+type textTimeRows struct {
+	driver.Rows
+}
+
+func (r textTimeRows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	for i, v := range dest {
+		if t, ok := v.(time.Time); ok {
+			dest[i] = t.UTC().Format("2006-01-02 15:04:05")
+		}
+	}
+	return nil
+}
+
+// newTestApp - приложение на временной SQLite со схемой из initDB. Запросы
+// идут через handleAPI со всеми middleware; пользователь передается в
+// telegram_id (TELEGRAM_AUTH=insecure).
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	t.Setenv("TELEGRAM_AUTH", "insecure")
+	t.Setenv("PAYMENT_SANDBOX_SECRET", "test-sandbox-secret")
+	t.Setenv("PAYMENT_PROVIDER", sandboxProviderName)
+	t.Setenv("BLOB_SIGNING_KEY", "test-signing-key")
+	db, err := sql.Open("sqlite3-text", filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		db.Close()
+		app, dbInitialized = nil, false
+	})
+	app = &App{db: db, blobs: &LocalBlobStore{Dir: t.TempDir(), URLPrefix: "/api/files/public/"}, fiscal: newFiscalStub()}
+	if app.payments, err = newPaymentProviderFromEnv(app); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.initDB(); err != nil {
+		t.Fatal(err)
+	}
+	dbInitialized = true
+	return app
+}
+
+// testUser заводит пользователя и возвращает его id
+func testUser(t *testing.T, a *App, telegramID int64, role string) int64 {
+	t.Helper()
+	id, err := a.createUser(telegramID, role, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// testOrder заводит заказ клиента по тарифу econom
+func testOrder(t *testing.T, a *App, clientID int64, area float64) *Order {
+	t.Helper()
+	id, err := a.createOrder(clientID, "econom", &area, nil, nil, nil, TARIFFS["econom"].PriceRange, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := a.getOrder(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+// doJSON выполняет запрос к API и разбирает JSON-ответ в out (если out не nil)
+func doJSON(t *testing.T, method, target string, body interface{}, out interface{}, header ...string) int {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := httptest.NewRequest(method, target, bytes.NewReader(data))
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	handleAPI(w, r)
+	if out != nil && w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: ответ не JSON: %s", method, target, w.Body)
+		}
+	}
+	if w.Code >= http.StatusInternalServerError {
+		t.Logf("%s %s: %d %s", method, target, w.Code, w.Body)
+	}
+	return w.Code
+}
@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxAttachmentSize = 10 << 20 // 10 МБ
+	signedURLTTL      = 15 * time.Minute
+)
+
+// Разрешенные типы файлов и расширения для ключей хранилища
+var attachmentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/pdf": ".pdf",
+}
+
+// Виды вложений: фото объекта и план загружает клиент, фото до/после - бригадир
+var attachmentKinds = map[string]string{
+	"photo":  "client",
+	"plan":   "client",
+	"before": "contractor",
+	"after":  "contractor",
+}
+
+type Attachment struct {
+	ID           int64     `json:"id"`
+	OrderID      int64     `json:"order_id"`
+	UploaderID   int64     `json:"uploader_id"`
+	Kind         string    `json:"kind"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	CreatedAt    time.Time `json:"created_at"`
+	URL          string    `json:"url"`
+	ThumbnailURL *string   `json:"thumbnail_url"`
+	blobKey      string
+	thumbnailKey *string
+}
+
+func (app *App) createAttachment(a *Attachment) (int64, error) {
+	result, err := app.db.Exec(`INSERT INTO order_attachments (order_id, uploader_id, kind, blob_key, thumbnail_key, content_type, size) VALUES (?, ?, ?, ?, ?, ?, ?)`, a.OrderID, a.UploaderID, a.Kind, a.blobKey, a.thumbnailKey, a.ContentType, a.Size)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (app *App) getOrderAttachments(orderID int64) ([]Attachment, error) {
+	rows, err := app.db.Query(`SELECT id, order_id, uploader_id, kind, blob_key, thumbnail_key, content_type, size, created_at FROM order_attachments WHERE order_id = ? ORDER BY id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	attachments := []Attachment{}
+	for rows.Next() {
+		var a Attachment
+		var createdAt *string
+		if err := rows.Scan(&a.ID, &a.OrderID, &a.UploaderID, &a.Kind, &a.blobKey, &a.thumbnailKey, &a.ContentType, &a.Size, &createdAt); err != nil {
+			return nil, err
+		}
+		if createdAt != nil {
+			a.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", *createdAt)
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+// signAttachmentURLs заполняет временные ссылки на файл и превью
+func (app *App) signAttachmentURLs(a *Attachment) error {
+	url, err := app.blobs.SignedURL(a.blobKey, signedURLTTL)
+	if err != nil {
+		return err
+	}
+	a.URL = url
+	if a.thumbnailKey != nil {
+		thumb, err := app.blobs.SignedURL(*a.thumbnailKey, signedURLTTL)
+		if err != nil {
+			return err
+		}
+		a.ThumbnailURL = &thumb
+	}
+	return nil
+}
+
+func (app *App) handleUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.ParseInt(mux.Vars(r)["orderId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Файл слишком большой или неверный формат данных")
+		return
+	}
+	user := app.formUser(w, r)
+	if user == nil {
+		return
+	}
+	kind := r.FormValue("kind")
+	requiredParty, ok := attachmentKinds[kind]
+	if !ok {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Неизвестный вид вложения")
+		return
+	}
+
+	order, err := app.getOrder(orderID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if order == nil {
+		writeError(w, http.StatusNotFound, CodeOrderNotFound, "Заказ не найден")
+		return
+	}
+	if orderParty(order, user) != requiredParty {
+		writeError(w, http.StatusForbidden, CodeOrderAccessDenied, "Нет доступа к заказу")
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeFileMissing, "Файл не передан")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxAttachmentSize+1))
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if len(data) > maxAttachmentSize {
+		writeErrorDetails(w, http.StatusRequestEntityTooLarge, CodeFileTooLarge, trf(responseLocale(w), "Файл больше %d МБ", maxAttachmentSize>>20),
+			map[string]interface{}{"max_mb": maxAttachmentSize >> 20})
+		return
+	}
+	// Тип определяем по содержимому, а не по заголовку клиента
+	contentType := http.DetectContentType(data)
+	ext, ok := attachmentTypes[contentType]
+	if !ok {
+		writeError(w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Допустимы только JPEG, PNG и PDF")
+		return
+	}
+
+	// Изображение проверяется и очищается от метаданных (EXIF с координатами
+	// съемки) до записи в хранилище, чтобы нечитаемый файл туда не попал
+	var thumbnail []byte
+	if contentType != "application/pdf" {
+		if data, thumbnail, err = sanitizeImage(data, contentType); err != nil {
+			writeImageError(w, err)
+			return
+		}
+	}
+	attachment := &Attachment{
+		OrderID:     orderID,
+		UploaderID:  user.ID,
+		Kind:        kind,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		blobKey:     fmt.Sprintf("orders/%d/%s%s", orderID, randomHex(16), ext),
+	}
+	if err := app.blobs.Put(r.Context(), attachment.blobKey, contentType, data); err != nil {
+		internalError(w, err)
+		return
+	}
+	// Если вложение не удалось сохранить до конца, записанные файлы удаляются
+	stored := []string{attachment.blobKey}
+	fail := func(err error) {
+		for _, key := range stored {
+			if err := app.blobs.Delete(r.Context(), key); err != nil {
+				log.Printf("Не удалось удалить файл несохраненного вложения %s: %v", key, err)
+			}
+		}
+		internalError(w, err)
+	}
+	if thumbnail != nil {
+		thumbKey := attachment.blobKey + "_thumb.jpg"
+		if err := app.blobs.Put(r.Context(), thumbKey, "image/jpeg", thumbnail); err != nil {
+			fail(err)
+			return
+		}
+		stored = append(stored, thumbKey)
+		attachment.thumbnailKey = &thumbKey
+	}
+
+	attachment.ID, err = app.createAttachment(attachment)
+	if err != nil {
+		fail(err)
+		return
+	}
+	attachment.CreatedAt = time.Now().UTC()
+	if err := app.signAttachmentURLs(attachment); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"attachment": attachment})
+}
+
+func (app *App) handleGetAttachments(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.ParseInt(mux.Vars(r)["orderId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
+		return
+	}
+	user := callerUser(w, r)
+	if user == nil {
+		return
+	}
+	order, err := app.getOrder(orderID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if order == nil {
+		writeError(w, http.StatusNotFound, CodeOrderNotFound, "Заказ не найден")
+		return
+	}
+	// Вложения видят стороны заказа, а пока заказ не принят - бригадиры, выбирающие заявку
+	canView := (orderParty(order, user) != "" || (order.Status == "pending" && user.Role == "contractor"))
+	if !canView {
+		writeError(w, http.StatusForbidden, CodeOrderAccessDenied, "Нет доступа к заказу")
+		return
+	}
+
+	attachments, err := app.getOrderAttachments(orderID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	for i := range attachments {
+		if err := app.signAttachmentURLs(&attachments[i]); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"attachments": attachments})
+}
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Пользователь запроса определяется один раз, в middleware authenticate, по
+// подписанным initData Telegram WebApp из заголовка X-Telegram-Init-Data
+// (для потока SSE - из параметра init_data).
+// Подпись проверяется ключом бота (TELEGRAM_BOT_TOKEN), initData старше
+// initDataMaxAge не принимаются. telegram_id в пути, строке запроса и JSON-теле
+// больше не определяет пользователя: если он передан, то должен совпадать с
+// подписанным. Обработчики берут пользователя только из контекста (callerUser).
+//
+// Для локальной разработки без бота TELEGRAM_AUTH=insecure разрешает верить
+// telegram_id из запроса. Без этой настройки запрос с telegram_id, но без
+// подписи отклоняется.
+
+const initDataHeader = "X-Telegram-Init-Data"
+
+// Сколько байт JSON-тела читает authenticate в поисках telegram_id
+const maxGuardBodySize = 1 << 20
+
+// Сколько живут initData: Mini App получает новые при каждом открытии
+const initDataMaxAge = 24 * time.Hour
+
+var (
+	errInitDataSignature  = errors.New("подпись initData не сошлась")
+	errInitDataExpired    = errors.New("initData устарели")
+	errInitDataUser       = errors.New("в initData нет пользователя")
+	errTelegramIDFormat   = errors.New("неверный telegram_id")
+	errTelegramIDConflict = errors.New("telegram_id в запросе не совпадают")
+)
+
+// Маршруты, где {telegramId} - чужой ресурс, а не вызывающий: аватар
+// подгружается тегом <img>, который не передает заголовки
+var resourceTelegramIDRoutes = map[string]bool{
+	"GET /api/user/{telegramId}/avatar": true,
+}
+
+type callerKey struct{}
+
+// caller - пользователь запроса. telegramID = 0 - анонимный запрос,
+// user = nil - пользователь еще не зарегистрирован. verified - личность
+// подтверждена подписью, а не принята в режиме TELEGRAM_AUTH=insecure.
+type caller struct {
+	telegramID int64
+	user       *User
+	verified   bool
+}
+
+func requestCaller(r *http.Request) caller {
+	c, _ := r.Context().Value(callerKey{}).(caller)
+	return c
+}
+
+// callerTelegramID - Telegram ID пользователя запроса, 0 для анонимного
+func callerTelegramID(r *http.Request) int64 {
+	return requestCaller(r).telegramID
+}
+
+// callerUser - зарегистрированный пользователь запроса. Если запрос анонимный,
+// отвечает 401, если пользователь не зарегистрирован - 404, и возвращает nil.
+func callerUser(w http.ResponseWriter, r *http.Request) *User {
+	return registeredUser(w, requestCaller(r))
+}
+
+// formUser - callerUser для multipart-запросов. authenticate не читает тело
+// формы, поэтому поле telegram_id сверяется здесь, после ParseMultipartForm
+// обработчика, а в режиме TELEGRAM_AUTH=insecure по нему определяется
+// пользователь. Статус такого пользователя userStatusGuard не видел - он
+// проверяется здесь же.
+func (app *App) formUser(w http.ResponseWriter, r *http.Request) *User {
+	c := requestCaller(r)
+	if value := r.FormValue("telegram_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		switch {
+		case err != nil || id <= 0:
+			writeError(w, http.StatusBadRequest, CodeInvalidTelegramID, "Неверный telegram_id")
+			return nil
+		case c.telegramID != 0 && id != c.telegramID:
+			writeError(w, http.StatusForbidden, CodeForbidden, "telegram_id не совпадает с авторизованным пользователем")
+			return nil
+		case c.telegramID == 0 && insecureTelegramAuth():
+			user, err := app.getUserByTelegramID(id)
+			if err != nil {
+				internalError(w, err)
+				return nil
+			}
+			if !applyActiveRole(w, r, user) {
+				return nil
+			}
+			c = caller{telegramID: id, user: user}
+		}
+	}
+	user := registeredUser(w, c)
+	if rejectInactive(w, user) {
+		return nil
+	}
+	return user
+}
+
+func registeredUser(w http.ResponseWriter, c caller) *User {
+	if c.telegramID == 0 {
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Требуется авторизация Telegram")
+		return nil
+	}
+	if c.user == nil {
+		writeError(w, http.StatusNotFound, CodeUserNotFound, "Пользователь не найден")
+		return nil
+	}
+	return c.user
+}
+
+// insecureTelegramAuth - режим разработки без проверки подписи
+func insecureTelegramAuth() bool {
+	return os.Getenv("TELEGRAM_AUTH") == "insecure"
+}
+
+// verifyInitData проверяет подпись initData и возвращает Telegram ID
+// пользователя. Алгоритм описан в документации Telegram Mini Apps: ключ -
+// HMAC-SHA256("WebAppData", токен бота), подпись - HMAC-SHA256 от строк
+// "key=value" всех полей, кроме hash, отсортированных по ключу.
+func verifyInitData(initData, botToken string, now time.Time) (int64, error) {
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return 0, errInitDataSignature
+	}
+	hash, err := hex.DecodeString(values.Get("hash"))
+	if err != nil || len(hash) == 0 {
+		return 0, errInitDataSignature
+	}
+	pairs := make([]string, 0, len(values))
+	for key := range values {
+		if key != "hash" {
+			pairs = append(pairs, key+"="+values.Get(key))
+		}
+	}
+	sort.Strings(pairs)
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	if !hmac.Equal(mac.Sum(nil), hash) {
+		return 0, errInitDataSignature
+	}
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil || now.Sub(time.Unix(authDate, 0)) > initDataMaxAge {
+		return 0, errInitDataExpired
+	}
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	if json.Unmarshal([]byte(values.Get("user")), &user) != nil || user.ID == 0 {
+		return 0, errInitDataUser
+	}
+	return user.ID, nil
+}
+
+// claimedTelegramID собирает telegram_id из пути, строки запроса и JSON-тела.
+// 0 - telegram_id не передан. Прочитанное тело возвращается в запрос для обработчика.
+func claimedTelegramID(r *http.Request) (int64, error) {
+	var claims []string
+	if id, ok := mux.Vars(r)["telegramId"]; ok {
+		route, _ := mux.CurrentRoute(r).GetPathTemplate()
+		if !resourceTelegramIDRoutes[r.Method+" "+route] {
+			claims = append(claims, id)
+		}
+	}
+	if id := r.URL.Query().Get("telegram_id"); id != "" {
+		claims = append(claims, id)
+	}
+	if r.Body != nil && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxGuardBodySize))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+		var body struct {
+			TelegramID json.RawMessage `json:"telegram_id"`
+		}
+		if err == nil && json.Unmarshal(data, &body) == nil && len(body.TelegramID) > 0 && string(body.TelegramID) != "null" {
+			claims = append(claims, string(body.TelegramID))
+		}
+	}
+	var claimed int64
+	for _, claim := range claims {
+		id, err := strconv.ParseInt(claim, 10, 64)
+		if err != nil || id <= 0 {
+			return 0, errTelegramIDFormat
+		}
+		if claimed != 0 && id != claimed {
+			return 0, errTelegramIDConflict
+		}
+		claimed = id
+	}
+	return claimed, nil
+}
+
+// authenticate - middleware /api: определяет пользователя запроса и кладет его в контекст
+func (app *App) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claimed, err := claimedTelegramID(r)
+		switch err {
+		case errTelegramIDFormat:
+			writeError(w, http.StatusBadRequest, CodeInvalidTelegramID, "Неверный telegram_id")
+			return
+		case errTelegramIDConflict:
+			writeError(w, http.StatusBadRequest, CodeInvalidTelegramID, "telegram_id в пути, строке запроса и теле не совпадают")
+			return
+		}
+		var telegramID int64
+		initData := r.Header.Get(initDataHeader)
+		if initData == "" {
+			// EventSource не умеет передавать заголовки, поток чата подписывается параметром
+			initData = r.URL.Query().Get("init_data")
+		}
+		if initData != "" {
+			token := os.Getenv("TELEGRAM_BOT_TOKEN")
+			if token == "" {
+				writeError(w, http.StatusServiceUnavailable, CodeServiceUnavailable, "Проверка подписи Telegram не настроена")
+				return
+			}
+			if telegramID, err = verifyInitData(initData, token, time.Now()); err != nil {
+				writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Подпись Telegram не прошла проверку")
+				return
+			}
+			if claimed != 0 && claimed != telegramID {
+				writeError(w, http.StatusForbidden, CodeForbidden, "telegram_id не совпадает с авторизованным пользователем")
+				return
+			}
+		} else if claimed != 0 {
+			if !insecureTelegramAuth() {
+				writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Требуется авторизация Telegram")
+				return
+			}
+			telegramID = claimed
+		}
+		c := caller{telegramID: telegramID, verified: initData != ""}
+		if telegramID != 0 {
+			if c.user, err = app.getUserByTelegramID(telegramID); err != nil {
+				internalError(w, err)
+				return
+			}
+			// Пользователь уже загружен - заодно выбираем язык ответа по его Telegram
+			applyUserLocale(w, r, c.user)
+			if !applyActiveRole(w, r, c.user) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, c)))
+	})
+}
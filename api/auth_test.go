@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const testBotToken = "123456:test-token"
+
+// signInitData подписывает initData так же, как Telegram
+func signInitData(t *testing.T, token string, telegramID int64, authDate time.Time) string {
+	t.Helper()
+	values := url.Values{}
+	values.Set("auth_date", strconv.FormatInt(authDate.Unix(), 10))
+	values.Set("query_id", "AAE")
+	values.Set("user", `{"id":`+strconv.FormatInt(telegramID, 10)+`,"first_name":"Иван"}`)
+	pairs := []string{}
+	for key := range values {
+		pairs = append(pairs, key+"="+values.Get(key))
+	}
+	sort.Strings(pairs)
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(token))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	values.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+	return values.Encode()
+}
+
+func TestVerifyInitData(t *testing.T) {
+	now := time.Now()
+	initData := signInitData(t, testBotToken, 111, now.Add(-time.Hour))
+
+	id, err := verifyInitData(initData, testBotToken, now)
+	if err != nil || id != 111 {
+		t.Fatalf("verifyInitData = %d, %v; want 111", id, err)
+	}
+	if _, err := verifyInitData(initData, "другой:токен", now); err != errInitDataSignature {
+		t.Errorf("чужой токен: err = %v", err)
+	}
+	tampered := strings.Replace(initData, "111", "222", 1)
+	if _, err := verifyInitData(tampered, testBotToken, now); err != errInitDataSignature {
+		t.Errorf("подмененный user: err = %v", err)
+	}
+	if _, err := verifyInitData(initData, testBotToken, now.Add(2*initDataMaxAge)); err != errInitDataExpired {
+		t.Errorf("устаревшие initData: err = %v", err)
+	}
+	if _, err := verifyInitData("user=%7B%7D", testBotToken, now); err != errInitDataSignature {
+		t.Errorf("без hash: err = %v", err)
+	}
+}
+
+// authRouter - маршруты с authenticate без базы: до загрузки пользователя
+// запросы в этих тестах не доходят
+func authRouter() *mux.Router {
+	router := mux.NewRouter()
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use((&App{}).authenticate)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	api.HandleFunc("/user/{telegramId}", ok)
+	api.HandleFunc("/user/{telegramId}/avatar", ok).Methods("GET")
+	api.HandleFunc("/tariffs", ok)
+	return router
+}
+
+func TestAuthenticateRejectsUnverifiedClaims(t *testing.T) {
+	t.Setenv("TELEGRAM_BOT_TOKEN", testBotToken)
+	t.Setenv("TELEGRAM_AUTH", "")
+	signed := signInitData(t, testBotToken, 111, time.Now())
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		initData string
+		want     int
+	}{
+		{"анонимный запрос", "GET", "/api/tariffs", "", "", http.StatusOK},
+		{"telegram_id без подписи", "GET", "/api/user/111", "", "", http.StatusUnauthorized},
+		{"неверная подпись", "GET", "/api/user/111", "", signed + "0", http.StatusUnauthorized},
+		{"чужой telegram_id", "GET", "/api/user/222", "", signed, http.StatusForbidden},
+		{"путь и строка запроса расходятся", "GET", "/api/user/111?telegram_id=222", "", signed, http.StatusBadRequest},
+		{"строка запроса и тело расходятся", "POST", "/api/tariffs?telegram_id=111", `{"telegram_id": 222}`, signed, http.StatusBadRequest},
+		{"чужой аватар открыт", "GET", "/api/user/222/avatar", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.body != "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			if tt.initData != "" {
+				r.Header.Set(initDataHeader, tt.initData)
+			}
+			w := httptest.NewRecorder()
+			authRouter().ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("статус %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+// Проверки сторон заказа и администратора опираются только на подписанного
+// пользователя: telegram_id без подписи не дает доступа ни к чему
+func TestOrderAccessUsesSignedCaller(t *testing.T) {
+	a := newTestApp(t)
+	t.Setenv("TELEGRAM_AUTH", "")
+	t.Setenv("TELEGRAM_BOT_TOKEN", testBotToken)
+	t.Setenv("ADMIN_TELEGRAM_IDS", "999")
+	clientID := testUser(t, a, 111, "client")
+	testUser(t, a, 333, "client")
+	testUser(t, a, 999, "client")
+	order := testOrder(t, a, clientID, 20)
+	if _, err := a.db.Exec(`UPDATE orders SET mode = ? WHERE id = ?`, OrderModeAuction, order.ID); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	for _, path := range []string{"messages", "bids", "documents", "payments", "receipts"} {
+		target := "/api/orders/" + strconv.FormatInt(order.ID, 10) + "/" + path
+		tests := []struct {
+			name     string
+			query    string
+			initData string
+			want     int
+		}{
+			{"клиент с подписью", "", signInitData(t, testBotToken, 111, now), http.StatusOK},
+			{"администратор с подписью", "", signInitData(t, testBotToken, 999, now), http.StatusOK},
+			{"посторонний с подписью", "", signInitData(t, testBotToken, 333, now), http.StatusForbidden},
+			{"посторонний выдает себя за клиента", "?telegram_id=111", signInitData(t, testBotToken, 333, now), http.StatusForbidden},
+			{"telegram_id клиента без подписи", "?telegram_id=111", "", http.StatusUnauthorized},
+			{"telegram_id администратора без подписи", "?telegram_id=999", "", http.StatusUnauthorized},
+			{"без пользователя", "", "", http.StatusUnauthorized},
+		}
+		for _, tt := range tests {
+			t.Run(path+"/"+tt.name, func(t *testing.T) {
+				var header []string
+				if tt.initData != "" {
+					header = []string{initDataHeader, tt.initData}
+				}
+				if code := doJSON(t, "GET", target+tt.query, nil, nil, header...); code != tt.want {
+					t.Errorf("статус %d, want %d", code, tt.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxAvatarSize     = 5 << 20 // 5 МБ
+	defaultAvatarSize = 128
+)
+
+// Стандартные размеры аватара (квадрат), px
+var avatarSizes = []int{64, 128, 512}
+
+func avatarBlobKey(baseKey string, size int) string {
+	return fmt.Sprintf("%s_%d.jpg", baseKey, size)
+}
+
+// saveAvatar обрезает фото до квадрата, сохраняет стандартные размеры без EXIF
+// и записывает пользователю ссылку на аватар в нашем API
+func (app *App) saveAvatar(ctx context.Context, user *User, data []byte) error {
+	img, err := decodeImage(data)
+	if err != nil {
+		return err
+	}
+	square := cropSquare(img)
+
+	version := randomHex(8)
+	baseKey := fmt.Sprintf("avatars/%d/%s", user.ID, version)
+	for _, size := range avatarSizes {
+		// Повторное кодирование в JPEG отбрасывает EXIF (геолокация, модель телефона)
+		resized, err := encodeJPEG(resizeImage(square, size))
+		if err != nil {
+			return err
+		}
+		if err := app.blobs.Put(ctx, avatarBlobKey(baseKey, size), "image/jpeg", resized); err != nil {
+			return err
+		}
+	}
+
+	oldKey, err := app.getAvatarKey(user.ID)
+	if err != nil {
+		return err
+	}
+	avatarURL := fmt.Sprintf("/api/user/%d/avatar?v=%s", user.TelegramID, version)
+	if _, err := app.db.Exec("UPDATE users SET avatar_url = ?, avatar_key = ? WHERE id = ?", avatarURL, baseKey, user.ID); err != nil {
+		return err
+	}
+	user.AvatarURL = &avatarURL
+
+	if oldKey != nil {
+		for _, size := range avatarSizes {
+			if err := app.blobs.Delete(ctx, avatarBlobKey(*oldKey, size)); err != nil {
+				log.Printf("Не удалось удалить старый аватар %s: %v", *oldKey, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (app *App) getAvatarKey(userID int64) (*string, error) {
+	var key *string
+	err := app.db.QueryRow("SELECT avatar_key FROM users WHERE id = ?", userID).Scan(&key)
+	return key, err
+}
+
+// importTelegramAvatar один раз забирает фото профиля через Bot API.
+// Возвращает false, если у пользователя нет фото или бот не настроен.
+func (app *App) importTelegramAvatar(ctx context.Context, user *User) (bool, error) {
+	if app.telegram == nil {
+		return false, nil
+	}
+	sizes, err := app.telegram.GetUserProfilePhotos(ctx, user.TelegramID)
+	if err != nil || len(sizes) == 0 {
+		return false, err
+	}
+	// Последний элемент - самый большой размер
+	file, err := app.telegram.GetFile(ctx, sizes[len(sizes)-1].FileID)
+	if err != nil {
+		return false, err
+	}
+	data, err := app.telegram.DownloadFile(ctx, file.FilePath, maxAvatarSize)
+	if err != nil {
+		return false, err
+	}
+	if err := app.saveAvatar(ctx, user, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// handleUploadAvatar - аватар меняет только сам пользователь: {telegramId} в пути
+// совпадает с авторизованным (см. authenticate)
+func (app *App) handleUploadAvatar(w http.ResponseWriter, r *http.Request) {
+	user := callerUser(w, r)
+	if user == nil {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize+1<<20)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeFileMissing, "Файл не передан или слишком большой")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarSize+1))
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if len(data) > maxAvatarSize {
+		writeErrorDetails(w, http.StatusRequestEntityTooLarge, CodeFileTooLarge, trf(responseLocale(w), "Файл больше %d МБ", maxAvatarSize>>20),
+			map[string]interface{}{"max_mb": maxAvatarSize >> 20})
+		return
+	}
+	if contentType := http.DetectContentType(data); contentType != "image/jpeg" && contentType != "image/png" {
+		writeError(w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Допустимы только JPEG и PNG")
+		return
+	}
+
+	if err := app.saveAvatar(r.Context(), user, data); err != nil {
+		writeImageError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": user})
+}
+
+// handleImportTelegramAvatar берет фото из профиля Telegram пользователя запроса
+func (app *App) handleImportTelegramAvatar(w http.ResponseWriter, r *http.Request) {
+	user := callerUser(w, r)
+	if user == nil {
+		return
+	}
+	if app.telegram == nil {
+		writeError(w, http.StatusServiceUnavailable, CodeServiceUnavailable, "Telegram бот не настроен")
+		return
+	}
+	imported, err := app.importTelegramAvatar(r.Context(), user)
+	if err != nil {
+		upstreamError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": user, "imported": imported})
+}
+
+// handleGetAvatar отдает аватар из хранилища; size - один из avatarSizes
+func (app *App) handleGetAvatar(w http.ResponseWriter, r *http.Request) {
+	telegramID, err := strconv.ParseInt(mux.Vars(r)["telegramId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidTelegramID, "Неверный telegram ID")
+		return
+	}
+	size := defaultAvatarSize
+	if param := r.URL.Query().Get("size"); param != "" {
+		size, _ = strconv.Atoi(param)
+	}
+	validSize := false
+	for _, s := range avatarSizes {
+		if s == size {
+			validSize = true
+		}
+	}
+	if !validSize {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Недопустимый размер аватара")
+		return
+	}
+
+	user, err := app.getUserByTelegramID(telegramID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if user == nil {
+		writeError(w, http.StatusNotFound, CodeUserNotFound, "Пользователь не найден")
+		return
+	}
+	key, err := app.getAvatarKey(user.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if key == nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Аватар не загружен")
+		return
+	}
+	data, contentType, err := app.blobs.Get(r.Context(), avatarBlobKey(*key, size))
+	if err == errBlobNotFound {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Аватар не найден")
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	// Ссылка меняется при каждой загрузке (параметр v), поэтому кэшируем надолго
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(data)
+}
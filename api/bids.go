@@ -0,0 +1,347 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Режимы заказа: instant - заказ забирает первый свободный бригадир,
+// auction - бригадиры присылают предложения, клиент выбирает одно из них
+const (
+	OrderModeInstant = "instant"
+	OrderModeAuction = "auction"
+)
+
+// Статусы предложений бригадиров
+const (
+	BidActive   = "active"
+	BidSelected = "selected"
+	BidRejected = "rejected"
+)
+
+const (
+	// Сколько часов по умолчанию принимаются предложения
+	auctionDefaultBidHours = 24
+	auctionMaxBidHours     = 7 * 24
+	// Самая поздняя дата начала работ по умолчанию - через столько дней после создания заказа
+	auctionDefaultStartDays = 30
+)
+
+// Bid - предложение бригадира по заказу в режиме торгов. Цена - за м², как в
+// тарифах; цена вне вилки тарифа допускается только с обоснованием.
+type Bid struct {
+	ID              int64     `json:"id"`
+	OrderID         int64     `json:"order_id"`
+	ContractorID    int64     `json:"contractor_id"`
+	Price           int       `json:"price"`
+	Amount          *int64    `json:"amount,omitempty"`
+	StartDate       string    `json:"start_date"`
+	Comment         *string   `json:"comment"`
+	Status          string    `json:"status"`
+	ContractorName  *string   `json:"contractor_name"`
+	AvatarURL       *string   `json:"avatar_url"`
+	Rating          float64   `json:"rating"`
+	CompletedOrders int       `json:"completed_orders"`
+	ExperienceYears *int      `json:"experience_years"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// orderBidding - сроки торгов, с которыми заказ создается в режиме auction
+type orderBidding struct {
+	Deadline time.Time
+	StartBy  string
+}
+
+// parseBiddingParams проверяет срок приема предложений (в часах) и крайнюю дату начала работ
+func parseBiddingParams(bidHours *int, startBy *string, now time.Time) (time.Time, string, error) {
+	hours := auctionDefaultBidHours
+	if bidHours != nil {
+		hours = *bidHours
+	}
+	if hours < 1 || hours > auctionMaxBidHours {
+		return time.Time{}, "", newUserError("срок приема предложений должен быть от 1 до %d часов", auctionMaxBidHours)
+	}
+	deadline := now.Add(time.Duration(hours) * time.Hour)
+	if startBy == nil || *startBy == "" {
+		return deadline, now.In(promoZone).AddDate(0, 0, auctionDefaultStartDays).Format("2006-01-02"), nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", *startBy, promoZone)
+	if err != nil {
+		return time.Time{}, "", newUserError("неверная дата %s, ожидается YYYY-MM-DD", *startBy)
+	}
+	if day.AddDate(0, 0, 1).Before(deadline) {
+		return time.Time{}, "", newUserError("крайняя дата начала работ раньше окончания приема предложений")
+	}
+	return deadline, *startBy, nil
+}
+
+func (app *App) getOrderBids(orderID int64, contractorID *int64) ([]Bid, error) {
+	query := `SELECT b.id, b.order_id, b.contractor_id, b.price, b.start_date, b.comment, b.status, b.created_at, b.updated_at,
+		u.name, u.avatar_url, COALESCE(cp.rating, 0), COALESCE(cp.completed_orders, 0), cp.experience_years
+		FROM order_bids b JOIN users u ON b.contractor_id = u.id LEFT JOIN contractor_profiles cp ON cp.user_id = u.id
+		WHERE b.order_id = ?`
+	args := []interface{}{orderID}
+	if contractorID != nil {
+		query += ` AND b.contractor_id = ?`
+		args = append(args, *contractorID)
+	}
+	rows, err := app.db.Query(query+` ORDER BY b.price, cp.rating DESC, b.created_at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bids := []Bid{}
+	for rows.Next() {
+		var bid Bid
+		var createdAt, updatedAt sql.NullString
+		if err := rows.Scan(&bid.ID, &bid.OrderID, &bid.ContractorID, &bid.Price, &bid.StartDate, &bid.Comment, &bid.Status, &createdAt, &updatedAt,
+			&bid.ContractorName, &bid.AvatarURL, &bid.Rating, &bid.CompletedOrders, &bid.ExperienceYears); err != nil {
+			return nil, err
+		}
+		if createdAt.Valid {
+			bid.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt.String)
+		}
+		if updatedAt.Valid {
+			bid.UpdatedAt, _ = time.Parse("2006-01-02 15:04:05", updatedAt.String)
+		}
+		bids = append(bids, bid)
+	}
+	return bids, rows.Err()
+}
+
+// bidAmount - стоимость работ по предложению в копейках
+func bidAmount(order *Order, price int) *int64 {
+	if order.Area == nil {
+		return nil
+	}
+	amount := int64(math.Round(*order.Area * float64(price) * 100))
+	return &amount
+}
+
+// bidsAccess - заказ в режиме торгов, который запрашивает пользователь запроса
+func (app *App) bidsAccess(w http.ResponseWriter, orderIDParam string, user *User) *Order {
+	if user == nil {
+		return nil
+	}
+	orderID, err := strconv.ParseInt(orderIDParam, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
+		return nil
+	}
+	order, err := app.getOrder(orderID)
+	if err != nil {
+		internalError(w, err)
+		return nil
+	}
+	if order == nil {
+		writeError(w, http.StatusNotFound, CodeOrderNotFound, "Заказ не найден")
+		return nil
+	}
+	if order.Mode != OrderModeAuction {
+		writeError(w, http.StatusConflict, CodeOrderState, "Заказ не в режиме торгов")
+		return nil
+	}
+	return order
+}
+
+// handleGetBids - клиент и администратор видят все предложения, бригадир - только свое
+func (app *App) handleGetBids(w http.ResponseWriter, r *http.Request) {
+	user := callerUser(w, r)
+	order := app.bidsAccess(w, mux.Vars(r)["orderId"], user)
+	if order == nil {
+		return
+	}
+	var contractorID *int64
+	if orderParty(order, user) != "client" && !isAdmin(user) {
+		if user.Role != "contractor" {
+			writeError(w, http.StatusForbidden, CodeOrderAccessDenied, "Нет доступа к заказу")
+			return
+		}
+		contractorID = &user.ID
+	}
+	bids, err := app.getOrderBids(order.ID, contractorID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	for i := range bids {
+		bids[i].Amount = bidAmount(order, bids[i].Price)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"bids": bids, "bid_deadline": order.BidDeadline, "start_by": order.StartBy})
+}
+
+// handleSubmitBid - бригадир подает или меняет предложение до окончания приема
+func (app *App) handleSubmitBid(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Price     int     `json:"price"`
+		StartDate string  `json:"start_date"`
+		Comment   *string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	user := callerUser(w, r)
+	order := app.bidsAccess(w, mux.Vars(r)["orderId"], user)
+	if order == nil {
+		return
+	}
+	if user.Role != "contractor" {
+		writeError(w, http.StatusBadRequest, CodeNotContractor, "Пользователь не является бригадиром")
+		return
+	}
+	if order.Status != "pending" || order.BidDeadline == nil || !time.Now().UTC().Before(*order.BidDeadline) {
+		writeError(w, http.StatusConflict, CodeOrderState, "Прием предложений по заказу закончен")
+		return
+	}
+
+	// Предлагать могут только бригадиры, которые работают по тарифу заказа и в его регионе
+	profile, err := app.getContractorProfile(user.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if profile == nil || !profile.IsActive {
+		writeError(w, http.StatusForbidden, CodeContractorProfile, "Профиль бригадира не активен")
+		return
+	}
+	if profile.Verification != VerificationVerified {
+		writeError(w, http.StatusForbidden, CodeContractorUnverified, "Профиль бригадира не подтвержден")
+		return
+	}
+	if profile.Categories != "[]" && !strings.Contains(profile.Categories, `"`+order.Category+`"`) {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Бригадир не работает по тарифу заказа")
+		return
+	}
+	if order.Region != nil && user.Region != nil && *order.Region != *user.Region {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Заказ в другом регионе")
+		return
+	}
+
+	if req.Price <= 0 {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Укажите цену за м²")
+		return
+	}
+	if req.Comment != nil {
+		trimmed := strings.TrimSpace(*req.Comment)
+		req.Comment = &trimmed
+	}
+	prices := orderPrices(order)
+	if (req.Price < prices.Min || req.Price > prices.Max) && (req.Comment == nil || *req.Comment == "") {
+		writeErrorDetails(w, http.StatusBadRequest, CodeValidation, trf(responseLocale(w), "Цена вне тарифа (%d–%d ₽/м²): укажите обоснование в comment", prices.Min, prices.Max),
+			map[string]interface{}{"field": "comment", "min_price": prices.Min, "max_price": prices.Max})
+		return
+	}
+	startDate, err := time.ParseInLocation("2006-01-02", req.StartDate, promoZone)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Укажите дату начала работ в формате YYYY-MM-DD")
+		return
+	}
+	today := time.Now().In(promoZone).Format("2006-01-02")
+	if startDate.Format("2006-01-02") < today || (order.StartBy != nil && startDate.Format("2006-01-02") > *order.StartBy) {
+		writeErrorDetails(w, http.StatusBadRequest, CodeValidation, trf(responseLocale(w), "Дата начала работ должна быть не раньше сегодняшней и не позже %s", derefString(order.StartBy)),
+			map[string]interface{}{"field": "start_date", "start_by": order.StartBy})
+		return
+	}
+
+	_, err = app.db.Exec(`INSERT INTO order_bids (order_id, contractor_id, price, start_date, comment) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(order_id, contractor_id) DO UPDATE SET price = excluded.price, start_date = excluded.start_date, comment = excluded.comment, updated_at = CURRENT_TIMESTAMP
+		WHERE order_bids.status = 'active'`,
+		order.ID, user.ID, req.Price, startDate.Format("2006-01-02"), req.Comment)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	bids, err := app.getOrderBids(order.ID, &user.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if len(bids) == 0 {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Предложение не сохранено")
+		return
+	}
+	bid := bids[0]
+	bid.Amount = bidAmount(order, bid.Price)
+	app.notifyBid(r.Context(), order, order.ClientTelegramID,
+		"🏷 Новое предложение по заказу №%d: %d ₽/м², начало работ %s", order.ID, bid.Price, startDate.Format("02.01.2006"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"bid": bid})
+}
+
+// handleSelectBid - клиент выбирает предложение. Цена предложения фиксируется в
+// заказе, дальше заказ принимается так же, как в обычном режиме.
+func (app *App) handleSelectBid(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	user := callerUser(w, r)
+	order := app.bidsAccess(w, vars["orderId"], user)
+	if order == nil {
+		return
+	}
+	if orderParty(order, user) != "client" {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Выбрать предложение может только клиент")
+		return
+	}
+	bidID, err := strconv.ParseInt(vars["bidId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный bid ID")
+		return
+	}
+	var bid Bid
+	err = app.db.QueryRow(`SELECT id, contractor_id, price, start_date, status FROM order_bids WHERE id = ? AND order_id = ?`, bidID, order.ID).
+		Scan(&bid.ID, &bid.ContractorID, &bid.Price, &bid.StartDate, &bid.Status)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeBidNotFound, "Предложение не найдено")
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if bid.Status != BidActive {
+		writeError(w, http.StatusConflict, CodeConflict, "Предложение уже неактуально")
+		return
+	}
+
+	// acceptOrder пропустит только один из одновременных выборов
+	if err := app.acceptOrder(order.ID, bid.ContractorID, &bid.Price); err != nil {
+		writeAcceptError(w, err)
+		return
+	}
+	if _, err := app.db.Exec(`UPDATE order_bids SET status = CASE WHEN id = ? THEN ? ELSE ? END, updated_at = CURRENT_TIMESTAMP WHERE order_id = ? AND status = ?`,
+		bid.ID, BidSelected, BidRejected, order.ID, BidActive); err != nil {
+		internalError(w, err)
+		return
+	}
+	order, err = app.onOrderAccepted(r.Context(), order.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	app.notifyBid(r.Context(), order, order.ContractorTelegramID,
+		"✅ Клиент выбрал ваше предложение по заказу №%d. Начало работ: %s", order.ID, bid.StartDate)
+	redactOrderContacts(order, user)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"order": order})
+}
+
+// notifyBid отправляет уведомление о торгах через бота на языке получателя, ошибки только логируются
+func (app *App) notifyBid(ctx context.Context, order *Order, telegramID *int64, format string, args ...interface{}) {
+	if app.telegram == nil || telegramID == nil {
+		return
+	}
+	if err := app.telegram.SendMessage(ctx, *telegramID, trf(app.telegramLocale(*telegramID), format, args...)); err != nil {
+		log.Printf("Не удалось отправить уведомление о торгах по заказу %d: %v", order.ID, err)
+	}
+}
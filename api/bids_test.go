@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestCreateAuctionOrder(t *testing.T) {
+	a := newTestApp(t)
+	contractorID := testUser(t, a, 333, "contractor")
+	if _, err := a.db.Exec(`INSERT INTO contractor_profiles (user_id, categories, verification_status) VALUES (?, '[]', ?)`, contractorID, VerificationVerified); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Order *Order `json:"order"`
+	}
+	body := map[string]interface{}{"category": "econom", "area": 20, "mode": OrderModeAuction, "bid_hours": 24}
+	if code := doJSON(t, "POST", "/api/orders?telegram_id=111", body, &resp); code != http.StatusOK {
+		t.Fatalf("заказ с торгами: статус %d", code)
+	}
+	if resp.Order.Mode != OrderModeAuction || resp.Order.BidDeadline == nil || resp.Order.StartBy == nil {
+		t.Fatalf("заказ создан без торгов: %+v", resp.Order)
+	}
+	if code := doJSON(t, "POST", fmt.Sprintf("/api/orders/%d/accept?telegram_id=333", resp.Order.ID), nil, nil); code != http.StatusConflict {
+		t.Errorf("прямое принятие заказа с торгами: статус %d, want 409", code)
+	}
+}
@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var errBlobNotFound = errors.New("файл не найден")
+
+// BlobStore - хранилище файлов (фото, планы, документы)
+type BlobStore interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, string, error)
+	Delete(ctx context.Context, key string) error
+	// SignedURL возвращает ссылку на скачивание, действующую ttl
+	SignedURL(key string, ttl time.Duration) (string, error)
+}
+
+// newBlobStoreFromEnv выбирает хранилище по BLOB_STORE: "s3" или локальная папка (по умолчанию).
+// prefix разделяет хранилища внутри одного бакета/папки.
+func newBlobStoreFromEnv(prefix string) BlobStore {
+	if os.Getenv("BLOB_STORE") == "s3" {
+		return &S3BlobStore{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    envOrDefault("S3_REGION", "us-east-1"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Prefix:    prefix,
+		}
+	}
+	// На Vercel запись возможна только в /tmp
+	dir := envOrDefault("BLOB_DIR", filepath.Join(os.TempDir(), "pol-strany-blobs"))
+	return &LocalBlobStore{
+		Dir:       filepath.Join(dir, prefix),
+		URLPrefix: "/api/files/" + prefix + "/",
+		SignKey:   blobSigningKey(),
+	}
+}
+
+// newPrivateBlobStoreFromEnv - хранилище документов бригадиров. В S3 это отдельный
+// приватный бакет S3_PRIVATE_BUCKET, локально - папка private. Без отдельного
+// бакета сканы паспортов попали бы в основной, поэтому это ошибка настройки.
+func newPrivateBlobStoreFromEnv() (BlobStore, error) {
+	store := newBlobStoreFromEnv("private")
+	if s3, ok := store.(*S3BlobStore); ok {
+		bucket := os.Getenv("S3_PRIVATE_BUCKET")
+		if bucket == "" || bucket == s3.Bucket {
+			return nil, fmt.Errorf("S3_PRIVATE_BUCKET не установлен или совпадает с S3_BUCKET")
+		}
+		s3.Bucket = bucket
+	}
+	return store, nil
+}
+
+func envOrDefault(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// blobSigningKey - ключ подписи ссылок локального хранилища (BLOB_SIGNING_KEY).
+// Ключ обязателен (см. checkBlobSigningKey): со случайным ключом ссылки,
+// подписанные одним инстансом, не открывались бы на другом.
+func blobSigningKey() []byte {
+	return []byte(os.Getenv("BLOB_SIGNING_KEY"))
+}
+
+// checkBlobSigningKey - приложение не запускается без BLOB_SIGNING_KEY
+func checkBlobSigningKey() error {
+	if os.Getenv("BLOB_SIGNING_KEY") == "" {
+		return fmt.Errorf("BLOB_SIGNING_KEY не установлен")
+	}
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LocalBlobStore хранит файлы в папке на диске и отдает их через /api/files/ по подписанным ссылкам
+type LocalBlobStore struct {
+	Dir       string
+	URLPrefix string
+	SignKey   []byte
+}
+
+func (s *LocalBlobStore) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", fmt.Errorf("недопустимый ключ: %s", key)
+	}
+	return filepath.Join(s.Dir, clean), nil
+}
+
+func (s *LocalBlobStore) Put(ctx context.Context, key, contentType string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (s *LocalBlobStore) Get(ctx context.Context, key string) ([]byte, string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", errBlobNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(key))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return data, contentType, nil
+}
+
+func (s *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalBlobStore) SignedURL(key string, ttl time.Duration) (string, error) {
+	expires := time.Now().Add(ttl).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sig", s.sign(key, expires))
+	return s.URLPrefix + key + "?" + query.Encode(), nil
+}
+
+func (s *LocalBlobStore) sign(key string, expires int64) string {
+	mac := hmac.New(sha256.New, s.SignKey)
+	fmt.Fprintf(mac, "%s|%d", key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify проверяет подпись и срок действия ссылки
+func (s *LocalBlobStore) verify(key, expiresParam, sig string) bool {
+	expires, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(s.sign(key, expires)))
+}
+
+// handleGetFile отдает файлы локальных хранилищ по подписанным ссылкам
+func (app *App) handleGetFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	store, ok := app.localStores()[vars["store"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeFileNotFound, "Файл не найден")
+		return
+	}
+	key := vars["key"]
+	if !store.verify(key, r.URL.Query().Get("expires"), r.URL.Query().Get("sig")) {
+		writeError(w, http.StatusForbidden, CodeFileNotFound, "Ссылка недействительна или устарела")
+		return
+	}
+	data, contentType, err := store.Get(r.Context(), key)
+	if err == errBlobNotFound {
+		writeError(w, http.StatusNotFound, CodeFileNotFound, "Файл не найден")
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Write(data)
+}
+
+// localStores - локальные хранилища приложения по имени префикса
+func (app *App) localStores() map[string]*LocalBlobStore {
+	stores := map[string]*LocalBlobStore{}
+	for _, store := range []BlobStore{app.blobs, app.private} {
+		if local, ok := store.(*LocalBlobStore); ok {
+			stores[strings.Trim(strings.TrimPrefix(local.URLPrefix, "/api/files/"), "/")] = local
+		}
+	}
+	return stores
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testS3AccessKey = "AKIDEXAMPLE"
+	testS3SecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testS3Region    = "ru-central1"
+)
+
+// fakeS3 - S3 в памяти. Подпись каждого запроса проверяется заново по тому,
+// что пришло по сети, поэтому тест ловит расхождения в экранировании пути,
+// заголовках и строке запроса.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeS3Object
+}
+
+type fakeS3Object struct {
+	data        []byte
+	contentType string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.verify(r) {
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		if sha256Hex(data) != r.Header.Get("X-Amz-Content-Sha256") {
+			http.Error(w, "<Error><Code>XAmzContentSHA256Mismatch</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = fakeS3Object{data: data, contentType: r.Header.Get("Content-Type")}
+	case http.MethodGet:
+		object, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		w.Write(object.data)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// verify проверяет подпись AWS Signature V4: из заголовка Authorization или
+// из параметров presigned-ссылки
+func (f *fakeS3) verify(r *http.Request) bool {
+	query := r.URL.Query()
+	var credential, signedHeaders, signature, amzDate, payloadHash string
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		fields := map[string]string{}
+		for _, part := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+			if name, value, ok := strings.Cut(part, "="); ok {
+				fields[name] = value
+			}
+		}
+		credential, signedHeaders, signature = fields["Credential"], fields["SignedHeaders"], fields["Signature"]
+		amzDate, payloadHash = r.Header.Get("X-Amz-Date"), r.Header.Get("X-Amz-Content-Sha256")
+	} else {
+		credential, signedHeaders, signature = query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders"), query.Get("X-Amz-Signature")
+		amzDate, payloadHash = query.Get("X-Amz-Date"), "UNSIGNED-PAYLOAD"
+		query.Del("X-Amz-Signature")
+		signedAt, err := time.Parse("20060102T150405Z", amzDate)
+		if err != nil || time.Since(signedAt) > time.Hour {
+			return false
+		}
+	}
+	accessKey, scope, ok := strings.Cut(credential, "/")
+	if !ok || accessKey != testS3AccessKey || len(amzDate) < 8 || scope != amzDate[:8]+"/"+testS3Region+"/s3/aws4_request" {
+		return false
+	}
+
+	var headers strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		pairs = append(pairs, url.QueryEscape(key)+"="+strings.ReplaceAll(url.QueryEscape(query.Get(key)), "+", "%20"))
+	}
+	canonical := strings.Join([]string{r.Method, r.URL.EscapedPath(), strings.Join(pairs, "&"), headers.String(), signedHeaders, payloadHash}, "\n")
+	digest := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := []byte("AWS4" + testS3SecretKey)
+	for _, part := range []string{amzDate[:8], testS3Region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	return hmac.Equal([]byte(hex.EncodeToString(key)), []byte(signature))
+}
+
+func newFakeS3Store(t *testing.T) (*S3BlobStore, *fakeS3) {
+	t.Helper()
+	fake := &fakeS3{objects: map[string]fakeS3Object{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return &S3BlobStore{
+		Endpoint:  server.URL,
+		Region:    testS3Region,
+		Bucket:    "pol-strany",
+		AccessKey: testS3AccessKey,
+		SecretKey: testS3SecretKey,
+		Prefix:    "public",
+		Client:    server.Client(),
+	}, fake
+}
+
+func TestS3BlobStoreRoundTrip(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	ctx := context.Background()
+	// Пробел, кириллица и плюс проверяют экранирование пути в подписи
+	key := "orders/15/план квартиры+1.png"
+	data := []byte("\x89PNG test")
+
+	if err := store.Put(ctx, key, "image/png", data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, ok := fake.objects["/pol-strany/public/"+key]; !ok {
+		t.Fatalf("объект не попал в бакет под префиксом: %v", fake.objects)
+	}
+	got, contentType, err := store.Get(ctx, key)
+	if err != nil || string(got) != string(data) || contentType != "image/png" {
+		t.Fatalf("Get = %q, %q, %v", got, contentType, err)
+	}
+
+	signed, err := store.SignedURL(key, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := store.Client.Get(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != string(data) {
+		t.Fatalf("presigned GET: %d %q", resp.StatusCode, body)
+	}
+	tampered := strings.Replace(signed, "X-Amz-Expires=60", "X-Amz-Expires=600000", 1)
+	resp, err = store.Client.Get(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("измененная presigned-ссылка: статус %d, want 403", resp.StatusCode)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, _, err := store.Get(ctx, key); !errors.Is(err, errBlobNotFound) {
+		t.Errorf("Get после Delete: %v, want errBlobNotFound", err)
+	}
+}
+
+func TestS3BlobStoreRejectedSignature(t *testing.T) {
+	store, _ := newFakeS3Store(t)
+	store.SecretKey = "другой-секрет"
+	if err := store.Put(context.Background(), "a.txt", "text/plain", []byte("x")); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Put с чужим ключом: %v, want ошибку 403", err)
+	}
+}
+
+func TestNewPrivateBlobStoreFromEnv(t *testing.T) {
+	t.Setenv("BLOB_STORE", "s3")
+	t.Setenv("S3_BUCKET", "pol-strany")
+	for _, bucket := range []string{"", "pol-strany"} {
+		t.Setenv("S3_PRIVATE_BUCKET", bucket)
+		if store, err := newPrivateBlobStoreFromEnv(); err == nil {
+			t.Errorf("S3_PRIVATE_BUCKET=%q: получили %+v, want ошибку", bucket, store)
+		}
+	}
+	t.Setenv("S3_PRIVATE_BUCKET", "pol-strany-private")
+	store, err := newPrivateBlobStoreFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s3 := store.(*S3BlobStore); s3.Bucket != "pol-strany-private" {
+		t.Errorf("бакет документов %s, want pol-strany-private", s3.Bucket)
+	}
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+)
+
+// MaterialNorm - нормы расхода материала для тарифа
+type MaterialNorm struct {
+	Material string `json:"material"`
+	// Расход смеси в кг на 1 м³ готового слоя
+	DensityKgM3 float64 `json:"densityKgM3"`
+	BagKg       float64 `json:"bagKg"`
+	// Допустимая толщина слоя, мм
+	MinThicknessMM     float64 `json:"minThicknessMm"`
+	MaxThicknessMM     float64 `json:"maxThicknessMm"`
+	DefaultThicknessMM float64 `json:"defaultThicknessMm"`
+	// Запас на отходы и неровности основания (0.05 = 5%)
+	Reserve float64 `json:"reserve"`
+	// Утеплитель входит в технологию (сухая стяжка)
+	Insulation bool `json:"insulation,omitempty"`
+}
+
+// Площадь листа утеплителя (ЭППС 1200×600), м²
+const insulationSheetM2 = 0.72
+
+// Нормы расхода по тарифам. Можно переопределить через переменную окружения
+// MATERIAL_NORMS (JSON в том же формате, ключи - тарифы)
+var MATERIAL_NORMS = map[string]MaterialNorm{
+	"econom": {
+		Material:           "Цементно-песчаная смесь М150",
+		DensityKgM3:        2000,
+		BagKg:              50,
+		MinThicknessMM:     30,
+		MaxThicknessMM:     100,
+		DefaultThicknessMM: 50,
+		Reserve:            0.05,
+	},
+	"comfort": {
+		Material:           "Полусухая цементно-песчаная смесь",
+		DensityKgM3:        1900,
+		BagKg:              50,
+		MinThicknessMM:     40,
+		MaxThicknessMM:     100,
+		DefaultThicknessMM: 60,
+		Reserve:            0.05,
+	},
+	"premium": {
+		Material:           "Сухая засыпка Кнауф",
+		DensityKgM3:        500,
+		BagKg:              20,
+		MinThicknessMM:     20,
+		MaxThicknessMM:     100,
+		DefaultThicknessMM: 40,
+		Reserve:            0.03,
+		Insulation:         true,
+	},
+	"self-leveling": {
+		Material:           "Самовыравнивающаяся смесь",
+		DensityKgM3:        1600,
+		BagKg:              25,
+		MinThicknessMM:     3,
+		MaxThicknessMM:     30,
+		DefaultThicknessMM: 10,
+		Reserve:            0.05,
+	},
+}
+
+// MaterialsEstimate - расчет материалов для стяжки
+type MaterialsEstimate struct {
+	Category         string  `json:"category"`
+	Material         string  `json:"material"`
+	Area             float64 `json:"area"`
+	ThicknessMM      float64 `json:"thickness_mm"`
+	VolumeM3         float64 `json:"volume_m3"`
+	MassKg           float64 `json:"mass_kg"`
+	BagKg            float64 `json:"bag_kg"`
+	Bags             int     `json:"bags"`
+	InsulationSheets int     `json:"insulation_sheets"`
+}
+
+func init() {
+	if raw := os.Getenv("MATERIAL_NORMS"); raw != "" {
+		var norms map[string]MaterialNorm
+		if err := json.Unmarshal([]byte(raw), &norms); err == nil {
+			for key, norm := range norms {
+				MATERIAL_NORMS[key] = norm
+			}
+		}
+	}
+}
+
+// estimateMaterials считает объем, массу и количество мешков смеси.
+// thicknessMM = 0 - берется типовая толщина для тарифа.
+func estimateMaterials(category string, area, thicknessMM float64, insulation bool) (*MaterialsEstimate, error) {
+	norm, ok := MATERIAL_NORMS[category]
+	if !ok {
+		return nil, newUserError("для тарифа %s нет норм расхода", category)
+	}
+	if area <= 0 {
+		return nil, newUserError("площадь должна быть больше нуля")
+	}
+	if thicknessMM == 0 {
+		thicknessMM = norm.DefaultThicknessMM
+	}
+	if thicknessMM < norm.MinThicknessMM || thicknessMM > norm.MaxThicknessMM {
+		return nil, newUserError("толщина слоя для тарифа %s должна быть от %g до %g мм", category, norm.MinThicknessMM, norm.MaxThicknessMM)
+	}
+
+	volume := area * thicknessMM / 1000 * (1 + norm.Reserve)
+	mass := volume * norm.DensityKgM3
+
+	estimate := &MaterialsEstimate{
+		Category:    category,
+		Material:    norm.Material,
+		Area:        area,
+		ThicknessMM: thicknessMM,
+		VolumeM3:    math.Round(volume*100) / 100,
+		MassKg:      math.Round(mass),
+		BagKg:       norm.BagKg,
+		Bags:        int(math.Ceil(mass / norm.BagKg)),
+	}
+	if norm.Insulation || insulation {
+		estimate.InsulationSheets = int(math.Ceil(area * (1 + norm.Reserve) / insulationSheetM2))
+	}
+	return estimate, nil
+}
+
+// orderMaterials - список материалов для заказа, если для тарифа есть нормы
+func orderMaterials(order *Order) *MaterialsEstimate {
+	if order.Area == nil {
+		return nil
+	}
+	var thickness float64
+	if order.ThicknessMM != nil {
+		thickness = *order.ThicknessMM
+	}
+	estimate, err := estimateMaterials(order.Category, *order.Area, thickness, false)
+	if err != nil {
+		return nil
+	}
+	return estimate
+}
+
+func (app *App) handleCalculateMaterials(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Category    string  `json:"category"`
+		Area        float64 `json:"area"`
+		ThicknessMM float64 `json:"thickness_mm"`
+		Insulation  bool    `json:"insulation"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	estimate, err := estimateMaterials(req.Category, req.Area, req.ThicknessMM, req.Insulation)
+	if err != nil {
+		writeUserError(w, http.StatusBadRequest, CodeValidation, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"materials": estimate})
+}
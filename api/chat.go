@@ -0,0 +1,392 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxMessageLength   = 4000
+	maxChatImageSize   = 10 << 20 // 10 МБ
+	chatImageMaxSide   = 1600
+	chatStreamDuration = 25 * time.Second // Vercel обрывает долгие запросы, EventSource переподключится сам
+	chatPollInterval   = 2 * time.Second
+	// Пользователь считается в сети, если был активен в чате за это время
+	onlineWindow = 60 * time.Second
+)
+
+type Message struct {
+	ID            int64      `json:"id"`
+	OrderID       int64      `json:"order_id"`
+	SenderID      int64      `json:"sender_id"`
+	SenderName    *string    `json:"sender_name"`
+	Text          *string    `json:"text"`
+	AttachmentURL *string    `json:"attachment_url"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ReadAt        *time.Time `json:"read_at"`
+	attachmentKey *string
+}
+
+func (app *App) createMessage(orderID, senderID int64, text, attachmentKey *string) (int64, error) {
+	result, err := app.db.Exec("INSERT INTO order_messages (order_id, sender_id, text, attachment_key) VALUES (?, ?, ?, ?)", orderID, senderID, text, attachmentKey)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// getMessages возвращает сообщения заказа с id больше afterID
+func (app *App) getMessages(orderID, afterID int64, limit int) ([]Message, error) {
+	rows, err := app.db.Query(`SELECT m.id, m.order_id, m.sender_id, u.name, m.text, m.attachment_key, m.created_at, m.read_at FROM order_messages m LEFT JOIN users u ON m.sender_id = u.id WHERE m.order_id = ? AND m.id > ? ORDER BY m.id LIMIT ?`, orderID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	messages := []Message{}
+	for rows.Next() {
+		var m Message
+		var createdAt, readAt sql.NullString
+		if err := rows.Scan(&m.ID, &m.OrderID, &m.SenderID, &m.SenderName, &m.Text, &m.attachmentKey, &createdAt, &readAt); err != nil {
+			return nil, err
+		}
+		if createdAt.Valid && createdAt.String != "" {
+			m.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt.String)
+		}
+		if readAt.Valid && readAt.String != "" {
+			t, _ := time.Parse("2006-01-02 15:04:05", readAt.String)
+			m.ReadAt = &t
+		}
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+// markMessagesRead отмечает прочитанными чужие сообщения до upToID включительно
+func (app *App) markMessagesRead(orderID, readerID, upToID int64) error {
+	_, err := app.db.Exec(`UPDATE order_messages SET read_at = CURRENT_TIMESTAMP WHERE order_id = ? AND sender_id != ? AND id <= ? AND read_at IS NULL`, orderID, readerID, upToID)
+	return err
+}
+
+// lastReadMessageID - последнее сообщение отправителя, прочитанное собеседником
+func (app *App) lastReadMessageID(orderID, senderID int64) (int64, error) {
+	var id sql.NullInt64
+	err := app.db.QueryRow(`SELECT MAX(id) FROM order_messages WHERE order_id = ? AND sender_id = ? AND read_at IS NOT NULL`, orderID, senderID).Scan(&id)
+	return id.Int64, err
+}
+
+func (app *App) touchLastSeen(userID int64) error {
+	_, err := app.db.Exec("UPDATE users SET last_seen_at = CURRENT_TIMESTAMP WHERE id = ?", userID)
+	return err
+}
+
+func (app *App) isOnline(userID int64) (bool, error) {
+	var online bool
+	err := app.db.QueryRow(`SELECT COALESCE(last_seen_at >= datetime('now', ?), 0) FROM users WHERE id = ?`, fmt.Sprintf("-%d seconds", int(onlineWindow.Seconds())), userID).Scan(&online)
+	return online, err
+}
+
+func (app *App) signMessageURLs(messages []Message) error {
+	for i := range messages {
+		if messages[i].attachmentKey == nil {
+			continue
+		}
+		url, err := app.blobs.SignedURL(*messages[i].attachmentKey, signedURLTTL)
+		if err != nil {
+			return err
+		}
+		messages[i].AttachmentURL = &url
+	}
+	return nil
+}
+
+// chatAccess проверяет, что пользователь запроса - клиент или бригадир заказа
+// либо администратор. При отказе сам пишет ответ и возвращает nil.
+func (app *App) chatAccess(w http.ResponseWriter, orderIDParam string, user *User) *Order {
+	if user == nil {
+		return nil
+	}
+	orderID, err := strconv.ParseInt(orderIDParam, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
+		return nil
+	}
+	order, err := app.getOrder(orderID)
+	if err != nil {
+		internalError(w, err)
+		return nil
+	}
+	if order == nil {
+		writeError(w, http.StatusNotFound, CodeOrderNotFound, "Заказ не найден")
+		return nil
+	}
+	if orderParty(order, user) == "" && !isAdmin(user) {
+		writeError(w, http.StatusForbidden, CodeOrderAccessDenied, "Нет доступа к чату заказа")
+		return nil
+	}
+	return order
+}
+
+// relayMessage уведомляет через бота участников заказа, которые сейчас не в сети
+func (app *App) relayMessage(ctx context.Context, order *Order, sender *User, message *Message) {
+	if app.telegram == nil {
+		return
+	}
+	type recipient struct {
+		userID     int64
+		telegramID *int64
+	}
+	recipients := []recipient{{order.ClientID, order.ClientTelegramID}}
+	if order.ContractorID != nil {
+		recipients = append(recipients, recipient{*order.ContractorID, order.ContractorTelegramID})
+	}
+
+	for _, rcpt := range recipients {
+		if rcpt.userID == sender.ID || rcpt.telegramID == nil {
+			continue
+		}
+		online, err := app.isOnline(rcpt.userID)
+		if err != nil || online {
+			continue
+		}
+		locale := app.telegramLocale(*rcpt.telegramID)
+		senderName := tr(locale, "Собеседник")
+		if sender.Name != nil {
+			senderName = *sender.Name
+		}
+		text := tr(locale, "[фото]")
+		if message.Text != nil {
+			text = *message.Text
+		}
+		notification := trf(locale, "💬 Заказ №%d, %s:\n%s", order.ID, senderName, text)
+		if err := app.telegram.SendMessage(ctx, *rcpt.telegramID, notification); err != nil {
+			log.Printf("Не удалось отправить уведомление о сообщении %d: %v", message.ID, err)
+		}
+	}
+}
+
+func (app *App) handleGetMessages(w http.ResponseWriter, r *http.Request) {
+	user := callerUser(w, r)
+	order := app.chatAccess(w, mux.Vars(r)["orderId"], user)
+	if order == nil {
+		return
+	}
+	afterID, _ := strconv.ParseInt(r.URL.Query().Get("after_id"), 10, 64)
+	messages, err := app.getMessages(order.ID, afterID, 200)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if err := app.signMessageURLs(messages); err != nil {
+		internalError(w, err)
+		return
+	}
+	app.touchLastSeen(user.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"messages": messages})
+}
+
+// handleSendMessage принимает JSON {text} или multipart с полями text и file (изображение)
+func (app *App) handleSendMessage(w http.ResponseWriter, r *http.Request) {
+	var user *User
+	var text string
+	var image []byte
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.Body = http.MaxBytesReader(w, r.Body, maxChatImageSize+1<<20)
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Файл слишком большой или неверный формат данных")
+			return
+		}
+		if user = app.formUser(w, r); user == nil {
+			return
+		}
+		text = r.FormValue("text")
+		if file, _, err := r.FormFile("file"); err == nil {
+			defer file.Close()
+			image, err = io.ReadAll(io.LimitReader(file, maxChatImageSize+1))
+			if err != nil {
+				internalError(w, err)
+				return
+			}
+		}
+	} else {
+		var req struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+			return
+		}
+		if user = callerUser(w, r); user == nil {
+			return
+		}
+		text = req.Text
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" && image == nil {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Пустое сообщение")
+		return
+	}
+	if len([]rune(text)) > maxMessageLength {
+		writeErrorDetails(w, http.StatusBadRequest, CodeValidation, trf(responseLocale(w), "Сообщение длиннее %d символов", maxMessageLength),
+			map[string]interface{}{"field": "text", "max_length": maxMessageLength})
+		return
+	}
+	if len(image) > maxChatImageSize {
+		writeErrorDetails(w, http.StatusRequestEntityTooLarge, CodeFileTooLarge, trf(responseLocale(w), "Файл больше %d МБ", maxChatImageSize>>20),
+			map[string]interface{}{"max_mb": maxChatImageSize >> 20})
+		return
+	}
+
+	order := app.chatAccess(w, mux.Vars(r)["orderId"], user)
+	if order == nil {
+		return
+	}
+
+	var attachmentKey *string
+	if image != nil {
+		if contentType := http.DetectContentType(image); contentType != "image/jpeg" && contentType != "image/png" {
+			writeError(w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Допустимы только JPEG и PNG")
+			return
+		}
+		img, err := decodeImage(image)
+		if err != nil {
+			writeImageError(w, err)
+			return
+		}
+		// Перекодирование уменьшает фото и убирает EXIF
+		data, err := encodeJPEG(resizeImage(img, chatImageMaxSide))
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		key := fmt.Sprintf("chat/%d/%s.jpg", order.ID, randomHex(16))
+		if err := app.blobs.Put(r.Context(), key, "image/jpeg", data); err != nil {
+			internalError(w, err)
+			return
+		}
+		attachmentKey = &key
+	}
+
+	var textPtr *string
+	if text != "" {
+		textPtr = &text
+	}
+	messageID, err := app.createMessage(order.ID, user.ID, textPtr, attachmentKey)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	app.touchLastSeen(user.ID)
+
+	messages, err := app.getMessages(order.ID, messageID-1, 1)
+	if err != nil || len(messages) == 0 {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Не удалось получить сообщение")
+		return
+	}
+	if err := app.signMessageURLs(messages); err != nil {
+		internalError(w, err)
+		return
+	}
+	app.relayMessage(r.Context(), order, user, &messages[0])
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": messages[0]})
+}
+
+func (app *App) handleReadMessages(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UpToID int64 `json:"up_to_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	user := callerUser(w, r)
+	order := app.chatAccess(w, mux.Vars(r)["orderId"], user)
+	if order == nil {
+		return
+	}
+	// Отметки о прочтении ставят только участники заказа, не администраторы
+	if orderParty(order, user) != "" {
+		if err := app.markMessagesRead(order.ID, user.ID, req.UpToID); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+	app.touchLastSeen(user.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// handleMessagesStream - Server-Sent Events: новые сообщения (event: message)
+// и отметки о прочтении своих сообщений (event: read). Поток закрывается через
+// chatStreamDuration, клиент переподключается с Last-Event-ID.
+func (app *App) handleMessagesStream(w http.ResponseWriter, r *http.Request) {
+	user := callerUser(w, r)
+	order := app.chatAccess(w, mux.Vars(r)["orderId"], user)
+	if order == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Потоковая передача не поддерживается")
+		return
+	}
+
+	lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	if lastID == 0 {
+		lastID, _ = strconv.ParseInt(r.URL.Query().Get("after_id"), 10, 64)
+	}
+	var lastRead int64 = -1
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fmt.Fprintf(w, "retry: %d\n\n", chatPollInterval.Milliseconds())
+	flusher.Flush()
+
+	ticker := time.NewTicker(chatPollInterval)
+	defer ticker.Stop()
+	deadline := time.After(chatStreamDuration)
+	for {
+		app.touchLastSeen(user.ID)
+
+		messages, err := app.getMessages(order.ID, lastID, 100)
+		if err != nil {
+			log.Printf("Ошибка чтения сообщений заказа %d: %v", order.ID, err)
+			return
+		}
+		app.signMessageURLs(messages)
+		for _, m := range messages {
+			data, _ := json.Marshal(m)
+			fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", m.ID, data)
+			lastID = m.ID
+		}
+
+		readID, err := app.lastReadMessageID(order.ID, user.ID)
+		if err == nil && readID != lastRead {
+			fmt.Fprintf(w, "event: read\ndata: {\"up_to_id\": %d}\n\n", readID)
+			lastRead = readID
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-deadline:
+			return
+		case <-ticker.C:
+		}
+	}
+}
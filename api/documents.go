@@ -0,0 +1,544 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Документы по заказу: договор формируется при принятии заказа, акт - при завершении
+const (
+	DocumentContract = "contract"
+	DocumentAct      = "act"
+)
+
+// Ошибки документов, о которых сообщается стороне заказа
+var (
+	errDocumentSigned  = errors.New("документ уже подписан и не может быть изменен")
+	errDocumentChanged = errors.New("файл документа изменен после формирования")
+)
+
+var documentTitles = map[string]string{
+	DocumentContract: "Договор подряда",
+	DocumentAct:      "Акт выполненных работ",
+}
+
+// OrderDocument - сформированный PDF. Файл хранится как вложение заказа, Hash -
+// SHA-256 файла. Подпись стороны - отметка времени и хеш от хеша документа,
+// пользователя и времени: по ним можно проверить, что подписан именно этот файл.
+type OrderDocument struct {
+	ID                  int64      `json:"id"`
+	OrderID             int64      `json:"order_id"`
+	Kind                string     `json:"kind"`
+	Title               string     `json:"title"`
+	AttachmentID        int64      `json:"attachment_id"`
+	Hash                string     `json:"hash"`
+	ClientSignedAt      *time.Time `json:"client_signed_at"`
+	ClientSignature     *string    `json:"client_signature"`
+	ContractorSignedAt  *time.Time `json:"contractor_signed_at"`
+	ContractorSignature *string    `json:"contractor_signature"`
+	CreatedAt           time.Time  `json:"created_at"`
+	URL                 string     `json:"url"`
+	blobKey             string
+}
+
+const documentColumns = `d.id, d.order_id, d.kind, d.attachment_id, d.hash, d.client_signed_at, d.client_signature, d.contractor_signed_at, d.contractor_signature, d.created_at, a.blob_key`
+
+func scanDocument(row interface{ Scan(...interface{}) error }) (*OrderDocument, error) {
+	var doc OrderDocument
+	var clientSignedAt, contractorSignedAt, createdAt sql.NullString
+	if err := row.Scan(&doc.ID, &doc.OrderID, &doc.Kind, &doc.AttachmentID, &doc.Hash, &clientSignedAt, &doc.ClientSignature,
+		&contractorSignedAt, &doc.ContractorSignature, &createdAt, &doc.blobKey); err != nil {
+		return nil, err
+	}
+	doc.Title = documentTitles[doc.Kind]
+	if clientSignedAt.Valid {
+		t, _ := time.Parse("2006-01-02 15:04:05", clientSignedAt.String)
+		doc.ClientSignedAt = &t
+	}
+	if contractorSignedAt.Valid {
+		t, _ := time.Parse("2006-01-02 15:04:05", contractorSignedAt.String)
+		doc.ContractorSignedAt = &t
+	}
+	if createdAt.Valid {
+		doc.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt.String)
+	}
+	return &doc, nil
+}
+
+func (app *App) getOrderDocuments(orderID int64) ([]OrderDocument, error) {
+	rows, err := app.db.Query(`SELECT `+documentColumns+` FROM order_documents d JOIN order_attachments a ON a.id = d.attachment_id WHERE d.order_id = ? ORDER BY d.id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	documents := []OrderDocument{}
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, *doc)
+	}
+	return documents, rows.Err()
+}
+
+func (app *App) getOrderDocument(orderID int64, kind string) (*OrderDocument, error) {
+	doc, err := scanDocument(app.db.QueryRow(`SELECT `+documentColumns+` FROM order_documents d JOIN order_attachments a ON a.id = d.attachment_id WHERE d.order_id = ? AND d.kind = ?`, orderID, kind))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return doc, err
+}
+
+// generateDocument формирует документ заказа и сохраняет его вложением.
+// Неподписанный документ пересоздается (например, после выбора цены в торгах),
+// подписанный не меняется.
+func (app *App) generateDocument(ctx context.Context, order *Order, kind string, uploaderID int64) (*OrderDocument, error) {
+	existing, err := app.getOrderDocument(order.ID, kind)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && (existing.ClientSignedAt != nil || existing.ContractorSignedAt != nil) {
+		return nil, errDocumentSigned
+	}
+
+	var data []byte
+	switch kind {
+	case DocumentContract:
+		data, err = app.renderContract(order)
+	case DocumentAct:
+		data, err = app.renderAct(order)
+	default:
+		return nil, fmt.Errorf("неизвестный вид документа: %s", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+
+	attachment := &Attachment{
+		OrderID:     order.ID,
+		UploaderID:  uploaderID,
+		Kind:        kind,
+		ContentType: "application/pdf",
+		Size:        int64(len(data)),
+		blobKey:     fmt.Sprintf("orders/%d/%s_%s.pdf", order.ID, kind, randomHex(8)),
+	}
+	if err := app.blobs.Put(ctx, attachment.blobKey, attachment.ContentType, data); err != nil {
+		return nil, err
+	}
+	if attachment.ID, err = app.createAttachment(attachment); err != nil {
+		return nil, err
+	}
+	if _, err := app.db.Exec(`INSERT INTO order_documents (order_id, kind, attachment_id, hash) VALUES (?, ?, ?, ?)
+		ON CONFLICT(order_id, kind) DO UPDATE SET attachment_id = excluded.attachment_id, hash = excluded.hash, created_at = CURRENT_TIMESTAMP`,
+		order.ID, kind, attachment.ID, hex.EncodeToString(sum[:])); err != nil {
+		return nil, err
+	}
+
+	// Прежняя версия больше не нужна
+	if existing != nil {
+		if _, err := app.db.Exec(`DELETE FROM order_attachments WHERE id = ?`, existing.AttachmentID); err != nil {
+			log.Printf("Не удалось удалить прежнюю версию документа %d: %v", existing.ID, err)
+		} else if err := app.blobs.Delete(ctx, existing.blobKey); err != nil {
+			log.Printf("Не удалось удалить файл прежней версии документа %d: %v", existing.ID, err)
+		}
+	}
+	return app.getOrderDocument(order.ID, kind)
+}
+
+// documentParties - наименования сторон для документов. Для бригадира берутся
+// реквизиты для выплат, если они заполнены.
+func (app *App) documentParties(order *Order) (client, contractor string, err error) {
+	client = derefString(order.ClientName)
+	if order.ClientPhone != nil {
+		client += ", тел. " + *order.ClientPhone
+	}
+	contractor = derefString(order.ContractorName)
+	if order.ContractorID == nil {
+		return client, contractor, nil
+	}
+	var payoutName, payoutINN sql.NullString
+	err = app.db.QueryRow(`SELECT payout_name, payout_inn FROM contractor_profiles WHERE user_id = ?`, *order.ContractorID).Scan(&payoutName, &payoutINN)
+	if err != nil && err != sql.ErrNoRows {
+		return "", "", err
+	}
+	if payoutName.String != "" {
+		contractor = payoutName.String
+	}
+	if payoutINN.String != "" {
+		contractor += ", ИНН " + payoutINN.String
+	}
+	return client, contractor, nil
+}
+
+func documentDate(t time.Time) string {
+	return t.In(promoZone).Format("02.01.2006")
+}
+
+// formatArea - площадь без лишних нулей: 30 м², 30,5 м²
+func formatArea(area float64) string {
+	return strings.Replace(strconv.FormatFloat(area, 'f', -1, 64), ".", ",", 1) + " м²"
+}
+
+// formatRubles - сумма в копейках для документов: 12 345,50 ₽
+func formatRubles(kopecks int64) string {
+	whole := strconv.FormatInt(kopecks/100, 10)
+	var grouped []string
+	for len(whole) > 3 {
+		grouped = append([]string{whole[len(whole)-3:]}, grouped...)
+		whole = whole[:len(whole)-3]
+	}
+	grouped = append([]string{whole}, grouped...)
+	result := strings.Join(grouped, " ")
+	if kopecks%100 != 0 {
+		result += fmt.Sprintf(",%02d", kopecks%100)
+	}
+	return result + " ₽"
+}
+
+func signatureLines(d *pdfDocument, client, contractor string) {
+	d.space(24)
+	d.paragraph("Заказчик: ____________________ / "+client+" /", 10)
+	d.space(12)
+	d.paragraph("Подрядчик: ____________________ / "+contractor+" /", 10)
+	d.space(12)
+	d.paragraph("Документ подписывается сторонами в приложении: при подписании фиксируются дата, время и контрольная сумма (SHA-256) файла.", 8)
+}
+
+func (app *App) renderContract(order *Order) ([]byte, error) {
+	client, contractor, err := app.documentParties(order)
+	if err != nil {
+		return nil, err
+	}
+	tariff := TARIFFS[order.Category]
+	prices := orderPrices(order)
+	quote := orderQuote(order)
+	escrow, err := app.getEscrow(order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := newPDFDocument()
+	if err != nil {
+		return nil, err
+	}
+	d.heading(fmt.Sprintf("ДОГОВОР ПОДРЯДА № %d", order.ID), 14)
+	date := time.Now()
+	if order.AcceptedAt != nil {
+		date = *order.AcceptedAt
+	}
+	d.heading(documentDate(date), 10)
+	d.space(10)
+	d.paragraph(fmt.Sprintf("%s, именуемый в дальнейшем «Заказчик», и %s, именуемый в дальнейшем «Подрядчик», заключили настоящий договор о нижеследующем.", client, contractor), 10)
+
+	d.space(8)
+	d.paragraph("1. Предмет договора", 11)
+	d.paragraph(fmt.Sprintf("1.1. Подрядчик обязуется выполнить работы по устройству стяжки пола по тарифу «%s» (%s), а Заказчик - принять и оплатить их.", tariff.Name, tariff.Description), 10)
+	object := "1.2. Адрес объекта: " + derefString(order.Address)
+	if order.Address == nil {
+		object = "1.2. Адрес объекта указывается Заказчиком в заказе"
+	}
+	if order.Area != nil {
+		object += ". Площадь: " + formatArea(*order.Area)
+	}
+	if order.ThicknessMM != nil {
+		object += fmt.Sprintf(". Толщина стяжки: %s мм", strconv.FormatFloat(*order.ThicknessMM, 'f', -1, 64))
+	}
+	d.paragraph(object+".", 10)
+	if len(tariff.Features) > 0 {
+		d.paragraph("1.3. В работы входит: "+strings.Join(tariff.Features, ", ")+".", 10)
+	}
+
+	d.space(8)
+	d.paragraph("2. Стоимость и порядок оплаты", 11)
+	if prices.Min == prices.Max {
+		d.paragraph(fmt.Sprintf("2.1. Цена работ - %d ₽ за м².", prices.Max), 10)
+	} else {
+		d.paragraph(fmt.Sprintf("2.1. Цена работ - от %d до %d ₽ за м², окончательная цена определяется по результату осмотра объекта.", prices.Min, prices.Max), 10)
+	}
+	if quote != nil {
+		total := "2.2. Стоимость работ: " + formatRubles(quote.Max)
+		if quote.Min != quote.Max {
+			total = fmt.Sprintf("2.2. Стоимость работ: от %s до %s", formatRubles(quote.Min), formatRubles(quote.Max))
+		}
+		if quote.Discount > 0 {
+			total += fmt.Sprintf(" с учетом скидки по промокоду %s (%s)", quote.PromoCode, formatRubles(quote.Discount))
+		}
+		d.paragraph(total+".", 10)
+	}
+	if escrow != nil {
+		d.paragraph(fmt.Sprintf("2.3. Заказчик вносит через сервис предоплату %s. Предоплата удерживается сервисом и перечисляется Подрядчику после подписания Заказчиком акта выполненных работ либо через %d ч после завершения работ, если Заказчик не заявил возражений.",
+			formatRubles(escrow.Amount), int(escrowAutoConfirm().Hours())), 10)
+	} else {
+		d.paragraph("2.3. Оплата производится после подписания сторонами акта выполненных работ.", 10)
+	}
+
+	if schedule := orderSchedule(order); schedule != nil {
+		d.space(8)
+		d.paragraph("3. Сроки", 11)
+		d.paragraph(fmt.Sprintf("3.1. Начало работ: %s. Продолжительность работ: %d раб. дн., окончание работ не позднее %s.",
+			documentDate(schedule.StartDate), schedule.WorkDays, documentDate(schedule.WorkEndDate)), 10)
+		d.paragraph(fmt.Sprintf("3.2. Стяжка набирает прочность до %s. Укладка плитки - не ранее %s, ламината - не ранее %s.",
+			documentDate(schedule.CompletionDate), documentDate(schedule.TileReadyDate), documentDate(schedule.LaminateReadyDate)), 10)
+	}
+
+	d.space(8)
+	d.paragraph("4. Приемка работ", 11)
+	d.paragraph("4.1. По окончании работ стороны подписывают акт выполненных работ в приложении. Подписание в приложении подтверждает согласие стороны с документом.", 10)
+	d.paragraph("4.2. Переписка сторон в чате заказа является частью договора.", 10)
+
+	signatureLines(d, derefString(order.ClientName), derefString(order.ContractorName))
+	return d.bytes()
+}
+
+func (app *App) renderAct(order *Order) ([]byte, error) {
+	client, contractor, err := app.documentParties(order)
+	if err != nil {
+		return nil, err
+	}
+	tariff := TARIFFS[order.Category]
+	prices := orderPrices(order)
+	quote := orderQuote(order)
+	if quote == nil {
+		return nil, fmt.Errorf("для акта нужна площадь заказа")
+	}
+
+	d, err := newPDFDocument()
+	if err != nil {
+		return nil, err
+	}
+	d.heading(fmt.Sprintf("АКТ ВЫПОЛНЕННЫХ РАБОТ № %d", order.ID), 14)
+	d.heading(fmt.Sprintf("к договору подряда № %d", order.ID), 10)
+	date := time.Now()
+	if order.CompletedAt != nil {
+		date = *order.CompletedAt
+	}
+	d.heading(documentDate(date), 10)
+	d.space(10)
+	d.paragraph(fmt.Sprintf("Заказчик %s и Подрядчик %s составили настоящий акт о том, что Подрядчик выполнил, а Заказчик принял следующие работы:", client, contractor), 10)
+	d.space(8)
+
+	widths := []float64{0.06, 0.46, 0.14, 0.16, 0.18}
+	d.tableRow([]string{"№", "Наименование работ", "Кол-во", "Цена за м²", "Сумма"}, widths, 9)
+	work := fmt.Sprintf("Устройство стяжки пола, тариф «%s»", tariff.Name)
+	if order.Address != nil {
+		work += ", " + *order.Address
+	}
+	d.tableRow([]string{"1", work, formatArea(quote.Area), formatRubles(int64(prices.Max) * 100), formatRubles(quote.Max + quote.Discount)}, widths, 9)
+	if quote.Discount > 0 {
+		d.tableRow([]string{"", "Скидка по промокоду " + quote.PromoCode, "", "", "-" + formatRubles(quote.Discount)}, widths, 9)
+	}
+	d.tableRow([]string{"", "Итого", "", "", formatRubles(quote.Max)}, widths, 9)
+
+	d.space(10)
+	d.paragraph(fmt.Sprintf("Всего выполнено работ на сумму %s. НДС не облагается.", formatRubles(quote.Max)), 10)
+	d.paragraph("Работы выполнены полностью. Заказчик претензий по объему, качеству и срокам выполнения работ не имеет.", 10)
+
+	signatureLines(d, derefString(order.ClientName), derefString(order.ContractorName))
+	return d.bytes()
+}
+
+// generateOrderDocument - формирование документа из обработчиков заказа: ошибка
+// не мешает принятию или завершению заказа и только логируется
+func (app *App) generateOrderDocument(ctx context.Context, orderID int64, kind string, uploaderID int64) {
+	order, err := app.getOrder(orderID)
+	if err == nil && order != nil {
+		_, err = app.generateDocument(ctx, order, kind, uploaderID)
+	}
+	if err != nil {
+		log.Printf("Не удалось сформировать документ %s по заказу %d: %v", kind, orderID, err)
+	}
+}
+
+// signDocument записывает подпись стороны. Перед подписью файл перечитывается
+// из хранилища и сверяется с хешем, сохраненным при формировании.
+func (app *App) signDocument(ctx context.Context, doc *OrderDocument, party string, user *User) error {
+	data, _, err := app.blobs.Get(ctx, doc.blobKey)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != doc.Hash {
+		return errDocumentChanged
+	}
+	signedAt := time.Now().UTC().Format("2006-01-02 15:04:05")
+	signature := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%s", doc.Hash, user.TelegramID, signedAt)))
+
+	// Подпись ставится один раз, повторный вызов ничего не меняет
+	_, err = app.db.Exec(`UPDATE order_documents SET `+party+`_signed_at = ?, `+party+`_signature = ? WHERE id = ? AND `+party+`_signed_at IS NULL`,
+		signedAt, hex.EncodeToString(signature[:]), doc.ID)
+	return err
+}
+
+// migrateAttachmentKinds расширяет CHECK вида вложения для документов.
+// SQLite не умеет менять ограничения, поэтому таблица пересоздается.
+func (app *App) migrateAttachmentKinds() error {
+	var schema string
+	if err := app.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'order_attachments'`).Scan(&schema); err != nil {
+		return err
+	}
+	if strings.Contains(schema, "'act'") {
+		return nil
+	}
+	queries := []string{
+		`CREATE TABLE order_attachments_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			order_id INTEGER NOT NULL,
+			uploader_id INTEGER NOT NULL,
+			kind TEXT NOT NULL CHECK(kind IN ('photo', 'plan', 'before', 'after', 'contract', 'act')),
+			blob_key TEXT NOT NULL,
+			thumbnail_key TEXT,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (order_id) REFERENCES orders(id),
+			FOREIGN KEY (uploader_id) REFERENCES users(id)
+		)`,
+		`INSERT INTO order_attachments_new SELECT id, order_id, uploader_id, kind, blob_key, thumbnail_key, content_type, size, created_at FROM order_attachments`,
+		`DROP TABLE order_attachments`,
+		`ALTER TABLE order_attachments_new RENAME TO order_attachments`,
+	}
+	// Копирование и замена таблицы - одной транзакцией, чтобы сбой посередине
+	// не оставил базу без order_attachments
+	tx, err := app.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// handleGetDocuments - документы заказа со ссылками на PDF (стороны заказа и администратор)
+func (app *App) handleGetDocuments(w http.ResponseWriter, r *http.Request) {
+	order := app.escrowAccess(w, mux.Vars(r)["orderId"], callerUser(w, r))
+	if order == nil {
+		return
+	}
+	documents, err := app.getOrderDocuments(order.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	for i := range documents {
+		if documents[i].URL, err = app.blobs.SignedURL(documents[i].blobKey, signedURLTTL); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"documents": documents})
+}
+
+// handleGenerateDocument - сформировать документ заново (например, после правки реквизитов)
+func (app *App) handleGenerateDocument(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	user := callerUser(w, r)
+	order := app.escrowAccess(w, mux.Vars(r)["orderId"], user)
+	if order == nil {
+		return
+	}
+	switch {
+	case req.Kind == DocumentContract && order.ContractorID == nil:
+		writeError(w, http.StatusConflict, CodeOrderState, "Договор формируется после назначения бригадира")
+		return
+	case req.Kind == DocumentAct && order.Status != "completed":
+		writeError(w, http.StatusConflict, CodeOrderState, "Акт формируется после завершения работ")
+		return
+	case documentTitles[req.Kind] == "":
+		writeError(w, http.StatusBadRequest, CodeValidation, "Неизвестный вид документа")
+		return
+	}
+	doc, err := app.generateDocument(r.Context(), order, req.Kind, user.ID)
+	if err == errDocumentSigned {
+		writeError(w, http.StatusConflict, CodeConflict, "Документ уже подписан и не может быть изменен")
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if doc.URL, err = app.blobs.SignedURL(doc.blobKey, signedURLTTL); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"document": doc})
+}
+
+// handleSignDocument - подписание документа стороной заказа в приложении.
+// Подпись акта клиентом подтверждает выполнение, как handleConfirmCompletion.
+func (app *App) handleSignDocument(w http.ResponseWriter, r *http.Request) {
+	user := callerUser(w, r)
+	order := app.escrowAccess(w, mux.Vars(r)["orderId"], user)
+	if order == nil {
+		return
+	}
+	party := orderParty(order, user)
+	if party == "" {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Подписать документ может только сторона заказа")
+		return
+	}
+	documentID, err := strconv.ParseInt(mux.Vars(r)["documentId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный document ID")
+		return
+	}
+	doc, err := scanDocument(app.db.QueryRow(`SELECT `+documentColumns+` FROM order_documents d JOIN order_attachments a ON a.id = d.attachment_id WHERE d.id = ? AND d.order_id = ?`, documentID, order.ID))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeDocumentNotFound, "Документ не найден")
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if doc.Kind == DocumentAct && order.Status != "completed" {
+		writeError(w, http.StatusConflict, CodeOrderState, "Акт подписывается после завершения работ")
+		return
+	}
+	if err := app.signDocument(r.Context(), doc, party, user); err == errDocumentChanged {
+		writeError(w, http.StatusConflict, CodeConflict, "Файл документа изменен после формирования, сформируйте его заново")
+		return
+	} else if err != nil {
+		internalError(w, err)
+		return
+	}
+	// Подписанный клиентом акт - это приемка работ: предоплата уходит
+	// бригадиру, как обещает п. 2.3 договора
+	if doc.Kind == DocumentAct && party == "client" {
+		if err := app.releaseEscrow(r.Context(), order.ID, "Клиент подписал акт выполненных работ"); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+	if doc, err = app.getOrderDocument(order.ID, doc.Kind); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"document": doc})
+}
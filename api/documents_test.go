@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSignActReleasesEscrow(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	clientID := testUser(t, a, 111, "client")
+	contractorID := testUser(t, a, 333, "contractor")
+	order := testOrder(t, a, clientID, 20)
+	if _, err := a.db.Exec(`UPDATE orders SET contractor_id = ?, status = 'accepted' WHERE id = ?`, contractorID, order.ID); err != nil {
+		t.Fatal(err)
+	}
+	order, _ = a.getOrder(order.ID)
+	escrow, err := a.openEscrow(ctx, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.payments.(*SandboxProvider).Simulate(ctx, escrow.Payment, "success"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.db.Exec(`UPDATE orders SET status = 'completed' WHERE id = ?`, order.ID); err != nil {
+		t.Fatal(err)
+	}
+	order, _ = a.getOrder(order.ID)
+	act, err := a.generateDocument(ctx, order, DocumentAct, contractorID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(telegramID int64) int {
+		return doJSON(t, "POST", fmt.Sprintf("/api/orders/%d/documents/%d/sign?telegram_id=%d", order.ID, act.ID, telegramID), nil, nil)
+	}
+	// Подпись бригадира не выплачивает предоплату
+	if code := sign(333); code != http.StatusOK {
+		t.Fatalf("подпись бригадира: статус %d", code)
+	}
+	if escrow, _ = a.getEscrow(order.ID); escrow.Status != EscrowHeld {
+		t.Fatalf("после подписи бригадира статус %s, want %s", escrow.Status, EscrowHeld)
+	}
+	if code := sign(111); code != http.StatusOK {
+		t.Fatalf("подпись клиента: статус %d", code)
+	}
+	if escrow, _ = a.getEscrow(order.ID); escrow.Status != EscrowReleased {
+		t.Errorf("после подписи акта клиентом статус %s, want %s", escrow.Status, EscrowReleased)
+	}
+	if balance, _ := a.accountBalance(contractorAccount(contractorID)); balance != escrow.Amount {
+		t.Errorf("на счете бригадира %d, want %d", balance, escrow.Amount)
+	}
+}
+
+func TestMigrateAttachmentKinds(t *testing.T) {
+	a := newTestApp(t)
+	clientID := testUser(t, a, 111, "client")
+	order := testOrder(t, a, clientID, 20)
+	queries := []string{
+		`DROP TABLE order_attachments`,
+		`CREATE TABLE order_attachments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			order_id INTEGER NOT NULL,
+			uploader_id INTEGER NOT NULL,
+			kind TEXT NOT NULL CHECK(kind IN ('photo', 'plan', 'before', 'after')),
+			blob_key TEXT NOT NULL,
+			thumbnail_key TEXT,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		fmt.Sprintf(`INSERT INTO order_attachments (order_id, uploader_id, kind, blob_key, content_type, size) VALUES (%d, %d, 'plan', 'orders/plan.png', 'image/png', 10)`, order.ID, clientID),
+	}
+	for _, query := range queries {
+		if _, err := a.db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.migrateAttachmentKinds(); err != nil {
+		t.Fatal(err)
+	}
+	var schema, blobKey string
+	a.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'order_attachments'`).Scan(&schema)
+	if !strings.Contains(schema, "'act'") {
+		t.Errorf("CHECK не расширен: %s", schema)
+	}
+	if err := a.db.QueryRow(`SELECT blob_key FROM order_attachments WHERE order_id = ?`, order.ID).Scan(&blobKey); err != nil || blobKey != "orders/plan.png" {
+		t.Errorf("вложение после миграции: %q, %v", blobKey, err)
+	}
+	// Повторный запуск ничего не меняет
+	if err := a.migrateAttachmentKinds(); err != nil {
+		t.Errorf("повторная миграция: %v", err)
+	}
+}
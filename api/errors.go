@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Все ошибки API отдаются в одном формате:
+//
+//	{"error": {"code": "order_not_found", "message": "Заказ не найден", "details": {...}, "request_id": "..."}}
+//
+// code - машиночитаемый код из errorCatalog, по нему клиент выбирает реакцию;
+// message - текст для пользователя на языке ответа (см. i18n.go). Внутренние ошибки пишутся в лог вместе с
+// request_id, а клиент получает только код internal.
+
+// Коды ошибок
+const (
+	// Общие коды по HTTP-статусу
+	CodeBadRequest           = "bad_request"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeUnprocessable        = "unprocessable"
+	CodeInternal             = "internal"
+	CodeUpstreamUnavailable  = "upstream_unavailable"
+	CodeServiceUnavailable   = "service_unavailable"
+
+	// Запрос
+	CodeInvalidJSON       = "invalid_json"
+	CodeInvalidTelegramID = "invalid_telegram_id"
+	CodeInvalidID         = "invalid_id"
+	CodeValidation        = "validation_failed"
+	CodeInvalidRegion     = "invalid_region"
+	CodeFileMissing       = "file_missing"
+	CodeFileTooLarge      = "file_too_large"
+	CodeFileType          = "unsupported_file_type"
+
+	// Доступ
+	CodeAdminRequired        = "admin_required"
+	CodeInvalidAPIKey        = "invalid_api_key"
+	CodeUserInactive         = "user_inactive"
+	CodeNotContractor        = "not_contractor"
+	CodeContractorProfile    = "contractor_profile_required"
+	CodeContractorUnverified = "contractor_unverified"
+	CodeOrderAccessDenied    = "order_access_denied"
+	CodeRoleBusy             = "role_busy"
+	CodeInvalidRole          = "invalid_role"
+
+	// Сущности
+	CodeUserNotFound     = "user_not_found"
+	CodeOrderNotFound    = "order_not_found"
+	CodePaymentNotFound  = "payment_not_found"
+	CodeDocumentNotFound = "document_not_found"
+	CodeFileNotFound     = "file_not_found"
+	CodeBidNotFound      = "bid_not_found"
+
+	// Состояние
+	CodeOrderState   = "order_state_conflict"
+	CodePromoInvalid = "promo_invalid"
+
+	// Idempotency-Key
+	CodeIdempotencyMismatch   = "idempotency_key_reused"
+	CodeIdempotencyInProgress = "idempotency_in_progress"
+)
+
+// errorCatalog - описание каждого кода; тот же список приведен в API_EXAMPLES.md
+var errorCatalog = map[string]string{
+	CodeBadRequest:            "Некорректный запрос",
+	CodeUnauthorized:          "Требуется аутентификация или подпись не сошлась",
+	CodeForbidden:             "Действие запрещено",
+	CodeNotFound:              "Ресурс или маршрут не найден",
+	CodeMethodNotAllowed:      "Метод не поддерживается маршрутом",
+	CodeConflict:              "Действие противоречит текущему состоянию",
+	CodePayloadTooLarge:       "Тело запроса слишком большое",
+	CodeUnsupportedMediaType:  "Неподдерживаемый тип содержимого",
+	CodeUnprocessable:         "Запрос понятен, но не может быть обработан",
+	CodeInternal:              "Внутренняя ошибка; подробности в логах по request_id",
+	CodeUpstreamUnavailable:   "Внешний сервис (платежи, чеки, Telegram) не ответил",
+	CodeServiceUnavailable:    "Функция не настроена на сервере",
+	CodeInvalidJSON:           "Тело запроса не разобрано как JSON",
+	CodeInvalidTelegramID:     "Неверный telegram_id",
+	CodeInvalidID:             "Неверный идентификатор в пути",
+	CodeValidation:            "Поле запроса не прошло проверку",
+	CodeInvalidRegion:         "Неизвестный или неактивный регион",
+	CodeFileMissing:           "Файл не передан",
+	CodeFileTooLarge:          "Файл больше допустимого размера; details.max_mb",
+	CodeFileType:              "Недопустимый тип файла",
+	CodeAdminRequired:         "Нужны права администратора",
+	CodeInvalidAPIKey:         "API-ключ не найден или отозван",
+	CodeUserInactive:          "Пользователь приостановлен или заблокирован; details.status",
+	CodeNotContractor:         "Пользователь не в роли бригадира",
+	CodeContractorProfile:     "Профиль бригадира не заполнен или не активен",
+	CodeContractorUnverified:  "Профиль бригадира не прошел проверку документов",
+	CodeOrderAccessDenied:     "Пользователь не сторона заказа",
+	CodeRoleBusy:              "Нельзя сменить роль, пока в ней есть незавершенные заказы",
+	CodeInvalidRole:           "Роль должна быть client или contractor",
+	CodeUserNotFound:          "Пользователь не найден",
+	CodeOrderNotFound:         "Заказ не найден",
+	CodePaymentNotFound:       "Платеж не найден",
+	CodeDocumentNotFound:      "Документ не найден",
+	CodeFileNotFound:          "Файл не найден или ссылка устарела",
+	CodeBidNotFound:           "Предложение не найдено",
+	CodeOrderState:            "Заказ в статусе, в котором действие недоступно",
+	CodePromoInvalid:          "Промокод не действует",
+	CodeIdempotencyMismatch:   "Idempotency-Key уже использован с другим запросом",
+	CodeIdempotencyInProgress: "Запрос с этим Idempotency-Key еще выполняется",
+}
+
+// statusCodes - код по умолчанию для HTTP-статуса
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodePayloadTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMediaType,
+	http.StatusUnprocessableEntity:   CodeUnprocessable,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusBadGateway:            CodeUpstreamUnavailable,
+	http.StatusServiceUnavailable:    CodeServiceUnavailable,
+}
+
+// APIError - тело ошибки
+type APIError struct {
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// writeError отвечает ошибкой в едином формате. Пустой code берется по статусу.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details map[string]interface{}) {
+	if code == "" {
+		code = statusCodes[status]
+	}
+	message = tr(responseLocale(w), message)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": APIError{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: w.Header().Get("X-Request-ID"),
+	}})
+}
+
+// localizable - текст, который переводится на язык ответа
+type localizable interface {
+	message(locale string) string
+}
+
+// userError - ошибка проверки данных, текст которой показывается пользователю.
+// format - ключ каталога сообщений, параметры подставляются после перевода
+// (параметры-localizable переводятся так же). kind - признак для errors.Is,
+// например errPromoInvalid.
+type userError struct {
+	kind   error
+	format string
+	args   []interface{}
+}
+
+func newUserError(format string, args ...interface{}) error {
+	return &userError{format: format, args: args}
+}
+
+func (e *userError) Error() string {
+	return e.message("")
+}
+
+func (e *userError) Unwrap() error {
+	return e.kind
+}
+
+func (e *userError) message(locale string) string {
+	args := make([]interface{}, len(e.args))
+	for i, arg := range e.args {
+		if l, ok := arg.(localizable); ok {
+			arg = l.message(locale)
+		}
+		args[i] = arg
+	}
+	return trf(locale, e.format, args...)
+}
+
+// userErrorMessage - текст userError на языке ответа; false, если err - не userError
+func userErrorMessage(w http.ResponseWriter, err error) (string, bool) {
+	var ue *userError
+	if !errors.As(err, &ue) {
+		return "", false
+	}
+	return ue.message(responseLocale(w)), true
+}
+
+// writeUserError отвечает текстом userError, остальные ошибки - внутренние
+func writeUserError(w http.ResponseWriter, status int, code string, err error) {
+	message, ok := userErrorMessage(w, err)
+	if !ok {
+		internalError(w, err)
+		return
+	}
+	writeError(w, status, code, message)
+}
+
+// internalError пишет ошибку в лог и отвечает 500 без подробностей
+func internalError(w http.ResponseWriter, err error) {
+	log.Printf("[%s] внутренняя ошибка: %v", w.Header().Get("X-Request-ID"), err)
+	writeError(w, http.StatusInternalServerError, CodeInternal, "Внутренняя ошибка сервера")
+}
+
+// upstreamError - то же для отказа внешнего сервиса: текст ошибки провайдера
+// может содержать реквизиты и ответы его API, клиенту он не нужен.
+func upstreamError(w http.ResponseWriter, err error) {
+	log.Printf("[%s] ошибка внешнего сервиса: %v", w.Header().Get("X-Request-ID"), err)
+	writeError(w, http.StatusBadGateway, CodeUpstreamUnavailable, "Внешний сервис недоступен, повторите позже")
+}
+
+// requestID берет X-Request-ID от прокси или генерирует новый
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= 64 {
+		return id
+	}
+	if id := r.Header.Get("X-Vercel-Id"); id != "" && len(id) <= 64 {
+		return id
+	}
+	return randomHex(8)
+}
+
+// notFoundHandler и methodNotAllowedHandler - ответы роутера в том же формате
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, CodeNotFound, "Маршрут не найден")
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Метод не поддерживается")
+}
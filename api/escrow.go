@@ -0,0 +1,498 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Статусы удержания предоплаты
+const (
+	EscrowAwaitingPayment = "awaiting_payment"
+	EscrowPaymentFailed   = "payment_failed"
+	EscrowHeld            = "held"
+	EscrowReleased        = "released"
+	EscrowRefunded        = "refunded"
+	EscrowCanceled        = "canceled"
+)
+
+// Ошибки выставления предоплаты, о которых сообщается клиенту
+var (
+	errEscrowNoAmount   = errors.New("не удалось рассчитать предоплату")
+	errEscrowNotPayable = errors.New("предоплата уже внесена или возвращена")
+)
+
+// Через сколько после завершения работ предоплата уходит бригадиру без подтверждения клиента
+const escrowDefaultAutoConfirm = 72 * time.Hour
+
+// Escrow - предоплата клиента по заказу. Деньги списываются при оплате и
+// числятся на счете escrow:{orderID}, пока клиент не подтвердит выполнение
+// (или не истечет срок автоподтверждения) - тогда они переходят на счет
+// бригадира. При отмене заказа предоплата возвращается клиенту.
+type Escrow struct {
+	OrderID         int64      `json:"order_id"`
+	PaymentID       *int64     `json:"payment_id"`
+	Amount          int64      `json:"amount"`
+	Status          string     `json:"status"`
+	ConfirmDeadline *time.Time `json:"confirm_deadline"`
+	Balance         int64      `json:"balance"`
+	RefundDue       int64      `json:"refund_due"`
+	Payment         *Payment   `json:"payment,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+func escrowAutoConfirm() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("ESCROW_AUTO_CONFIRM")); err == nil && d > 0 {
+		return d
+	}
+	return escrowDefaultAutoConfirm
+}
+
+// escrowPrepaymentAmount - ESCROW_PREPAYMENT_PERCENT (по умолчанию 100) от стоимости заказа
+func escrowPrepaymentAmount(order *Order) int64 {
+	percent := int64(100)
+	if p, err := strconv.ParseInt(os.Getenv("ESCROW_PREPAYMENT_PERCENT"), 10, 64); err == nil && p > 0 && p <= 100 {
+		percent = p
+	}
+	return orderPrice(order) * percent / 100
+}
+
+func (app *App) getEscrow(orderID int64) (*Escrow, error) {
+	var e Escrow
+	var deadline sql.NullString
+	var createdAt, updatedAt string
+	err := app.db.QueryRow(`SELECT order_id, payment_id, amount, status, confirm_deadline, refund_due, created_at, updated_at FROM escrows WHERE order_id = ?`, orderID).
+		Scan(&e.OrderID, &e.PaymentID, &e.Amount, &e.Status, &deadline, &e.RefundDue, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline.Valid {
+		if t, err := time.Parse("2006-01-02 15:04:05", deadline.String); err == nil {
+			e.ConfirmDeadline = &t
+		}
+	}
+	e.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt)
+	e.UpdatedAt, _ = time.Parse("2006-01-02 15:04:05", updatedAt)
+	if e.Balance, err = app.accountBalance(escrowAccount(orderID)); err != nil {
+		return nil, err
+	}
+	if e.PaymentID != nil {
+		if e.Payment, err = app.getPayment(*e.PaymentID); err != nil {
+			return nil, err
+		}
+	}
+	return &e, nil
+}
+
+// setEscrowStatus меняет статус, только если текущий входит в from. Возвращает true, если статус изменился.
+func (app *App) setEscrowStatus(orderID int64, status string, from ...string) (bool, error) {
+	placeholders := "?"
+	for i := 1; i < len(from); i++ {
+		placeholders += ", ?"
+	}
+	args := []interface{}{status, orderID}
+	for _, f := range from {
+		args = append(args, f)
+	}
+	result, err := app.db.Exec(fmt.Sprintf(`UPDATE escrows SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE order_id = ? AND status IN (%s)`, placeholders), args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// openEscrow выставляет клиенту счет на предоплату после принятия заказа.
+// Повторный вызов при неудачной оплате создает новый платеж.
+func (app *App) openEscrow(ctx context.Context, order *Order) (*Escrow, error) {
+	amount := escrowPrepaymentAmount(order)
+	if amount <= 0 {
+		return nil, fmt.Errorf("%w: заказ %d", errEscrowNoAmount, order.ID)
+	}
+	if _, err := app.db.Exec(`INSERT OR IGNORE INTO escrows (order_id, amount) VALUES (?, ?)`, order.ID, amount); err != nil {
+		return nil, err
+	}
+	escrow, err := app.getEscrow(order.ID)
+	if err != nil {
+		return nil, err
+	}
+	if escrow.Status != EscrowAwaitingPayment && escrow.Status != EscrowPaymentFailed {
+		return nil, fmt.Errorf("%w: заказ %d, статус %s", errEscrowNotPayable, order.ID, escrow.Status)
+	}
+	if escrow.Payment != nil && escrow.Payment.Status == PaymentPending {
+		return escrow, nil
+	}
+
+	payment, err := app.createPayment(ctx, order, "prepayment", escrow.Amount, true)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := app.db.Exec(`UPDATE escrows SET payment_id = ?, status = ?, updated_at = CURRENT_TIMESTAMP WHERE order_id = ?`, payment.ID, EscrowAwaitingPayment, order.ID); err != nil {
+		return nil, err
+	}
+	return app.getEscrow(order.ID)
+}
+
+// onPrepaymentStatusChanged двигает удержание вслед за платежом предоплаты
+func (app *App) onPrepaymentStatusChanged(ctx context.Context, payment *Payment) error {
+	switch payment.Status {
+	case PaymentSucceeded:
+		// Деньги поступили - фиксируем их на счете удержания
+		orderID := payment.OrderID
+		if err := app.postLedger(fmt.Sprintf("escrow_hold:%d", payment.ID), cashAccount(payment.Provider), escrowAccount(orderID), payment.Amount, &orderID, &payment.ID, "Предоплата по заказу"); err != nil {
+			return err
+		}
+		held, err := app.setEscrowStatus(orderID, EscrowHeld, EscrowAwaitingPayment, EscrowPaymentFailed)
+		if err != nil || held {
+			return err
+		}
+		// Заказ отменили, пока клиент платил - сразу возвращаем деньги
+		escrow, err := app.getEscrow(orderID)
+		if err != nil || escrow == nil || escrow.Status != EscrowCanceled {
+			return err
+		}
+		if _, err := app.setEscrowStatus(orderID, EscrowHeld, EscrowCanceled); err != nil {
+			return err
+		}
+		return app.refundEscrow(ctx, orderID)
+	case PaymentCanceled:
+		_, err := app.setEscrowStatus(payment.OrderID, EscrowPaymentFailed, EscrowAwaitingPayment)
+		return err
+	}
+	return nil
+}
+
+// scheduleEscrowConfirm запускает отсчет автоподтверждения после завершения работ
+func (app *App) scheduleEscrowConfirm(orderID int64) error {
+	deadline := time.Now().UTC().Add(escrowAutoConfirm()).Format("2006-01-02 15:04:05")
+	_, err := app.db.Exec(`UPDATE escrows SET confirm_deadline = ?, updated_at = CURRENT_TIMESTAMP WHERE order_id = ? AND confirm_deadline IS NULL`, deadline, orderID)
+	return err
+}
+
+// releaseEscrow переводит удержанную предоплату бригадиру. Статус released и
+// проводка выплаты записываются одной транзакцией.
+func (app *App) releaseEscrow(ctx context.Context, orderID int64, memo string) error {
+	var contractorID sql.NullInt64
+	if err := app.db.QueryRow(`SELECT contractor_id FROM orders WHERE id = ?`, orderID).Scan(&contractorID); err != nil {
+		return err
+	}
+	if !contractorID.Valid {
+		return fmt.Errorf("у заказа %d нет бригадира", orderID)
+	}
+	escrow, err := app.getEscrow(orderID)
+	if err != nil {
+		return err
+	}
+	if escrow == nil || escrow.Status != EscrowHeld {
+		return nil
+	}
+
+	tx, err := app.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`UPDATE escrows SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE order_id = ? AND status = ?`, EscrowReleased, orderID, EscrowHeld)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		// Предоплату одновременно выплатили или вернули
+		return err
+	}
+	if escrow.Balance > 0 {
+		if err := postLedgerExec(tx, fmt.Sprintf("escrow_release:%d", orderID), escrowAccount(orderID), contractorAccount(contractorID.Int64), escrow.Balance, &orderID, escrow.PaymentID, memo); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Работы приняты - чек полного расчета на всю стоимость с зачетом предоплаты
+	if err := app.issueSettlementReceipt(ctx, orderID, escrow.PaymentID, escrow.Balance); err != nil {
+		log.Printf("Не удалось выпустить чек полного расчета по заказу %d: %v", orderID, err)
+	}
+	return nil
+}
+
+// refundEscrow возвращает клиенту удержанную предоплату. Если оплата еще не
+// поступила, удержание просто отменяется. Статус refunded, проводка возврата и
+// сумма к возврату (refund_due) записываются одной транзакцией, затем деньги
+// возвращаются через провайдера. Если провайдер не ответил, refund_due
+// остается и возврат повторяет cron (retryEscrowRefunds).
+func (app *App) refundEscrow(ctx context.Context, orderID int64) error {
+	escrow, err := app.getEscrow(orderID)
+	if err != nil || escrow == nil {
+		return err
+	}
+	switch escrow.Status {
+	case EscrowAwaitingPayment, EscrowPaymentFailed:
+		_, err := app.setEscrowStatus(orderID, EscrowCanceled, EscrowAwaitingPayment, EscrowPaymentFailed)
+		return err
+	case EscrowHeld:
+	default:
+		return nil
+	}
+
+	tx, err := app.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`UPDATE escrows SET status = ?, refund_due = ?, updated_at = CURRENT_TIMESTAMP WHERE order_id = ? AND status = ?`, EscrowRefunded, max(escrow.Balance, 0), orderID, EscrowHeld)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		// Предоплату одновременно выплатили или уже вернули
+		return err
+	}
+	if escrow.Balance > 0 {
+		if err := postLedgerExec(tx, fmt.Sprintf("escrow_refund:%d", orderID), escrowAccount(orderID), cashAccount(escrow.Payment.Provider), escrow.Balance, &orderID, escrow.PaymentID, "Возврат предоплаты клиенту"); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return app.sendEscrowRefund(ctx, orderID)
+}
+
+// sendEscrowRefund возвращает через провайдера сумму refund_due. Сумма
+// списывается до вызова провайдера, чтобы параллельный повтор не вернул
+// деньги дважды, и восстанавливается, если провайдер ответил ошибкой.
+func (app *App) sendEscrowRefund(ctx context.Context, orderID int64) error {
+	escrow, err := app.getEscrow(orderID)
+	if err != nil || escrow == nil || escrow.RefundDue <= 0 {
+		return err
+	}
+	result, err := app.db.Exec(`UPDATE escrows SET refund_due = 0, updated_at = CURRENT_TIMESTAMP WHERE order_id = ? AND refund_due = ?`, orderID, escrow.RefundDue)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return err
+	}
+	payment := escrow.Payment
+	if payment == nil {
+		return fmt.Errorf("у предоплаты по заказу %d нет платежа", orderID)
+	}
+	amount := min(escrow.RefundDue, payment.Amount-payment.RefundedAmount)
+	if payment.Status == PaymentRefunded || payment.Status == PaymentCanceled || amount <= 0 {
+		// Платеж уже вернули иначе (например, администратор вручную)
+		return nil
+	}
+	if err := app.refundPayment(ctx, payment, amount); err != nil {
+		if _, restoreErr := app.db.Exec(`UPDATE escrows SET refund_due = ?, updated_at = CURRENT_TIMESTAMP WHERE order_id = ?`, escrow.RefundDue, orderID); restoreErr != nil {
+			log.Printf("Не удалось сохранить долг по возврату предоплаты заказа %d: %v", orderID, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// retryEscrowRefunds повторяет возвраты предоплаты, которые не прошли у провайдера
+func (app *App) retryEscrowRefunds(ctx context.Context) (int, error) {
+	orderIDs, err := app.escrowOrderIDs(`SELECT order_id FROM escrows WHERE status = ? AND refund_due > 0`, EscrowRefunded)
+	if err != nil {
+		return 0, err
+	}
+	refunded := 0
+	for _, id := range orderIDs {
+		if err := app.sendEscrowRefund(ctx, id); err != nil {
+			log.Printf("Не удалось вернуть предоплату по заказу %d: %v", id, err)
+			continue
+		}
+		refunded++
+	}
+	return refunded, nil
+}
+
+func (app *App) escrowOrderIDs(query string, args ...interface{}) ([]int64, error) {
+	rows, err := app.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var orderIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		orderIDs = append(orderIDs, id)
+	}
+	return orderIDs, rows.Err()
+}
+
+// autoConfirmEscrows выплачивает предоплату по заказам, где клиент не ответил в срок
+func (app *App) autoConfirmEscrows(ctx context.Context) (int, error) {
+	orderIDs, err := app.escrowOrderIDs(`SELECT order_id FROM escrows WHERE status = ? AND confirm_deadline IS NOT NULL AND confirm_deadline <= ?`, EscrowHeld, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, id := range orderIDs {
+		if err := app.releaseEscrow(ctx, id, "Автоподтверждение выполнения"); err != nil {
+			log.Printf("Не удалось выплатить предоплату по заказу %d: %v", id, err)
+			continue
+		}
+		released++
+	}
+	return released, nil
+}
+
+// escrowAccess проверяет, что пользователь запроса - участник заказа (или администратор)
+func (app *App) escrowAccess(w http.ResponseWriter, orderIDParam string, user *User) *Order {
+	if user == nil {
+		return nil
+	}
+	orderID, err := strconv.ParseInt(orderIDParam, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
+		return nil
+	}
+	order, err := app.getOrder(orderID)
+	if err != nil {
+		internalError(w, err)
+		return nil
+	}
+	if order == nil {
+		writeError(w, http.StatusNotFound, CodeOrderNotFound, "Заказ не найден")
+		return nil
+	}
+	if orderParty(order, user) == "" && !isAdmin(user) {
+		writeError(w, http.StatusForbidden, CodeOrderAccessDenied, "Нет доступа к заказу")
+		return nil
+	}
+	return order
+}
+
+// handleGetEscrow - состояние предоплаты и проводки по заказу
+func (app *App) handleGetEscrow(w http.ResponseWriter, r *http.Request) {
+	order := app.escrowAccess(w, mux.Vars(r)["orderId"], callerUser(w, r))
+	if order == nil {
+		return
+	}
+	escrow, err := app.getEscrow(order.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if escrow == nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Предоплата по заказу не выставлялась")
+		return
+	}
+	entries, err := app.getOrderLedger(order.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"escrow": escrow, "ledger": entries})
+}
+
+// handlePayEscrow - повторный счет на предоплату, если прошлая оплата не прошла
+func (app *App) handlePayEscrow(w http.ResponseWriter, r *http.Request) {
+	user := callerUser(w, r)
+	order := app.escrowAccess(w, mux.Vars(r)["orderId"], user)
+	if order == nil {
+		return
+	}
+	if orderParty(order, user) != "client" {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Оплатить заказ может только клиент")
+		return
+	}
+	if order.Status != "accepted" {
+		writeError(w, http.StatusConflict, CodeOrderState, "Предоплата вносится после принятия заказа бригадиром")
+		return
+	}
+	escrow, err := app.openEscrow(r.Context(), order)
+	switch {
+	case errors.Is(err, errEscrowNoAmount):
+		writeError(w, http.StatusConflict, CodeOrderState, "Не удалось рассчитать предоплату: у заказа не указана площадь")
+		return
+	case errors.Is(err, errEscrowNotPayable):
+		writeError(w, http.StatusConflict, CodeConflict, "Предоплата уже внесена или возвращена")
+		return
+	case err != nil:
+		writePaymentError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"escrow": escrow})
+}
+
+// handleConfirmCompletion - клиент подтверждает выполнение работ, предоплата уходит бригадиру
+func (app *App) handleConfirmCompletion(w http.ResponseWriter, r *http.Request) {
+	user := callerUser(w, r)
+	order := app.escrowAccess(w, mux.Vars(r)["orderId"], user)
+	if order == nil {
+		return
+	}
+	if orderParty(order, user) != "client" {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Подтвердить выполнение может только клиент")
+		return
+	}
+	if order.Status != "completed" {
+		writeError(w, http.StatusConflict, CodeOrderState, "Бригадир еще не завершил работы")
+		return
+	}
+	if err := app.releaseEscrow(r.Context(), order.ID, "Клиент подтвердил выполнение"); err != nil {
+		internalError(w, err)
+		return
+	}
+	escrow, err := app.getEscrow(order.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"escrow": escrow})
+}
+
+// cronAuthorized проверяет, что периодическую задачу вызвал cron: запрос должен
+// нести заголовок Authorization: Bearer $CRON_SECRET. Иначе отвечает 401.
+func cronAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	secret := os.Getenv("CRON_SECRET")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+secret)) != 1 {
+		writeError(w, http.StatusUnauthorized, CodeAdminRequired, "Недостаточно прав")
+		return false
+	}
+	return true
+}
+
+// handleEscrowCron - периодическая задача автоподтверждения и повтора
+// невыполненных возвратов (Vercel Cron).
+func (app *App) handleEscrowCron(w http.ResponseWriter, r *http.Request) {
+	if !cronAuthorized(w, r) {
+		return
+	}
+	released, err := app.autoConfirmEscrows(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	refunded, err := app.retryEscrowRefunds(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"released": released, "refunded": refunded})
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// heldEscrow заводит принятый заказ с оплаченной предоплатой
+func heldEscrow(t *testing.T, a *App) (order *Order, escrow *Escrow, contractorID int64) {
+	t.Helper()
+	ctx := context.Background()
+	clientID := testUser(t, a, 111, "client")
+	contractorID = testUser(t, a, 333, "contractor")
+	order = testOrder(t, a, clientID, 20)
+	if _, err := a.db.Exec(`UPDATE orders SET contractor_id = ?, status = 'accepted' WHERE id = ?`, contractorID, order.ID); err != nil {
+		t.Fatal(err)
+	}
+	order, _ = a.getOrder(order.ID)
+	escrow, err := a.openEscrow(ctx, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if escrow.Status != EscrowAwaitingPayment {
+		t.Fatalf("новая предоплата в статусе %s, want %s", escrow.Status, EscrowAwaitingPayment)
+	}
+	if err := a.payments.(*SandboxProvider).Simulate(ctx, escrow.Payment, "success"); err != nil {
+		t.Fatal(err)
+	}
+	if escrow, err = a.getEscrow(order.ID); err != nil || escrow.Status != EscrowHeld {
+		t.Fatalf("после оплаты: %+v, %v", escrow, err)
+	}
+	return order, escrow, contractorID
+}
+
+// assertLedgerBalanced проверяет, что каждая проводка - две строки на одну
+// сумму, а сумма дебетов по всем счетам равна сумме кредитов
+func assertLedgerBalanced(t *testing.T, a *App) {
+	t.Helper()
+	var unbalanced int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM (SELECT tx_id FROM ledger_entries GROUP BY tx_id HAVING COUNT(*) != 2 OR SUM(debit) != SUM(credit))`).Scan(&unbalanced); err != nil {
+		t.Fatal(err)
+	}
+	if unbalanced > 0 {
+		t.Errorf("несбалансированных проводок: %d", unbalanced)
+	}
+	t.Setenv("ADMIN_API_KEY_HASHES", hashAPIKey("test-admin-key"))
+	var resp struct {
+		TotalDebit  int64 `json:"total_debit"`
+		TotalCredit int64 `json:"total_credit"`
+		Balanced    bool  `json:"balanced"`
+	}
+	if code := doJSON(t, "GET", "/api/admin/ledger/balances", nil, &resp, "X-API-Key", "test-admin-key"); code != http.StatusOK {
+		t.Fatalf("сальдо: статус %d", code)
+	}
+	if !resp.Balanced || resp.TotalDebit != resp.TotalCredit {
+		t.Errorf("дебет %d, кредит %d", resp.TotalDebit, resp.TotalCredit)
+	}
+}
+
+func balanceOf(t *testing.T, a *App, account string) int64 {
+	t.Helper()
+	balance, err := a.accountBalance(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
+
+func TestEscrowHoldAndRelease(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	order, escrow, contractorID := heldEscrow(t, a)
+	if escrow.Amount != orderPrice(order) || escrow.Balance != escrow.Amount {
+		t.Fatalf("удержание: сумма %d, остаток %d, want %d", escrow.Amount, escrow.Balance, orderPrice(order))
+	}
+	if got := balanceOf(t, a, cashAccount(sandboxProviderName)); got != -escrow.Amount {
+		t.Errorf("деньги у провайдера %d, want %d", -got, escrow.Amount)
+	}
+	assertLedgerBalanced(t, a)
+
+	// Повторная выплата ничего не проводит второй раз
+	for range 2 {
+		if err := a.releaseEscrow(ctx, order.ID, "Работы приняты"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if escrow, _ = a.getEscrow(order.ID); escrow.Status != EscrowReleased || escrow.Balance != 0 {
+		t.Errorf("после выплаты: статус %s, остаток %d", escrow.Status, escrow.Balance)
+	}
+	if got := balanceOf(t, a, contractorAccount(contractorID)); got != escrow.Amount {
+		t.Errorf("на счете бригадира %d, want %d", got, escrow.Amount)
+	}
+	// Выплаченную предоплату уже не вернуть
+	if err := a.refundEscrow(ctx, order.ID); err != nil {
+		t.Fatal(err)
+	}
+	if escrow, _ = a.getEscrow(order.ID); escrow.Status != EscrowReleased {
+		t.Errorf("возврат после выплаты сменил статус на %s", escrow.Status)
+	}
+	payment, _ := a.getPayment(*escrow.PaymentID)
+	if err := a.adminRefundPayment(ctx, payment, payment.Amount); !errors.Is(err, errPrepaymentReleased) {
+		t.Errorf("возврат выплаченной предоплаты: %v, want errPrepaymentReleased", err)
+	}
+	assertLedgerBalanced(t, a)
+}
+
+func TestEscrowRefund(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	order, escrow, contractorID := heldEscrow(t, a)
+
+	payment, _ := a.getPayment(*escrow.PaymentID)
+	if err := a.adminRefundPayment(ctx, payment, payment.Amount-1); !errors.Is(err, errPrepaymentPartial) {
+		t.Errorf("частичный возврат удержанной предоплаты: %v, want errPrepaymentPartial", err)
+	}
+	// Админский возврат идет через удержание: закрывается и счет escrow
+	if err := a.adminRefundPayment(ctx, payment, payment.Amount); err != nil {
+		t.Fatal(err)
+	}
+	if escrow, _ = a.getEscrow(order.ID); escrow.Status != EscrowRefunded || escrow.Balance != 0 || escrow.RefundDue != 0 {
+		t.Errorf("после возврата: %+v", escrow)
+	}
+	if payment, _ = a.getPayment(payment.ID); payment.Status != PaymentRefunded || payment.RefundedAmount != payment.Amount {
+		t.Errorf("платеж после возврата: %+v", payment)
+	}
+	if got := balanceOf(t, a, cashAccount(sandboxProviderName)); got != 0 {
+		t.Errorf("у провайдера осталось %d", -got)
+	}
+	// Возвращенную предоплату не выплатить бригадиру
+	if err := a.releaseEscrow(ctx, order.ID, "Работы приняты"); err != nil {
+		t.Fatal(err)
+	}
+	if got := balanceOf(t, a, contractorAccount(contractorID)); got != 0 {
+		t.Errorf("бригадиру выплачено %d из возвращенной предоплаты", got)
+	}
+	assertLedgerBalanced(t, a)
+}
+
+// failingRefunds - песочница, которая не принимает возвраты
+type failingRefunds struct {
+	*SandboxProvider
+}
+
+func (failingRefunds) Refund(ctx context.Context, providerPaymentID string, amount int64) (*ProviderRefund, error) {
+	return nil, errors.New("провайдер недоступен")
+}
+
+func TestEscrowRefundRetry(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	order, escrow, _ := heldEscrow(t, a)
+
+	sandbox := a.payments.(*SandboxProvider)
+	a.payments = failingRefunds{sandbox}
+	if err := a.refundEscrow(ctx, order.ID); !errors.Is(err, errPaymentProvider) {
+		t.Fatalf("возврат при недоступном провайдере: %v, want errPaymentProvider", err)
+	}
+	// Статус и проводка уже записаны, долг перед клиентом остался
+	if escrow, _ = a.getEscrow(order.ID); escrow.Status != EscrowRefunded || escrow.RefundDue != escrow.Amount || escrow.Balance != 0 {
+		t.Fatalf("после неудачного возврата: %+v", escrow)
+	}
+	if refunded, err := a.retryEscrowRefunds(ctx); err != nil || refunded != 0 {
+		t.Errorf("повтор при недоступном провайдере: %d, %v", refunded, err)
+	}
+	if escrow, _ = a.getEscrow(order.ID); escrow.RefundDue != escrow.Amount {
+		t.Errorf("долг после повторной ошибки %d, want %d", escrow.RefundDue, escrow.Amount)
+	}
+
+	a.payments = sandbox
+	if refunded, err := a.retryEscrowRefunds(ctx); err != nil || refunded != 1 {
+		t.Fatalf("повтор: %d, %v", refunded, err)
+	}
+	if escrow, _ = a.getEscrow(order.ID); escrow.RefundDue != 0 {
+		t.Errorf("долг после возврата %d", escrow.RefundDue)
+	}
+	if payment, _ := a.getPayment(*escrow.PaymentID); payment.RefundedAmount != escrow.Amount {
+		t.Errorf("возвращено %d, want %d", payment.RefundedAmount, escrow.Amount)
+	}
+	// Больше возвращать нечего
+	if refunded, err := a.retryEscrowRefunds(ctx); err != nil || refunded != 0 {
+		t.Errorf("повтор без долга: %d, %v", refunded, err)
+	}
+	assertLedgerBalanced(t, a)
+}
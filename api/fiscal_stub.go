@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// Номера накопителей, начинающиеся с 9999, используются тестовыми кассами
+const fiscalStubFN = "9999078900001234"
+
+// FiscalStub - заглушка кассы для локальной разработки. Регистрирует чек сразу
+// и выдает правдоподобные, но ненастоящие фискальные реквизиты.
+type FiscalStub struct {
+	secret []byte
+}
+
+func newFiscalStub() *FiscalStub {
+	return &FiscalStub{secret: blobSigningKey()}
+}
+
+func (s *FiscalStub) Name() string {
+	return fiscalStubName
+}
+
+func (s *FiscalStub) Register(ctx context.Context, receipt *Receipt) (*FiscalResult, error) {
+	var sum int64
+	for _, item := range receipt.Items {
+		// Касса сверяет цену × количество с суммой позиции до копейки
+		if int64(math.Round(float64(item.Price)*item.Quantity)) != item.Sum {
+			return nil, fmt.Errorf("позиция %q: цена × количество не равно сумме %d", item.Name, item.Sum)
+		}
+		sum += item.Sum
+	}
+	if sum != receipt.Amount {
+		return nil, fmt.Errorf("сумма позиций %d не равна сумме чека %d", sum, receipt.Amount)
+	}
+	if receipt.Customer == "" {
+		return nil, fmt.Errorf("не указан телефон или email покупателя")
+	}
+
+	// Фискальный признак - 10 цифр, как у настоящего ФПД
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s:%d", receipt.ExternalID, receipt.Amount)
+	sign := binary.BigEndian.Uint32(mac.Sum(nil))
+
+	log.Printf("Касса-заглушка: чек %s (%s) на %s ₽", receipt.ExternalID, receipt.Kind, rubles(receipt.Amount))
+	return &FiscalResult{
+		ID:     fmt.Sprintf("stub_%d", receipt.ID),
+		Status: ReceiptRegistered,
+		Attributes: &FiscalAttributes{
+			FNNumber:     fiscalStubFN,
+			FDNumber:     fmt.Sprintf("%d", receipt.ID),
+			FiscalSign:   fmt.Sprintf("%010d", sign),
+			RegisteredAt: time.Now().UTC(),
+		},
+	}, nil
+}
+
+func (s *FiscalStub) Check(ctx context.Context, providerReceiptID string) (*FiscalResult, error) {
+	return nil, fmt.Errorf("касса-заглушка регистрирует чеки сразу, чек %s не найден", providerReceiptID)
+}
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Тексты в коде пишутся по-русски, каталоги других языков переводят их по
+// исходной строке (для строк с параметрами - по строке формата). Если перевода
+// нет, остается русский текст.
+//
+// Язык запроса: ?lang=, затем language_code пользователя из Telegram, затем
+// Accept-Language. Выбранный язык отдается в заголовке Content-Language, по
+// нему тексты переводят writeError и обработчики. Уведомления бота переводятся
+// на язык получателя.
+
+const defaultLocale = "ru"
+
+// catalogs - переводы по языкам; у русского каталога нет
+var catalogs = map[string]map[string]string{
+	"en": messagesEN,
+	"uz": messagesUZ,
+}
+
+// normalizeLocale приводит "en-US", "EN" и т.п. к поддерживаемому языку или ""
+func normalizeLocale(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	if _, ok := catalogs[code]; ok || code == defaultLocale {
+		return code
+	}
+	return ""
+}
+
+// acceptLanguage выбирает из заголовка Accept-Language поддерживаемый язык с наибольшим q
+func acceptLanguage(header string) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		locale := normalizeLocale(tag)
+		if locale == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{locale, q})
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].locale
+}
+
+// requestLocale - язык запроса без учета пользователя: ?lang= или Accept-Language
+func requestLocale(r *http.Request) string {
+	if locale := normalizeLocale(r.URL.Query().Get("lang")); locale != "" {
+		return locale
+	}
+	if locale := acceptLanguage(r.Header.Get("Accept-Language")); locale != "" {
+		return locale
+	}
+	return defaultLocale
+}
+
+// applyUserLocale переключает ответ на язык Telegram пользователя, если язык не задан явно через ?lang=
+func applyUserLocale(w http.ResponseWriter, r *http.Request, user *User) {
+	if user == nil || normalizeLocale(r.URL.Query().Get("lang")) != "" {
+		return
+	}
+	if locale := user.locale(); locale != "" {
+		w.Header().Set("Content-Language", locale)
+	}
+}
+
+// locale - язык пользователя из Telegram или "", если он не поддерживается
+func (u *User) locale() string {
+	if u == nil || u.LanguageCode == nil {
+		return ""
+	}
+	return normalizeLocale(*u.LanguageCode)
+}
+
+// userLocale - язык для уведомлений пользователю
+func userLocale(u *User) string {
+	if locale := u.locale(); locale != "" {
+		return locale
+	}
+	return defaultLocale
+}
+
+// telegramLocale - язык получателя уведомления по telegram_id
+func (app *App) telegramLocale(telegramID int64) string {
+	var code *string
+	if err := app.db.QueryRow(`SELECT language_code FROM users WHERE telegram_id = ?`, telegramID).Scan(&code); err != nil {
+		return defaultLocale
+	}
+	if locale := normalizeLocale(derefString(code)); locale != "" {
+		return locale
+	}
+	return defaultLocale
+}
+
+// responseLocale - язык, выбранный для ответа
+func responseLocale(w http.ResponseWriter) string {
+	if locale := w.Header().Get("Content-Language"); locale != "" {
+		return locale
+	}
+	return defaultLocale
+}
+
+// tr переводит строку; без перевода возвращает исходную
+func tr(locale, message string) string {
+	if translated, ok := catalogs[locale][message]; ok {
+		return translated
+	}
+	return message
+}
+
+// trf переводит строку формата и подставляет параметры
+func trf(locale, format string, args ...interface{}) string {
+	return fmt.Sprintf(tr(locale, format), args...)
+}
+
+// translateTariff переводит название, описание, сроки и особенности тарифа
+func translateTariff(locale string, tariff Tariff) Tariff {
+	if locale == defaultLocale {
+		return tariff
+	}
+	tariff.Name = tr(locale, tariff.Name)
+	tariff.Description = tr(locale, tariff.Description)
+	tariff.Days = tariff.Duration.TextIn(locale)
+	features := make([]string, len(tariff.Features))
+	for i, feature := range tariff.Features {
+		features[i] = tr(locale, feature)
+	}
+	tariff.Features = features
+	return tariff
+}
+
+// pluralDaysIn - слово "день" в нужной форме
+func pluralDaysIn(locale string, n int) string {
+	switch locale {
+	case "en":
+		if n == 1 {
+			return "day"
+		}
+		return "days"
+	case "uz":
+		return "kun"
+	}
+	return pluralDays(n)
+}
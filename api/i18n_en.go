@@ -0,0 +1,272 @@
+package handler
+
+// messagesEN - английский каталог
+var messagesEN = map[string]string{
+	// Тарифы
+	"ЭКОНОМ":            "ECONOMY",
+	"КОМФОРТ":           "COMFORT",
+	"БИЗНЕС":            "BUSINESS",
+	"ПРЕМИУМ":           "PREMIUM",
+	"УНИВЕРСАЛ":         "UNIVERSAL",
+	"САМОВЫРАВНИВАТЕЛЬ": "SELF-LEVELING",
+	"Мокрая, ручная":    "Wet, manual",
+	"Полусухая механизированная":                "Semi-dry, machine-laid",
+	"С армированием":                            "Reinforced",
+	"Сухая стяжка Кнауф":                        "Knauf dry screed",
+	"Плавающая / Утепленная":                    "Floating / Insulated",
+	"Финишный слой":                             "Finishing layer",
+	"Классика":                                  "Classic method",
+	"Низкая цена материалов":                    "Low material cost",
+	"Долгий срок высыхания":                     "Long drying time",
+	"Высокий риск трещин":                       "High risk of cracks",
+	"Оптимальный баланс":                        "Best balance",
+	"Минимум усадки":                            "Minimal shrinkage",
+	"Можно ходить через 12 часов":               "Walkable after 12 hours",
+	"Самый популярный выбор":                    "Most popular choice",
+	"Повышенная прочность":                      "Increased strength",
+	"Надбавка за армирование сеткой или фиброй": "Surcharge for mesh or fiber reinforcement",
+	"Нет мокрых процессов":                      "No wet processes",
+	"Идеальная геометрия":                       "Perfect geometry",
+	"Теплоизоляция":                             "Thermal insulation",
+	"Высокая цена материалов":                   "High material cost",
+	"Зависит от вида утеплителя":                "Depends on insulation type",
+	"Включает слой изоляции":                    "Includes an insulation layer",
+	"Как у базового тарифа":                     "Same as the base tariff",
+	" (плитка — %s, ламинат — %s)":              " (tiles — %s, laminate — %s)",
+
+	// Уведомления бота
+	"🏷 Новое предложение по заказу №%d: %d ₽/м², начало работ %s":      "🏷 New bid on order #%d: %d ₽/m², work starts %s",
+	"✅ Клиент выбрал ваше предложение по заказу №%d. Начало работ: %s": "✅ The client selected your bid on order #%d. Work starts: %s",
+	"Мы ввели проверку бригадиров. Чтобы снова получать заказы, загрузите в профиле паспорт и справку самозанятого или выписку ЕГРИП/ЕГРЮЛ и отправьте их на проверку.": "We now verify contractors. To receive orders again, upload your passport and a self-employment certificate or a registry extract in your profile and submit them for review.",
+	"💬 Заказ №%d, %s:\n%s": "💬 Order #%d, %s:\n%s",
+	"Собеседник":           "Participant",
+	"[фото]":               "[photo]",
+	"Ваш профиль бригадира подтвержден, теперь вам доступны заказы.": "Your contractor profile has been verified, orders are now available to you.",
+	"Проверка профиля бригадира не пройдена: %s":                     "Contractor profile verification failed: %s",
+	"Ваш профиль бригадира приостановлен: %s":                        "Your contractor profile has been suspended: %s",
+	"Ваш аккаунт снова активен.":                                     "Your account is active again.",
+	"Аккаунт заблокирован":                                           "Account is banned",
+	"Аккаунт приостановлен":                                          "Account is suspended",
+	" до %s (МСК)": " until %s (Moscow time)",
+	"Кассовый чек": "Receipt",
+	"Чек возврата": "Refund receipt",
+	"Чек полного расчета (зачет предоплаты)": "Final payment receipt (prepayment offset)",
+	"Чек предоплаты":                         "Prepayment receipt",
+	"%s по заказу №%d\n":                     "%s for order #%d\n",
+	"Итого: %s ₽\n":                          "Total: %s ₽\n",
+	"Зачтена предоплата: %s ₽\n":             "Advance applied: %s ₽\n",
+	"Постоплата: %s ₽\n":                     "Postpayment: %s ₽\n",
+	"  скидка по промокоду %s: %s ₽\n":       "  promo code %s discount: %s ₽\n",
+	"\nПроверить чек: %s":                    "\nVerify receipt: %s",
+
+	// Ошибки
+	"Внутренняя ошибка сервера":                  "Internal server error",
+	"Внешний сервис недоступен, повторите позже": "External service is unavailable, please try again later",
+	"База данных не настроена":                   "Database is not configured",
+	"Маршрут не найден":                          "Route not found",
+	"Метод не поддерживается":                    "Method not allowed",
+	"Неверный формат данных":                     "Invalid request body",
+	"Не удалось прочитать запрос":                "Could not read the request",
+	"Запрос слишком большой":                     "Request is too large",
+	"Неверный telegram ID":                       "Invalid telegram ID",
+	"Неверный order ID":                          "Invalid order ID",
+	"Неверный user ID":                           "Invalid user ID",
+	"Неверный bid ID":                            "Invalid bid ID",
+	"Неверный document ID":                       "Invalid document ID",
+	"Неверный payment ID":                        "Invalid payment ID",
+	"Неверный receipt ID":                        "Invalid receipt ID",
+	"Неверный batch ID":                          "Invalid batch ID",
+	"Неверный ID ключа":                          "Invalid key ID",
+	"Неверный ID флага":                          "Invalid flag ID",
+	"Неверный API-ключ":                          "Invalid API key",
+	"Недостаточно прав":                          "Insufficient permissions",
+	"Пользователь не найден":                     "User not found",
+	"Не удалось создать пользователя":            "Could not create the user",
+	"Пользователь не является бригадиром":        "User is not a contractor",
+	"Сначала заполните профиль бригадира":        "Fill in your contractor profile first",
+	"Профиль бригадира не найден":                "Contractor profile not found",
+	"Профиль бригадира не активен":               "Contractor profile is not active",
+	"Профиль бригадира не подтвержден":           "Contractor profile is not verified",
+	"Заказ не найден":                            "Order not found",
+	"Заказ отменен":                              "Order has been cancelled",
+	"Заказ уже выполнен":                         "Order is already completed",
+	"Заказ уже отменен или выполнен":             "Order has already been cancelled or completed",
+	"Заказ уже принят или отменен":               "Order has already been accepted or cancelled",
+	"Бригадир занят или не допущен к заказам":    "Contractor is busy or not allowed to take orders",
+	"Завершить можно только принятый заказ":      "Only an accepted order can be completed",
+	"Неизвестный статус заказа":                  "Unknown order status",
+	"Бригадир занят другим заказом":              "The contractor is busy with another order",
+	"Недопустимая смена статуса заказа":          "This order status change is not allowed",
+	"Неверный курсор":                            "Invalid cursor",
+	"Заказ уже в этом статусе":                   "Order already has this status",
+	"Заказ в другом регионе":                     "Order is in another region",
+	"Заказ не в режиме торгов":                   "Order is not open for bids",
+	"Заказ в режиме торгов: отправьте предложение, клиент выберет бригадира": "Order is open for bids: submit a bid and the client will choose a contractor",
+	"Неизвестный режим заказа":                                                "Unknown order mode",
+	"Вознаграждение по заказу уже в реестре выплат":                           "The contractor's earnings for this order are already in a payout batch",
+	"Нет доступа к заказу":                                                    "No access to the order",
+	"Нет доступа к чату заказа":                                               "No access to the order chat",
+	"Бригадир не работает по тарифу заказа":                                   "Contractor does not work with the order tariff",
+	"Бригадир еще не завершил работы":                                         "Contractor has not finished the work yet",
+	"Подтвердить выполнение может только клиент":                              "Only the client can confirm completion",
+	"Неявку бригадира может указать только клиент по принятому заказу":        "Only the client of an accepted order can report a contractor no-show",
+	"Прием предложений по заказу закончен":                                    "Bidding on this order has ended",
+	"Предложение не найдено":                                                  "Bid not found",
+	"Предложение не сохранено":                                                "Bid was not saved",
+	"Предложение уже неактуально":                                             "Bid is no longer active",
+	"Выбрать предложение может только клиент":                                 "Only the client can select a bid",
+	"Укажите цену за м²":                                                      "Specify the price per m²",
+	"Укажите дату начала работ в формате YYYY-MM-DD":                          "Specify the start date in YYYY-MM-DD format",
+	"Цена вне тарифа (%d–%d ₽/м²): укажите обоснование в comment":             "Price is outside the tariff (%d–%d ₽/m²): explain it in comment",
+	"Дата начала работ должна быть не раньше сегодняшней и не позже %s":       "Start date must be no earlier than today and no later than %s",
+	"Пустое сообщение":                                                        "Empty message",
+	"Сообщение длиннее %d символов":                                           "Message is longer than %d characters",
+	"Не удалось получить сообщение":                                           "Could not load the message",
+	"Потоковая передача не поддерживается":                                    "Streaming is not supported",
+	"Файл не передан":                                                         "No file provided",
+	"Файл не передан или слишком большой":                                     "No file provided or the file is too large",
+	"Файл слишком большой или неверный формат данных":                         "File is too large or the request is malformed",
+	"Файл больше %d МБ":                                                       "File is larger than %d MB",
+	"Файл не найден":                                                          "File not found",
+	"Ссылка недействительна или устарела":                                     "Link is invalid or expired",
+	"Допустимы только JPEG и PNG":                                             "Only JPEG and PNG are allowed",
+	"Допустимы только JPEG, PNG и PDF":                                        "Only JPEG, PNG and PDF are allowed",
+	"Не удалось прочитать изображение":                                        "Could not read the image",
+	"Изображение слишком большое":                                             "Image is too large",
+	"Недопустимый размер аватара":                                             "Invalid avatar size",
+	"Аватар не загружен":                                                      "Avatar is not uploaded",
+	"Аватар не найден":                                                        "Avatar not found",
+	"Telegram бот не настроен":                                                "Telegram bot is not configured",
+	"Неизвестный вид вложения":                                                "Unknown attachment kind",
+	"Неизвестный вид документа":                                               "Unknown document kind",
+	"Документ не найден":                                                      "Document not found",
+	"Договор формируется после назначения бригадира":                          "The contract is generated once a contractor is assigned",
+	"Акт формируется после завершения работ":                                  "The acceptance certificate is generated after the work is completed",
+	"Акт подписывается после завершения работ":                                "The acceptance certificate is signed after the work is completed",
+	"Подписать документ может только сторона заказа":                          "Only a party to the order can sign the document",
+	"Загрузите паспорт":                                                       "Upload your passport",
+	"Загрузите справку самозанятого или выписку из ЕГРИП/ЕГРЮЛ":               "Upload a self-employment certificate or a business registry extract",
+	"Документы нельзя изменить, пока заявка на проверке или подтверждена":     "Documents cannot be changed while the application is under review or verified",
+	"Заявка уже на проверке или профиль подтвержден":                          "Application is already under review or the profile is verified",
+	"Оплатить заказ может только клиент":                                      "Only the client can pay for the order",
+	"Не указана сумма платежа":                                                "Payment amount is not specified",
+	"Платеж не найден":                                                        "Payment not found",
+	"Неизвестный провайдер":                                                   "Unknown provider",
+	"Песочница отключена":                                                     "Sandbox is disabled",
+	"Статус должен быть paid или failed":                                      "Status must be paid or failed",
+	"Предоплата вносится после принятия заказа бригадиром":                    "Prepayment is made after a contractor accepts the order",
+	"Предоплата по заказу не выставлялась":                                    "No prepayment was requested for this order",
+	"Чек не найден":                                                           "Receipt not found",
+	"Чек может запросить только клиент":                                       "Only the client can request the receipt",
+	"Нет начислений к выплате":                                                "Nothing to pay out",
+	"Реестр не найден":                                                        "Payout batch not found",
+	"Неизвестный формат, доступны csv и 1c":                                   "Unknown format, csv and 1c are available",
+	"Укажите получателя, ИНН (10 или 12 цифр), счет (20 цифр) и БИК (9 цифр)": "Specify the recipient, INN (10 or 12 digits), account (20 digits) and BIC (9 digits)",
+	"Процент комиссии должен быть от 0 до 100":                                "Commission percent must be between 0 and 100",
+	"Не указан код":                                                           "Code is not specified",
+	"Не указана категория":                                                    "Category is not specified",
+	"Неизвестный тариф":                                                       "Unknown tariff",
+	"Неверные дополнительные работы":                                          "Invalid add-on works",
+	"Процент скидки должен быть от 0 до 100":                                  "Discount percent must be between 0 and 100",
+	"Сумма скидки должна быть положительной":                                  "Discount amount must be positive",
+	"Укажите скидку в процентах (percent) или суммой в копейках (amount)":     "Specify the discount as percent or as amount in kopecks",
+	"Неверная дата from":                                                      "Invalid from date",
+	"Неверная дата to":                                                        "Invalid to date",
+	"Дата to раньше from":                                                     "The to date is earlier than from",
+	"Рейтинг должен быть от 0 до 5":                                           "Rating must be between 0 and 5",
+	"Укажите код региона (латиница, цифры, дефис) и название":                 "Specify the region code (Latin letters, digits, hyphen) and name",
+	"Коэффициент должен быть больше 0 и не больше 10":                         "Multiplier must be greater than 0 and at most 10",
+	"Idempotency-Key длиннее 255 символов":                                    "Idempotency-Key is longer than 255 characters",
+	"Idempotency-Key уже использован с другим запросом":                       "Idempotency-Key has already been used with a different request",
+	"Запрос с этим Idempotency-Key еще выполняется":                           "A request with this Idempotency-Key is still in progress",
+	"Нельзя сменить роль: в текущей роли есть незавершенные заказы (%d)":      "Cannot switch role: the current role has unfinished orders (%d)",
+	"Роль не выдана пользователю":                                             "The role has not been granted to the user",
+	"Платеж нельзя вернуть в текущем статусе":                                 "The payment cannot be refunded in its current status",
+	"Удержанную предоплату можно вернуть только целиком":                      "A held prepayment can only be refunded in full",
+	"Предоплата уже выплачена бригадиру":                                      "The prepayment has already been paid out to the contractor",
+	"Сумма возврата должна быть от 1 копейки до остатка платежа":              "Refund amount must be between 1 kopeck and the remaining payment",
+	"Неверная подпись уведомления":                                            "Invalid notification signature",
+	"Уведомление не разобрано":                                                "The notification could not be parsed",
+	"Уведомление не относится к известному платежу":                           "The notification does not refer to a known payment",
+	"Неизвестный сценарий: success, failure или delayed":                      "Unknown scenario: success, failure or delayed",
+	"Не удалось рассчитать предоплату: у заказа не указана площадь":           "Cannot calculate the prepayment: the order has no area",
+	"Предоплата уже внесена или возвращена":                                   "The prepayment has already been paid or refunded",
+	"Документ уже подписан и не может быть изменен":                           "The document is already signed and cannot be changed",
+	"Файл документа изменен после формирования, сформируйте его заново":       "The document file changed after it was generated, generate it again",
+	"Реестр уже закрыт":                                                       "The payout batch is already closed",
+	"У заказа нет бригадира":                                                  "The order has no contractor",
+	"роль должна быть client или contractor":                                  "role must be client or contractor",
+	"площадь должна быть больше нуля":                                         "area must be greater than zero",
+	"Требуется авторизация Telegram":                                          "Telegram authorization is required",
+	"Проверка подписи Telegram не настроена":                                  "Telegram signature verification is not configured",
+	"Подпись Telegram не прошла проверку":                                     "Telegram signature verification failed",
+	"Неверный telegram_id":                                                    "Invalid telegram_id",
+	"telegram_id в пути, строке запроса и теле не совпадают":                  "telegram_id in the path, query string and body do not match",
+	"telegram_id не совпадает с авторизованным пользователем":                 "telegram_id does not match the authorized user",
+	"Требуется API-ключ или подпись Telegram администратора":                  "An API key or an administrator Telegram signature is required",
+	"Укажите название ключа":                                                  "Specify the key name",
+	"Ключ не найден или уже отозван":                                          "Key not found or already revoked",
+	"Заказ уже оплачен":                                                       "The order has already been paid",
+	"Укажите причину смены статуса":                                           "Specify the reason for the status change",
+	"Статус должен быть active, suspended или banned":                         "Status must be active, suspended or banned",
+	"Срок приостановки не может быть отрицательным":                           "Suspension period cannot be negative",
+	"Укажите причину":                                                         "Specify the reason",
+	"Неизвестное правило":                                                     "Unknown rule",
+	"Порог должен быть не меньше 1, окно - не меньше 0 дней":                  "Threshold must be at least 1 and the window at least 0 days",
+	"Флаг не найден":                                                          "Flag not found",
+	"Флаг уже разобран":                                                       "Flag has already been resolved",
+	"Действие должно быть dismiss, suspend или ban":                           "Action must be dismiss, suspend or ban",
+	"Действие должно быть approve, reject или suspend":                        "Action must be approve, reject or suspend",
+	"Укажите комментарий для бригадира":                                       "Specify a comment for the contractor",
+	"Действие недоступно в текущем статусе проверки":                          "Action is not available in the current verification status",
+	"Неизвестный тариф: %s":                                                   "Unknown tariff: %s",
+	"Неверная цена тарифа %s":                                                 "Invalid price for tariff %s",
+
+	// Проверка данных: регионы, промокоды, торги, комнаты, расчет материалов
+	"комната %d: %s":                                                                 "room %d: %s",
+	"неизвестный регион: %s":                                                         "unknown region: %s",
+	"промокод не найден":                                                             "promo code not found",
+	"промокод начнет действовать %s":                                                 "promo code becomes valid on %s",
+	"срок действия промокода истек":                                                  "promo code has expired",
+	"промокод действует только на тарифы %s":                                         "promo code is valid only for tariffs %s",
+	"промокод закончился":                                                            "promo code has run out",
+	"вы уже использовали этот промокод":                                              "you have already used this promo code",
+	"промокод действует только на первый заказ":                                      "promo code is valid only for the first order",
+	"неверная дата %s, ожидается YYYY-MM-DD":                                         "invalid date %s, expected YYYY-MM-DD",
+	"срок приема предложений должен быть от 1 до %d часов":                           "bidding period must be between 1 and %d hours",
+	"крайняя дата начала работ раньше окончания приема предложений":                  "the latest start date is earlier than the end of bidding",
+	"площадь должна быть от 0 до %d м²":                                              "area must be between 0 and %d m²",
+	"для прямоугольной комнаты нужны ширина и длина":                                 "a rectangular room needs width and length",
+	"размеры комнаты должны быть от 0 до %d м":                                       "room dimensions must be between 0 and %d m",
+	"неизвестная форма комнаты: %s":                                                  "unknown room shape: %s",
+	"площадь комнаты %.2f м² вне допустимых пределов (%g–%d м²)":                     "room area %.2f m² is out of range (%g–%d m²)",
+	"у многоугольной комнаты должно быть не меньше 3 стен":                           "a polygonal room must have at least 3 walls",
+	"длина стены должна быть от 0 до %d м":                                           "wall length must be between 0 and %d m",
+	"угол между стенами должен быть от 0 до 360 градусов":                            "the angle between walls must be between 0 and 360 degrees",
+	"контур комнаты не замыкается (расхождение %.2f м), проверьте длины стен и углы": "the room outline does not close (gap %.2f m), check wall lengths and angles",
+	"сумма углов комнаты %.1f°, а для %d стен должна быть %g°":                       "the room's angles add up to %.1f°, but for %d walls they must add up to %g°",
+	"стены комнаты пересекаются, проверьте длины стен и углы":                        "the room's walls intersect, check the wall lengths and angles",
+	"для тарифа %s нет норм расхода":                                                 "no consumption rates for tariff %s",
+	"толщина слоя для тарифа %s должна быть от %g до %g мм":                          "layer thickness for tariff %s must be between %g and %g mm",
+
+	// Проверка запросов
+	"Запрос не прошел проверку":             "Request validation failed",
+	"Обязательное поле":                     "Required field",
+	"Обязательный параметр":                 "Required parameter",
+	"Обязательна при создании пользователя": "Required when creating a user",
+	"Ожидается объект":                      "Expected an object",
+	"Ожидается массив":                      "Expected an array",
+	"Ожидается строка":                      "Expected a string",
+	"Ожидается число":                       "Expected a number",
+	"Ожидается целое число":                 "Expected an integer",
+	"Ожидается true или false":              "Expected true or false",
+	"Допустимые значения: %s":               "Allowed values: %s",
+	"Поле не может быть пустым":             "Must not be empty",
+	"Длина должна быть не меньше %d":        "Length must be at least %d",
+	"Длина должна быть не больше %d":        "Length must be at most %d",
+	"Ожидается дата в формате YYYY-MM-DD":   "Expected a date in YYYY-MM-DD format",
+	"Неверный формат":                       "Invalid format",
+	"Должно быть больше %g":                 "Must be greater than %g",
+	"Должно быть не меньше %g":              "Must be at least %g",
+	"Должно быть не больше %g":              "Must be at most %g",
+}
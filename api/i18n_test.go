@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCatalogsHaveSameKeys(t *testing.T) {
+	for key := range messagesEN {
+		if _, ok := messagesUZ[key]; !ok {
+			t.Errorf("нет перевода на узбекский: %q", key)
+		}
+	}
+	for key := range messagesUZ {
+		if _, ok := messagesEN[key]; !ok {
+			t.Errorf("нет перевода на английский: %q", key)
+		}
+	}
+}
+
+func TestValidatePromoLocalized(t *testing.T) {
+	newTestApp(t)
+	var resp struct {
+		Valid bool   `json:"valid"`
+		Error string `json:"error"`
+	}
+	body := map[string]interface{}{"code": "NOPE", "category": "econom"}
+	if code := doJSON(t, "POST", "/api/promo/validate?lang=en", body, &resp); code != http.StatusOK || resp.Valid || resp.Error != messagesEN["промокод не найден"] {
+		t.Errorf("неизвестный промокод: статус %d, ответ %+v", code, resp)
+	}
+
+	var errResp struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	body["region"] = "atlantis"
+	if code := doJSON(t, "POST", "/api/promo/validate?lang=uz", body, &errResp); code != http.StatusBadRequest || errResp.Error.Message != "noma'lum hudud: atlantis" {
+		t.Errorf("неизвестный регион: статус %d, ответ %+v", code, errResp)
+	}
+}
@@ -0,0 +1,272 @@
+package handler
+
+// messagesUZ - узбекский каталог (латиница), ключи совпадают с messagesEN
+var messagesUZ = map[string]string{
+	// Тарифы
+	"ЭКОНОМ":            "EKONOM",
+	"КОМФОРТ":           "KOMFORT",
+	"БИЗНЕС":            "BIZNES",
+	"ПРЕМИУМ":           "PREMIUM",
+	"УНИВЕРСАЛ":         "UNIVERSAL",
+	"САМОВЫРАВНИВАТЕЛЬ": "O'ZI TEKISLANUVCHI",
+	"Мокрая, ручная":    "Ho'l, qo'lda",
+	"Полусухая механизированная":                "Yarim quruq, mexanizatsiyalashgan",
+	"С армированием":                            "Armaturali",
+	"Сухая стяжка Кнауф":                        "Knauf quruq styajkasi",
+	"Плавающая / Утепленная":                    "Suzuvchi / Isitilgan",
+	"Финишный слой":                             "Pardozlash qatlami",
+	"Классика":                                  "Klassika",
+	"Низкая цена материалов":                    "Materiallar arzon",
+	"Долгий срок высыхания":                     "Uzoq quriydi",
+	"Высокий риск трещин":                       "Yorilish xavfi yuqori",
+	"Оптимальный баланс":                        "Eng maqbul muvozanat",
+	"Минимум усадки":                            "Cho'kish minimal",
+	"Можно ходить через 12 часов":               "12 soatdan keyin yurish mumkin",
+	"Самый популярный выбор":                    "Eng ommabop tanlov",
+	"Повышенная прочность":                      "Yuqori mustahkamlik",
+	"Надбавка за армирование сеткой или фиброй": "To'r yoki fibra bilan armaturalash uchun qo'shimcha to'lov",
+	"Нет мокрых процессов":                      "Ho'l jarayonlar yo'q",
+	"Идеальная геометрия":                       "Mukammal geometriya",
+	"Теплоизоляция":                             "Issiqlik izolyatsiyasi",
+	"Высокая цена материалов":                   "Materiallar qimmat",
+	"Зависит от вида утеплителя":                "Isitgich turiga bog'liq",
+	"Включает слой изоляции":                    "Izolyatsiya qatlami kiradi",
+	"Как у базового тарифа":                     "Asosiy tarifdagidek",
+	" (плитка — %s, ламинат — %s)":              " (kafel — %s, laminat — %s)",
+
+	// Уведомления бота
+	"🏷 Новое предложение по заказу №%d: %d ₽/м², начало работ %s":      "🏷 №%d buyurtma bo'yicha yangi taklif: %d ₽/m², ish boshlanishi %s",
+	"✅ Клиент выбрал ваше предложение по заказу №%d. Начало работ: %s": "✅ Mijoz №%d buyurtma bo'yicha taklifingizni tanladi. Ish boshlanishi: %s",
+	"Мы ввели проверку бригадиров. Чтобы снова получать заказы, загрузите в профиле паспорт и справку самозанятого или выписку ЕГРИП/ЕГРЮЛ и отправьте их на проверку.": "Biz brigadirlarni tekshirishni joriy qildik. Yana buyurtmalar olish uchun profilingizga pasport va o'zini o'zi band qilganlik ma'lumotnomasi yoki reestrdan ko'chirmani yuklang va tekshiruvga yuboring.",
+	"💬 Заказ №%d, %s:\n%s": "💬 Buyurtma №%d, %s:\n%s",
+	"Собеседник":           "Suhbatdosh",
+	"[фото]":               "[rasm]",
+	"Ваш профиль бригадира подтвержден, теперь вам доступны заказы.": "Brigadir profilingiz tasdiqlandi, endi sizga buyurtmalar ochiq.",
+	"Проверка профиля бригадира не пройдена: %s":                     "Brigadir profili tekshiruvdan o'tmadi: %s",
+	"Ваш профиль бригадира приостановлен: %s":                        "Brigadir profilingiz to'xtatildi: %s",
+	"Ваш аккаунт снова активен.":                                     "Akkauntingiz yana faol.",
+	"Аккаунт заблокирован":                                           "Akkaunt bloklangan",
+	"Аккаунт приостановлен":                                          "Akkaunt to'xtatilgan",
+	" до %s (МСК)": " %s gacha (Moskva vaqti)",
+	"Кассовый чек": "Kassa cheki",
+	"Чек возврата": "Qaytarish cheki",
+	"Чек полного расчета (зачет предоплаты)": "To'liq hisob-kitob cheki (oldindan to'lov hisobga olingan)",
+	"Чек предоплаты":                         "Oldindan to'lov cheki",
+	"%s по заказу №%d\n":                     "№%[2]d buyurtma bo'yicha %[1]s\n",
+	"Итого: %s ₽\n":                          "Jami: %s ₽\n",
+	"Зачтена предоплата: %s ₽\n":             "Oldindan to'lov hisobga olindi: %s ₽\n",
+	"Постоплата: %s ₽\n":                     "Keyingi to'lov: %s ₽\n",
+	"  скидка по промокоду %s: %s ₽\n":       "  %[1]s promokodi bo'yicha chegirma: %[2]s ₽\n",
+	"\nПроверить чек: %s":                    "\nChekni tekshirish: %s",
+
+	// Ошибки
+	"Внутренняя ошибка сервера":                  "Serverning ichki xatosi",
+	"Внешний сервис недоступен, повторите позже": "Tashqi xizmat mavjud emas, keyinroq qayta urinib ko'ring",
+	"База данных не настроена":                   "Ma'lumotlar bazasi sozlanmagan",
+	"Маршрут не найден":                          "Yo'nalish topilmadi",
+	"Метод не поддерживается":                    "Usul qo'llab-quvvatlanmaydi",
+	"Неверный формат данных":                     "Ma'lumotlar formati noto'g'ri",
+	"Не удалось прочитать запрос":                "So'rovni o'qib bo'lmadi",
+	"Запрос слишком большой":                     "So'rov juda katta",
+	"Неверный telegram ID":                       "Telegram ID noto'g'ri",
+	"Неверный order ID":                          "Buyurtma ID noto'g'ri",
+	"Неверный user ID":                           "user ID noto'g'ri",
+	"Неверный bid ID":                            "bid ID noto'g'ri",
+	"Неверный document ID":                       "document ID noto'g'ri",
+	"Неверный payment ID":                        "payment ID noto'g'ri",
+	"Неверный receipt ID":                        "receipt ID noto'g'ri",
+	"Неверный batch ID":                          "batch ID noto'g'ri",
+	"Неверный ID ключа":                          "Kalit ID noto'g'ri",
+	"Неверный ID флага":                          "Belgi ID noto'g'ri",
+	"Неверный API-ключ":                          "API kaliti noto'g'ri",
+	"Недостаточно прав":                          "Huquqlar yetarli emas",
+	"Пользователь не найден":                     "Foydalanuvchi topilmadi",
+	"Не удалось создать пользователя":            "Foydalanuvchini yaratib bo'lmadi",
+	"Пользователь не является бригадиром":        "Foydalanuvchi brigadir emas",
+	"Сначала заполните профиль бригадира":        "Avval brigadir profilini to'ldiring",
+	"Профиль бригадира не найден":                "Brigadir profili topilmadi",
+	"Профиль бригадира не активен":               "Brigadir profili faol emas",
+	"Профиль бригадира не подтвержден":           "Brigadir profili tasdiqlanmagan",
+	"Заказ не найден":                            "Buyurtma topilmadi",
+	"Заказ отменен":                              "Buyurtma bekor qilingan",
+	"Заказ уже выполнен":                         "Buyurtma allaqachon bajarilgan",
+	"Заказ уже отменен или выполнен":             "Buyurtma allaqachon bekor qilingan yoki bajarilgan",
+	"Заказ уже принят или отменен":               "Buyurtma allaqachon qabul qilingan yoki bekor qilingan",
+	"Бригадир занят или не допущен к заказам":    "Brigadir band yoki buyurtmalarga ruxsat etilmagan",
+	"Завершить можно только принятый заказ":      "Faqat qabul qilingan buyurtmani yakunlash mumkin",
+	"Неизвестный статус заказа":                  "Buyurtma holati noma'lum",
+	"Бригадир занят другим заказом":              "Brigadir boshqa buyurtma bilan band",
+	"Недопустимая смена статуса заказа":          "Buyurtma holatini bunday o'zgartirib bo'lmaydi",
+	"Неверный курсор":                            "Kursor noto'g'ri",
+	"Заказ уже в этом статусе":                   "Buyurtma allaqachon shu holatda",
+	"Заказ в другом регионе":                     "Buyurtma boshqa hududda",
+	"Заказ не в режиме торгов":                   "Buyurtma savdo rejimida emas",
+	"Заказ в режиме торгов: отправьте предложение, клиент выберет бригадира": "Buyurtma savdo rejimida: taklif yuboring, mijoz brigadirni tanlaydi",
+	"Неизвестный режим заказа":                                                "Noma'lum buyurtma rejimi",
+	"Вознаграждение по заказу уже в реестре выплат":                           "Buyurtma bo'yicha haq allaqachon to'lovlar reestrida",
+	"Нет доступа к заказу":                                                    "Buyurtmaga kirish huquqi yo'q",
+	"Нет доступа к чату заказа":                                               "Buyurtma chatiga kirish huquqi yo'q",
+	"Бригадир не работает по тарифу заказа":                                   "Brigadir buyurtma tarifi bo'yicha ishlamaydi",
+	"Бригадир еще не завершил работы":                                         "Brigadir hali ishni tugatmagan",
+	"Подтвердить выполнение может только клиент":                              "Bajarilganini faqat mijoz tasdiqlashi mumkin",
+	"Неявку бригадира может указать только клиент по принятому заказу":        "Brigadir kelmaganini faqat qabul qilingan buyurtma mijozi ko'rsatishi mumkin",
+	"Прием предложений по заказу закончен":                                    "Buyurtma bo'yicha takliflar qabul qilish tugadi",
+	"Предложение не найдено":                                                  "Taklif topilmadi",
+	"Предложение не сохранено":                                                "Taklif saqlanmadi",
+	"Предложение уже неактуально":                                             "Taklif endi dolzarb emas",
+	"Выбрать предложение может только клиент":                                 "Taklifni faqat mijoz tanlashi mumkin",
+	"Укажите цену за м²":                                                      "1 m² narxini kiriting",
+	"Укажите дату начала работ в формате YYYY-MM-DD":                          "Ish boshlanish sanasini YYYY-MM-DD formatida kiriting",
+	"Цена вне тарифа (%d–%d ₽/м²): укажите обоснование в comment":             "Narx tarifdan tashqarida (%d–%d ₽/m²): comment maydonida asoslang",
+	"Дата начала работ должна быть не раньше сегодняшней и не позже %s":       "Ish boshlanish sanasi bugundan oldin va %s dan keyin bo'lmasligi kerak",
+	"Пустое сообщение":                                                        "Bo'sh xabar",
+	"Сообщение длиннее %d символов":                                           "Xabar %d belgidan uzun",
+	"Не удалось получить сообщение":                                           "Xabarni olib bo'lmadi",
+	"Потоковая передача не поддерживается":                                    "Oqimli uzatish qo'llab-quvvatlanmaydi",
+	"Файл не передан":                                                         "Fayl yuborilmadi",
+	"Файл не передан или слишком большой":                                     "Fayl yuborilmagan yoki juda katta",
+	"Файл слишком большой или неверный формат данных":                         "Fayl juda katta yoki ma'lumotlar formati noto'g'ri",
+	"Файл больше %d МБ":                                                       "Fayl %d MB dan katta",
+	"Файл не найден":                                                          "Fayl topilmadi",
+	"Ссылка недействительна или устарела":                                     "Havola yaroqsiz yoki eskirgan",
+	"Допустимы только JPEG и PNG":                                             "Faqat JPEG va PNG ruxsat etiladi",
+	"Допустимы только JPEG, PNG и PDF":                                        "Faqat JPEG, PNG va PDF ruxsat etiladi",
+	"Не удалось прочитать изображение":                                        "Rasmni o'qib bo'lmadi",
+	"Изображение слишком большое":                                             "Rasm juda katta",
+	"Недопустимый размер аватара":                                             "Avatar o'lchami yaroqsiz",
+	"Аватар не загружен":                                                      "Avatar yuklanmagan",
+	"Аватар не найден":                                                        "Avatar topilmadi",
+	"Telegram бот не настроен":                                                "Telegram bot sozlanmagan",
+	"Неизвестный вид вложения":                                                "Noma'lum ilova turi",
+	"Неизвестный вид документа":                                               "Noma'lum hujjat turi",
+	"Документ не найден":                                                      "Hujjat topilmadi",
+	"Договор формируется после назначения бригадира":                          "Shartnoma brigadir tayinlangandan keyin shakllantiriladi",
+	"Акт формируется после завершения работ":                                  "Dalolatnoma ishlar tugagandan keyin shakllantiriladi",
+	"Акт подписывается после завершения работ":                                "Dalolatnoma ishlar tugagandan keyin imzolanadi",
+	"Подписать документ может только сторона заказа":                          "Hujjatni faqat buyurtma tomoni imzolashi mumkin",
+	"Загрузите паспорт":                                                       "Pasportni yuklang",
+	"Загрузите справку самозанятого или выписку из ЕГРИП/ЕГРЮЛ":               "O'zini o'zi band qilganlik ma'lumotnomasini yoki reestrdan ko'chirmani yuklang",
+	"Документы нельзя изменить, пока заявка на проверке или подтверждена":     "Ariza tekshiruvda yoki tasdiqlangan paytda hujjatlarni o'zgartirib bo'lmaydi",
+	"Заявка уже на проверке или профиль подтвержден":                          "Ariza allaqachon tekshiruvda yoki profil tasdiqlangan",
+	"Оплатить заказ может только клиент":                                      "Buyurtmani faqat mijoz to'lashi mumkin",
+	"Не указана сумма платежа":                                                "To'lov summasi ko'rsatilmagan",
+	"Платеж не найден":                                                        "To'lov topilmadi",
+	"Неизвестный провайдер":                                                   "Noma'lum provayder",
+	"Песочница отключена":                                                     "Sinov rejimi o'chirilgan",
+	"Статус должен быть paid или failed":                                      "Holat paid yoki failed bo'lishi kerak",
+	"Предоплата вносится после принятия заказа бригадиром":                    "Oldindan to'lov brigadir buyurtmani qabul qilgandan keyin kiritiladi",
+	"Предоплата по заказу не выставлялась":                                    "Buyurtma bo'yicha oldindan to'lov so'ralmagan",
+	"Чек не найден":                                                           "Chek topilmadi",
+	"Чек может запросить только клиент":                                       "Chekni faqat mijoz so'rashi mumkin",
+	"Нет начислений к выплате":                                                "To'lanadigan hisoblashlar yo'q",
+	"Реестр не найден":                                                        "Reestr topilmadi",
+	"Неизвестный формат, доступны csv и 1c":                                   "Noma'lum format, csv va 1c mavjud",
+	"Укажите получателя, ИНН (10 или 12 цифр), счет (20 цифр) и БИК (9 цифр)": "Oluvchi, INN (10 yoki 12 raqam), hisob raqami (20 raqam) va BIK (9 raqam) ni ko'rsating",
+	"Процент комиссии должен быть от 0 до 100":                                "Komissiya foizi 0 dan 100 gacha bo'lishi kerak",
+	"Не указан код":                                                           "Kod ko'rsatilmagan",
+	"Не указана категория":                                                    "Toifa ko'rsatilmagan",
+	"Неизвестный тариф":                                                       "Noma'lum tarif",
+	"Неверные дополнительные работы":                                          "Qo'shimcha ishlar noto'g'ri",
+	"Процент скидки должен быть от 0 до 100":                                  "Chegirma foizi 0 dan 100 gacha bo'lishi kerak",
+	"Сумма скидки должна быть положительной":                                  "Chegirma summasi musbat bo'lishi kerak",
+	"Укажите скидку в процентах (percent) или суммой в копейках (amount)":     "Chegirmani foizda (percent) yoki tiyinlardagi summada (amount) ko'rsating",
+	"Неверная дата from":                                                      "from sanasi noto'g'ri",
+	"Неверная дата to":                                                        "to sanasi noto'g'ri",
+	"Дата to раньше from":                                                     "to sanasi from sanasidan oldin",
+	"Рейтинг должен быть от 0 до 5":                                           "Reyting 0 dan 5 gacha bo'lishi kerak",
+	"Укажите код региона (латиница, цифры, дефис) и название":                 "Hudud kodini (lotin harflari, raqamlar, chiziqcha) va nomini ko'rsating",
+	"Коэффициент должен быть больше 0 и не больше 10":                         "Koeffitsiyent 0 dan katta va 10 dan oshmasligi kerak",
+	"Idempotency-Key длиннее 255 символов":                                    "Idempotency-Key 255 belgidan uzun",
+	"Idempotency-Key уже использован с другим запросом":                       "Idempotency-Key boshqa so'rov bilan allaqachon ishlatilgan",
+	"Запрос с этим Idempotency-Key еще выполняется":                           "Bu Idempotency-Key bilan so'rov hali bajarilmoqda",
+	"Нельзя сменить роль: в текущей роли есть незавершенные заказы (%d)":      "Rolni almashtirib bo'lmaydi: joriy rolda tugallanmagan buyurtmalar bor (%d)",
+	"Роль не выдана пользователю":                                             "Foydalanuvchiga bu rol berilmagan",
+	"Платеж нельзя вернуть в текущем статусе":                                 "To'lovni joriy holatda qaytarib bo'lmaydi",
+	"Удержанную предоплату можно вернуть только целиком":                      "Ushlab turilgan oldindan to'lovni faqat to'liq qaytarish mumkin",
+	"Предоплата уже выплачена бригадиру":                                      "Oldindan to'lov brigadirga allaqachon to'langan",
+	"Сумма возврата должна быть от 1 копейки до остатка платежа":              "Qaytariladigan summa 1 tiyindan to'lov qoldig'igacha bo'lishi kerak",
+	"Неверная подпись уведомления":                                            "Bildirishnoma imzosi noto'g'ri",
+	"Уведомление не разобрано":                                                "Bildirishnomani o'qib bo'lmadi",
+	"Уведомление не относится к известному платежу":                           "Bildirishnoma ma'lum to'lovga tegishli emas",
+	"Неизвестный сценарий: success, failure или delayed":                      "Noma'lum ssenariy: success, failure yoki delayed",
+	"Не удалось рассчитать предоплату: у заказа не указана площадь":           "Oldindan to'lovni hisoblab bo'lmadi: buyurtmada maydon ko'rsatilmagan",
+	"Предоплата уже внесена или возвращена":                                   "Oldindan to'lov allaqachon kiritilgan yoki qaytarilgan",
+	"Документ уже подписан и не может быть изменен":                           "Hujjat allaqachon imzolangan va uni o'zgartirib bo'lmaydi",
+	"Файл документа изменен после формирования, сформируйте его заново":       "Hujjat fayli shakllantirilgandan keyin o'zgargan, uni qaytadan shakllantiring",
+	"Реестр уже закрыт":                                                       "Reestr allaqachon yopilgan",
+	"У заказа нет бригадира":                                                  "Buyurtmada brigadir yo'q",
+	"роль должна быть client или contractor":                                  "rol client yoki contractor bo'lishi kerak",
+	"площадь должна быть больше нуля":                                         "maydon noldan katta bo'lishi kerak",
+	"Требуется авторизация Telegram":                                          "Telegram avtorizatsiyasi talab qilinadi",
+	"Проверка подписи Telegram не настроена":                                  "Telegram imzosini tekshirish sozlanmagan",
+	"Подпись Telegram не прошла проверку":                                     "Telegram imzosi tekshiruvdan o'tmadi",
+	"Неверный telegram_id":                                                    "telegram_id noto'g'ri",
+	"telegram_id в пути, строке запроса и теле не совпадают":                  "Yo'l, so'rov satri va tanadagi telegram_id mos kelmaydi",
+	"telegram_id не совпадает с авторизованным пользователем":                 "telegram_id avtorizatsiyadan o'tgan foydalanuvchiga mos kelmaydi",
+	"Требуется API-ключ или подпись Telegram администратора":                  "API kaliti yoki administratorning Telegram imzosi talab qilinadi",
+	"Укажите название ключа":                                                  "Kalit nomini ko'rsating",
+	"Ключ не найден или уже отозван":                                          "Kalit topilmadi yoki allaqachon bekor qilingan",
+	"Заказ уже оплачен":                                                       "Buyurtma allaqachon to'langan",
+	"Укажите причину смены статуса":                                           "Holat o'zgarishi sababini ko'rsating",
+	"Статус должен быть active, suspended или banned":                         "Holat active, suspended yoki banned bo'lishi kerak",
+	"Срок приостановки не может быть отрицательным":                           "To'xtatib turish muddati manfiy bo'lishi mumkin emas",
+	"Укажите причину":                                                         "Sababini ko'rsating",
+	"Неизвестное правило":                                                     "Noma'lum qoida",
+	"Порог должен быть не меньше 1, окно - не меньше 0 дней":                  "Chegara kamida 1, oyna kamida 0 kun bo'lishi kerak",
+	"Флаг не найден":                                                          "Belgi topilmadi",
+	"Флаг уже разобран":                                                       "Belgi allaqachon ko'rib chiqilgan",
+	"Действие должно быть dismiss, suspend или ban":                           "Amal dismiss, suspend yoki ban bo'lishi kerak",
+	"Действие должно быть approve, reject или suspend":                        "Amal approve, reject yoki suspend bo'lishi kerak",
+	"Укажите комментарий для бригадира":                                       "Brigadir uchun izoh yozing",
+	"Действие недоступно в текущем статусе проверки":                          "Tekshiruvning joriy holatida bu amal mavjud emas",
+	"Неизвестный тариф: %s":                                                   "Noma'lum tarif: %s",
+	"Неверная цена тарифа %s":                                                 "%s tarifi narxi noto'g'ri",
+
+	// Проверка данных: регионы, промокоды, торги, комнаты, расчет материалов
+	"комната %d: %s":                                                                 "%d-xona: %s",
+	"неизвестный регион: %s":                                                         "noma'lum hudud: %s",
+	"промокод не найден":                                                             "promokod topilmadi",
+	"промокод начнет действовать %s":                                                 "promokod %s dan amal qiladi",
+	"срок действия промокода истек":                                                  "promokod muddati tugagan",
+	"промокод действует только на тарифы %s":                                         "promokod faqat %s tariflariga amal qiladi",
+	"промокод закончился":                                                            "promokod tugagan",
+	"вы уже использовали этот промокод":                                              "siz bu promokoddan allaqachon foydalangansiz",
+	"промокод действует только на первый заказ":                                      "promokod faqat birinchi buyurtmaga amal qiladi",
+	"неверная дата %s, ожидается YYYY-MM-DD":                                         "%s sanasi noto'g'ri, YYYY-MM-DD kutilmoqda",
+	"срок приема предложений должен быть от 1 до %d часов":                           "takliflarni qabul qilish muddati 1 dan %d soatgacha bo'lishi kerak",
+	"крайняя дата начала работ раньше окончания приема предложений":                  "ishlarni boshlashning oxirgi sanasi takliflar qabuli tugashidan oldin",
+	"площадь должна быть от 0 до %d м²":                                              "maydon 0 dan %d m² gacha bo'lishi kerak",
+	"для прямоугольной комнаты нужны ширина и длина":                                 "to'rtburchak xona uchun eni va bo'yi kerak",
+	"размеры комнаты должны быть от 0 до %d м":                                       "xona o'lchamlari 0 dan %d m gacha bo'lishi kerak",
+	"неизвестная форма комнаты: %s":                                                  "noma'lum xona shakli: %s",
+	"площадь комнаты %.2f м² вне допустимых пределов (%g–%d м²)":                     "xona maydoni %.2f m² ruxsat etilgan chegaradan tashqarida (%g–%d m²)",
+	"у многоугольной комнаты должно быть не меньше 3 стен":                           "ko'pburchak xonada kamida 3 ta devor bo'lishi kerak",
+	"длина стены должна быть от 0 до %d м":                                           "devor uzunligi 0 dan %d m gacha bo'lishi kerak",
+	"угол между стенами должен быть от 0 до 360 градусов":                            "devorlar orasidagi burchak 0 dan 360 gradusgacha bo'lishi kerak",
+	"контур комнаты не замыкается (расхождение %.2f м), проверьте длины стен и углы": "xona konturi yopilmaydi (farq %.2f m), devor uzunliklari va burchaklarni tekshiring",
+	"сумма углов комнаты %.1f°, а для %d стен должна быть %g°":                       "xona burchaklari yig'indisi %.1f°, %d ta devor uchun esa %g° bo'lishi kerak",
+	"стены комнаты пересекаются, проверьте длины стен и углы":                        "xona devorlari kesishadi, devor uzunliklari va burchaklarni tekshiring",
+	"для тарифа %s нет норм расхода":                                                 "%s tarifi uchun sarf me'yorlari yo'q",
+	"толщина слоя для тарифа %s должна быть от %g до %g мм":                          "%s tarifi uchun qatlam qalinligi %g dan %g mm gacha bo'lishi kerak",
+
+	// Проверка запросов
+	"Запрос не прошел проверку":             "So'rov tekshiruvdan o'tmadi",
+	"Обязательное поле":                     "Majburiy maydon",
+	"Обязательный параметр":                 "Majburiy parametr",
+	"Обязательна при создании пользователя": "Foydalanuvchi yaratishda majburiy",
+	"Ожидается объект":                      "Obyekt kutilmoqda",
+	"Ожидается массив":                      "Massiv kutilmoqda",
+	"Ожидается строка":                      "Satr kutilmoqda",
+	"Ожидается число":                       "Son kutilmoqda",
+	"Ожидается целое число":                 "Butun son kutilmoqda",
+	"Ожидается true или false":              "true yoki false kutilmoqda",
+	"Допустимые значения: %s":               "Ruxsat etilgan qiymatlar: %s",
+	"Поле не может быть пустым":             "Maydon bo'sh bo'lmasligi kerak",
+	"Длина должна быть не меньше %d":        "Uzunlik kamida %d bo'lishi kerak",
+	"Длина должна быть не больше %d":        "Uzunlik ko'pi bilan %d bo'lishi kerak",
+	"Ожидается дата в формате YYYY-MM-DD":   "YYYY-MM-DD formatidagi sana kutilmoqda",
+	"Неверный формат":                       "Format noto'g'ri",
+	"Должно быть больше %g":                 "%g dan katta bo'lishi kerak",
+	"Должно быть не меньше %g":              "Kamida %g bo'lishi kerak",
+	"Должно быть не больше %g":              "Ko'pi bilan %g bo'lishi kerak",
+}
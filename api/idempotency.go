@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Повторы запросов по заголовку Idempotency-Key. Ключ, хеш запроса и ответ
+// хранятся в БД, поэтому повтор, попавший на другой инстанс Vercel, получает
+// тот же ответ. Тот же ключ с другим запросом - 422, пока первый запрос
+// выполняется - 409. Ответы 5xx не сохраняются: такой запрос можно повторить.
+// Ключи действуют в пределах вызывающего (idempotencyScope): одинаковый ключ
+// у разных пользователей не отдает чужой ответ.
+const (
+	idempotencyTTL = 24 * time.Hour
+	// Через сколько незавершенный запрос считается брошенным
+	idempotencyLockTimeout = 2 * time.Minute
+	maxIdempotencyKeyLen   = 255
+	// Самое большое тело - загрузка вложения
+	maxIdempotentBodySize = maxAttachmentSize + 2<<20
+)
+
+// responseRecorder пишет ответ клиенту и одновременно запоминает его
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
+
+// idempotencyHash - хеш метода, адреса и тела. Граница multipart у каждой попытки
+// своя, поэтому в хеш она не входит.
+func idempotencyHash(r *http.Request, body []byte) string {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if boundary := params["boundary"]; boundary != "" {
+		body = bytes.ReplaceAll(body, []byte(boundary), nil)
+	}
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n"+mediaType+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+const idempotencyKeysTable = `CREATE TABLE IF NOT EXISTS idempotency_keys (
+	scope TEXT NOT NULL,
+	key TEXT NOT NULL,
+	request_hash TEXT NOT NULL,
+	status_code INTEGER,
+	content_type TEXT,
+	response BLOB,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	completed_at DATETIME,
+	PRIMARY KEY (scope, key)
+)`
+
+// migrateIdempotencyScope пересоздает таблицу с ключом (scope, key). Старые
+// записи не переносятся: они живут сутки, а чей это был ключ, уже не узнать.
+func (app *App) migrateIdempotencyScope() error {
+	var schema string
+	if err := app.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'idempotency_keys'`).Scan(&schema); err != nil {
+		return err
+	}
+	if strings.Contains(schema, "scope") {
+		return nil
+	}
+	tx, err := app.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, query := range []string{
+		`DROP TABLE idempotency_keys`,
+		idempotencyKeysTable,
+		`CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created ON idempotency_keys(created_at)`,
+	} {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// idempotencyScope - кто повторяет запрос: пользователь Telegram, владелец
+// API-ключа админ-API или анонимный клиент
+func idempotencyScope(r *http.Request) string {
+	if id := callerTelegramID(r); id != 0 {
+		return "tg:" + strconv.FormatInt(id, 10)
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:])
+	}
+	return "anon"
+}
+
+type idempotencyRecord struct {
+	requestHash string
+	status      sql.NullInt64
+	contentType sql.NullString
+	response    []byte
+}
+
+// claimIdempotencyKey занимает ключ и возвращает nil. Если ключ уже занят,
+// возвращает сохраненную запись. Запрос, который завис дольше
+// idempotencyLockTimeout (упал инстанс), можно выполнить заново.
+func (app *App) claimIdempotencyKey(scope, key, hash string) (*idempotencyRecord, error) {
+	now := time.Now().UTC()
+	if _, err := app.db.Exec(`DELETE FROM idempotency_keys WHERE created_at < ?`, now.Add(-idempotencyTTL).Format("2006-01-02 15:04:05")); err != nil {
+		return nil, err
+	}
+	result, err := app.db.Exec(`INSERT OR IGNORE INTO idempotency_keys (scope, key, request_hash) VALUES (?, ?, ?)`, scope, key, hash)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 1 {
+		return nil, nil
+	}
+	result, err = app.db.Exec(`UPDATE idempotency_keys SET created_at = CURRENT_TIMESTAMP WHERE scope = ? AND key = ? AND request_hash = ? AND status_code IS NULL AND created_at < ?`,
+		scope, key, hash, now.Add(-idempotencyLockTimeout).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 1 {
+		return nil, nil
+	}
+	var rec idempotencyRecord
+	err = app.db.QueryRow(`SELECT request_hash, status_code, content_type, response FROM idempotency_keys WHERE scope = ? AND key = ?`, scope, key).
+		Scan(&rec.requestHash, &rec.status, &rec.contentType, &rec.response)
+	return &rec, err
+}
+
+// idempotency - middleware /api для изменяющих запросов с заголовком Idempotency-Key
+func (app *App) idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Idempotency-Key длиннее 255 символов")
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBodySize+1))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Не удалось прочитать запрос")
+			return
+		}
+		if len(body) > maxIdempotentBodySize {
+			writeError(w, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "Запрос слишком большой")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := idempotencyHash(r, body)
+		scope := idempotencyScope(r)
+
+		stored, err := app.claimIdempotencyKey(scope, key, hash)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		if stored != nil {
+			switch {
+			case stored.requestHash != hash:
+				writeError(w, http.StatusUnprocessableEntity, CodeIdempotencyMismatch, "Idempotency-Key уже использован с другим запросом")
+			case !stored.status.Valid:
+				writeError(w, http.StatusConflict, CodeIdempotencyInProgress, "Запрос с этим Idempotency-Key еще выполняется")
+			default:
+				if stored.contentType.Valid {
+					w.Header().Set("Content-Type", stored.contentType.String)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(int(stored.status.Int64))
+				w.Write(stored.response)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status >= 500 {
+			_, err = app.db.Exec(`DELETE FROM idempotency_keys WHERE scope = ? AND key = ?`, scope, key)
+		} else {
+			_, err = app.db.Exec(`UPDATE idempotency_keys SET status_code = ?, content_type = ?, response = ?, completed_at = CURRENT_TIMESTAMP WHERE scope = ? AND key = ?`,
+				rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes(), scope, key)
+		}
+		if err != nil {
+			log.Printf("Не удалось сохранить ответ для Idempotency-Key %s: %v", key, err)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	a := newTestApp(t)
+	type orderResp struct {
+		Order *Order `json:"order"`
+	}
+	type errorResp struct {
+		Error APIError `json:"error"`
+	}
+	body := map[string]interface{}{"category": "econom", "area": 20}
+	createOrder := func(telegramID, key string, body interface{}, out interface{}) int {
+		t.Helper()
+		return doJSON(t, "POST", "/api/orders?telegram_id="+telegramID, body, out, "Idempotency-Key", key)
+	}
+	countOrders := func() int {
+		t.Helper()
+		var n int
+		if err := a.db.QueryRow(`SELECT COUNT(*) FROM orders`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	var first, replay orderResp
+	if code := createOrder("111", "order-1", body, &first); code != http.StatusOK {
+		t.Fatalf("первый запрос: статус %d", code)
+	}
+	// Повтор получает сохраненный ответ, второй заказ не создается
+	if code := createOrder("111", "order-1", body, &replay); code != http.StatusOK || replay.Order == nil || replay.Order.ID != first.Order.ID {
+		t.Fatalf("повтор: статус %d, заказ %+v, want %d", code, replay.Order, first.Order.ID)
+	}
+	if n := countOrders(); n != 1 {
+		t.Errorf("заказов после повтора: %d, want 1", n)
+	}
+
+	// Тот же ключ с другим телом
+	var errResp errorResp
+	if code := createOrder("111", "order-1", map[string]interface{}{"category": "econom", "area": 30}, &errResp); code != http.StatusUnprocessableEntity || errResp.Error.Code != CodeIdempotencyMismatch {
+		t.Errorf("другой запрос с тем же ключом: статус %d, код %q, want 422", code, errResp.Error.Code)
+	}
+
+	// Тот же ключ у другого пользователя - свой запрос, а не чужой ответ
+	var other orderResp
+	if code := createOrder("222", "order-1", body, &other); code != http.StatusOK || other.Order == nil || other.Order.ID == first.Order.ID {
+		t.Fatalf("ключ другого пользователя: статус %d, заказ %+v", code, other.Order)
+	}
+	if n := countOrders(); n != 2 {
+		t.Errorf("заказов: %d, want 2", n)
+	}
+
+	// Пока первый запрос выполняется, повтор получает 409
+	if _, err := a.db.Exec(`UPDATE idempotency_keys SET status_code = NULL, response = NULL, completed_at = NULL WHERE scope = 'tg:111' AND key = 'order-1'`); err != nil {
+		t.Fatal(err)
+	}
+	errResp = errorResp{}
+	if code := createOrder("111", "order-1", body, &errResp); code != http.StatusConflict || errResp.Error.Code != CodeIdempotencyInProgress {
+		t.Errorf("незавершенный запрос: статус %d, код %q, want 409", code, errResp.Error.Code)
+	}
+	// Брошенный запрос выполняется заново
+	if _, err := a.db.Exec(`UPDATE idempotency_keys SET created_at = datetime('now', '-3 minutes') WHERE scope = 'tg:111' AND key = 'order-1'`); err != nil {
+		t.Fatal(err)
+	}
+	if code := createOrder("111", "order-1", body, nil); code != http.StatusOK {
+		t.Errorf("брошенный запрос: статус %d", code)
+	}
+	if n := countOrders(); n != 3 {
+		t.Errorf("заказов после брошенного запроса: %d, want 3", n)
+	}
+
+	// Ответ 5xx не сохраняется, ключ можно повторить
+	if _, err := a.db.Exec(`ALTER TABLE orders RENAME TO orders_off`); err != nil {
+		t.Fatal(err)
+	}
+	code := createOrder("111", "order-2", body, nil)
+	if _, err := a.db.Exec(`ALTER TABLE orders_off RENAME TO orders`); err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusInternalServerError {
+		t.Fatalf("запрос без таблицы заказов: статус %d, want 500", code)
+	}
+	var kept int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM idempotency_keys WHERE key = 'order-2'`).Scan(&kept); err != nil {
+		t.Fatal(err)
+	}
+	if kept != 0 {
+		t.Errorf("ключ после 500 не освобожден")
+	}
+	var retry orderResp
+	if code := createOrder("111", "order-2", body, &retry); code != http.StatusOK || retry.Order == nil {
+		t.Errorf("повтор после 500: статус %d", code)
+	}
+}
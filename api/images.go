@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// Размер превью по большей стороне, px
+const thumbnailSize = 320
+
+// Качество JPEG для оригиналов вложений: выше, чем у превью
+const originalJPEGQuality = 92
+
+// Предел размера изображения в пикселях (как у 48-мегапиксельной камеры).
+// Файл в несколько мегабайт может объявить размеры, на которые при
+// декодировании ушли бы гигабайты памяти.
+const maxImagePixels = 50_000_000
+
+var (
+	errImageTooLarge   = errors.New("изображение слишком большое")
+	errImageUnreadable = errors.New("не удалось прочитать изображение")
+)
+
+// decodeImage декодирует JPEG или PNG. Размеры проверяются по заголовку до
+// декодирования пикселей.
+func decodeImage(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errImageUnreadable, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, errImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errImageUnreadable, err)
+	}
+	return img, nil
+}
+
+// writeImageError отвечает на ошибку обработки изображения: ошибки
+// decodeImage - клиенту, остальные (хранилище, БД) - в лог
+func writeImageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errImageTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, CodeFileTooLarge, "Изображение слишком большое")
+	case errors.Is(err, errImageUnreadable):
+		writeError(w, http.StatusBadRequest, CodeValidation, "Не удалось прочитать изображение")
+	default:
+		internalError(w, err)
+	}
+}
+
+// resizeImage уменьшает изображение так, чтобы большая сторона была не больше maxSize.
+// Каждый пиксель результата - среднее по соответствующей области исходника.
+func resizeImage(src image.Image, maxSize int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= maxSize && h <= maxSize {
+		return src
+	}
+	dw, dh := maxSize, maxSize
+	if w > h {
+		dh = h * maxSize / w
+	} else {
+		dw = w * maxSize / h
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+	return scaleImage(src, dw, dh)
+}
+
+// cropSquare вырезает центральный квадрат (для аватаров)
+func cropSquare(src image.Image) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	side := w
+	if h < side {
+		side = h
+	}
+	x0 := bounds.Min.X + (w-side)/2
+	y0 := bounds.Min.Y + (h-side)/2
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			dst.Set(x, y, src.At(x0+x, y0+y))
+		}
+	}
+	return dst
+}
+
+func scaleImage(src image.Image, dw, dh int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		sy0 := bounds.Min.Y + dy*h/dh
+		sy1 := bounds.Min.Y + (dy+1)*h/dh
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		for dx := 0; dx < dw; dx++ {
+			sx0 := bounds.Min.X + dx*w/dw
+			sx1 := bounds.Min.X + (dx+1)*w/dw
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.Set(dx, dy, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// encodeJPEG кодирует изображение заново, поэтому метаданные исходника (EXIF) не сохраняются
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sanitizeImage проверяет загруженное изображение и кодирует его заново в
+// том же формате (contentType), так что метаданные исходника - EXIF с
+// координатами съемки, текстовые блоки PNG - не сохраняются. Поворот из EXIF
+// применяется к пикселям, чтобы без метаданных фото не легло на бок. Вместе с
+// оригиналом возвращается превью в JPEG.
+func sanitizeImage(data []byte, contentType string) (original, thumbnail []byte, err error) {
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	img = orientImage(img, exifOrientation(data))
+	var buf bytes.Buffer
+	if contentType == "image/png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: originalJPEGQuality})
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if thumbnail, err = encodeJPEG(resizeImage(img, thumbnailSize)); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), thumbnail, nil
+}
+
+// exifOrientation читает тег Orientation (0x0112) из EXIF в JPEG. Без EXIF
+// или при поврежденных данных возвращает 1 - изображение не повернуто.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		marker := data[pos+1]
+		if marker == 0xD9 || marker == 0xDA {
+			// Дальше идут данные изображения, метаданных уже не будет
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		if segment := data[pos+4 : end]; marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		pos = end
+	}
+	return 1
+}
+
+// tiffOrientation ищет Orientation в IFD0 заголовка TIFF из EXIF
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orientImage поворачивает и отражает изображение по значению EXIF Orientation
+// (1 - как есть, 6 - повернуть на 90° по часовой, 8 - против часовой и т.д.)
+func orientImage(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// Повороты на 90° меняют ширину и высоту местами
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, src.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return dst
+}
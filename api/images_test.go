@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// pngWithSize - PNG 1x1, в заголовке которого объявлены размеры width x height
+func pngWithSize(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// Сигнатура (8 байт), длина и тип IHDR (8 байт), затем ширина и высота
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestDecodeImageChecksDimensionsFirst(t *testing.T) {
+	if _, err := decodeImage(pngWithSize(t, 1, 1)); err != nil {
+		t.Fatalf("PNG 1x1: %v", err)
+	}
+	// 100000 x 100000 - 40 ГБ RGBA: декодер не должен даже пытаться
+	if _, err := decodeImage(pngWithSize(t, 100000, 100000)); !errors.Is(err, errImageTooLarge) {
+		t.Errorf("огромное изображение: %v, want errImageTooLarge", err)
+	}
+	if _, err := decodeImage([]byte("не картинка")); err == nil || errors.Is(err, errImageTooLarge) {
+		t.Errorf("мусор: %v, want ошибку формата", err)
+	}
+}
+
+// jpegWithExif - JPEG width x height с EXIF: Orientation и строкой, которая
+// изображает координаты съемки
+func jpegWithExif(t *testing.T, width, height int, orientation uint16) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	// Левый верхний угол красный, чтобы проверить поворот
+	draw.Draw(img, image.Rect(0, 0, 10, 10), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	// TIFF little-endian: IFD0 с одной записью Orientation (SHORT), затем "GPS"
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	tiff = append(tiff, "GPS 55.7558N 37.6173E"...)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+func TestSanitizeImage(t *testing.T) {
+	data := jpegWithExif(t, 40, 20, 6)
+	if got := exifOrientation(data); got != 6 {
+		t.Fatalf("Orientation %d, want 6", got)
+	}
+	original, thumbnail, err := sanitizeImage(data, "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(original, []byte("Exif")) || bytes.Contains(original, []byte("GPS")) {
+		t.Error("в оригинале остались метаданные EXIF")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Поворот на 90° по часовой: 40x20 -> 20x40, левый верхний угол уходит в правый верхний
+	if b := img.Bounds(); b.Dx() != 20 || b.Dy() != 40 {
+		t.Fatalf("размер %dx%d, want 20x40", b.Dx(), b.Dy())
+	}
+	if r, g, _, _ := img.At(15, 5).RGBA(); r < 0x8000 || g > 0x8000 {
+		t.Errorf("правый верхний угол не красный: %v", img.At(15, 5))
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(thumbnail)); err != nil {
+		t.Errorf("превью: %v", err)
+	}
+
+	// PNG остается PNG, мусор не проходит проверку
+	original, _, err = sanitizeImage(pngWithSize(t, 1, 1), "image/png")
+	if err != nil || !bytes.HasPrefix(original, []byte("\x89PNG")) {
+		t.Errorf("PNG: %v", err)
+	}
+	if _, _, err := sanitizeImage([]byte("не картинка"), "image/jpeg"); !errors.Is(err, errImageUnreadable) {
+		t.Errorf("мусор: %v, want errImageUnreadable", err)
+	}
+}
@@ -1,9 +1,13 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -17,7 +21,13 @@ import (
 var app *App
 
 type App struct {
-	db *sql.DB
+	db        *sql.DB
+	blobs     BlobStore
+	private   BlobStore // документы бригадиров, ссылки выдаются только владельцу и администраторам
+	telegram  *TelegramClient
+	telephony TelephonyProvider
+	payments  PaymentProvider
+	fiscal    FiscalProvider
 }
 
 // Тарифы
@@ -25,22 +35,32 @@ var TARIFFS = map[string]Tariff{
 	"econom": {
 		Name:        "ЭКОНОМ",
 		Description: "Мокрая, ручная",
-		PriceRange: PriceRange{Min: 400, Max: 450},
-		Days:        "28 дней",
-		Features:    []string{"Классика", "Низкая цена материалов", "Долгий срок высыхания", "Высокий риск трещин"},
+		PriceRange:  PriceRange{Min: 400, Max: 450},
+		Duration: TariffDuration{
+			WorkDaysPerM2:     0.02,
+			CuringDays:        DayRange{Min: 28, Max: 28},
+			TileReadyDays:     DayRange{Min: 28, Max: 28},
+			LaminateReadyDays: DayRange{Min: 28, Max: 28},
+		},
+		Features: []string{"Классика", "Низкая цена материалов", "Долгий срок высыхания", "Высокий риск трещин"},
 	},
 	"comfort": {
 		Name:        "КОМФОРТ",
 		Description: "Полусухая механизированная",
 		PriceRange:  PriceRange{Min: 550, Max: 850},
-		Days:        "5-7 дней (плитка — 2 дня, ламинат — 14–20 дней)",
-		Features:    []string{"Оптимальный баланс", "Минимум усадки", "Можно ходить через 12 часов", "Самый популярный выбор"},
+		Duration: TariffDuration{
+			WorkDaysPerM2:     0.01,
+			CuringDays:        DayRange{Min: 5, Max: 7},
+			TileReadyDays:     DayRange{Min: 2, Max: 2},
+			LaminateReadyDays: DayRange{Min: 14, Max: 20},
+		},
+		Features: []string{"Оптимальный баланс", "Минимум усадки", "Можно ходить через 12 часов", "Самый популярный выбор"},
 	},
 	"business": {
 		Name:        "БИЗНЕС",
 		Description: "С армированием",
 		PriceRange:  PriceRange{Min: 150, Max: 300},
-		Days:        "Как у базового тарифа",
+		Duration:    TariffDuration{SameAsBase: true},
 		Features:    []string{"Повышенная прочность", "Надбавка за армирование сеткой или фиброй"},
 		IsAddon:     true,
 	},
@@ -48,14 +68,19 @@ var TARIFFS = map[string]Tariff{
 		Name:        "ПРЕМИУМ",
 		Description: "Сухая стяжка Кнауф",
 		PriceRange:  PriceRange{Min: 800, Max: 1000},
-		Days:        "1-2 дня",
-		Features:    []string{"Нет мокрых процессов", "Идеальная геометрия", "Теплоизоляция", "Высокая цена материалов"},
+		Duration: TariffDuration{
+			WorkDaysPerM2:     0.025,
+			CuringDays:        DayRange{Min: 1, Max: 2},
+			TileReadyDays:     DayRange{Min: 1, Max: 2},
+			LaminateReadyDays: DayRange{Min: 1, Max: 2},
+		},
+		Features: []string{"Нет мокрых процессов", "Идеальная геометрия", "Теплоизоляция", "Высокая цена материалов"},
 	},
 	"universal": {
 		Name:        "УНИВЕРСАЛ",
 		Description: "Плавающая / Утепленная",
 		PriceRange:  PriceRange{Min: 250, Max: 600},
-		Days:        "Как у базового тарифа",
+		Duration:    TariffDuration{SameAsBase: true},
 		Features:    []string{"Зависит от вида утеплителя", "Включает слой изоляции"},
 		IsAddon:     true,
 	},
@@ -63,18 +88,25 @@ var TARIFFS = map[string]Tariff{
 		Name:        "САМОВЫРАВНИВАТЕЛЬ",
 		Description: "Финишный слой",
 		PriceRange:  PriceRange{Min: 250, Max: 500},
-		Days:        "1-3 дня",
-		Features:    []string{"Финишный слой"},
+		Duration: TariffDuration{
+			WorkDaysPerM2:     0.005,
+			CuringDays:        DayRange{Min: 1, Max: 3},
+			TileReadyDays:     DayRange{Min: 1, Max: 3},
+			LaminateReadyDays: DayRange{Min: 1, Max: 3},
+		},
+		Features: []string{"Финишный слой"},
 	},
 }
 
 type Tariff struct {
-	Name        string     `json:"name"`
-	Description string     `json:"description"`
-	PriceRange  PriceRange `json:"priceRange"`
-	Days        string     `json:"days"`
-	Features    []string   `json:"features"`
-	IsAddon     bool       `json:"isAddon,omitempty"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	PriceRange  PriceRange     `json:"priceRange"`
+	Duration    TariffDuration `json:"duration"`
+	// Days - текстовое описание сроков, генерируется из Duration в init()
+	Days     string   `json:"days"`
+	Features []string `json:"features"`
+	IsAddon  bool     `json:"isAddon,omitempty"`
 }
 
 type PriceRange struct {
@@ -83,13 +115,24 @@ type PriceRange struct {
 }
 
 type User struct {
-	ID         int64     `json:"id"`
-	TelegramID int64     `json:"telegram_id"`
-	Role       string    `json:"role"`
-	Name       *string   `json:"name"`
-	Phone      *string   `json:"phone"`
-	AvatarURL  *string   `json:"avatar_url"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID         int64   `json:"id"`
+	TelegramID int64   `json:"telegram_id"`
+	Role       string  `json:"role"` // активная роль
+	Name       *string `json:"name"`
+	Phone      *string `json:"phone"`
+	AvatarURL  *string `json:"avatar_url"`
+	Region     *string `json:"region"`
+	// active, suspended (до StatusUntil или бессрочно) или banned, см. moderation.go
+	Status       string     `json:"status"`
+	StatusReason *string    `json:"status_reason,omitempty"`
+	StatusUntil  *time.Time `json:"status_until,omitempty"`
+	// Язык интерфейса Telegram (language_code), на нем приходят ответы и уведомления
+	LanguageCode *string   `json:"language_code"`
+	CreatedAt    time.Time `json:"created_at"`
+	// Все выданные роли, активная - Role
+	Roles []string `json:"roles"`
+	// Роль по умолчанию (users.role) для сессий без X-Active-Role
+	defaultRole string
 }
 
 type ContractorProfile struct {
@@ -101,6 +144,7 @@ type ContractorProfile struct {
 	Categories      string  `json:"categories"`
 	IsActive        bool    `json:"is_active"`
 	CurrentOrderID  *int64  `json:"current_order_id"`
+	Verification    string  `json:"verification_status"`
 	Name            *string `json:"name"`
 	Phone           *string `json:"phone"`
 	AvatarURL       *string `json:"avatar_url"`
@@ -114,14 +158,36 @@ type Order struct {
 	Category             string     `json:"category"`
 	Area                 *float64   `json:"area"`
 	Address              *string    `json:"address"`
+	ThicknessMM          *float64   `json:"thickness_mm"`
 	Status               string     `json:"status"`
 	CreatedAt            time.Time  `json:"created_at"`
 	AcceptedAt           *time.Time `json:"accepted_at"`
 	CompletedAt          *time.Time `json:"completed_at"`
 	ClientName           *string    `json:"client_name"`
 	ClientTelegramID     *int64     `json:"client_telegram_id"`
+	ClientPhone          *string    `json:"client_phone"`
 	ContractorName       *string    `json:"contractor_name"`
 	ContractorTelegramID *int64     `json:"contractor_telegram_id"`
+	ContractorPhone      *string    `json:"contractor_phone"`
+	// Подменный номер для связи сторон, если подключена телефония
+	ProxyPhone *string            `json:"proxy_phone"`
+	Schedule   *OrderSchedule     `json:"schedule,omitempty"`
+	Materials  *MaterialsEstimate `json:"materials,omitempty"`
+	Rooms      []Room             `json:"rooms,omitempty"`
+	Promo      *PromoDiscount     `json:"promo,omitempty"`
+	Region     *string            `json:"region"`
+	// Цена за м² на момент создания заказа (с учетом региона), в режиме торгов - цена выбранного предложения
+	Prices *PriceRange `json:"prices,omitempty"`
+	// Дополнительные работы (армирование, утепление) с ценами на момент создания
+	Addons []OrderAddon `json:"addons,omitempty"`
+	// Режим заказа (instant или auction) и сроки торгов
+	Mode        string     `json:"mode"`
+	BidDeadline *time.Time `json:"bid_deadline,omitempty"`
+	StartBy     *string    `json:"start_by,omitempty"`
+	// Стоимость с учетом скидки, заполняется только для отдельного заказа
+	Quote *Quote `json:"quote,omitempty"`
+	// Стоимость, зафиксированная при принятии заказа, в копейках
+	Price *int64 `json:"price,omitempty"`
 }
 
 var dbInitialized bool
@@ -149,12 +215,28 @@ func initDBIfNeeded() error {
 		}
 	}
 
+	if err := checkBlobSigningKey(); err != nil {
+		return err
+	}
+	private, err := newPrivateBlobStoreFromEnv()
+	if err != nil {
+		return fmt.Errorf("ошибка настройки хранилища документов: %w", err)
+	}
+
 	db, err := sql.Open("libsql", dsn)
 	if err != nil {
 		return fmt.Errorf("ошибка подключения к БД: %w", err)
 	}
 
-	app = &App{db: db}
+	app = &App{db: db, blobs: newBlobStoreFromEnv("public"), private: private, telegram: newTelegramClientFromEnv(), telephony: newTelephonyFromEnv()}
+	if app.payments, err = newPaymentProviderFromEnv(app); err != nil {
+		db.Close()
+		return fmt.Errorf("ошибка настройки платежей: %w", err)
+	}
+	if app.fiscal, err = newFiscalProviderFromEnv(); err != nil {
+		db.Close()
+		return fmt.Errorf("ошибка настройки кассы: %w", err)
+	}
 
 	if err := app.initDB(); err != nil {
 		return fmt.Errorf("ошибка инициализации БД: %w", err)
@@ -212,6 +294,323 @@ func (app *App) initDB() error {
 			FOREIGN KEY (contractor_id) REFERENCES users(id),
 			FOREIGN KEY (client_id) REFERENCES users(id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS order_rooms (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			order_id INTEGER NOT NULL,
+			name TEXT,
+			shape TEXT NOT NULL CHECK(shape IN ('rectangle', 'polygon')),
+			width REAL,
+			length REAL,
+			walls TEXT,
+			area REAL NOT NULL,
+			FOREIGN KEY (order_id) REFERENCES orders(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS order_attachments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			order_id INTEGER NOT NULL,
+			uploader_id INTEGER NOT NULL,
+			kind TEXT NOT NULL CHECK(kind IN ('photo', 'plan', 'before', 'after', 'contract', 'act')),
+			blob_key TEXT NOT NULL,
+			thumbnail_key TEXT,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (order_id) REFERENCES orders(id),
+			FOREIGN KEY (uploader_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS order_messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			order_id INTEGER NOT NULL,
+			sender_id INTEGER NOT NULL,
+			text TEXT,
+			attachment_key TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			read_at DATETIME,
+			FOREIGN KEY (order_id) REFERENCES orders(id),
+			FOREIGN KEY (sender_id) REFERENCES users(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_order_messages_order ON order_messages(order_id, id)`,
+		`CREATE TABLE IF NOT EXISTS payments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			order_id INTEGER NOT NULL,
+			provider TEXT NOT NULL,
+			provider_payment_id TEXT,
+			purpose TEXT NOT NULL DEFAULT 'order',
+			amount INTEGER NOT NULL,
+			refunded_amount INTEGER NOT NULL DEFAULT 0,
+			currency TEXT NOT NULL DEFAULT 'RUB',
+			status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'waiting_for_capture', 'succeeded', 'canceled', 'refunded')),
+			capture BOOLEAN NOT NULL DEFAULT 1,
+			confirmation_url TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (provider, provider_payment_id),
+			FOREIGN KEY (order_id) REFERENCES orders(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS payment_refunds (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			payment_id INTEGER NOT NULL,
+			provider_refund_id TEXT NOT NULL UNIQUE,
+			amount INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (payment_id) REFERENCES payments(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS payment_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			provider TEXT NOT NULL,
+			event_id TEXT NOT NULL,
+			payment_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (provider, event_id),
+			FOREIGN KEY (payment_id) REFERENCES payments(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS sandbox_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			payment_id INTEGER NOT NULL,
+			body TEXT NOT NULL,
+			due_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (payment_id) REFERENCES payments(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS escrows (
+			order_id INTEGER PRIMARY KEY,
+			payment_id INTEGER,
+			amount INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'awaiting_payment' CHECK(status IN ('awaiting_payment', 'payment_failed', 'held', 'released', 'refunded', 'canceled')),
+			confirm_deadline DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (order_id) REFERENCES orders(id),
+			FOREIGN KEY (payment_id) REFERENCES payments(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS ledger_entries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			tx_id TEXT NOT NULL,
+			account TEXT NOT NULL,
+			debit INTEGER NOT NULL DEFAULT 0,
+			credit INTEGER NOT NULL DEFAULT 0,
+			order_id INTEGER,
+			payment_id INTEGER,
+			memo TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (tx_id, account),
+			FOREIGN KEY (order_id) REFERENCES orders(id),
+			FOREIGN KEY (payment_id) REFERENCES payments(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_ledger_entries_account ON ledger_entries(account)`,
+		`CREATE INDEX IF NOT EXISTS idx_ledger_entries_order ON ledger_entries(order_id)`,
+		`CREATE TABLE IF NOT EXISTS commission_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			contractor_id INTEGER,
+			category TEXT,
+			percent REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (contractor_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS payout_batches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			status TEXT NOT NULL DEFAULT 'draft' CHECK(status IN ('draft', 'paid', 'failed')),
+			total INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS payouts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			batch_id INTEGER NOT NULL,
+			contractor_id INTEGER NOT NULL,
+			amount INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'draft' CHECK(status IN ('draft', 'paid', 'failed')),
+			FOREIGN KEY (batch_id) REFERENCES payout_batches(id),
+			FOREIGN KEY (contractor_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS contractor_accruals (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			order_id INTEGER NOT NULL UNIQUE,
+			contractor_id INTEGER NOT NULL,
+			category TEXT NOT NULL,
+			gross INTEGER NOT NULL,
+			commission_percent REAL NOT NULL,
+			commission INTEGER NOT NULL,
+			net INTEGER NOT NULL,
+			via_escrow BOOLEAN NOT NULL DEFAULT 0,
+			status TEXT NOT NULL DEFAULT 'accrued' CHECK(status IN ('accrued', 'in_payout', 'paid')),
+			payout_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (order_id) REFERENCES orders(id),
+			FOREIGN KEY (contractor_id) REFERENCES users(id),
+			FOREIGN KEY (payout_id) REFERENCES payouts(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_contractor_accruals_contractor ON contractor_accruals(contractor_id, created_at)`,
+		`CREATE TABLE IF NOT EXISTS receipts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			order_id INTEGER NOT NULL,
+			payment_id INTEGER,
+			external_id TEXT NOT NULL UNIQUE,
+			kind TEXT NOT NULL CHECK(kind IN ('income', 'income_return')),
+			settlement TEXT NOT NULL,
+			amount INTEGER NOT NULL,
+			items TEXT NOT NULL,
+			customer TEXT NOT NULL DEFAULT '',
+			tax_system TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'registered', 'failed')),
+			provider TEXT NOT NULL,
+			provider_receipt_id TEXT,
+			fiscal TEXT,
+			error TEXT,
+			sent_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (order_id) REFERENCES orders(id),
+			FOREIGN KEY (payment_id) REFERENCES payments(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_receipts_order ON receipts(order_id)`,
+		`CREATE TABLE IF NOT EXISTS promo_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			code TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			description TEXT,
+			percent REAL,
+			amount INTEGER,
+			categories TEXT,
+			starts_at DATETIME,
+			ends_at DATETIME,
+			max_uses INTEGER,
+			max_uses_per_user INTEGER,
+			first_order_only BOOLEAN NOT NULL DEFAULT 0,
+			is_active BOOLEAN NOT NULL DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS promo_redemptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			promo_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			order_id INTEGER NOT NULL UNIQUE,
+			code TEXT NOT NULL,
+			percent REAL NOT NULL DEFAULT 0,
+			amount INTEGER NOT NULL DEFAULT 0,
+			discount INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (promo_id) REFERENCES promo_codes(id),
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (order_id) REFERENCES orders(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_promo_redemptions_promo ON promo_redemptions(promo_id, user_id)`,
+		`CREATE TABLE IF NOT EXISTS regions (
+			code TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			multiplier REAL NOT NULL DEFAULT 1,
+			is_active BOOLEAN NOT NULL DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS region_prices (
+			region_code TEXT NOT NULL,
+			category TEXT NOT NULL,
+			price_min INTEGER NOT NULL,
+			price_max INTEGER NOT NULL,
+			PRIMARY KEY (region_code, category),
+			FOREIGN KEY (region_code) REFERENCES regions(code)
+		)`,
+		`CREATE TABLE IF NOT EXISTS order_bids (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			order_id INTEGER NOT NULL,
+			contractor_id INTEGER NOT NULL,
+			price INTEGER NOT NULL,
+			start_date TEXT NOT NULL,
+			comment TEXT,
+			status TEXT NOT NULL DEFAULT 'active',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (order_id, contractor_id),
+			FOREIGN KEY (order_id) REFERENCES orders(id),
+			FOREIGN KEY (contractor_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS order_documents (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			order_id INTEGER NOT NULL,
+			kind TEXT NOT NULL CHECK(kind IN ('contract', 'act')),
+			attachment_id INTEGER NOT NULL,
+			hash TEXT NOT NULL,
+			client_signed_at DATETIME,
+			client_signature TEXT,
+			contractor_signed_at DATETIME,
+			contractor_signature TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (order_id, kind),
+			FOREIGN KEY (order_id) REFERENCES orders(id),
+			FOREIGN KEY (attachment_id) REFERENCES order_attachments(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS admin_api_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			key_hash TEXT NOT NULL UNIQUE,
+			prefix TEXT NOT NULL,
+			created_by TEXT NOT NULL,
+			last_used_at DATETIME,
+			revoked_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS admin_audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target_type TEXT NOT NULL,
+			target_id INTEGER NOT NULL,
+			reason TEXT,
+			details TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_admin_audit_target ON admin_audit_log(target_type, target_id)`,
+		`CREATE TABLE IF NOT EXISTS contractor_documents (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			kind TEXT NOT NULL CHECK(kind IN ('passport', 'self_employment', 'legal_entity')),
+			blob_key TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, kind),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_roles (
+			user_id INTEGER NOT NULL,
+			role TEXT NOT NULL CHECK(role IN ('client', 'contractor')),
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, role),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		// Роли пользователей, созданных до user_roles: активная роль и роль бригадира при наличии профиля
+		`INSERT OR IGNORE INTO user_roles (user_id, role) SELECT id, role FROM users`,
+		`INSERT OR IGNORE INTO user_roles (user_id, role) SELECT user_id, 'contractor' FROM contractor_profiles`,
+		`CREATE TABLE IF NOT EXISTS fraud_rules (
+			rule TEXT PRIMARY KEY CHECK(rule IN ('cancellations', 'no_show', 'duplicate_phone')),
+			threshold INTEGER NOT NULL,
+			window_days INTEGER NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`INSERT OR IGNORE INTO fraud_rules (rule, threshold, window_days) VALUES ('cancellations', 5, 7), ('no_show', 2, 30), ('duplicate_phone', 1, 0)`,
+		`CREATE TABLE IF NOT EXISTS fraud_flags (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			rule TEXT NOT NULL,
+			details TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'open' CHECK(status IN ('open', 'dismissed', 'actioned')),
+			resolution TEXT,
+			review_comment TEXT,
+			reviewed_by TEXT,
+			reviewed_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_fraud_flags_open ON fraud_flags(user_id, rule) WHERE status = 'open'`,
+		idempotencyKeysTable,
+		`CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created ON idempotency_keys(created_at)`,
+		// Списки заказов листаются курсором по (created_at, id)
+		`CREATE INDEX IF NOT EXISTS idx_orders_status_created ON orders(status, created_at, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_orders_client_created ON orders(client_id, created_at, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_orders_contractor_created ON orders(contractor_id, created_at, id)`,
+		// Стартовые регионы с национальными ценами, коэффициенты задаются через API
+		`INSERT OR IGNORE INTO regions (code, name) VALUES ('moscow', 'Москва'), ('spb', 'Санкт-Петербург')`,
 	}
 
 	for _, query := range queries {
@@ -219,33 +618,126 @@ func (app *App) initDB() error {
 			return fmt.Errorf("ошибка создания таблицы: %w", err)
 		}
 	}
+
+	// Колонки, добавленные после создания таблиц
+	columns := []struct{ table, column, definition string }{
+		{"orders", "thickness_mm", "REAL"},
+		{"users", "avatar_key", "TEXT"},
+		{"users", "last_seen_at", "DATETIME"},
+		{"orders", "proxy_phone", "TEXT"},
+		{"users", "region", "TEXT REFERENCES regions(code)"},
+		{"orders", "region", "TEXT REFERENCES regions(code)"},
+		{"orders", "price_min", "INTEGER"},
+		{"orders", "price_max", "INTEGER"},
+		{"orders", "addons", "TEXT"},
+		{"orders", "mode", "TEXT NOT NULL DEFAULT 'instant'"},
+		{"orders", "bid_deadline", "DATETIME"},
+		{"orders", "start_by", "TEXT"},
+		{"orders", "price", "INTEGER"},
+		{"users", "status_reason", "TEXT"},
+		{"users", "status_until", "DATETIME"},
+		{"users", "status_changed_at", "DATETIME"},
+		{"users", "language_code", "TEXT"},
+		{"orders", "cancelled_by", "INTEGER REFERENCES users(id)"},
+		{"orders", "cancelled_at", "DATETIME"},
+		{"orders", "cancel_reason", "TEXT"},
+		{"contractor_profiles", "payout_name", "TEXT"},
+		{"contractor_profiles", "payout_inn", "TEXT"},
+		{"contractor_profiles", "payout_account", "TEXT"},
+		{"contractor_profiles", "payout_bik", "TEXT"},
+		{"contractor_profiles", "verification_comment", "TEXT"},
+		{"contractor_profiles", "submitted_at", "DATETIME"},
+		{"contractor_profiles", "verified_at", "DATETIME"},
+		{"contractor_profiles", "verification_notice", "INTEGER NOT NULL DEFAULT 0"},
+		{"escrows", "refund_due", "INTEGER NOT NULL DEFAULT 0"},
+		{"receipts", "advance_offset", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, c := range columns {
+		if err := app.addColumnIfNotExists(c.table, c.column, c.definition); err != nil {
+			return fmt.Errorf("ошибка добавления колонки %s.%s: %w", c.table, c.column, err)
+		}
+	}
+	if err := app.migrateUserStatus(); err != nil {
+		return fmt.Errorf("ошибка добавления статуса пользователей: %w", err)
+	}
+	if err := app.migrateContractorVerification(); err != nil {
+		return fmt.Errorf("ошибка добавления статуса проверки бригадиров: %w", err)
+	}
+	if err := app.migrateAttachmentKinds(); err != nil {
+		return fmt.Errorf("ошибка обновления таблицы вложений: %w", err)
+	}
+	if err := app.migrateIdempotencyScope(); err != nil {
+		return fmt.Errorf("ошибка обновления таблицы Idempotency-Key: %w", err)
+	}
 	return nil
 }
 
-func (app *App) getUserByTelegramID(telegramID int64) (*User, error) {
-	row := app.db.QueryRow("SELECT id, telegram_id, role, name, phone, avatar_url, created_at FROM users WHERE telegram_id = ?", telegramID)
-	var user User
-	var createdAt sql.NullString
-	err := row.Scan(&user.ID, &user.TelegramID, &user.Role, &user.Name, &user.Phone, &user.AvatarURL, &createdAt)
-	if err == sql.ErrNoRows {
-		return nil, nil
+func (app *App) addColumnIfNotExists(table, column, definition string) error {
+	var count int
+	if err := app.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count); err != nil {
+		return err
 	}
+	if count > 0 {
+		return nil
+	}
+	_, err := app.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+const userColumns = "id, telegram_id, role, name, phone, avatar_url, region, status, status_reason, status_until, language_code, created_at, (SELECT group_concat(role) FROM user_roles WHERE user_roles.user_id = users.id)"
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var user User
+	var createdAt, statusUntil, roles sql.NullString
+	err := row.Scan(&user.ID, &user.TelegramID, &user.Role, &user.Name, &user.Phone, &user.AvatarURL, &user.Region, &user.Status, &user.StatusReason, &statusUntil, &user.LanguageCode, &createdAt, &roles)
 	if err != nil {
 		return nil, err
 	}
+	user.defaultRole = user.Role
+	user.Roles = []string{user.Role}
+	if roles.Valid && roles.String != "" {
+		user.Roles = strings.Split(roles.String, ",")
+	}
 	if createdAt.Valid && createdAt.String != "" {
 		user.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt.String)
 	}
+	if statusUntil.Valid && statusUntil.String != "" {
+		t, _ := time.Parse("2006-01-02 15:04:05", statusUntil.String)
+		user.StatusUntil = &t
+	}
+	// Истекшая приостановка снимается при чтении
+	if user.Status == UserSuspended && user.StatusUntil != nil && !time.Now().UTC().Before(*user.StatusUntil) {
+		user.Status, user.StatusReason, user.StatusUntil = UserActive, nil, nil
+	}
 	return &user, nil
 }
 
+func (app *App) getUserByTelegramID(telegramID int64) (*User, error) {
+	user, err := scanUser(app.db.QueryRow("SELECT "+userColumns+" FROM users WHERE telegram_id = ?", telegramID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+func (app *App) getUserByID(userID int64) (*User, error) {
+	user, err := scanUser(app.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
 func (app *App) createUser(telegramID int64, role string, name, phone, avatarURL *string) (int64, error) {
 	result, err := app.db.Exec("INSERT INTO users (telegram_id, role, name, phone, avatar_url) VALUES (?, ?, ?, ?, ?)", telegramID, role, name, phone, avatarURL)
 	if err != nil {
 		return 0, err
 	}
 	id, err := result.LastInsertId()
-	return id, err
+	if err != nil {
+		return 0, err
+	}
+	return id, app.grantRole(id, role)
 }
 
 func (app *App) updateUser(telegramID int64, updates map[string]interface{}) error {
@@ -266,9 +758,13 @@ func (app *App) updateUser(telegramID int64, updates map[string]interface{}) err
 		setParts = append(setParts, "avatar_url = ?")
 		args = append(args, avatarURL)
 	}
-	if role, ok := updates["role"].(string); ok {
-		setParts = append(setParts, "role = ?")
-		args = append(args, role)
+	if region, ok := updates["region"].(*string); ok {
+		setParts = append(setParts, "region = ?")
+		args = append(args, region)
+	}
+	if languageCode, ok := updates["language_code"].(*string); ok {
+		setParts = append(setParts, "language_code = ?")
+		args = append(args, languageCode)
 	}
 	if len(setParts) == 0 {
 		return nil
@@ -280,9 +776,9 @@ func (app *App) updateUser(telegramID int64, updates map[string]interface{}) err
 }
 
 func (app *App) getContractorProfile(userID int64) (*ContractorProfile, error) {
-	row := app.db.QueryRow(`SELECT cp.id, cp.user_id, cp.experience_years, cp.rating, cp.completed_orders, cp.categories, cp.is_active, cp.current_order_id, u.name, u.phone, u.avatar_url, u.telegram_id FROM contractor_profiles cp JOIN users u ON cp.user_id = u.id WHERE u.id = ?`, userID)
+	row := app.db.QueryRow(`SELECT cp.id, cp.user_id, cp.experience_years, cp.rating, cp.completed_orders, cp.categories, cp.is_active, cp.current_order_id, cp.verification_status, u.name, u.phone, u.avatar_url, u.telegram_id FROM contractor_profiles cp JOIN users u ON cp.user_id = u.id WHERE u.id = ?`, userID)
 	var profile ContractorProfile
-	err := row.Scan(&profile.ID, &profile.UserID, &profile.ExperienceYears, &profile.Rating, &profile.CompletedOrders, &profile.Categories, &profile.IsActive, &profile.CurrentOrderID, &profile.Name, &profile.Phone, &profile.AvatarURL, &profile.TelegramID)
+	err := row.Scan(&profile.ID, &profile.UserID, &profile.ExperienceYears, &profile.Rating, &profile.CompletedOrders, &profile.Categories, &profile.IsActive, &profile.CurrentOrderID, &profile.Verification, &profile.Name, &profile.Phone, &profile.AvatarURL, &profile.TelegramID)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -308,8 +804,10 @@ func (app *App) createOrUpdateContractorProfile(userID int64, experienceYears *i
 	return err
 }
 
-func (app *App) getAvailableContractors(category string) ([]ContractorProfile, error) {
-	rows, err := app.db.Query(`SELECT cp.id, cp.user_id, cp.experience_years, cp.rating, cp.completed_orders, cp.categories, cp.is_active, cp.current_order_id, u.name, u.phone, u.avatar_url, u.telegram_id FROM contractor_profiles cp JOIN users u ON cp.user_id = u.id WHERE cp.is_active = 1 AND (cp.current_order_id IS NULL OR cp.current_order_id = 0) AND (cp.categories LIKE ? OR cp.categories = '[]') ORDER BY cp.rating DESC, cp.completed_orders DESC LIMIT 10`, "%"+category+"%")
+// getAvailableContractors - свободные проверенные бригадиры категории, лучшие первыми
+func (app *App) getAvailableContractors(category string, region *string, limit, offset int) ([]ContractorProfile, error) {
+	filter, args := regionFilter("u.region", region)
+	rows, err := app.db.Query(`SELECT cp.id, cp.user_id, cp.experience_years, cp.rating, cp.completed_orders, cp.categories, cp.is_active, cp.current_order_id, cp.verification_status, u.name, u.phone, u.avatar_url, u.telegram_id FROM contractor_profiles cp JOIN users u ON cp.user_id = u.id WHERE cp.is_active = 1 AND cp.verification_status = 'verified' AND `+userActiveSQL+` AND (cp.current_order_id IS NULL OR cp.current_order_id = 0) AND (cp.categories LIKE ? OR cp.categories = '[]')`+filter+` ORDER BY cp.rating DESC, cp.completed_orders DESC, cp.id LIMIT ? OFFSET ?`, append(append([]interface{}{"%" + category + "%"}, args...), limit, offset)...)
 	if err != nil {
 		return nil, err
 	}
@@ -317,7 +815,7 @@ func (app *App) getAvailableContractors(category string) ([]ContractorProfile, e
 	var contractors []ContractorProfile
 	for rows.Next() {
 		var profile ContractorProfile
-		err := rows.Scan(&profile.ID, &profile.UserID, &profile.ExperienceYears, &profile.Rating, &profile.CompletedOrders, &profile.Categories, &profile.IsActive, &profile.CurrentOrderID, &profile.Name, &profile.Phone, &profile.AvatarURL, &profile.TelegramID)
+		err := rows.Scan(&profile.ID, &profile.UserID, &profile.ExperienceYears, &profile.Rating, &profile.CompletedOrders, &profile.Categories, &profile.IsActive, &profile.CurrentOrderID, &profile.Verification, &profile.Name, &profile.Phone, &profile.AvatarURL, &profile.TelegramID)
 		if err != nil {
 			return nil, err
 		}
@@ -326,20 +824,48 @@ func (app *App) getAvailableContractors(category string) ([]ContractorProfile, e
 	return contractors, nil
 }
 
-func (app *App) createOrder(clientID int64, category string, area *float64, address *string) (int64, error) {
-	result, err := app.db.Exec("INSERT INTO orders (client_id, category, area, address) VALUES (?, ?, ?, ?)", clientID, category, area, address)
+// createOrder создает заказ вместе с комнатами одной транзакцией. bidding,
+// если задан, создает заказ сразу в режиме торгов, чтобы его нельзя было
+// принять напрямую ни на мгновение.
+func (app *App) createOrder(clientID int64, category string, area *float64, address *string, thicknessMM *float64, region *string, prices PriceRange, addons []OrderAddon, rooms []Room, bidding *orderBidding) (int64, error) {
+	var addonsJSON interface{}
+	if len(addons) > 0 {
+		data, _ := json.Marshal(addons)
+		addonsJSON = string(data)
+	}
+	mode := OrderModeInstant
+	var bidDeadline, startBy interface{}
+	if bidding != nil {
+		mode = OrderModeAuction
+		bidDeadline = bidding.Deadline.UTC().Format("2006-01-02 15:04:05")
+		startBy = bidding.StartBy
+	}
+	tx, err := app.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("INSERT INTO orders (client_id, category, area, address, thickness_mm, region, price_min, price_max, addons, mode, bid_deadline, start_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		clientID, category, area, address, thicknessMM, region, prices.Min, prices.Max, addonsJSON, mode, bidDeadline, startBy)
 	if err != nil {
 		return 0, err
 	}
 	id, err := result.LastInsertId()
-	return id, err
+	if err != nil {
+		return 0, err
+	}
+	if err := saveOrderRooms(tx, id, rooms); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
 }
 
 func (app *App) getOrder(orderID int64) (*Order, error) {
-	row := app.db.QueryRow(`SELECT o.id, o.client_id, o.contractor_id, o.category, o.area, o.address, o.status, o.created_at, o.accepted_at, o.completed_at, uc.name, uc.telegram_id, uct.name, uct.telegram_id FROM orders o LEFT JOIN users uc ON o.client_id = uc.id LEFT JOIN users uct ON o.contractor_id = uct.id WHERE o.id = ?`, orderID)
+	row := app.db.QueryRow(`SELECT o.id, o.client_id, o.contractor_id, o.category, o.area, o.address, o.thickness_mm, o.status, o.created_at, o.accepted_at, o.completed_at, o.proxy_phone, o.region, o.price_min, o.price_max, o.addons, o.mode, o.bid_deadline, o.start_by, o.price, uc.name, uc.telegram_id, uc.phone, uct.name, uct.telegram_id, uct.phone FROM orders o LEFT JOIN users uc ON o.client_id = uc.id LEFT JOIN users uct ON o.contractor_id = uct.id WHERE o.id = ?`, orderID)
 	var order Order
-	var createdAt, acceptedAt, completedAt sql.NullString
-	err := row.Scan(&order.ID, &order.ClientID, &order.ContractorID, &order.Category, &order.Area, &order.Address, &order.Status, &createdAt, &acceptedAt, &completedAt, &order.ClientName, &order.ClientTelegramID, &order.ContractorName, &order.ContractorTelegramID)
+	var createdAt, acceptedAt, completedAt, bidDeadline, addons sql.NullString
+	var priceMin, priceMax sql.NullInt64
+	err := row.Scan(&order.ID, &order.ClientID, &order.ContractorID, &order.Category, &order.Area, &order.Address, &order.ThicknessMM, &order.Status, &createdAt, &acceptedAt, &completedAt, &order.ProxyPhone, &order.Region, &priceMin, &priceMax, &addons, &order.Mode, &bidDeadline, &order.StartBy, &order.Price, &order.ClientName, &order.ClientTelegramID, &order.ClientPhone, &order.ContractorName, &order.ContractorTelegramID, &order.ContractorPhone)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -357,22 +883,125 @@ func (app *App) getOrder(orderID int64) (*Order, error) {
 		t, _ := time.Parse("2006-01-02 15:04:05", completedAt.String)
 		order.CompletedAt = &t
 	}
+	if bidDeadline.Valid && bidDeadline.String != "" {
+		t, _ := time.Parse("2006-01-02 15:04:05", bidDeadline.String)
+		order.BidDeadline = &t
+	}
+	if priceMin.Valid && priceMax.Valid {
+		order.Prices = &PriceRange{Min: int(priceMin.Int64), Max: int(priceMax.Int64)}
+	}
+	if addons.Valid {
+		json.Unmarshal([]byte(addons.String), &order.Addons)
+	}
+	order.Schedule = orderSchedule(&order)
+	order.Materials = orderMaterials(&order)
+	order.Rooms, err = app.getOrderRooms(order.ID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Promo, err = app.getOrderPromo(order.ID); err != nil {
+		return nil, err
+	}
+	order.Quote = orderQuote(&order)
 	return &order, nil
 }
 
-func (app *App) getContractorOrders(contractorID int64) ([]Order, error) {
-	rows, err := app.db.Query(`SELECT o.id, o.client_id, o.contractor_id, o.category, o.area, o.address, o.status, o.created_at, o.accepted_at, o.completed_at, u.name, u.telegram_id FROM orders o JOIN users u ON o.client_id = u.id WHERE o.contractor_id = ? ORDER BY o.created_at DESC`, contractorID)
+// getContractorOrders - страница заказов бригадира с фильтрами q
+func (app *App) getContractorOrders(contractorID int64, q orderListQuery) ([]Order, *string, error) {
+	q.ContractorID = contractorID
+	return app.listOrders(q)
+}
+
+// getAllPendingOrders - страница открытых заказов региона с фильтрами q
+func (app *App) getAllPendingOrders(region *string, q orderListQuery) ([]Order, *string, error) {
+	q.Pending = true
+	q.Region = region
+	return app.listOrders(q)
+}
+
+// orderListQuery - условия выборки списка заказов; пустые поля не фильтруют
+type orderListQuery struct {
+	ClientID     int64
+	ContractorID int64
+	// Pending - открытые заказы, которые может принять бригадир региона Region
+	Pending  bool
+	Region   *string
+	Status   string
+	Category string
+	// From и To - даты создания YYYY-MM-DD включительно
+	From    string
+	To      string
+	AreaMin *float64
+	AreaMax *float64
+	// Sort - OrderSortNewest (по умолчанию) или OrderSortOldest
+	Sort   string
+	Cursor *orderCursor
+	// Limit 0 - без ограничения
+	Limit int
+}
+
+// listOrders - страница заказов с контактами клиента и курсор следующей
+// страницы ("" - страница последняя)
+func (app *App) listOrders(q orderListQuery) ([]Order, *string, error) {
+	var filter adminFilter
+	if q.ClientID != 0 {
+		filter.add("o.client_id = ?", q.ClientID)
+	}
+	if q.ContractorID != 0 {
+		filter.add("o.contractor_id = ?", q.ContractorID)
+	}
+	if q.Pending {
+		filter.add("o.status = 'pending' AND (o.mode = 'instant' OR o.bid_deadline > CURRENT_TIMESTAMP)")
+		if q.Region != nil && *q.Region != "" {
+			filter.add("(o.region = ? OR o.region IS NULL)", *q.Region)
+		}
+	}
+	if q.Status != "" {
+		filter.add("o.status = ?", q.Status)
+	}
+	if q.Category != "" {
+		filter.add("o.category = ?", q.Category)
+	}
+	if q.From != "" {
+		filter.add("o.created_at >= ?", q.From)
+	}
+	if q.To != "" {
+		filter.add("o.created_at < date(?, '+1 day')", q.To)
+	}
+	if q.AreaMin != nil {
+		filter.add("o.area >= ?", *q.AreaMin)
+	}
+	if q.AreaMax != nil {
+		filter.add("o.area <= ?", *q.AreaMax)
+	}
+	order := " ORDER BY o.created_at DESC, o.id DESC"
+	after := "(o.created_at < ? OR (o.created_at = ? AND o.id < ?))"
+	if q.Sort == OrderSortOldest {
+		order = " ORDER BY o.created_at ASC, o.id ASC"
+		after = "(o.created_at > ? OR (o.created_at = ? AND o.id > ?))"
+	}
+	if q.Cursor != nil {
+		filter.add(after, q.Cursor.createdAt, q.Cursor.createdAt, q.Cursor.id)
+	}
+	query := `SELECT o.id, o.client_id, o.contractor_id, o.category, o.area, o.address, o.thickness_mm, o.status, o.created_at, o.accepted_at, o.completed_at, o.proxy_phone, o.region, o.mode, o.bid_deadline, u.name, u.telegram_id, u.phone FROM orders o JOIN users u ON o.client_id = u.id` + filter.where() + order
+	args := filter.args
+	if q.Limit > 0 {
+		// Лишняя строка показывает, есть ли следующая страница
+		query += ` LIMIT ?`
+		args = append(args, q.Limit+1)
+	}
+	rows, err := app.db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 	var orders []Order
 	for rows.Next() {
 		var order Order
-		var createdAt, acceptedAt, completedAt sql.NullString
-		err := rows.Scan(&order.ID, &order.ClientID, &order.ContractorID, &order.Category, &order.Area, &order.Address, &order.Status, &createdAt, &acceptedAt, &completedAt, &order.ClientName, &order.ClientTelegramID)
+		var createdAt, acceptedAt, completedAt, bidDeadline sql.NullString
+		err := rows.Scan(&order.ID, &order.ClientID, &order.ContractorID, &order.Category, &order.Area, &order.Address, &order.ThicknessMM, &order.Status, &createdAt, &acceptedAt, &completedAt, &order.ProxyPhone, &order.Region, &order.Mode, &bidDeadline, &order.ClientName, &order.ClientTelegramID, &order.ClientPhone)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if createdAt.Valid && createdAt.String != "" {
 			order.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt.String)
@@ -385,48 +1014,117 @@ func (app *App) getContractorOrders(contractorID int64) ([]Order, error) {
 			t, _ := time.Parse("2006-01-02 15:04:05", completedAt.String)
 			order.CompletedAt = &t
 		}
+		if bidDeadline.Valid && bidDeadline.String != "" {
+			t, _ := time.Parse("2006-01-02 15:04:05", bidDeadline.String)
+			order.BidDeadline = &t
+		}
+		order.Schedule = orderSchedule(&order)
+		order.Materials = orderMaterials(&order)
 		orders = append(orders, order)
 	}
-	return orders, nil
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	var next *string
+	if q.Limit > 0 && len(orders) > q.Limit {
+		orders = orders[:q.Limit]
+		cursor := encodeOrderCursor(&orders[len(orders)-1])
+		next = &cursor
+	}
+	return orders, next, nil
+}
+
+// Ошибки назначения бригадира
+var (
+	errOrderNotPending       = errors.New("заказ уже принят или отменен")
+	errContractorUnavailable = errors.New("бригадир занят, не проверен или заблокирован")
+)
+
+// acceptOrder назначает бригадира на заказ. Заказ должен еще ждать бригадира,
+// а бригадир - быть свободным, проверенным и не заблокированным. Проверки и
+// назначение выполняются одной транзакцией, поэтому из одновременных принятий
+// (или выборов предложений) проходит только одно. price, если задана,
+// фиксирует цену выбранного предложения.
+func (app *App) acceptOrder(orderID, contractorID int64, price *int) error {
+	tx, err := app.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`UPDATE contractor_profiles SET current_order_id = ? WHERE user_id = ? AND is_active = 1 AND verification_status = 'verified' AND (current_order_id IS NULL OR current_order_id = 0)
+		AND EXISTS (SELECT 1 FROM users u WHERE u.id = contractor_profiles.user_id AND `+userActiveSQL+`)`, orderID, contractorID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return errContractorUnavailable
+	}
+	result, err = tx.Exec(`UPDATE orders SET contractor_id = ?, status = 'accepted', accepted_at = CURRENT_TIMESTAMP, price_min = COALESCE(?, price_min), price_max = COALESCE(?, price_max) WHERE id = ? AND status = 'pending'`,
+		contractorID, price, price, orderID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return errOrderNotPending
+	}
+	return tx.Commit()
 }
 
-func (app *App) getAllPendingOrders() ([]Order, error) {
-	rows, err := app.db.Query(`SELECT o.id, o.client_id, o.contractor_id, o.category, o.area, o.address, o.status, o.created_at, o.accepted_at, o.completed_at, u.name, u.telegram_id FROM orders o JOIN users u ON o.client_id = u.id WHERE o.status = 'pending' ORDER BY o.created_at DESC`)
+// writeAcceptError отвечает на ошибку acceptOrder
+func writeAcceptError(w http.ResponseWriter, err error) {
+	switch err {
+	case errOrderNotPending:
+		writeError(w, http.StatusConflict, CodeOrderState, "Заказ уже принят или отменен")
+	case errContractorUnavailable:
+		writeError(w, http.StatusConflict, CodeConflict, "Бригадир занят или не допущен к заказам")
+	default:
+		internalError(w, err)
+	}
+}
+
+// onOrderAccepted выдает подменный номер, выставляет предоплату и формирует договор после назначения бригадира.
+// Ошибки внешних сервисов не мешают принятию заказа и только логируются.
+func (app *App) onOrderAccepted(ctx context.Context, orderID int64) (*Order, error) {
+	order, err := app.getOrder(orderID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	var orders []Order
-	for rows.Next() {
-		var order Order
-		var createdAt, acceptedAt, completedAt sql.NullString
-		err := rows.Scan(&order.ID, &order.ClientID, &order.ContractorID, &order.Category, &order.Area, &order.Address, &order.Status, &createdAt, &acceptedAt, &completedAt, &order.ClientName, &order.ClientTelegramID)
-		if err != nil {
-			return nil, err
-		}
-		if createdAt.Valid && createdAt.String != "" {
-			order.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt.String)
-		}
-		orders = append(orders, order)
+	if err := app.fixOrderPrice(order); err != nil {
+		log.Printf("Не удалось зафиксировать стоимость заказа %d: %v", orderID, err)
+	}
+	if err := app.assignProxyPhone(ctx, order); err != nil {
+		log.Printf("Не удалось выдать подменный номер заказу %d: %v", orderID, err)
+	} else if order, err = app.getOrder(orderID); err != nil {
+		return nil, err
+	}
+	if _, err := app.openEscrow(ctx, order); err != nil {
+		log.Printf("Не удалось выставить предоплату по заказу %d: %v", orderID, err)
 	}
-	return orders, nil
+	if order.ContractorID != nil {
+		app.generateOrderDocument(ctx, orderID, DocumentContract, *order.ContractorID)
+	}
+	return order, nil
 }
 
-func (app *App) acceptOrder(orderID, contractorID int64) error {
-	queries := []string{
-		`UPDATE orders SET contractor_id = ?, status = 'accepted', accepted_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'pending'`,
-		`UPDATE contractor_profiles SET current_order_id = ? WHERE user_id = ?`,
+// fixOrderPrice фиксирует стоимость принятого заказа: от нее считаются
+// предоплата, остаток к оплате, чек полного расчета и комиссия. Повторный
+// вызов цену не меняет.
+func (app *App) fixOrderPrice(order *Order) error {
+	if order.Price != nil {
+		return nil
 	}
-	args := [][]interface{}{
-		{contractorID, orderID},
-		{orderID, contractorID},
+	price := orderPrice(order)
+	if price <= 0 {
+		return nil
 	}
-	for i, query := range queries {
-		if _, err := app.db.Exec(query, args[i]...); err != nil {
-			return err
-		}
+	if _, err := app.db.Exec(`UPDATE orders SET price = ? WHERE id = ? AND price IS NULL`, price, order.ID); err != nil {
+		return err
 	}
-	return nil
+	return app.db.QueryRow(`SELECT price FROM orders WHERE id = ?`, order.ID).Scan(&order.Price)
 }
 
 func (app *App) completeOrder(orderID int64) error {
@@ -441,133 +1139,208 @@ func (app *App) completeOrder(orderID int64) error {
 		return err
 	}
 	if contractorID.Valid {
-		_, err = app.db.Exec(`UPDATE contractor_profiles SET current_order_id = NULL, completed_orders = completed_orders + 1 WHERE user_id = ?`, contractorID.Int64)
-		return err
+		if _, err = app.db.Exec(`UPDATE contractor_profiles SET current_order_id = NULL, completed_orders = completed_orders + 1 WHERE user_id = ?`, contractorID.Int64); err != nil {
+			return err
+		}
+	}
+	// Начисление не должно мешать завершению заказа
+	if err := app.accrueOrder(orderID); err != nil {
+		log.Printf("Не удалось начислить вознаграждение по заказу %d: %v", orderID, err)
 	}
 	return nil
 }
 
 func (app *App) cancelOrder(orderID int64) error {
-	_, err := app.db.Exec("UPDATE orders SET status = 'cancelled' WHERE id = ?", orderID)
+	_, err := app.cancelOrderBy(orderID, nil, nil)
 	return err
 }
 
+// cancelOrderBy отменяет заказ и запоминает, кто и почему отменил (для антифрода).
+// Отмененный и выполненный заказы не меняются - тогда возвращается false.
+func (app *App) cancelOrderBy(orderID int64, cancelledBy *int64, reason *string) (bool, error) {
+	result, err := app.db.Exec("UPDATE orders SET status = 'cancelled', cancelled_at = CURRENT_TIMESTAMP, cancelled_by = ?, cancel_reason = ? WHERE id = ? AND status NOT IN ('cancelled', 'completed')", cancelledBy, reason, orderID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return false, err
+	}
+	// Бригадир отмененного заказа снова свободен
+	_, err = app.db.Exec(`UPDATE contractor_profiles SET current_order_id = NULL WHERE current_order_id = ?`, orderID)
+	return true, err
+}
+
+// getTariffs - тарифы; с ?region= цены пересчитываются для региона
 func (app *App) getTariffs(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("region")
+	region, err := app.resolveRegion(&code)
+	if err != nil {
+		writeUserError(w, http.StatusBadRequest, CodeInvalidRegion, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(TARIFFS)
+	json.NewEncoder(w).Encode(localizedTariffs(region, responseLocale(w)))
 }
 
+// getUser - профиль пользователя; {telegramId} в пути совпадает с авторизованным (см. authenticate)
 func (app *App) getUser(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	telegramID, err := strconv.ParseInt(vars["telegramId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Неверный telegram ID", http.StatusBadRequest)
+	user := callerUser(w, r)
+	if user == nil {
 		return
 	}
-	user, err := app.getUserByTelegramID(telegramID)
+	profile, err := app.userProfile(user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
-	if user == nil {
-		http.Error(w, "Пользователь не найден", http.StatusNotFound)
-		return
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": user, "profile": profile})
+}
+
+// userProfile - профиль бригадира, если у пользователя есть эта роль
+func (app *App) userProfile(user *User) (*ContractorProfile, error) {
+	if !user.hasRole("contractor") {
+		return nil, nil
 	}
-	var profile *ContractorProfile
-	if user.Role == "contractor" {
-		profile, err = app.getContractorProfile(user.ID)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	return app.getContractorProfile(user.ID)
+}
+
+// userFields - изменяемые поля пользователя (POST /api/user, PATCH /api/v2/me)
+type userFields struct {
+	Role   string  `json:"role"`
+	Name   *string `json:"name"`
+	Phone  *string `json:"phone"`
+	Region *string `json:"region"`
+	// language_code из Telegram WebApp initData
+	LanguageCode *string `json:"language_code"`
+}
+
+// updateUserFields меняет активную роль и поля существующего пользователя.
+// Ошибку отвечает сам и возвращает nil.
+func (app *App) updateUserFields(w http.ResponseWriter, user *User, fields userFields) *User {
+	if rejectInactive(w, user) {
+		return nil
+	}
+	if _, err := app.resolveRegion(fields.Region); err != nil {
+		writeUserError(w, http.StatusBadRequest, CodeInvalidRegion, err)
+		return nil
+	}
+	// Роль меняется с теми же проверками, что и в /api/user/role
+	if fields.Role != "" {
+		if err := app.setActiveRole(user, fields.Role, false); err != nil {
+			roleError(w, err)
+			return nil
 		}
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"user": user, "profile": profile})
+	updates := map[string]interface{}{}
+	if fields.Name != nil {
+		updates["name"] = fields.Name
+	}
+	if fields.Phone != nil {
+		updates["phone"] = fields.Phone
+	}
+	if fields.Region != nil {
+		updates["region"] = fields.Region
+	}
+	if fields.LanguageCode != nil {
+		updates["language_code"] = fields.LanguageCode
+	}
+	if err := app.updateUser(user.TelegramID, updates); err != nil {
+		internalError(w, err)
+		return nil
+	}
+	updated, err := app.getUserByTelegramID(user.TelegramID)
+	if err != nil {
+		internalError(w, err)
+		return nil
+	}
+	// Активная роль сессии не хранится в базе
+	updated.Role = user.Role
+	if fields.Phone != nil {
+		app.checkFraudRule(FraudDuplicatePhone, updated)
+	}
+	return updated
 }
 
 func (app *App) createOrUpdateUser(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		TelegramID int64   `json:"telegram_id"`
-		Role       string  `json:"role"`
-		Name       *string `json:"name"`
-		Phone      *string `json:"phone"`
-		AvatarURL  *string `json:"avatar_url"`
+	telegramID := callerTelegramID(r)
+	if telegramID == 0 {
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Требуется авторизация Telegram")
+		return
 	}
+	var req userFields
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Неверный формат данных", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
 		return
 	}
-	user, err := app.getUserByTelegramID(req.TelegramID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, err := app.resolveRegion(req.Region); err != nil {
+		writeUserError(w, http.StatusBadRequest, CodeInvalidRegion, err)
 		return
 	}
+	user := requestCaller(r).user
 	if user == nil {
-		_, err := app.createUser(req.TelegramID, req.Role, req.Name, req.Phone, req.AvatarURL)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if !validRole(req.Role) {
+			validationError(w, []FieldError{{Field: "role", Message: tr(responseLocale(w), "Обязательна при создании пользователя")}})
 			return
 		}
-		user, err = app.getUserByTelegramID(req.TelegramID)
+		// avatar_url от клиента не принимаем: аватар загружается через /api/user/{telegramId}/avatar
+		_, err := app.createUser(telegramID, req.Role, req.Name, req.Phone, nil)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			internalError(w, err)
 			return
 		}
-	} else {
-		updates := map[string]interface{}{}
-		if req.Role != "" {
-			updates["role"] = req.Role
+		if req.Region != nil || req.LanguageCode != nil {
+			if err := app.updateUser(telegramID, map[string]interface{}{"region": req.Region, "language_code": req.LanguageCode}); err != nil {
+				internalError(w, err)
+				return
+			}
 		}
-		if req.Name != nil {
-			updates["name"] = req.Name
-		}
-		if req.Phone != nil {
-			updates["phone"] = req.Phone
-		}
-		if req.AvatarURL != nil {
-			updates["avatar_url"] = req.AvatarURL
-		}
-		if err := app.updateUser(req.TelegramID, updates); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		user, err = app.getUserByTelegramID(req.TelegramID)
+		user, err = app.getUserByTelegramID(telegramID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			internalError(w, err)
 			return
 		}
+		// Новому пользователю один раз подтягиваем фото профиля из Telegram
+		if _, err := app.importTelegramAvatar(r.Context(), user); err != nil {
+			log.Printf("Не удалось импортировать аватар пользователя %d: %v", user.TelegramID, err)
+		}
+		if req.Phone != nil {
+			app.checkFraudRule(FraudDuplicatePhone, user)
+		}
+	} else if user = app.updateUserFields(w, user, req); user == nil {
+		return
 	}
+	applyUserLocale(w, r, user)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{"user": user})
 }
 
 func (app *App) updateContractorProfile(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		TelegramID      int64    `json:"telegram_id"`
 		ExperienceYears *int     `json:"experience_years"`
 		Categories      []string `json:"categories"`
 		IsActive        bool     `json:"is_active"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Неверный формат данных", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
 		return
 	}
-	user, err := app.getUserByTelegramID(req.TelegramID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	user := callerUser(w, r)
+	if user == nil {
 		return
 	}
-	if user == nil || user.Role != "contractor" {
-		http.Error(w, "Пользователь не является бригадиром", http.StatusBadRequest)
+	if user.Role != "contractor" {
+		writeError(w, http.StatusBadRequest, CodeNotContractor, "Пользователь не является бригадиром")
 		return
 	}
 	if err := app.createOrUpdateContractorProfile(user.ID, req.ExperienceYears, req.Categories, req.IsActive); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
 	profile, err := app.getContractorProfile(user.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -577,12 +1350,21 @@ func (app *App) updateContractorProfile(w http.ResponseWriter, r *http.Request)
 func (app *App) searchContractors(w http.ResponseWriter, r *http.Request) {
 	category := r.URL.Query().Get("category")
 	if category == "" {
-		http.Error(w, "Не указана категория", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeValidation, "Не указана категория")
 		return
 	}
-	contractors, err := app.getAvailableContractors(category)
+	var region *string
+	if code := r.URL.Query().Get("region"); code != "" {
+		region = &code
+	}
+	limit := queryLimit(r, contractorDefaultPageSize, contractorMaxPageSize)
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	contractors, err := app.getAvailableContractors(category, region, limit, offset)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
 	type ContractorResponse struct {
@@ -615,211 +1397,423 @@ func (app *App) searchContractors(w http.ResponseWriter, r *http.Request) {
 			IsActive:        contractors[i].IsActive,
 			CurrentOrderID:  contractors[i].CurrentOrderID,
 			Name:            contractors[i].Name,
-			Phone:           contractors[i].Phone,
-			AvatarURL:       contractors[i].AvatarURL,
-			TelegramID:      contractors[i].TelegramID,
+			// Контакты бригадира открываются клиенту только после принятия заказа
+			Phone:     maskPhonePtr(contractors[i].Phone),
+			AvatarURL: contractors[i].AvatarURL,
 		})
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"contractors": response})
+	json.NewEncoder(w).Encode(map[string]interface{}{"contractors": response, "limit": limit, "offset": offset})
 }
 
 func (app *App) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		TelegramID int64    `json:"telegram_id"`
-		Category   string   `json:"category"`
-		Area       *float64 `json:"area"`
-		Address    *string  `json:"address"`
+		Category    string   `json:"category"`
+		Area        *float64 `json:"area"`
+		Address     *string  `json:"address"`
+		ThicknessMM *float64 `json:"thickness_mm"`
+		Rooms       []Room   `json:"rooms"`
+		PromoCode   *string  `json:"promo_code"`
+		Region      *string  `json:"region"`
+		// Дополнительные работы - ключи тарифов с isAddon
+		Addons []string `json:"addons"`
+		// Режим торгов: mode = "auction", срок приема предложений и крайняя дата начала работ
+		Mode     string  `json:"mode"`
+		BidHours *int    `json:"bid_hours"`
+		StartBy  *string `json:"start_by"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Неверный формат данных", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
 		return
 	}
-	user, err := app.getUserByTelegramID(req.TelegramID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+	// Если переданы комнаты, общая площадь считается по ним
+	if len(req.Rooms) > 0 {
+		total, err := calculateRoomsArea(req.Rooms)
+		if err != nil {
+			writeUserError(w, http.StatusBadRequest, CodeValidation, err)
+			return
+		}
+		req.Area = &total
+	} else if req.Area != nil {
+		if err := validateOrderArea(*req.Area); err != nil {
+			writeUserError(w, http.StatusBadRequest, CodeValidation, err)
+			return
+		}
+	}
+
+	telegramID := callerTelegramID(r)
+	if telegramID == 0 {
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Требуется авторизация Telegram")
+		return
+	}
+	user := requestCaller(r).user
+
+	var bidding *orderBidding
+	var err error
+	switch req.Mode {
+	case "", OrderModeInstant:
+	case OrderModeAuction:
+		deadline, startBy, err := parseBiddingParams(req.BidHours, req.StartBy, time.Now().UTC())
+		if err != nil {
+			writeUserError(w, http.StatusBadRequest, CodeValidation, err)
+			return
+		}
+		bidding = &orderBidding{Deadline: deadline, StartBy: startBy}
+	default:
+		writeError(w, http.StatusBadRequest, CodeValidation, "Неизвестный режим заказа")
 		return
 	}
-	
+
 	// Если пользователь не найден, создаем его как клиента
 	if user == nil {
-		defaultName := fmt.Sprintf("User %d", req.TelegramID)
-		_, err := app.createUser(req.TelegramID, "client", &defaultName, nil, nil)
+		defaultName := fmt.Sprintf("User %d", telegramID)
+		_, err := app.createUser(telegramID, "client", &defaultName, nil, nil)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Ошибка создания пользователя: %v", err), http.StatusInternalServerError)
+			internalError(w, err)
 			return
 		}
-		user, err = app.getUserByTelegramID(req.TelegramID)
+		user, err = app.getUserByTelegramID(telegramID)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Ошибка получения пользователя: %v", err), http.StatusInternalServerError)
+			internalError(w, err)
 			return
 		}
 		if user == nil {
-			http.Error(w, "Не удалось создать пользователя", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Не удалось создать пользователя")
 			return
 		}
 	}
-	
-	// Если роль не "client", обновляем на "client" (так как убрали режим бригадира)
-	if user.Role != "client" {
-		_, err = app.db.Exec("UPDATE users SET role = ? WHERE id = ?", "client", user.ID)
+
+	// Заказ создается от имени клиента: роль клиента выдается, если ее еще нет,
+	// роль по умолчанию и сессии на других устройствах не меняются
+	if err := app.addRole(user, "client"); err != nil {
+		internalError(w, err)
+		return
+	}
+
+	// Регион заказа - из запроса или из профиля клиента
+	if req.Region == nil {
+		req.Region = user.Region
+	}
+	region, err := app.resolveRegion(req.Region)
+	if err != nil {
+		writeUserError(w, http.StatusBadRequest, CodeInvalidRegion, err)
+		return
+	}
+	prices := region.priceRange(req.Category)
+	var regionCode *string
+	if region != nil {
+		regionCode = &region.Code
+	}
+	addons, ok := orderAddons(req.Category, req.Addons, region)
+	if !ok {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Неверные дополнительные работы")
+		return
+	}
+
+	var promo *PromoCode
+	if req.PromoCode != nil && *req.PromoCode != "" {
+		promo, err = app.checkPromo(*req.PromoCode, user, req.Category, time.Now().UTC())
+		if errors.Is(err, errPromoInvalid) {
+			writeUserError(w, http.StatusBadRequest, CodePromoInvalid, err)
+			return
+		}
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Ошибка обновления роли: %v", err), http.StatusInternalServerError)
+			internalError(w, err)
 			return
 		}
-		user.Role = "client"
 	}
-	orderID, err := app.createOrder(user.ID, req.Category, req.Area, req.Address)
+	orderID, err := app.createOrder(user.ID, req.Category, req.Area, req.Address, req.ThicknessMM, regionCode, prices, addons, req.Rooms, bidding)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
+	if promo != nil {
+		var quote *Quote
+		if req.Area != nil {
+			quote = quoteWithAddons(req.Category, *req.Area, prices, addons, promo.discount())
+		}
+		if err := app.redeemPromo(promo, user.ID, orderID, quote); err != nil {
+			// Заказ без обещанной скидки не оставляем
+			app.cancelOrder(orderID)
+			if errors.Is(err, errPromoInvalid) {
+				writeUserError(w, http.StatusConflict, CodePromoInvalid, err)
+			} else {
+				internalError(w, err)
+			}
+			return
+		}
+	}
 	order, err := app.getOrder(orderID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
+	redactOrderContacts(order, user)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{"order": order})
 }
 
 func (app *App) handleGetContractorOrders(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	telegramID, err := strconv.ParseInt(vars["telegramId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Неверный telegram ID", http.StatusBadRequest)
+	user := callerUser(w, r)
+	if user == nil {
 		return
 	}
-	user, err := app.getUserByTelegramID(telegramID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if user.Role != "contractor" {
+		writeError(w, http.StatusBadRequest, CodeNotContractor, "Пользователь не является бригадиром")
 		return
 	}
-	if user == nil || user.Role != "contractor" {
-		http.Error(w, "Пользователь не является бригадиром", http.StatusBadRequest)
+	q, ok := orderListParams(w, r)
+	if !ok {
 		return
 	}
-	orders, err := app.getContractorOrders(user.ID)
+	orders, next, err := app.getContractorOrders(user.ID, q)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
+	redactOrdersContacts(orders, user)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"orders": orders})
+	json.NewEncoder(w).Encode(map[string]interface{}{"orders": orders, "next_cursor": next})
 }
 
 func (app *App) getPendingOrders(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	telegramID, err := strconv.ParseInt(vars["telegramId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Неверный telegram ID", http.StatusBadRequest)
+	user := callerUser(w, r)
+	if user == nil {
 		return
 	}
-	user, err := app.getUserByTelegramID(telegramID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if user.Role != "contractor" {
+		writeError(w, http.StatusBadRequest, CodeNotContractor, "Пользователь не является бригадиром")
 		return
 	}
-	if user == nil || user.Role != "contractor" {
-		http.Error(w, "Пользователь не является бригадиром", http.StatusBadRequest)
+	if app.rejectUnverified(w, user) {
 		return
 	}
-	orders, err := app.getAllPendingOrders()
+	q, ok := orderListParams(w, r)
+	if !ok {
+		return
+	}
+	orders, next, err := app.getAllPendingOrders(user.Region, q)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 		return
 	}
+	redactOrdersContacts(orders, user)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"orders": orders})
+	json.NewEncoder(w).Encode(map[string]interface{}{"orders": orders, "next_cursor": next})
 }
 
 func (app *App) handleAcceptOrder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	orderID, err := strconv.ParseInt(vars["orderId"], 10, 64)
 	if err != nil {
-		http.Error(w, "Неверный order ID", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
 		return
 	}
-	var req struct {
-		TelegramID int64 `json:"telegram_id"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Неверный формат данных", http.StatusBadRequest)
+	user := callerUser(w, r)
+	if user == nil {
 		return
 	}
-	user, err := app.getUserByTelegramID(req.TelegramID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	order := app.acceptOrderAs(w, r, orderID, user)
+	if order == nil {
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"order": order})
+}
+
+// acceptOrderAs - бригадир принимает заказ (v1 accept и PATCH /api/v2/orders/{id}).
+// Ошибку отвечает сам и возвращает nil.
+func (app *App) acceptOrderAs(w http.ResponseWriter, r *http.Request, orderID int64, user *User) *Order {
 	if user == nil || user.Role != "contractor" {
-		http.Error(w, "Пользователь не является бригадиром", http.StatusBadRequest)
-		return
+		writeError(w, http.StatusBadRequest, CodeNotContractor, "Пользователь не является бригадиром")
+		return nil
 	}
-	if err := app.acceptOrder(orderID, user.ID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if rejectInactive(w, user) || app.rejectUnverified(w, user) {
+		return nil
 	}
 	order, err := app.getOrder(orderID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		internalError(w, err)
+		return nil
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"order": order})
+	if order == nil {
+		writeError(w, http.StatusNotFound, CodeOrderNotFound, "Заказ не найден")
+		return nil
+	}
+	if order.Mode == OrderModeAuction {
+		writeError(w, http.StatusConflict, CodeOrderState, "Заказ в режиме торгов: отправьте предложение, клиент выберет бригадира")
+		return nil
+	}
+	if order.Status != "pending" {
+		writeError(w, http.StatusConflict, CodeOrderState, "Заказ уже принят или отменен")
+		return nil
+	}
+	if err := app.acceptOrder(orderID, user.ID, nil); err != nil {
+		writeAcceptError(w, err)
+		return nil
+	}
+	order, err = app.onOrderAccepted(r.Context(), orderID)
+	if err != nil {
+		internalError(w, err)
+		return nil
+	}
+	redactOrderContacts(order, user)
+	return order
 }
 
 func (app *App) handleCompleteOrder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	orderID, err := strconv.ParseInt(vars["orderId"], 10, 64)
 	if err != nil {
-		http.Error(w, "Неверный order ID", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
 		return
 	}
-	var req struct {
-		TelegramID int64 `json:"telegram_id"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Неверный формат данных", http.StatusBadRequest)
+	user := callerUser(w, r)
+	if user == nil {
 		return
 	}
-	user, err := app.getUserByTelegramID(req.TelegramID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	order := app.completeOrderAs(w, r, orderID, user)
+	if order == nil {
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"order": order})
+}
+
+// completeOrderAs - бригадир завершает работы по своему заказу.
+// Ошибку отвечает сам и возвращает nil.
+func (app *App) completeOrderAs(w http.ResponseWriter, r *http.Request, orderID int64, user *User) *Order {
 	if user == nil || user.Role != "contractor" {
-		http.Error(w, "Пользователь не является бригадиром", http.StatusBadRequest)
-		return
+		writeError(w, http.StatusBadRequest, CodeNotContractor, "Пользователь не является бригадиром")
+		return nil
+	}
+	order, err := app.getOrder(orderID)
+	if err != nil {
+		internalError(w, err)
+		return nil
+	}
+	if order == nil {
+		writeError(w, http.StatusNotFound, CodeOrderNotFound, "Заказ не найден")
+		return nil
+	}
+	if orderParty(order, user) != "contractor" {
+		writeError(w, http.StatusForbidden, CodeOrderAccessDenied, "Нет доступа к заказу")
+		return nil
+	}
+	if order.Status != "accepted" {
+		writeError(w, http.StatusConflict, CodeOrderState, "Завершить можно только принятый заказ")
+		return nil
 	}
 	if err := app.completeOrder(orderID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		internalError(w, err)
+		return nil
 	}
-	order, err := app.getOrder(orderID)
+	if err := app.scheduleEscrowConfirm(orderID); err != nil {
+		log.Printf("Не удалось запустить автоподтверждение по заказу %d: %v", orderID, err)
+	}
+	app.generateOrderDocument(r.Context(), orderID, DocumentAct, user.ID)
+	if err := app.releaseProxyPhone(r.Context(), orderID); err != nil {
+		log.Printf("Не удалось освободить подменный номер заказа %d: %v", orderID, err)
+	}
+	order, err = app.getOrder(orderID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		internalError(w, err)
+		return nil
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"order": order})
+	redactOrderContacts(order, user)
+	return order
 }
 
 func (app *App) handleRejectOrder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	orderID, err := strconv.ParseInt(vars["orderId"], 10, 64)
 	if err != nil {
-		http.Error(w, "Неверный order ID", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
+		return
+	}
+	order, err := app.getOrder(orderID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if order == nil {
+		writeError(w, http.StatusNotFound, CodeOrderNotFound, "Заказ не найден")
+		return
+	}
+	// reason необязателен; "no_show" - клиент отменяет из-за неявки бригадира
+	var req struct {
+		Reason *string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	user := callerUser(w, r)
+	if user == nil {
 		return
 	}
-	if err := app.cancelOrder(orderID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !app.cancelOrderAs(w, r, order, user, req.Reason) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
 }
 
+// cancelOrderAs отменяет заказ от имени user с причиной reason.
+// Ошибку отвечает сам и возвращает false.
+func (app *App) cancelOrderAs(w http.ResponseWriter, r *http.Request, order *Order, user *User, reason *string) bool {
+	// Выполненный заказ отменить нельзя: предоплата по нему уже причитается бригадиру
+	if order.Status == "completed" {
+		writeError(w, http.StatusConflict, CodeOrderState, "Заказ уже выполнен")
+		return false
+	}
+	if order.Status == "cancelled" {
+		writeError(w, http.StatusConflict, CodeOrderState, "Заказ отменен")
+		return false
+	}
+	orderID := order.ID
+	party := orderParty(order, user)
+	// Отменить заказ могут только его участники и администратор
+	if party == "" && !isAdmin(user) {
+		writeError(w, http.StatusForbidden, CodeOrderAccessDenied, "Нет доступа к заказу")
+		return false
+	}
+	cancelledBy := &user.ID
+	if reason != nil && *reason == "no_show" && (party != "client" || order.Status != "accepted") {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Неявку бригадира может указать только клиент по принятому заказу")
+		return false
+	}
+	cancelled, err := app.cancelOrderBy(orderID, cancelledBy, reason)
+	if err != nil {
+		internalError(w, err)
+		return false
+	}
+	if !cancelled {
+		// Заказ успели отменить или завершить параллельным запросом
+		writeError(w, http.StatusConflict, CodeOrderState, "Заказ уже отменен или выполнен")
+		return false
+	}
+	if party == "client" {
+		app.checkFraudRule(FraudCancellations, user)
+	}
+	if order.Status == "accepted" && order.ContractorID != nil {
+		contractor, err := app.getUserByID(*order.ContractorID)
+		if err != nil {
+			log.Printf("Не удалось загрузить бригадира заказа %d: %v", orderID, err)
+		}
+		app.checkFraudRule(FraudNoShow, contractor)
+	}
+	if err := app.releaseProxyPhone(r.Context(), orderID); err != nil {
+		log.Printf("Не удалось освободить подменный номер заказа %d: %v", orderID, err)
+	}
+	if err := app.refundEscrow(r.Context(), orderID); err != nil {
+		// Возврат, не принятый провайдером, повторит /api/cron/escrow
+		log.Printf("Не удалось вернуть предоплату по заказу %d: %v", orderID, err)
+	}
+	return true
+}
+
 // Handler - экспортированная функция для Vercel
 // Обрабатывает только API запросы через rewrites, статика обслуживается Vercel автоматически
 func Handler(w http.ResponseWriter, r *http.Request) {
@@ -829,16 +1823,24 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleAPI(w http.ResponseWriter, r *http.Request) {
+	// request_id попадает в тело ошибок и в логи
+	w.Header().Set("X-Request-ID", requestID(r))
+	// Язык ответа; authenticate уточняет его по language_code пользователя
+	w.Header().Set("Content-Language", requestLocale(r))
+	w.Header().Set("Vary", "Accept-Language")
+
 	// Инициализируем БД если нужно
 	if err := initDBIfNeeded(); err != nil {
-		http.Error(w, "База данных не настроена: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("[%s] база данных не настроена: %v", w.Header().Get("X-Request-ID"), err)
+		writeError(w, http.StatusServiceUnavailable, CodeServiceUnavailable, "База данных не настроена")
 		return
 	}
 
 	// CORS middleware
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key, X-Telegram-Init-Data, X-Active-Role, Idempotency-Key, X-Request-ID")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Idempotent-Replayed, Deprecation, Link")
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
@@ -847,13 +1849,28 @@ func handleAPI(w http.ResponseWriter, r *http.Request) {
 
 	// Настройка роутера для API
 	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
 
 	// API routes
 	api := router.PathPrefix("/api").Subrouter()
+	api.Use(deprecateV1, app.authenticate, app.userStatusGuard, validateRequest, app.idempotency)
+	api.HandleFunc("/openapi.json", app.handleOpenAPI).Methods("GET")
 	api.HandleFunc("/tariffs", app.getTariffs).Methods("GET")
+	api.HandleFunc("/regions", app.handleGetRegions).Methods("GET")
 	api.HandleFunc("/user/{telegramId}", app.getUser).Methods("GET")
 	api.HandleFunc("/user", app.createOrUpdateUser).Methods("POST")
+	api.HandleFunc("/user/role", app.handleSwitchRole).Methods("POST")
+	api.HandleFunc("/user/{telegramId}/avatar", app.handleUploadAvatar).Methods("POST")
+	api.HandleFunc("/user/{telegramId}/avatar", app.handleGetAvatar).Methods("GET")
+	api.HandleFunc("/user/{telegramId}/avatar/telegram", app.handleImportTelegramAvatar).Methods("POST")
 	api.HandleFunc("/contractor/profile", app.updateContractorProfile).Methods("POST")
+	api.HandleFunc("/promo/validate", app.handleValidatePromo).Methods("POST")
+	api.HandleFunc("/contractor/earnings", app.handleContractorEarnings).Methods("GET")
+	api.HandleFunc("/contractor/payout-details", app.handleUpdatePayoutDetails).Methods("POST")
+	api.HandleFunc("/contractor/verification", app.handleGetVerification).Methods("GET")
+	api.HandleFunc("/contractor/verification/submit", app.handleSubmitVerification).Methods("POST")
+	api.HandleFunc("/contractor/documents", app.handleUploadContractorDocument).Methods("POST")
 	api.HandleFunc("/contractors/search", app.searchContractors).Methods("GET")
 	api.HandleFunc("/orders", app.handleCreateOrder).Methods("POST")
 	api.HandleFunc("/contractor/orders/{telegramId}", app.handleGetContractorOrders).Methods("GET")
@@ -861,7 +1878,34 @@ func handleAPI(w http.ResponseWriter, r *http.Request) {
 	api.HandleFunc("/orders/{orderId}/accept", app.handleAcceptOrder).Methods("POST")
 	api.HandleFunc("/orders/{orderId}/complete", app.handleCompleteOrder).Methods("POST")
 	api.HandleFunc("/orders/{orderId}/reject", app.handleRejectOrder).Methods("POST")
-	api.HandleFunc("/migrate", app.handleMigrate).Methods("POST")
+	api.HandleFunc("/orders/{orderId}/attachments", app.handleUploadAttachment).Methods("POST")
+	api.HandleFunc("/orders/{orderId}/attachments", app.handleGetAttachments).Methods("GET")
+	api.HandleFunc("/orders/{orderId}/messages", app.handleGetMessages).Methods("GET")
+	api.HandleFunc("/orders/{orderId}/messages", app.handleSendMessage).Methods("POST")
+	api.HandleFunc("/orders/{orderId}/messages/read", app.handleReadMessages).Methods("POST")
+	api.HandleFunc("/orders/{orderId}/messages/stream", app.handleMessagesStream).Methods("GET")
+	api.HandleFunc("/orders/{orderId}/payments", app.handleCreatePayment).Methods("POST")
+	api.HandleFunc("/orders/{orderId}/payments", app.handleGetOrderPayments).Methods("GET")
+	api.HandleFunc("/orders/{orderId}/bids", app.handleGetBids).Methods("GET")
+	api.HandleFunc("/orders/{orderId}/bids", app.handleSubmitBid).Methods("POST")
+	api.HandleFunc("/orders/{orderId}/bids/{bidId}/select", app.handleSelectBid).Methods("POST")
+	api.HandleFunc("/orders/{orderId}/documents", app.handleGetDocuments).Methods("GET")
+	api.HandleFunc("/orders/{orderId}/documents", app.handleGenerateDocument).Methods("POST")
+	api.HandleFunc("/orders/{orderId}/documents/{documentId}/sign", app.handleSignDocument).Methods("POST")
+	api.HandleFunc("/orders/{orderId}/escrow", app.handleGetEscrow).Methods("GET")
+	api.HandleFunc("/orders/{orderId}/escrow/pay", app.handlePayEscrow).Methods("POST")
+	api.HandleFunc("/orders/{orderId}/confirm", app.handleConfirmCompletion).Methods("POST")
+	api.HandleFunc("/orders/{orderId}/receipts", app.handleGetOrderReceipts).Methods("GET")
+	api.HandleFunc("/receipts/{receiptId}/resend", app.handleResendReceipt).Methods("POST")
+	api.HandleFunc("/cron/escrow", app.handleEscrowCron).Methods("GET")
+	api.HandleFunc("/cron/verification", app.handleVerificationCron).Methods("GET")
+	api.HandleFunc("/cron/payments", app.handlePaymentsCron).Methods("GET")
+	api.HandleFunc("/payments/callback/{provider}", app.handlePaymentCallback).Methods("POST")
+	api.HandleFunc("/payments/sandbox/{providerPaymentId}", app.handleSandboxCheckout).Methods("GET", "POST")
+	api.HandleFunc("/files/{store}/{key:.+}", app.handleGetFile).Methods("GET")
+	api.HandleFunc("/calculator/materials", app.handleCalculateMaterials).Methods("POST")
+	app.registerV2Routes(api)
+	app.registerAdminRoutes(api)
 
 	router.ServeHTTP(w, r)
 }
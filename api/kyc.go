@@ -0,0 +1,527 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Проверка бригадиров: draft -> submitted -> verified или rejected.
+// Отклоненный бригадир дозагружает документы и отправляет их снова,
+// подтвержденного администратор может приостановить (suspended).
+// В подбор попадают только подтвержденные и активные бригадиры.
+const (
+	VerificationDraft     = "draft"
+	VerificationSubmitted = "submitted"
+	VerificationVerified  = "verified"
+	VerificationRejected  = "rejected"
+	VerificationSuspended = "suspended"
+
+	// Ссылки на документы короче обычных: это персональные данные
+	kycURLTTL = 5 * time.Minute
+)
+
+// Виды документов бригадира
+var kycDocumentKinds = map[string]string{
+	"passport":        "Паспорт",
+	"self_employment": "Справка о постановке на учет самозанятого",
+	"legal_entity":    "Выписка из ЕГРИП или ЕГРЮЛ",
+}
+
+type ContractorDocument struct {
+	ID          int64     `json:"id"`
+	Kind        string    `json:"kind"`
+	Title       string    `json:"title"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+	URL         string    `json:"url"`
+	blobKey     string
+}
+
+type Verification struct {
+	UserID      int64                `json:"user_id"`
+	Status      string               `json:"status"`
+	Comment     *string              `json:"comment"`
+	SubmittedAt *time.Time           `json:"submitted_at"`
+	VerifiedAt  *time.Time           `json:"verified_at"`
+	Documents   []ContractorDocument `json:"documents"`
+}
+
+// Напоминание бригадирам, которые работали до появления проверки
+const verificationNoticeMessage = "Мы ввели проверку бригадиров. Чтобы снова получать заказы, загрузите в профиле паспорт и справку самозанятого или выписку ЕГРИП/ЕГРЮЛ и отправьте их на проверку."
+
+// migrateContractorVerification добавляет статус проверки. Бригадиры, которые
+// работали до появления проверки, документов не загружали: они остаются в draft
+// и получают напоминание пройти проверку (verification_notice, см.
+// sendVerificationNotices).
+func (app *App) migrateContractorVerification() error {
+	var count int
+	if err := app.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('contractor_profiles') WHERE name = 'verification_status'").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	if err := app.addColumnIfNotExists("contractor_profiles", "verification_status", "TEXT NOT NULL DEFAULT 'draft'"); err != nil {
+		return err
+	}
+	_, err := app.db.Exec(`UPDATE contractor_profiles SET verification_notice = 1`)
+	return err
+}
+
+// sendVerificationNotices отправляет напоминания бригадирам, отмеченным при
+// миграции. Кто уже отправил документы, напоминание не получает; неотправленные
+// напоминания повторит следующий запуск.
+func (app *App) sendVerificationNotices(ctx context.Context) (int, error) {
+	if _, err := app.db.Exec(`UPDATE contractor_profiles SET verification_notice = 0 WHERE verification_notice = 1 AND verification_status != ?`, VerificationDraft); err != nil {
+		return 0, err
+	}
+	if app.telegram == nil {
+		return 0, nil
+	}
+	rows, err := app.db.Query(`SELECT ` + userColumns + ` FROM users WHERE id IN (SELECT user_id FROM contractor_profiles WHERE verification_notice = 1)`)
+	if err != nil {
+		return 0, err
+	}
+	var users []*User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, user := range users {
+		if err := app.telegram.SendMessage(ctx, user.TelegramID, tr(userLocale(user), verificationNoticeMessage)); err != nil {
+			log.Printf("Не удалось отправить напоминание о проверке бригадиру %d: %v", user.ID, err)
+			continue
+		}
+		if _, err := app.db.Exec(`UPDATE contractor_profiles SET verification_notice = 0 WHERE user_id = ?`, user.ID); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// handleVerificationCron - периодическая отправка напоминаний о проверке (Vercel Cron)
+func (app *App) handleVerificationCron(w http.ResponseWriter, r *http.Request) {
+	if !cronAuthorized(w, r) {
+		return
+	}
+	sent, err := app.sendVerificationNotices(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"notified": sent})
+}
+
+// getVerification возвращает nil, если у пользователя нет профиля бригадира
+func (app *App) getVerification(userID int64) (*Verification, error) {
+	v := Verification{UserID: userID}
+	var submittedAt, verifiedAt sql.NullString
+	err := app.db.QueryRow(`SELECT verification_status, verification_comment, submitted_at, verified_at FROM contractor_profiles WHERE user_id = ?`, userID).
+		Scan(&v.Status, &v.Comment, &submittedAt, &verifiedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if submittedAt.Valid {
+		t, _ := time.Parse("2006-01-02 15:04:05", submittedAt.String)
+		v.SubmittedAt = &t
+	}
+	if verifiedAt.Valid {
+		t, _ := time.Parse("2006-01-02 15:04:05", verifiedAt.String)
+		v.VerifiedAt = &t
+	}
+	if v.Documents, err = app.getContractorDocuments(userID); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (app *App) getContractorDocuments(userID int64) ([]ContractorDocument, error) {
+	rows, err := app.db.Query(`SELECT id, kind, blob_key, content_type, size, created_at FROM contractor_documents WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	docs := []ContractorDocument{}
+	for rows.Next() {
+		var doc ContractorDocument
+		var createdAt string
+		if err := rows.Scan(&doc.ID, &doc.Kind, &doc.blobKey, &doc.ContentType, &doc.Size, &createdAt); err != nil {
+			return nil, err
+		}
+		doc.Title = kycDocumentKinds[doc.Kind]
+		doc.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt)
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// signVerificationURLs заполняет временные ссылки на документы из приватного хранилища
+func (app *App) signVerificationURLs(v *Verification) error {
+	for i := range v.Documents {
+		url, err := app.private.SignedURL(v.Documents[i].blobKey, kycURLTTL)
+		if err != nil {
+			return err
+		}
+		v.Documents[i].URL = url
+	}
+	return nil
+}
+
+// kycAccess проверяет, что пользователь запроса - бригадир с профилем
+func (app *App) kycAccess(w http.ResponseWriter, user *User) *User {
+	if user == nil {
+		return nil
+	}
+	if user.Role != "contractor" {
+		writeError(w, http.StatusBadRequest, CodeNotContractor, "Пользователь не является бригадиром")
+		return nil
+	}
+	profile, err := app.getContractorProfile(user.ID)
+	if err != nil {
+		internalError(w, err)
+		return nil
+	}
+	if profile == nil {
+		writeError(w, http.StatusBadRequest, CodeContractorProfile, "Сначала заполните профиль бригадира")
+		return nil
+	}
+	return user
+}
+
+// rejectUnverified отвечает 403, если бригадир еще не прошел проверку
+func (app *App) rejectUnverified(w http.ResponseWriter, user *User) bool {
+	var status string
+	err := app.db.QueryRow(`SELECT verification_status FROM contractor_profiles WHERE user_id = ?`, user.ID).Scan(&status)
+	if err != nil && err != sql.ErrNoRows {
+		internalError(w, err)
+		return true
+	}
+	if status != VerificationVerified {
+		writeError(w, http.StatusForbidden, CodeContractorUnverified, "Профиль бригадира не подтвержден")
+		return true
+	}
+	return false
+}
+
+func (app *App) handleGetVerification(w http.ResponseWriter, r *http.Request) {
+	user := app.kycAccess(w, callerUser(w, r))
+	if user == nil {
+		return
+	}
+	v, err := app.getVerification(user.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if err := app.signVerificationURLs(v); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"verification": v})
+}
+
+// handleUploadContractorDocument загружает документ (multipart: kind, file).
+// Документ того же вида заменяется. Пока заявка на проверке или подтверждена, менять документы нельзя.
+func (app *App) handleUploadContractorDocument(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Файл слишком большой или неверный формат данных")
+		return
+	}
+	user := app.kycAccess(w, app.formUser(w, r))
+	if user == nil {
+		return
+	}
+	kind := r.FormValue("kind")
+	if _, ok := kycDocumentKinds[kind]; !ok {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Неизвестный вид документа")
+		return
+	}
+	v, err := app.getVerification(user.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if v.Status != VerificationDraft && v.Status != VerificationRejected {
+		writeError(w, http.StatusConflict, CodeConflict, "Документы нельзя изменить, пока заявка на проверке или подтверждена")
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeFileMissing, "Файл не передан")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxAttachmentSize+1))
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if len(data) > maxAttachmentSize {
+		writeErrorDetails(w, http.StatusRequestEntityTooLarge, CodeFileTooLarge, trf(responseLocale(w), "Файл больше %d МБ", maxAttachmentSize>>20),
+			map[string]interface{}{"max_mb": maxAttachmentSize >> 20})
+		return
+	}
+	contentType := http.DetectContentType(data)
+	ext, ok := attachmentTypes[contentType]
+	if !ok {
+		writeError(w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Допустимы только JPEG, PNG и PDF")
+		return
+	}
+
+	key := fmt.Sprintf("contractors/%d/%s_%s%s", user.ID, kind, randomHex(16), ext)
+	if err := app.private.Put(r.Context(), key, contentType, data); err != nil {
+		internalError(w, err)
+		return
+	}
+	var oldKey string
+	for _, doc := range v.Documents {
+		if doc.Kind == kind {
+			oldKey = doc.blobKey
+		}
+	}
+	if _, err := app.db.Exec(`INSERT INTO contractor_documents (user_id, kind, blob_key, content_type, size) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, kind) DO UPDATE SET blob_key = excluded.blob_key, content_type = excluded.content_type, size = excluded.size, created_at = CURRENT_TIMESTAMP`,
+		user.ID, kind, key, contentType, len(data)); err != nil {
+		app.private.Delete(r.Context(), key)
+		internalError(w, err)
+		return
+	}
+	if oldKey != "" {
+		if err := app.private.Delete(r.Context(), oldKey); err != nil {
+			log.Printf("Не удалось удалить старый документ %s: %v", oldKey, err)
+		}
+	}
+
+	if v, err = app.getVerification(user.ID); err != nil {
+		internalError(w, err)
+		return
+	}
+	if err := app.signVerificationURLs(v); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"verification": v})
+}
+
+// handleSubmitVerification отправляет документы на проверку. Нужен паспорт и
+// документ о статусе: справка самозанятого или выписка ЕГРИП/ЕГРЮЛ.
+func (app *App) handleSubmitVerification(w http.ResponseWriter, r *http.Request) {
+	user := app.kycAccess(w, callerUser(w, r))
+	if user == nil {
+		return
+	}
+	docs, err := app.getContractorDocuments(user.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	kinds := map[string]bool{}
+	for _, doc := range docs {
+		kinds[doc.Kind] = true
+	}
+	if !kinds["passport"] {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Загрузите паспорт")
+		return
+	}
+	if !kinds["self_employment"] && !kinds["legal_entity"] {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Загрузите справку самозанятого или выписку из ЕГРИП/ЕГРЮЛ")
+		return
+	}
+	result, err := app.db.Exec(`UPDATE contractor_profiles SET verification_status = 'submitted', verification_comment = NULL, submitted_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND verification_status IN ('draft', 'rejected')`, user.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, http.StatusConflict, CodeConflict, "Заявка уже на проверке или профиль подтвержден")
+		return
+	}
+	v, err := app.getVerification(user.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if err := app.signVerificationURLs(v); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"verification": v})
+}
+
+// handleAdminListVerifications - очередь проверки: по умолчанию заявки в статусе submitted, старые первыми
+func (app *App) handleAdminListVerifications(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = VerificationSubmitted
+	}
+	limit, offset := pageParams(r)
+	rows, err := app.db.Query(`SELECT cp.user_id, u.name, u.telegram_id, cp.verification_status, cp.verification_comment, cp.submitted_at
+		FROM contractor_profiles cp JOIN users u ON cp.user_id = u.id
+		WHERE cp.verification_status = ? ORDER BY cp.submitted_at, cp.id LIMIT ? OFFSET ?`, status, limit, offset)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer rows.Close()
+	type queueItem struct {
+		UserID      int64      `json:"user_id"`
+		Name        *string    `json:"name"`
+		TelegramID  int64      `json:"telegram_id"`
+		Status      string     `json:"status"`
+		Comment     *string    `json:"comment"`
+		SubmittedAt *time.Time `json:"submitted_at"`
+	}
+	items := []queueItem{}
+	for rows.Next() {
+		var item queueItem
+		var submittedAt sql.NullString
+		if err := rows.Scan(&item.UserID, &item.Name, &item.TelegramID, &item.Status, &item.Comment, &submittedAt); err != nil {
+			internalError(w, err)
+			return
+		}
+		if submittedAt.Valid {
+			t, _ := time.Parse("2006-01-02 15:04:05", submittedAt.String)
+			item.SubmittedAt = &t
+		}
+		items = append(items, item)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"verifications": items, "limit": limit, "offset": offset})
+}
+
+func (app *App) handleAdminGetVerification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	v, err := app.getVerification(userID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if v == nil {
+		writeError(w, http.StatusNotFound, CodeContractorProfile, "Профиль бригадира не найден")
+		return
+	}
+	if err := app.signVerificationURLs(v); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"verification": v})
+}
+
+// verificationActions - решения администратора: из каких статусов и в какой статус
+var verificationActions = map[string]struct {
+	from, to       string
+	requireComment bool
+	message        string
+}{
+	"approve": {"'submitted', 'suspended'", VerificationVerified, false, "Ваш профиль бригадира подтвержден, теперь вам доступны заказы."},
+	"reject":  {"'submitted'", VerificationRejected, true, "Проверка профиля бригадира не пройдена: %s"},
+	"suspend": {"'verified'", VerificationSuspended, true, "Ваш профиль бригадира приостановлен: %s"},
+}
+
+// handleAdminDecideVerification - одобрить, отклонить или приостановить бригадира {action, comment}
+func (app *App) handleAdminDecideVerification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Action  string  `json:"action"`
+		Comment *string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	action, ok := verificationActions[req.Action]
+	if !ok {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Действие должно быть approve, reject или suspend")
+		return
+	}
+	if action.requireComment && (req.Comment == nil || *req.Comment == "") {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Укажите комментарий для бригадира")
+		return
+	}
+	user, err := app.getUserByID(userID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if user == nil {
+		writeError(w, http.StatusNotFound, CodeUserNotFound, "Пользователь не найден")
+		return
+	}
+
+	verifiedAt := "verified_at"
+	if action.to == VerificationVerified {
+		verifiedAt = "CURRENT_TIMESTAMP"
+	}
+	result, err := app.db.Exec(`UPDATE contractor_profiles SET verification_status = ?, verification_comment = ?, verified_at = `+verifiedAt+`
+		WHERE user_id = ? AND verification_status IN (`+action.from+`)`, action.to, req.Comment, userID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, http.StatusConflict, CodeConflict, "Действие недоступно в текущем статусе проверки")
+		return
+	}
+	app.audit(r.Context(), "verification."+req.Action, "user", userID, req.Comment, nil)
+	message := tr(userLocale(user), action.message)
+	if action.requireComment {
+		message = fmt.Sprintf(message, *req.Comment)
+	}
+	app.notifyVerification(r.Context(), user, message)
+
+	v, err := app.getVerification(userID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if err := app.signVerificationURLs(v); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"verification": v})
+}
+
+func (app *App) notifyVerification(ctx context.Context, user *User, message string) {
+	if app.telegram == nil {
+		return
+	}
+	if err := app.telegram.SendMessage(ctx, user.TelegramID, message); err != nil {
+		log.Printf("Не удалось отправить уведомление о проверке бригадиру %d: %v", user.ID, err)
+	}
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeBotAPI - Bot API, который запоминает получателей sendMessage и
+// отказывает чатам из failing
+type fakeBotAPI struct {
+	mu      sync.Mutex
+	sent    []int64
+	failing map[int64]bool
+}
+
+func (f *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChatID int64 `json:"chat_id"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing[req.ChatID] {
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "description": "Forbidden: bot was blocked by the user"})
+		return
+	}
+	f.sent = append(f.sent, req.ChatID)
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": map[string]interface{}{}})
+}
+
+func TestMigrateContractorVerification(t *testing.T) {
+	a := newTestApp(t)
+	t.Setenv("CRON_SECRET", "test-cron-secret")
+	// Бригадиры, которые работали до появления проверки
+	for _, telegramID := range []int64{333, 444, 555} {
+		id := testUser(t, a, telegramID, "contractor")
+		if _, err := a.db.Exec(`INSERT INTO contractor_profiles (user_id, categories) VALUES (?, '[]')`, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := a.db.Exec(`ALTER TABLE contractor_profiles DROP COLUMN verification_status`); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.migrateContractorVerification(); err != nil {
+		t.Fatal(err)
+	}
+	var verified int
+	a.db.QueryRow(`SELECT COUNT(*) FROM contractor_profiles WHERE verification_status != ? OR verification_notice != 1`, VerificationDraft).Scan(&verified)
+	if verified != 0 {
+		t.Fatalf("после миграции %d бригадиров не в draft или без напоминания", verified)
+	}
+
+	// 444 уже отправил документы, 555 заблокировал бота
+	if _, err := a.db.Exec(`UPDATE contractor_profiles SET verification_status = ? WHERE user_id = (SELECT id FROM users WHERE telegram_id = 444)`, VerificationSubmitted); err != nil {
+		t.Fatal(err)
+	}
+	bot := &fakeBotAPI{failing: map[int64]bool{555: true}}
+	server := httptest.NewServer(bot)
+	defer server.Close()
+	a.telegram = &TelegramClient{Token: "test", BaseURL: server.URL, HTTP: server.Client()}
+
+	cron := func() int {
+		var resp struct {
+			Notified int `json:"notified"`
+		}
+		if code := doJSON(t, "GET", "/api/cron/verification", nil, &resp, "Authorization", "Bearer test-cron-secret"); code != http.StatusOK {
+			t.Fatalf("cron: статус %d", code)
+		}
+		return resp.Notified
+	}
+	if n := cron(); n != 1 || len(bot.sent) != 1 || bot.sent[0] != 333 {
+		t.Fatalf("первый запуск: отправлено %d, получатели %v; want только 333", n, bot.sent)
+	}
+	// Недоставленное напоминание повторяется, доставленное - нет
+	bot.failing = nil
+	if n := cron(); n != 1 || len(bot.sent) != 2 || bot.sent[1] != 555 {
+		t.Fatalf("повтор: отправлено %d, получатели %v; want 555", n, bot.sent)
+	}
+	if n := cron(); n != 0 {
+		t.Errorf("третий запуск отправил %d напоминаний", n)
+	}
+	if code := doJSON(t, "GET", "/api/cron/verification", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("cron без секрета: статус %d, want 401", code)
+	}
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Счета учета. Каждое движение денег - проводка из двух строк с одинаковым
+// tx_id: дебет одного счета и кредит другого на одну сумму, поэтому сумма
+// дебетов всегда равна сумме кредитов.
+//
+//	cash:{provider}    - деньги на счете у платежного провайдера
+//	escrow:{orderID}   - предоплата, удерживаемая по заказу
+//	contractor:{id}    - задолженность платформы перед бригадиром
+//	revenue:commission - комиссия платформы
+//	cash:payouts       - деньги, выплаченные бригадирам
+func cashAccount(provider string) string    { return "cash:" + provider }
+func escrowAccount(orderID int64) string    { return fmt.Sprintf("escrow:%d", orderID) }
+func contractorAccount(userID int64) string { return fmt.Sprintf("contractor:%d", userID) }
+
+type LedgerEntry struct {
+	ID        int64  `json:"id"`
+	TxID      string `json:"tx_id"`
+	Account   string `json:"account"`
+	Debit     int64  `json:"debit"`
+	Credit    int64  `json:"credit"`
+	OrderID   *int64 `json:"order_id"`
+	PaymentID *int64 `json:"payment_id"`
+	Memo      string `json:"memo"`
+	CreatedAt string `json:"created_at"`
+}
+
+// postLedger записывает проводку одним запросом, чтобы обе строки появились вместе.
+// txID уникален для движения (например "escrow_release:15"), повтор игнорируется.
+func (app *App) postLedger(txID, debitAccount, creditAccount string, amount int64, orderID, paymentID *int64, memo string) error {
+	return postLedgerExec(app.db, txID, debitAccount, creditAccount, amount, orderID, paymentID, memo)
+}
+
+// postLedgerExec - postLedger внутри транзакции, когда проводка должна
+// появиться вместе с изменением статуса
+func postLedgerExec(db interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, txID, debitAccount, creditAccount string, amount int64, orderID, paymentID *int64, memo string) error {
+	if amount <= 0 {
+		return fmt.Errorf("сумма проводки должна быть положительной")
+	}
+	_, err := db.Exec(`INSERT OR IGNORE INTO ledger_entries (tx_id, account, debit, credit, order_id, payment_id, memo) VALUES (?, ?, ?, 0, ?, ?, ?), (?, ?, 0, ?, ?, ?, ?)`,
+		txID, debitAccount, amount, orderID, paymentID, memo,
+		txID, creditAccount, amount, orderID, paymentID, memo)
+	return err
+}
+
+// accountBalance - кредит минус дебет (для счетов обязательств - сколько платформа должна)
+func (app *App) accountBalance(account string) (int64, error) {
+	var balance int64
+	err := app.db.QueryRow(`SELECT COALESCE(SUM(credit) - SUM(debit), 0) FROM ledger_entries WHERE account = ?`, account).Scan(&balance)
+	return balance, err
+}
+
+func (app *App) getOrderLedger(orderID int64) ([]LedgerEntry, error) {
+	rows, err := app.db.Query(`SELECT id, tx_id, account, debit, credit, order_id, payment_id, memo, created_at FROM ledger_entries WHERE order_id = ? ORDER BY id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []LedgerEntry{}
+	for rows.Next() {
+		var e LedgerEntry
+		if err := rows.Scan(&e.ID, &e.TxID, &e.Account, &e.Debit, &e.Credit, &e.OrderID, &e.PaymentID, &e.Memo, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// handleLedgerBalances - сальдо по счетам для сверки (только администратор).
+// Сумма дебетов должна совпадать с суммой кредитов.
+func (app *App) handleLedgerBalances(w http.ResponseWriter, r *http.Request) {
+	rows, err := app.db.Query(`SELECT account, SUM(debit), SUM(credit) FROM ledger_entries GROUP BY account ORDER BY account`)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer rows.Close()
+	type balance struct {
+		Account string `json:"account"`
+		Debit   int64  `json:"debit"`
+		Credit  int64  `json:"credit"`
+		Balance int64  `json:"balance"`
+	}
+	balances := []balance{}
+	var totalDebit, totalCredit int64
+	for rows.Next() {
+		var b balance
+		if err := rows.Scan(&b.Account, &b.Debit, &b.Credit); err != nil {
+			internalError(w, err)
+			return
+		}
+		b.Balance = b.Credit - b.Debit
+		totalDebit += b.Debit
+		totalCredit += b.Credit
+		balances = append(balances, b)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"balances":     balances,
+		"total_debit":  totalDebit,
+		"total_credit": totalCredit,
+		"balanced":     totalDebit == totalCredit,
+	})
+}
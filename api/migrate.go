@@ -53,7 +53,7 @@ var contractorsData = []ContractorData{
 
 func (app *App) handleMigrate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Метод не поддерживается")
 		return
 	}
 
@@ -91,6 +91,12 @@ func (app *App) handleMigrate(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
+		if err := app.grantRole(userID, "contractor"); err != nil {
+			errors++
+			results = append(results, fmt.Sprintf("❌ Ошибка выдачи роли %s: %v", contractor.Name, err))
+			continue
+		}
+
 		// Создаем категории в формате JSON
 		categories := []string{contractor.Category}
 		categoriesJSON, _ := json.Marshal(categories)
@@ -102,8 +108,8 @@ func (app *App) handleMigrate(w http.ResponseWriter, r *http.Request) {
 		if err == sql.ErrNoRows {
 			// Создаем профиль
 			_, err = app.db.Exec(
-				`INSERT INTO contractor_profiles (user_id, experience_years, rating, completed_orders, categories, is_active)
-				 VALUES (?, ?, ?, ?, ?, ?)`,
+				`INSERT INTO contractor_profiles (user_id, experience_years, rating, completed_orders, categories, is_active, verification_status, verified_at)
+				 VALUES (?, ?, ?, ?, ?, ?, 'verified', CURRENT_TIMESTAMP)`,
 				userID, contractor.Experience, contractor.Rating, contractor.Orders, string(categoriesJSON), true,
 			)
 			if err != nil {
@@ -120,7 +126,7 @@ func (app *App) handleMigrate(w http.ResponseWriter, r *http.Request) {
 			// Обновляем профиль
 			_, err = app.db.Exec(
 				`UPDATE contractor_profiles 
-				 SET experience_years = ?, rating = ?, completed_orders = ?, categories = ?, is_active = ?
+				 SET experience_years = ?, rating = ?, completed_orders = ?, categories = ?, is_active = ?, verification_status = 'verified'
 				 WHERE user_id = ?`,
 				contractor.Experience, contractor.Rating, contractor.Orders, string(categoriesJSON), true, userID,
 			)
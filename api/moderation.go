@@ -0,0 +1,439 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Статусы пользователя. Приостановленному доступно только чтение, заблокированному -
+// только собственный профиль. Приостановка со сроком снимается сама.
+const (
+	UserActive    = "active"
+	UserSuspended = "suspended"
+	UserBanned    = "banned"
+)
+
+// userActiveSQL - условие "пользователь u активен" для запросов
+const userActiveSQL = "(u.status = 'active' OR (u.status = 'suspended' AND u.status_until <= CURRENT_TIMESTAMP))"
+
+// Правила антифрода
+const (
+	FraudCancellations  = "cancellations"   // клиент отменил threshold заказов за window_days
+	FraudNoShow         = "no_show"         // бригадир принял и не вышел на объект threshold раз за window_days
+	FraudDuplicatePhone = "duplicate_phone" // телефон указан еще у threshold других аккаунтов
+)
+
+type FraudRule struct {
+	Rule       string `json:"rule"`
+	Threshold  int    `json:"threshold"`
+	WindowDays int    `json:"window_days"`
+	Enabled    bool   `json:"enabled"`
+}
+
+type FraudFlag struct {
+	ID            int64      `json:"id"`
+	UserID        int64      `json:"user_id"`
+	UserName      *string    `json:"user_name"`
+	TelegramID    int64      `json:"telegram_id"`
+	UserStatus    string     `json:"user_status"`
+	Rule          string     `json:"rule"`
+	Details       string     `json:"details"`
+	Status        string     `json:"status"`
+	Resolution    *string    `json:"resolution"`
+	ReviewComment *string    `json:"review_comment"`
+	ReviewedBy    *string    `json:"reviewed_by"`
+	ReviewedAt    *time.Time `json:"reviewed_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// migrateUserStatus добавляет статус пользователя. Блокировки, выставленные
+// до появления статусов (users.blocked_at), становятся баном.
+func (app *App) migrateUserStatus() error {
+	var count int
+	if err := app.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = 'status'").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	if err := app.addColumnIfNotExists("users", "status", "TEXT NOT NULL DEFAULT 'active' CHECK(status IN ('active', 'suspended', 'banned'))"); err != nil {
+		return err
+	}
+	if err := app.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = 'blocked_at'").Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+	_, err := app.db.Exec(`UPDATE users SET status = 'banned', status_reason = blocked_reason, status_changed_at = blocked_at WHERE blocked_at IS NOT NULL`)
+	return err
+}
+
+func statusMessage(user *User, locale string) string {
+	message := tr(locale, "Аккаунт заблокирован")
+	if user.Status == UserSuspended {
+		message = tr(locale, "Аккаунт приостановлен")
+		if user.StatusUntil != nil {
+			message += trf(locale, " до %s (МСК)", user.StatusUntil.In(promoZone).Format("02.01.2006 15:04"))
+		}
+	}
+	if user.StatusReason != nil && *user.StatusReason != "" {
+		message += ": " + *user.StatusReason
+	}
+	return message
+}
+
+// inactiveError отвечает 403 с кодом user_inactive, статусом и сроком приостановки
+func inactiveError(w http.ResponseWriter, user *User) {
+	details := map[string]interface{}{"status": user.Status}
+	if user.StatusUntil != nil {
+		details["until"] = user.StatusUntil
+	}
+	writeErrorDetails(w, http.StatusForbidden, CodeUserInactive, statusMessage(user, responseLocale(w)), details)
+}
+
+// rejectInactive отвечает 403, если пользователь приостановлен или заблокирован.
+func rejectInactive(w http.ResponseWriter, user *User) bool {
+	if user != nil && user.Status != UserActive {
+		inactiveError(w, user)
+		return true
+	}
+	return false
+}
+
+// userStatusGuard - middleware /api: статус пользователя, определенного
+// authenticate, проверяется для всех обработчиков, которые работают от его имени
+func (app *App) userStatusGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Админ-API проверяет права само, статус администратора не ограничивает
+		if strings.HasPrefix(r.URL.Path, "/api/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user := requestCaller(r).user
+		if user != nil && user.Status != UserActive {
+			allowed := r.Method == "GET"
+			if user.Status == UserBanned {
+				route, _ := mux.CurrentRoute(r).GetPathTemplate()
+				allowed = allowed && (route == "/api/user/{telegramId}" || route == "/api/v2/me")
+			}
+			if !allowed {
+				inactiveError(w, user)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setUserStatus меняет статус пользователя и пишет действие в журнал.
+// days > 0 ограничивает срок приостановки. Возвращает nil, если пользователь не найден.
+func (app *App) setUserStatus(ctx context.Context, userID int64, status string, reason *string, days int) (*User, error) {
+	var until *string
+	if status == UserSuspended && days > 0 {
+		t := time.Now().UTC().AddDate(0, 0, days).Format("2006-01-02 15:04:05")
+		until = &t
+	}
+	if status == UserActive {
+		reason = nil
+	}
+	result, err := app.db.Exec(`UPDATE users SET status = ?, status_reason = ?, status_until = ?, status_changed_at = CURRENT_TIMESTAMP WHERE id = ?`, status, reason, until, userID)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, nil
+	}
+	app.audit(ctx, "user.status", "user", userID, reason, map[string]interface{}{"status": status, "days": days})
+	user, err := app.getUserByID(userID)
+	if err != nil || user == nil {
+		return user, err
+	}
+	if app.telegram != nil {
+		message := tr(userLocale(user), "Ваш аккаунт снова активен.")
+		if user.Status != UserActive {
+			message = statusMessage(user, userLocale(user))
+		}
+		if err := app.telegram.SendMessage(ctx, user.TelegramID, message); err != nil {
+			log.Printf("Не удалось уведомить пользователя %d о смене статуса: %v", user.ID, err)
+		}
+	}
+	return user, nil
+}
+
+// parseStatusRequest проверяет статус и причину из запроса администратора
+func parseStatusRequest(w http.ResponseWriter, status string, reason *string, days int) bool {
+	if status != UserActive && status != UserSuspended && status != UserBanned {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Статус должен быть active, suspended или banned")
+		return false
+	}
+	if status != UserActive && (reason == nil || strings.TrimSpace(*reason) == "") {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Укажите причину")
+		return false
+	}
+	if days < 0 {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Срок приостановки не может быть отрицательным")
+		return false
+	}
+	return true
+}
+
+// handleAdminSetUserStatus - {status, reason, days}: приостановка (days - срок, 0 - бессрочно), бан или снятие ограничений
+func (app *App) handleAdminSetUserStatus(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Status string  `json:"status"`
+		Reason *string `json:"reason"`
+		Days   int     `json:"days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	if !parseStatusRequest(w, req.Status, req.Reason, req.Days) {
+		return
+	}
+	user, err := app.setUserStatus(r.Context(), userID, req.Status, req.Reason, req.Days)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if user == nil {
+		writeError(w, http.StatusNotFound, CodeUserNotFound, "Пользователь не найден")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": user})
+}
+
+func (app *App) getFraudRules() ([]FraudRule, error) {
+	rows, err := app.db.Query(`SELECT rule, threshold, window_days, enabled FROM fraud_rules ORDER BY rule`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []FraudRule{}
+	for rows.Next() {
+		var rule FraudRule
+		if err := rows.Scan(&rule.Rule, &rule.Threshold, &rule.WindowDays, &rule.Enabled); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// fraudSignal считает значение сигнала по правилу и описание для флага
+func (app *App) fraudSignal(rule *FraudRule, user *User) (int, string, error) {
+	window := fmt.Sprintf("-%d days", rule.WindowDays)
+	var count int
+	switch rule.Rule {
+	case FraudCancellations:
+		// Отмены из-за неявки бригадира клиенту не засчитываются
+		err := app.db.QueryRow(`SELECT COUNT(*) FROM orders WHERE client_id = ? AND cancelled_by = ? AND (cancel_reason IS NULL OR cancel_reason != 'no_show')
+			AND cancelled_at >= datetime('now', ?)`, user.ID, user.ID, window).Scan(&count)
+		return count, fmt.Sprintf("Отменено заказов за %d дн.: %d", rule.WindowDays, count), err
+	case FraudNoShow:
+		// Принятый заказ отменил сам бригадир или клиент из-за неявки
+		err := app.db.QueryRow(`SELECT COUNT(*) FROM orders WHERE contractor_id = ? AND status = 'cancelled' AND accepted_at IS NOT NULL
+			AND (cancelled_by = ? OR cancel_reason = 'no_show') AND cancelled_at >= datetime('now', ?)`, user.ID, user.ID, window).Scan(&count)
+		return count, fmt.Sprintf("Срывов принятых заказов за %d дн.: %d", rule.WindowDays, count), err
+	case FraudDuplicatePhone:
+		if user.Phone == nil || strings.TrimSpace(*user.Phone) == "" {
+			return 0, "", nil
+		}
+		rows, err := app.db.Query(`SELECT telegram_id FROM users WHERE phone = ? AND id != ?`, *user.Phone, user.ID)
+		if err != nil {
+			return 0, "", err
+		}
+		defer rows.Close()
+		var ids []string
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return 0, "", err
+			}
+			ids = append(ids, strconv.FormatInt(id, 10))
+		}
+		return len(ids), "Телефон указан у других аккаунтов (telegram_id): " + strings.Join(ids, ", "), rows.Err()
+	}
+	return 0, "", fmt.Errorf("неизвестное правило: %s", rule.Rule)
+}
+
+// checkFraudRule ставит флаг, если сигнал достиг порога правила. У пользователя
+// может быть один открытый флаг на правило. Ошибки только логируются: проверка
+// не должна мешать действию, после которого она запущена.
+func (app *App) checkFraudRule(ruleName string, user *User) {
+	if user == nil {
+		return
+	}
+	var rule FraudRule
+	err := app.db.QueryRow(`SELECT rule, threshold, window_days, enabled FROM fraud_rules WHERE rule = ?`, ruleName).Scan(&rule.Rule, &rule.Threshold, &rule.WindowDays, &rule.Enabled)
+	if err != nil {
+		log.Printf("Не удалось загрузить правило %s: %v", ruleName, err)
+		return
+	}
+	if !rule.Enabled {
+		return
+	}
+	count, details, err := app.fraudSignal(&rule, user)
+	if err != nil {
+		log.Printf("Не удалось проверить правило %s для пользователя %d: %v", ruleName, user.ID, err)
+		return
+	}
+	if count < rule.Threshold {
+		return
+	}
+	if _, err := app.db.Exec(`INSERT OR IGNORE INTO fraud_flags (user_id, rule, details) VALUES (?, ?, ?)`, user.ID, rule.Rule, details); err != nil {
+		log.Printf("Не удалось поставить флаг %s пользователю %d: %v", ruleName, user.ID, err)
+	}
+}
+
+func (app *App) handleAdminGetFraudRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.getFraudRules()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"rules": rules})
+}
+
+// handleAdminSaveFraudRule - изменить порог, окно или выключить правило
+func (app *App) handleAdminSaveFraudRule(w http.ResponseWriter, r *http.Request) {
+	var req FraudRule
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	if req.Threshold < 1 || req.WindowDays < 0 {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Порог должен быть не меньше 1, окно - не меньше 0 дней")
+		return
+	}
+	result, err := app.db.Exec(`UPDATE fraud_rules SET threshold = ?, window_days = ?, enabled = ?, updated_at = CURRENT_TIMESTAMP WHERE rule = ?`,
+		req.Threshold, req.WindowDays, req.Enabled, req.Rule)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Неизвестное правило")
+		return
+	}
+	app.audit(r.Context(), "fraud_rule.update", "fraud_rule", 0, nil, req)
+	app.handleAdminGetFraudRules(w, r)
+}
+
+// handleAdminListFlags - очередь флагов: status (по умолчанию open), rule, user_id; старые первыми
+func (app *App) handleAdminListFlags(w http.ResponseWriter, r *http.Request) {
+	var filter adminFilter
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "open"
+	}
+	filter.add("f.status = ?", status)
+	filter.addParam(r, "rule", "f.rule = ?")
+	filter.addParam(r, "user_id", "f.user_id = ?")
+	limit, offset := pageParams(r)
+	rows, err := app.db.Query(`SELECT f.id, f.user_id, u.name, u.telegram_id, u.status, f.rule, f.details, f.status, f.resolution, f.review_comment, f.reviewed_by, f.reviewed_at, f.created_at
+		FROM fraud_flags f JOIN users u ON u.id = f.user_id`+filter.where()+` ORDER BY f.id LIMIT ? OFFSET ?`, append(filter.args, limit, offset)...)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer rows.Close()
+	flags := []FraudFlag{}
+	for rows.Next() {
+		var f FraudFlag
+		var reviewedAt sql.NullString
+		var createdAt string
+		if err := rows.Scan(&f.ID, &f.UserID, &f.UserName, &f.TelegramID, &f.UserStatus, &f.Rule, &f.Details, &f.Status, &f.Resolution, &f.ReviewComment, &f.ReviewedBy, &reviewedAt, &createdAt); err != nil {
+			internalError(w, err)
+			return
+		}
+		if reviewedAt.Valid {
+			t, _ := time.Parse("2006-01-02 15:04:05", reviewedAt.String)
+			f.ReviewedAt = &t
+		}
+		f.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt)
+		flags = append(flags, f)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"flags": flags, "limit": limit, "offset": offset})
+}
+
+// handleAdminResolveFlag - разбор флага {action: dismiss|suspend|ban, comment, days}
+func (app *App) handleAdminResolveFlag(w http.ResponseWriter, r *http.Request) {
+	flagID, err := strconv.ParseInt(mux.Vars(r)["flagId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный ID флага")
+		return
+	}
+	var req struct {
+		Action  string  `json:"action"`
+		Comment *string `json:"comment"`
+		Days    int     `json:"days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	flagStatus, userStatus := "actioned", ""
+	switch req.Action {
+	case "dismiss":
+		flagStatus = "dismissed"
+	case "suspend":
+		userStatus = UserSuspended
+	case "ban":
+		userStatus = UserBanned
+	default:
+		writeError(w, http.StatusBadRequest, CodeValidation, "Действие должно быть dismiss, suspend или ban")
+		return
+	}
+	if userStatus != "" && !parseStatusRequest(w, userStatus, req.Comment, req.Days) {
+		return
+	}
+
+	var userID int64
+	if err := app.db.QueryRow(`SELECT user_id FROM fraud_flags WHERE id = ?`, flagID).Scan(&userID); err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Флаг не найден")
+		return
+	} else if err != nil {
+		internalError(w, err)
+		return
+	}
+	result, err := app.db.Exec(`UPDATE fraud_flags SET status = ?, resolution = ?, review_comment = ?, reviewed_by = ?, reviewed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'open'`,
+		flagStatus, req.Action, req.Comment, adminActor(r.Context()), flagID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, http.StatusConflict, CodeConflict, "Флаг уже разобран")
+		return
+	}
+	app.audit(r.Context(), "flag."+req.Action, "fraud_flag", flagID, req.Comment, nil)
+
+	user, err := app.getUserByID(userID)
+	if userStatus != "" {
+		user, err = app.setUserStatus(r.Context(), userID, userStatus, req.Comment, req.Days)
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "user": user})
+}
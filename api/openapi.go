@@ -0,0 +1,690 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Контракт API описан здесь таблицей операций. Из нее строится OpenAPI 3.1
+// (/api/openapi.json) и по ней же validateRequest проверяет запросы, поэтому
+// новый маршрут нужно добавить и в handleAPI, и в apiOperations.
+
+// Schema - подмножество JSON Schema, которого хватает для описания API
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+func strSchema() *Schema              { return &Schema{Type: "string"} }
+func intSchema() *Schema              { return &Schema{Type: "integer"} }
+func numSchema() *Schema              { return &Schema{Type: "number"} }
+func boolSchema() *Schema             { return &Schema{Type: "boolean"} }
+func arrSchema(items *Schema) *Schema { return &Schema{Type: "array", Items: items} }
+func refSchema(name string) *Schema   { return &Schema{Ref: "#/components/schemas/" + name} }
+func enumSchema(values ...string) *Schema {
+	return &Schema{Type: "string", Enum: values}
+}
+
+// objSchema - объект; обязательные поля помечаются "!" в конце имени
+func objSchema(props map[string]*Schema) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for name, prop := range props {
+		if strings.HasSuffix(name, "!") {
+			name = strings.TrimSuffix(name, "!")
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = prop
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+func (s *Schema) min(v float64) *Schema  { s.Minimum = &v; return s }
+func (s *Schema) gt(v float64) *Schema   { s.ExclusiveMinimum = &v; return s }
+func (s *Schema) max(v float64) *Schema  { s.Maximum = &v; return s }
+func (s *Schema) minLen(n int) *Schema   { s.MinLength = &n; return s }
+func (s *Schema) maxLen(n int) *Schema   { s.MaxLength = &n; return s }
+func (s *Schema) match(p string) *Schema { s.Pattern = p; return s }
+func (s *Schema) desc(d string) *Schema  { s.Description = d; return s }
+
+func dateSchema() *Schema {
+	return &Schema{Type: "string", Format: "date", Pattern: `^\d{4}-\d{2}-\d{2}$`}
+}
+
+// categorySchema - категория заказа: ключ TARIFFS
+func categorySchema() *Schema {
+	keys := make([]string, 0, len(TARIFFS))
+	for key := range TARIFFS {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return enumSchema(keys...)
+}
+
+// addonSchema - тарифы дополнительных работ (isAddon)
+func addonSchema() *Schema {
+	var keys []string
+	for key, tariff := range TARIFFS {
+		if tariff.IsAddon {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return enumSchema(keys...)
+}
+
+func mapKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type apiParam struct {
+	name     string
+	in       string // query или path
+	required bool
+	schema   *Schema
+}
+
+func query(name string, schema *Schema) apiParam { return apiParam{name, "query", false, schema} }
+
+// telegramIDQuery - ?telegram_id= пользователя запроса. Пользователь определяется
+// по X-Telegram-Init-Data, параметр нужен только для совместимости и сверяется с подписью.
+func telegramIDQuery() apiParam {
+	return query("telegram_id", intSchema().desc(telegramIDNote))
+}
+
+const telegramIDNote = "Необязателен: пользователь определяется по X-Telegram-Init-Data; если передан, должен с ним совпадать"
+
+// pageQuery - limit и offset списков админ-API
+func pageQuery() []apiParam {
+	return []apiParam{
+		query("limit", intSchema().min(1).max(adminMaxPageSize)),
+		query("offset", intSchema().min(0)),
+	}
+}
+
+type apiOperation struct {
+	method  string
+	path    string // шаблон маршрута mux от /api
+	tag     string
+	summary string
+	params  []apiParam
+	// JSON-тело; проверяется validateRequest
+	body *Schema
+	// Поля multipart/form-data; только для документации
+	multipart *Schema
+	// Тело успешного ответа, по умолчанию - произвольный объект
+	response *Schema
+	admin    bool
+	// Замена в /api/v2 ("PATCH /v2/orders/{orderId}"); операция помечается устаревшей
+	successor string
+}
+
+// withTelegramID добавляет к телу необязательный telegram_id (см. telegramIDQuery)
+func withTelegramID(props map[string]*Schema) *Schema {
+	props["telegram_id"] = intSchema().desc(telegramIDNote)
+	return objSchema(props)
+}
+
+// actor - тело действия без параметров
+func actor() *Schema { return withTelegramID(map[string]*Schema{}) }
+
+func roleSchema() *Schema { return enumSchema("client", "contractor") }
+
+// Статусы заказа
+var orderStatuses = []string{"pending", "accepted", "completed", "cancelled"}
+
+var (
+	apiOperationsOnce  sync.Once
+	apiOperationsList  []apiOperation
+	apiOperationsIndex map[string]*apiOperation
+)
+
+// apiOperations - все операции /api. Строится один раз: схемы зависят от
+// TARIFFS и каталогов, которые заполняются в init().
+func apiOperations() ([]apiOperation, map[string]*apiOperation) {
+	apiOperationsOnce.Do(func() {
+		apiOperationsList = buildAPIOperations()
+		apiOperationsIndex = make(map[string]*apiOperation, len(apiOperationsList))
+		for i := range apiOperationsList {
+			op := &apiOperationsList[i]
+			apiOperationsIndex[op.method+" /api"+op.path] = op
+		}
+	})
+	return apiOperationsList, apiOperationsIndex
+}
+
+func buildAPIOperations() []apiOperation {
+	room := objSchema(map[string]*Schema{
+		"name":   strSchema().maxLen(100),
+		"shape":  enumSchema("rectangle", "polygon"),
+		"width":  numSchema().gt(0).max(maxWallLength),
+		"length": numSchema().gt(0).max(maxWallLength),
+		"walls": arrSchema(objSchema(map[string]*Schema{
+			"length!": numSchema().gt(0).max(maxWallLength),
+			"angle!":  numSchema().gt(0).max(360),
+		})),
+	})
+	categories := arrSchema(categorySchema())
+	percent := numSchema().min(0).max(100)
+	newOrder := withTelegramID(map[string]*Schema{
+		"category!":    categorySchema(),
+		"area":         numSchema().gt(0).max(maxOrderArea).desc("Обязательна, если не переданы rooms"),
+		"address":      strSchema().maxLen(500),
+		"thickness_mm": numSchema().gt(0),
+		"rooms":        arrSchema(room),
+		"promo_code":   strSchema(),
+		"region":       strSchema(),
+		"addons":       arrSchema(addonSchema()).desc("Дополнительные работы к основному тарифу"),
+		"mode":         enumSchema(OrderModeInstant, OrderModeAuction),
+		"bid_hours":    intSchema().min(1).max(auctionMaxBidHours),
+		"start_by":     dateSchema(),
+	})
+	orderResponse := objSchema(map[string]*Schema{"order": refSchema("Order")})
+	orderPage := objSchema(map[string]*Schema{
+		"orders":      arrSchema(refSchema("Order")),
+		"next_cursor": strSchema().desc("Курсор следующей страницы; null - страница последняя"),
+	})
+
+	return []apiOperation{
+		// Справочники
+		{method: "GET", path: "/tariffs", tag: "catalog", summary: "Тарифы с ценами региона",
+			params:   []apiParam{query("region", strSchema()), query("lang", enumSchema("ru", "en", "uz"))},
+			response: &Schema{Type: "object", AdditionalProperties: refSchema("Tariff")}},
+		{method: "GET", path: "/regions", tag: "catalog", summary: "Активные регионы"},
+
+		// Пользователи
+		{method: "GET", path: "/user/{telegramId}", tag: "users", summary: "Пользователь и профиль бригадира",
+			successor: "GET /v2/me"},
+		{method: "POST", path: "/user", tag: "users", summary: "Создать или обновить пользователя",
+			body: withTelegramID(map[string]*Schema{
+				"role":          roleSchema().desc("Обязательна при создании пользователя"),
+				"name":          strSchema().maxLen(200),
+				"phone":         strSchema().maxLen(32),
+				"region":        strSchema(),
+				"language_code": strSchema().maxLen(35),
+			}),
+			response: objSchema(map[string]*Schema{"user": refSchema("User")})},
+		{method: "POST", path: "/user/role", tag: "users", summary: "Сменить активную роль",
+			body:      withTelegramID(map[string]*Schema{"role!": roleSchema()}),
+			successor: "PATCH /v2/me"},
+		{method: "POST", path: "/user/{telegramId}/avatar", tag: "users", summary: "Загрузить аватар",
+			multipart: objSchema(map[string]*Schema{"file!": {Type: "string", Format: "binary"}})},
+		{method: "GET", path: "/user/{telegramId}/avatar", tag: "users", summary: "Аватар",
+			params: []apiParam{query("size", enumSchema("64", "128", "512"))}},
+		{method: "POST", path: "/user/{telegramId}/avatar/telegram", tag: "users", summary: "Импортировать фото профиля из Telegram"},
+
+		// Бригадиры
+		{method: "POST", path: "/contractor/profile", tag: "contractors", summary: "Сохранить профиль бригадира",
+			body: withTelegramID(map[string]*Schema{
+				"experience_years": intSchema().min(0).max(80),
+				"categories":       categories,
+				"is_active":        boolSchema(),
+			})},
+		{method: "GET", path: "/contractor/earnings", tag: "contractors", summary: "Начисления бригадира",
+			params: []apiParam{telegramIDQuery(), query("from", dateSchema()), query("to", dateSchema())}},
+		{method: "POST", path: "/contractor/payout-details", tag: "contractors", summary: "Реквизиты для выплат",
+			body: withTelegramID(map[string]*Schema{
+				"recipient_name!": strSchema().minLen(1).maxLen(200),
+				"inn!":            strSchema().match(`^(\d{10}|\d{12})$`),
+				"account!":        strSchema().match(`^\d{20}$`),
+				"bik!":            strSchema().match(`^\d{9}$`),
+			})},
+		{method: "GET", path: "/contractor/verification", tag: "contractors", summary: "Статус проверки и документы",
+			params: []apiParam{telegramIDQuery()}},
+		{method: "POST", path: "/contractor/verification/submit", tag: "contractors", summary: "Отправить документы на проверку",
+			body: actor()},
+		{method: "POST", path: "/contractor/documents", tag: "contractors", summary: "Загрузить документ для проверки",
+			multipart: objSchema(map[string]*Schema{
+				"telegram_id": intSchema().desc(telegramIDNote),
+				"kind!":       enumSchema(mapKeys(kycDocumentKinds)...),
+				"file!":       {Type: "string", Format: "binary"},
+			})},
+		{method: "GET", path: "/contractors/search", tag: "contractors", summary: "Подбор бригад",
+			params: []apiParam{
+				query("category", categorySchema()),
+				query("region", strSchema()),
+				query("limit", intSchema().min(1).max(contractorMaxPageSize)),
+				query("offset", intSchema().min(0)),
+			}},
+		{method: "GET", path: "/contractor/orders/{telegramId}", tag: "contractors", summary: "Заказы бригадира",
+			params: orderListQueryParams(), response: orderPage, successor: "GET /v2/orders"},
+		{method: "GET", path: "/contractor/pending-orders/{telegramId}", tag: "contractors", summary: "Новые заказы для бригадира",
+			params: orderListQueryParams(), response: orderPage, successor: "GET /v2/orders?scope=available"},
+
+		// Промокоды
+		{method: "POST", path: "/promo/validate", tag: "promo", summary: "Проверить промокод",
+			body: objSchema(map[string]*Schema{
+				"telegram_id": intSchema(),
+				"code!":       strSchema().minLen(1),
+				"category!":   categorySchema(),
+				"area":        numSchema().gt(0).max(maxOrderArea),
+				"region":      strSchema(),
+				"addons":      arrSchema(addonSchema()),
+			})},
+
+		// Заказы
+		{method: "POST", path: "/orders", tag: "orders", summary: "Создать заказ",
+			body: newOrder, response: orderResponse, successor: "POST /v2/orders"},
+		{method: "POST", path: "/orders/{orderId}/accept", tag: "orders", summary: "Бригадир принимает заказ", body: actor(),
+			successor: "PATCH /v2/orders/{orderId}"},
+		{method: "POST", path: "/orders/{orderId}/complete", tag: "orders", summary: "Бригадир завершает работы", body: actor(),
+			successor: "PATCH /v2/orders/{orderId}"},
+		{method: "POST", path: "/orders/{orderId}/reject", tag: "orders", summary: "Отменить заказ",
+			body: objSchema(map[string]*Schema{
+				"telegram_id": intSchema(),
+				"reason":      strSchema().maxLen(500).desc("no_show - бригадир не вышел на объект"),
+			}),
+			successor: "PATCH /v2/orders/{orderId}"},
+		{method: "POST", path: "/orders/{orderId}/confirm", tag: "orders", summary: "Клиент подтверждает выполнение", body: actor()},
+		{method: "POST", path: "/orders/{orderId}/attachments", tag: "orders", summary: "Загрузить вложение",
+			multipart: objSchema(map[string]*Schema{
+				"telegram_id": intSchema().desc(telegramIDNote),
+				"kind":        enumSchema(mapKeys(attachmentKinds)...),
+				"file!":       {Type: "string", Format: "binary"},
+			})},
+		{method: "GET", path: "/orders/{orderId}/attachments", tag: "orders", summary: "Вложения заказа",
+			params: []apiParam{telegramIDQuery()}},
+
+		// Чат
+		{method: "GET", path: "/orders/{orderId}/messages", tag: "chat", summary: "Сообщения чата",
+			params: []apiParam{telegramIDQuery(), query("after_id", intSchema().min(0))}},
+		{method: "POST", path: "/orders/{orderId}/messages", tag: "chat", summary: "Отправить сообщение (JSON или multipart с file)",
+			body: withTelegramID(map[string]*Schema{"text!": strSchema().minLen(1).maxLen(maxMessageLength)}),
+			multipart: objSchema(map[string]*Schema{
+				"telegram_id": intSchema().desc(telegramIDNote),
+				"text":        strSchema().maxLen(maxMessageLength),
+				"file":        {Type: "string", Format: "binary"},
+			})},
+		{method: "POST", path: "/orders/{orderId}/messages/read", tag: "chat", summary: "Отметить сообщения прочитанными",
+			body: withTelegramID(map[string]*Schema{"up_to_id": intSchema().min(0)})},
+		{method: "GET", path: "/orders/{orderId}/messages/stream", tag: "chat", summary: "Поток новых сообщений (SSE)",
+			params: []apiParam{
+				telegramIDQuery(),
+				query("after_id", intSchema().min(0)),
+				query("init_data", strSchema().desc("initData Telegram WebApp: EventSource не передает заголовки")),
+			}},
+
+		// Торги
+		{method: "GET", path: "/orders/{orderId}/bids", tag: "bids", summary: "Предложения по заказу",
+			params: []apiParam{telegramIDQuery()}},
+		{method: "POST", path: "/orders/{orderId}/bids", tag: "bids", summary: "Сделать предложение",
+			body: withTelegramID(map[string]*Schema{
+				"price!":      intSchema().gt(0),
+				"start_date!": dateSchema(),
+				"comment":     strSchema().maxLen(1000),
+			})},
+		{method: "POST", path: "/orders/{orderId}/bids/{bidId}/select", tag: "bids", summary: "Выбрать предложение", body: actor()},
+
+		// Документы
+		{method: "GET", path: "/orders/{orderId}/documents", tag: "documents", summary: "Документы заказа",
+			params: []apiParam{telegramIDQuery()}},
+		{method: "POST", path: "/orders/{orderId}/documents", tag: "documents", summary: "Сформировать договор или акт",
+			body: withTelegramID(map[string]*Schema{"kind!": enumSchema(mapKeys(documentTitles)...)})},
+		{method: "POST", path: "/orders/{orderId}/documents/{documentId}/sign", tag: "documents", summary: "Подписать документ (подпись акта клиентом выплачивает предоплату)", body: actor()},
+
+		// Платежи
+		{method: "POST", path: "/orders/{orderId}/payments", tag: "payments", summary: "Создать платеж на неоплаченный остаток заказа", body: actor()},
+		{method: "GET", path: "/orders/{orderId}/payments", tag: "payments", summary: "Платежи заказа",
+			params: []apiParam{telegramIDQuery()}},
+		{method: "GET", path: "/orders/{orderId}/escrow", tag: "payments", summary: "Предоплата по заказу",
+			params: []apiParam{telegramIDQuery()}},
+		{method: "POST", path: "/orders/{orderId}/escrow/pay", tag: "payments", summary: "Внести предоплату", body: actor()},
+		{method: "GET", path: "/orders/{orderId}/receipts", tag: "payments", summary: "Чеки заказа",
+			params: []apiParam{telegramIDQuery()}},
+		{method: "POST", path: "/receipts/{receiptId}/resend", tag: "payments", summary: "Отправить чек повторно", body: actor()},
+		{method: "POST", path: "/payments/callback/{provider}", tag: "payments", summary: "Уведомление платежного провайдера"},
+		{method: "GET", path: "/payments/sandbox/{providerPaymentId}", tag: "payments", summary: "Страница оплаты песочницы"},
+		{method: "POST", path: "/payments/sandbox/{providerPaymentId}", tag: "payments", summary: "Исход оплаты в песочнице",
+			params: []apiParam{query("outcome", enumSchema("success", "failure", "delayed"))},
+			body:   objSchema(map[string]*Schema{"outcome": enumSchema("success", "failure", "delayed")})},
+
+		{method: "GET", path: "/cron/escrow", tag: "system", summary: "Автоподтверждение предоплат и повтор возвратов (Vercel Cron)"},
+		{method: "GET", path: "/cron/verification", tag: "system", summary: "Напоминания о проверке бригадирам, работавшим до ее появления (Vercel Cron)"},
+		{method: "GET", path: "/cron/payments", tag: "system", summary: "Доставка отложенных уведомлений песочницы платежей (Vercel Cron)"},
+		{method: "GET", path: "/files/{store}/{key:.+}", tag: "system", summary: "Файл по подписанной ссылке",
+			params: []apiParam{query("expires", intSchema()), query("sig", strSchema())}},
+		{method: "POST", path: "/calculator/materials", tag: "catalog", summary: "Расчет материалов",
+			body: objSchema(map[string]*Schema{
+				"category!":    categorySchema(),
+				"area!":        numSchema().gt(0).max(maxOrderArea),
+				"thickness_mm": numSchema().min(0).desc("0 - толщина по умолчанию для тарифа"),
+				"insulation":   boolSchema(),
+			})},
+		{method: "GET", path: "/openapi.json", tag: "system", summary: "Эта спецификация"},
+
+		// API v2
+		{method: "GET", path: "/v2/me", tag: "v2", summary: "Текущий пользователь и профиль бригадира"},
+		{method: "PATCH", path: "/v2/me", tag: "v2", summary: "Изменить профиль и активную роль",
+			body: objSchema(map[string]*Schema{
+				"role":          roleSchema(),
+				"name":          strSchema().maxLen(200),
+				"phone":         strSchema().maxLen(32),
+				"region":        strSchema(),
+				"language_code": strSchema().maxLen(35),
+			}),
+			response: objSchema(map[string]*Schema{"user": refSchema("User")})},
+		{method: "GET", path: "/v2/orders", tag: "v2", summary: "Заказы пользователя или открытые заказы для бригадира",
+			params: append([]apiParam{
+				query("scope", enumSchema("mine", "available").desc("mine - заказы в активной роли, available - открытые заказы региона бригадира")),
+			}, orderListQueryParams()...),
+			response: orderPage},
+		{method: "POST", path: "/v2/orders", tag: "v2", summary: "Создать заказ", body: newOrder, response: orderResponse},
+		{method: "GET", path: "/v2/orders/{orderId}", tag: "v2", summary: "Заказ", response: orderResponse},
+		{method: "PATCH", path: "/v2/orders/{orderId}", tag: "v2", summary: "Сменить статус заказа",
+			body: objSchema(map[string]*Schema{
+				"status!": enumSchema(orderTransitions...).desc("accepted - принять (бригадир), completed - завершить (бригадир), cancelled - отменить (сторона заказа)"),
+				"reason":  strSchema().maxLen(500).desc("Причина отмены; no_show - бригадир не вышел на объект"),
+			}),
+			response: orderResponse},
+		// Админ-API
+		{method: "GET", path: "/admin/users", tag: "admin", admin: true, summary: "Пользователи",
+			params: append(pageQuery(), query("q", strSchema()), query("role", roleSchema()), query("region", strSchema()),
+				query("status", enumSchema(UserActive, UserSuspended, UserBanned)))},
+		{method: "GET", path: "/admin/users/{userId}", tag: "admin", admin: true, summary: "Пользователь"},
+		{method: "POST", path: "/admin/users/{userId}", tag: "admin", admin: true, summary: "Изменить пользователя",
+			body: objSchema(map[string]*Schema{
+				"name":   strSchema().maxLen(200),
+				"phone":  strSchema().maxLen(32),
+				"role":   roleSchema(),
+				"region": strSchema(),
+				"reason": strSchema(),
+				"contractor": objSchema(map[string]*Schema{
+					"experience_years": intSchema().min(0).max(80),
+					"categories":       categories,
+					"is_active":        boolSchema(),
+					"rating":           numSchema().min(0).max(5),
+				}),
+			})},
+		{method: "POST", path: "/admin/users/{userId}/status", tag: "admin", admin: true, summary: "Приостановить, заблокировать или разблокировать",
+			body: objSchema(map[string]*Schema{
+				"status!": enumSchema(UserActive, UserSuspended, UserBanned),
+				"reason":  strSchema(),
+				"days":    intSchema().min(0),
+			})},
+		{method: "GET", path: "/admin/flags", tag: "admin", admin: true, summary: "Очередь флагов антифрода",
+			params: append(pageQuery(), query("status", enumSchema("open", "dismissed", "actioned")),
+				query("rule", enumSchema(FraudCancellations, FraudNoShow, FraudDuplicatePhone)), query("user_id", intSchema()))},
+		{method: "POST", path: "/admin/flags/{flagId}/resolve", tag: "admin", admin: true, summary: "Разобрать флаг",
+			body: objSchema(map[string]*Schema{
+				"action!": enumSchema("dismiss", "suspend", "ban"),
+				"comment": strSchema(),
+				"days":    intSchema().min(0),
+			})},
+		{method: "GET", path: "/admin/fraud-rules", tag: "admin", admin: true, summary: "Правила антифрода"},
+		{method: "POST", path: "/admin/fraud-rules", tag: "admin", admin: true, summary: "Изменить правило антифрода",
+			body: objSchema(map[string]*Schema{
+				"rule!":       enumSchema(FraudCancellations, FraudNoShow, FraudDuplicatePhone),
+				"threshold!":  intSchema().min(1),
+				"window_days": intSchema().min(0),
+				"enabled":     boolSchema(),
+			})},
+		{method: "GET", path: "/admin/orders", tag: "admin", admin: true, summary: "Заказы",
+			params: append(pageQuery(), query("status", enumSchema(orderStatuses...)),
+				query("category", categorySchema()), query("region", strSchema()), query("mode", enumSchema(OrderModeInstant, OrderModeAuction)),
+				query("client_id", intSchema()), query("contractor_id", intSchema()), query("from", dateSchema()), query("to", dateSchema()), query("q", strSchema()))},
+		{method: "GET", path: "/admin/orders/{orderId}", tag: "admin", admin: true, summary: "Заказ"},
+		{method: "POST", path: "/admin/orders/{orderId}/status", tag: "admin", admin: true, summary: "Принудительно сменить статус заказа",
+			body: objSchema(map[string]*Schema{
+				"status!": enumSchema(orderStatuses...),
+				"reason!": strSchema().minLen(1),
+			})},
+		{method: "GET", path: "/admin/contractors", tag: "admin", admin: true, summary: "Бригадиры",
+			params: append(pageQuery(), query("category", categorySchema()), query("region", strSchema()), query("min_rating", numSchema().min(0).max(5)),
+				query("verification", enumSchema(VerificationDraft, VerificationSubmitted, VerificationVerified, VerificationRejected, VerificationSuspended)),
+				query("active", enumSchema("true", "false")), query("status", enumSchema(UserActive, UserSuspended, UserBanned)), query("q", strSchema()))},
+		{method: "GET", path: "/admin/contractors/{userId}/verification", tag: "admin", admin: true, summary: "Проверка бригадира"},
+		{method: "POST", path: "/admin/contractors/{userId}/verification", tag: "admin", admin: true, summary: "Решение по проверке",
+			body: objSchema(map[string]*Schema{
+				"action!": enumSchema("approve", "reject", "suspend"),
+				"comment": strSchema(),
+			})},
+		{method: "GET", path: "/admin/verifications", tag: "admin", admin: true, summary: "Очередь проверки",
+			params: append(pageQuery(), query("status", enumSchema(VerificationDraft, VerificationSubmitted, VerificationVerified, VerificationRejected, VerificationSuspended)))},
+		{method: "GET", path: "/admin/api-keys", tag: "admin", admin: true, summary: "API-ключи"},
+		{method: "POST", path: "/admin/api-keys", tag: "admin", admin: true, summary: "Выпустить API-ключ",
+			body: objSchema(map[string]*Schema{"name!": strSchema().minLen(1).maxLen(100)})},
+		{method: "POST", path: "/admin/api-keys/{keyId}/revoke", tag: "admin", admin: true, summary: "Отозвать API-ключ"},
+		{method: "POST", path: "/admin/migrate", tag: "admin", admin: true, summary: "Заполнить тестовых бригадиров"},
+		{method: "POST", path: "/admin/promo", tag: "admin", admin: true, summary: "Создать или изменить промокод",
+			body: objSchema(map[string]*Schema{
+				"code!":             strSchema().minLen(1).maxLen(64),
+				"description":       strSchema(),
+				"percent":           percent,
+				"amount":            intSchema().gt(0),
+				"categories":        categories,
+				"valid_from":        dateSchema(),
+				"valid_to":          dateSchema(),
+				"max_uses":          intSchema().min(0),
+				"max_uses_per_user": intSchema().min(0),
+				"first_order_only":  boolSchema(),
+				"is_active":         boolSchema(),
+			})},
+		{method: "GET", path: "/admin/promo", tag: "admin", admin: true, summary: "Отчет по промокодам"},
+		{method: "POST", path: "/admin/regions", tag: "admin", admin: true, summary: "Создать или изменить регион",
+			body: objSchema(map[string]*Schema{
+				"code!":      strSchema().match(`^[a-z0-9-]{2,32}$`),
+				"name!":      strSchema().minLen(1).maxLen(100),
+				"multiplier": numSchema().gt(0).max(10),
+				"prices":     &Schema{Type: "object", AdditionalProperties: refSchema("PriceRange")},
+				"is_active":  boolSchema(),
+			})},
+		{method: "GET", path: "/admin/commission/rules", tag: "admin", admin: true, summary: "Правила комиссии"},
+		{method: "POST", path: "/admin/commission/rules", tag: "admin", admin: true, summary: "Задать правило комиссии",
+			body: objSchema(map[string]*Schema{
+				"contractor_id": intSchema(),
+				"category":      categorySchema(),
+				"percent!":      percent,
+			})},
+		{method: "POST", path: "/admin/payouts/batches", tag: "admin", admin: true, summary: "Собрать реестр выплат"},
+		{method: "GET", path: "/admin/payouts/batches/{batchId}/export", tag: "admin", admin: true, summary: "Выгрузка реестра",
+			params: []apiParam{query("format", enumSchema("csv", "1c"))}},
+		{method: "POST", path: "/admin/payouts/batches/{batchId}/status", tag: "admin", admin: true, summary: "Отметить реестр оплаченным или неуспешным",
+			body: objSchema(map[string]*Schema{"status!": enumSchema(PayoutBatchPaid, PayoutBatchFailed)})},
+		{method: "POST", path: "/admin/payments/{paymentId}/refund", tag: "admin", admin: true, summary: "Вернуть платеж",
+			body: objSchema(map[string]*Schema{"amount": intSchema().gt(0).desc("В копейках")})},
+		{method: "GET", path: "/admin/ledger/balances", tag: "admin", admin: true, summary: "Остатки по счетам"},
+	}
+}
+
+// pathParamPattern - параметры в шаблоне mux, в том числе с регулярным выражением {key:.+}
+var pathParamPattern = regexp.MustCompile(`\{(\w+)(:[^}]*)?\}`)
+
+// pathParams - параметры пути; *Id - целые числа
+func (op *apiOperation) pathParams() []apiParam {
+	var params []apiParam
+	for _, m := range pathParamPattern.FindAllStringSubmatch(op.path, -1) {
+		schema := strSchema()
+		if strings.HasSuffix(m[1], "Id") && m[1] != "providerPaymentId" {
+			schema = intSchema()
+		}
+		params = append(params, apiParam{m[1], "path", true, schema})
+	}
+	return params
+}
+
+// componentSchemas - общие схемы ответов
+func componentSchemas() map[string]*Schema {
+	errorCodes := make([]string, 0, len(errorCatalog))
+	for code := range errorCatalog {
+		errorCodes = append(errorCodes, code)
+	}
+	sort.Strings(errorCodes)
+	return map[string]*Schema{
+		"Error": objSchema(map[string]*Schema{
+			"error!": objSchema(map[string]*Schema{
+				"code!":    enumSchema(errorCodes...),
+				"message!": strSchema(),
+				"details": &Schema{Type: "object", Description: "Для validation_failed - fields: [{field, message}]",
+					AdditionalProperties: &Schema{}},
+				"request_id": strSchema(),
+			}),
+		}),
+		"PriceRange": objSchema(map[string]*Schema{"min!": intSchema(), "max!": intSchema()}),
+		"Tariff": objSchema(map[string]*Schema{
+			"name!":        strSchema(),
+			"description!": strSchema(),
+			"priceRange!":  refSchema("PriceRange"),
+			"days!":        strSchema(),
+			"features!":    arrSchema(strSchema()),
+			"isAddon":      boolSchema(),
+			"duration":     &Schema{Type: "object"},
+		}),
+		"User": objSchema(map[string]*Schema{
+			"id!":           intSchema(),
+			"telegram_id!":  intSchema(),
+			"role!":         roleSchema(),
+			"roles!":        arrSchema(roleSchema()),
+			"name":          strSchema(),
+			"phone":         strSchema(),
+			"avatar_url":    strSchema(),
+			"region":        strSchema(),
+			"language_code": strSchema(),
+			"status!":       enumSchema(UserActive, UserSuspended, UserBanned),
+			"status_reason": strSchema(),
+			"status_until":  {Type: "string", Format: "date-time"},
+			"created_at!":   {Type: "string", Format: "date-time"},
+		}),
+		"Order": objSchema(map[string]*Schema{
+			"id!":           intSchema(),
+			"client_id!":    intSchema(),
+			"contractor_id": intSchema(),
+			"category!":     categorySchema(),
+			"area":          numSchema(),
+			"address":       strSchema(),
+			"status!":       enumSchema(orderStatuses...),
+			"mode":          enumSchema(OrderModeInstant, OrderModeAuction),
+			"price_min":     intSchema(),
+			"price_max":     intSchema(),
+			"price":         intSchema().desc("Стоимость, зафиксированная при принятии заказа, в копейках"),
+			"addons": arrSchema(objSchema(map[string]*Schema{
+				"category!": addonSchema(),
+				"prices!":   objSchema(map[string]*Schema{"min!": intSchema(), "max!": intSchema()}),
+			})),
+			"region":      strSchema(),
+			"created_at!": {Type: "string", Format: "date-time"},
+		}),
+	}
+}
+
+// openAPISpec собирает документ OpenAPI 3.1
+func openAPISpec() map[string]interface{} {
+	ops, _ := apiOperations()
+	errorResponse := map[string]interface{}{
+		"description": "Ошибка",
+		"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": refSchema("Error")}},
+	}
+	paths := map[string]map[string]interface{}{}
+	for i := range ops {
+		op := &ops[i]
+		var params []map[string]interface{}
+		for _, p := range append(op.pathParams(), op.params...) {
+			params = append(params, map[string]interface{}{"name": p.name, "in": p.in, "required": p.required, "schema": p.schema})
+		}
+		response := op.response
+		if response == nil {
+			response = &Schema{Type: "object"}
+		}
+		operation := map[string]interface{}{
+			"tags":        []string{op.tag},
+			"summary":     op.summary,
+			"operationId": strings.ToLower(op.method) + operationName(op.path),
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "OK",
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": response}},
+				},
+				"default": errorResponse,
+			},
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		content := map[string]interface{}{}
+		if op.body != nil {
+			content["application/json"] = map[string]interface{}{"schema": op.body}
+		}
+		if op.multipart != nil {
+			content["multipart/form-data"] = map[string]interface{}{"schema": op.multipart}
+		}
+		if len(content) > 0 {
+			operation["requestBody"] = map[string]interface{}{"required": op.body != nil && len(op.body.Required) > 0, "content": content}
+		}
+		if op.successor != "" {
+			operation["deprecated"] = true
+			operation["description"] = "Устарело, используйте " + op.successor
+		}
+		if op.admin {
+			operation["security"] = []map[string][]string{{"apiKey": {}}, {"telegramInitData": {}}}
+		}
+		path := "/api" + pathParamPattern.ReplaceAllString(op.path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(op.method)] = operation
+	}
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":       "Пол страны API",
+			"version":     "2.0.0",
+			"description": "Ресурсный API - /api/v2; маршруты v1 с заменой помечены deprecated. Ошибки возвращаются в формате Error, коды перечислены в API_EXAMPLES.md. Пользователь определяется по подписанным initData Telegram WebApp в заголовке X-Telegram-Init-Data. Активная роль сессии (client или contractor) передается в заголовке X-Active-Role, без него действует роль по умолчанию. Изменяющие запросы принимают заголовок Idempotency-Key, язык ответа выбирается по ?lang= и Accept-Language.",
+		},
+		// Публичные маршруты (тарифы, регионы, подбор бригад) работают и без подписи
+		"security": []map[string][]string{{"telegramInitData": {}}, {}},
+		"paths":    paths,
+		"components": map[string]interface{}{
+			"schemas": componentSchemas(),
+			"securitySchemes": map[string]interface{}{
+				"apiKey":           map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"telegramInitData": map[string]interface{}{"type": "apiKey", "in": "header", "name": initDataHeader},
+			},
+		},
+	}
+}
+
+// operationName - "/orders/{orderId}/bids" -> "OrdersOrderIdBids"
+func operationName(path string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(pathParamPattern.ReplaceAllString(path, "$1"), func(r rune) bool {
+		return r == '/' || r == '-' || r == '.' || r == '{' || r == '}'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+// handleOpenAPI отдает спецификацию; документ строится один раз на инстанс
+func (app *App) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIJSON, _ = json.MarshalIndent(openAPISpec(), "", "  ")
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+}
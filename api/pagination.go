@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Списки заказов листаются курсором по (created_at, id): в ответе приходит
+// next_cursor, его передают в ?cursor= за следующей страницей. В отличие от
+// offset страницы не съезжают, когда во время просмотра появляются новые заказы.
+// Админ-API по-прежнему листается limit и offset.
+
+const (
+	orderDefaultPageSize = 50
+	orderMaxPageSize     = 200
+
+	contractorDefaultPageSize = 10
+	contractorMaxPageSize     = 50
+)
+
+// Сортировки списков заказов
+const (
+	OrderSortNewest = "newest"
+	OrderSortOldest = "oldest"
+)
+
+// orderCursor - последний заказ предыдущей страницы
+type orderCursor struct {
+	createdAt string
+	id        int64
+}
+
+// encodeOrderCursor - непрозрачная строка курсора после заказа order
+func encodeOrderCursor(order *Order) string {
+	raw := order.CreatedAt.UTC().Format("2006-01-02 15:04:05") + "|" + strconv.FormatInt(order.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeOrderCursor(s string) (*orderCursor, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, false
+	}
+	createdAt, idPart, ok := strings.Cut(string(data), "|")
+	if !ok {
+		return nil, false
+	}
+	if _, err := time.Parse("2006-01-02 15:04:05", createdAt); err != nil {
+		return nil, false
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil || id <= 0 {
+		return nil, false
+	}
+	return &orderCursor{createdAt: createdAt, id: id}, true
+}
+
+// queryLimit - размер страницы из ?limit= в пределах max
+func queryLimit(r *http.Request, def, max int) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return def
+	}
+	return min(limit, max)
+}
+
+// queryFloat - необязательный числовой параметр строки запроса
+func queryFloat(r *http.Request, name string) *float64 {
+	value, err := strconv.ParseFloat(r.URL.Query().Get(name), 64)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+// orderListParams разбирает фильтры (status, category, from, to, area_min,
+// area_max), сортировку и страницу списка заказов. Форматы уже проверил
+// validateRequest; неверный курсор отвечает 400 и возвращает false.
+func orderListParams(w http.ResponseWriter, r *http.Request) (orderListQuery, bool) {
+	query := r.URL.Query()
+	q := orderListQuery{
+		Status:   query.Get("status"),
+		Category: query.Get("category"),
+		From:     query.Get("from"),
+		To:       query.Get("to"),
+		AreaMin:  queryFloat(r, "area_min"),
+		AreaMax:  queryFloat(r, "area_max"),
+		Sort:     query.Get("sort"),
+		Limit:    queryLimit(r, orderDefaultPageSize, orderMaxPageSize),
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		var ok bool
+		if q.Cursor, ok = decodeOrderCursor(cursor); !ok {
+			validationError(w, []FieldError{{Field: "cursor", Message: tr(responseLocale(w), "Неверный курсор")}})
+			return q, false
+		}
+	}
+	return q, true
+}
+
+// orderListQueryParams - параметры списков заказов для apiOperations
+func orderListQueryParams() []apiParam {
+	return []apiParam{
+		query("status", enumSchema(orderStatuses...)),
+		query("category", categorySchema()),
+		query("from", dateSchema().desc("Создан не раньше даты")),
+		query("to", dateSchema().desc("Создан не позже даты")),
+		query("area_min", numSchema().min(0)),
+		query("area_max", numSchema().min(0)),
+		query("sort", enumSchema(OrderSortNewest, OrderSortOldest).desc("По дате создания, по умолчанию newest")),
+		query("cursor", strSchema().desc("next_cursor предыдущей страницы")),
+		query("limit", intSchema().min(1).max(orderMaxPageSize)),
+	}
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// listOrderIDs листает GET /api/v2/orders страницами по limit и собирает id
+func listOrderIDs(t *testing.T, telegramID, params string) []int64 {
+	t.Helper()
+	var ids []int64
+	cursor := ""
+	for page := 0; ; page++ {
+		if page > 20 {
+			t.Fatalf("%s: курсор не кончается", params)
+		}
+		target := "/api/v2/orders?telegram_id=" + telegramID + "&" + params
+		if cursor != "" {
+			target += "&cursor=" + url.QueryEscape(cursor)
+		}
+		var resp struct {
+			Orders     []Order `json:"orders"`
+			NextCursor *string `json:"next_cursor"`
+		}
+		if code := doJSON(t, "GET", target, nil, &resp); code != http.StatusOK {
+			t.Fatalf("%s: статус %d", target, code)
+		}
+		for _, order := range resp.Orders {
+			ids = append(ids, order.ID)
+		}
+		if resp.NextCursor == nil {
+			return ids
+		}
+		cursor = *resp.NextCursor
+	}
+}
+
+func TestOrderListCursor(t *testing.T) {
+	a := newTestApp(t)
+	clientID := testUser(t, a, 111, "client")
+	otherID := testUser(t, a, 222, "client")
+
+	// Пять заказов из семи созданы в одну секунду: курсор по одному created_at
+	// пропустил бы или повторил их на границе страниц
+	var ids []int64
+	for i := range 7 {
+		order := testOrder(t, a, clientID, 20)
+		createdAt, category, status, area := "2026-01-10 12:00:00", "econom", "pending", 20.0
+		switch i {
+		case 0:
+			createdAt, area = "2026-01-09 10:00:00", 10
+		case 6:
+			createdAt, category, status, area = "2026-01-11 09:00:00", "comfort", "completed", 40
+		}
+		if _, err := a.db.Exec(`UPDATE orders SET created_at = ?, category = ?, status = ?, area = ? WHERE id = ?`, createdAt, category, status, area, order.ID); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, order.ID)
+	}
+	testOrder(t, a, otherID, 20)
+	newest := slices.Clone(ids)
+	slices.Reverse(newest)
+
+	for _, tc := range []struct {
+		params string
+		want   []int64
+	}{
+		{"limit=2", newest},
+		{"limit=3&sort=newest", newest},
+		{"limit=2&sort=oldest", ids},
+		{"sort=newest", newest},
+		{"limit=2&status=completed", ids[6:]},
+		{"limit=2&category=econom&sort=oldest", ids[:6]},
+		{"limit=2&from=2026-01-10&to=2026-01-10", newest[1:6]},
+		{"limit=2&from=2026-01-10&sort=oldest", ids[1:]},
+		{"limit=2&to=2026-01-10", newest[1:]},
+		{"limit=2&area_min=15&area_max=30", newest[1:6]},
+		{"limit=2&status=cancelled", nil},
+	} {
+		if got := listOrderIDs(t, "111", tc.params); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: заказы %v, want %v", tc.params, got, tc.want)
+		}
+	}
+
+	if code := doJSON(t, "GET", "/api/v2/orders?telegram_id=111&cursor=not-a-cursor", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("неверный курсор: статус %d, want 400", code)
+	}
+	if code := doJSON(t, "GET", "/api/v2/orders?telegram_id=111&limit=500", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("страница больше %d: статус %d, want 400", orderMaxPageSize, code)
+	}
+	if code := doJSON(t, "GET", "/api/v2/orders?telegram_id=111&sort=random", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("неизвестная сортировка: статус %d, want 400", code)
+	}
+}
@@ -0,0 +1,564 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Статусы платежа
+const (
+	PaymentPending           = "pending"
+	PaymentWaitingForCapture = "waiting_for_capture"
+	PaymentSucceeded         = "succeeded"
+	PaymentCanceled          = "canceled"
+	PaymentRefunded          = "refunded"
+)
+
+// Разрешенные переходы статусов. Повторные и запоздавшие уведомления
+// не откатывают платеж назад.
+var paymentTransitions = map[string][]string{
+	PaymentPending:           {PaymentWaitingForCapture, PaymentSucceeded, PaymentCanceled},
+	PaymentWaitingForCapture: {PaymentSucceeded, PaymentCanceled},
+	PaymentSucceeded:         {PaymentRefunded},
+}
+
+// Ошибки платежей, о которых сообщается клиенту. Ответ провайдера
+// заворачивается в errPaymentProvider и в ответ API не попадает.
+var (
+	errPaymentProvider      = errors.New("ошибка платежного провайдера")
+	errPaymentNotRefundable = errors.New("платеж нельзя вернуть в текущем статусе")
+	errRefundAmount         = errors.New("сумма возврата больше остатка платежа")
+	errWebhookSignature     = errors.New("неверная подпись уведомления")
+	errWebhookMalformed     = errors.New("уведомление не разобрано")
+	errUnknownPaymentEvent  = errors.New("уведомление не относится к известному платежу")
+	errPrepaymentPartial    = errors.New("удержанную предоплату можно вернуть только целиком")
+	errPrepaymentReleased   = errors.New("предоплата уже выплачена бригадиру")
+)
+
+// PaymentProvider - платежный провайдер (эквайринг)
+type PaymentProvider interface {
+	Name() string
+	// CreatePayment создает платеж. capture=false - двухстадийный платеж: деньги
+	// замораживаются на карте до Capture.
+	CreatePayment(ctx context.Context, req PaymentRequest) (*ProviderPayment, error)
+	Capture(ctx context.Context, providerPaymentID string, amount int64) (*ProviderPayment, error)
+	Refund(ctx context.Context, providerPaymentID string, amount int64) (*ProviderRefund, error)
+	// VerifyWebhook проверяет подпись уведомления и разбирает его
+	VerifyWebhook(r *http.Request, body []byte) (*PaymentEvent, error)
+}
+
+type PaymentRequest struct {
+	PaymentID   int64
+	OrderID     int64
+	Amount      int64 // копейки
+	Description string
+	Capture     bool
+}
+
+type ProviderPayment struct {
+	ID              string
+	Status          string
+	ConfirmationURL string
+}
+
+type ProviderRefund struct {
+	ID     string
+	Status string
+}
+
+// PaymentEvent - уведомление провайдера о смене статуса
+type PaymentEvent struct {
+	EventID   string `json:"event_id"`
+	Type      string `json:"type"` // payment.waiting_for_capture, payment.succeeded, payment.canceled, refund.succeeded
+	PaymentID string `json:"payment_id"`
+	RefundID  string `json:"refund_id,omitempty"`
+	Amount    int64  `json:"amount"`
+}
+
+type Payment struct {
+	ID                int64     `json:"id"`
+	OrderID           int64     `json:"order_id"`
+	Provider          string    `json:"provider"`
+	ProviderPaymentID *string   `json:"provider_payment_id"`
+	Purpose           string    `json:"purpose"`
+	Amount            int64     `json:"amount"`
+	RefundedAmount    int64     `json:"refunded_amount"`
+	Currency          string    `json:"currency"`
+	Status            string    `json:"status"`
+	Capture           bool      `json:"capture"`
+	ConfirmationURL   *string   `json:"confirmation_url"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// Песочница платежей: деньги в ней ненастоящие, поэтому оплаченные через нее
+// заказы не попадают в начисления и выплаты бригадирам
+const sandboxProviderName = "sandbox"
+
+// newPaymentProviderFromEnv выбирает провайдера по PAYMENT_PROVIDER. Песочница
+// включается только явно (PAYMENT_PROVIDER=sandbox): пустое или неизвестное
+// значение - ошибка запуска, а не тихий переход на ненастоящие деньги.
+func newPaymentProviderFromEnv(app *App) (PaymentProvider, error) {
+	switch provider := os.Getenv("PAYMENT_PROVIDER"); provider {
+	case sandboxProviderName:
+		return newSandboxProvider(app), nil
+	case "":
+		return nil, fmt.Errorf("PAYMENT_PROVIDER не задан (для разработки - sandbox)")
+	default:
+		return nil, fmt.Errorf("неизвестный PAYMENT_PROVIDER=%s", provider)
+	}
+}
+
+const paymentColumns = `id, order_id, provider, provider_payment_id, purpose, amount, refunded_amount, currency, status, capture, confirmation_url, created_at, updated_at`
+
+func scanPayment(row interface{ Scan(...interface{}) error }) (*Payment, error) {
+	var p Payment
+	var createdAt, updatedAt sql.NullString
+	err := row.Scan(&p.ID, &p.OrderID, &p.Provider, &p.ProviderPaymentID, &p.Purpose, &p.Amount, &p.RefundedAmount, &p.Currency, &p.Status, &p.Capture, &p.ConfirmationURL, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if createdAt.Valid && createdAt.String != "" {
+		p.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt.String)
+	}
+	if updatedAt.Valid && updatedAt.String != "" {
+		p.UpdatedAt, _ = time.Parse("2006-01-02 15:04:05", updatedAt.String)
+	}
+	return &p, nil
+}
+
+func (app *App) getPayment(paymentID int64) (*Payment, error) {
+	p, err := scanPayment(app.db.QueryRow("SELECT "+paymentColumns+" FROM payments WHERE id = ?", paymentID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+func (app *App) getPaymentByProviderID(provider, providerPaymentID string) (*Payment, error) {
+	p, err := scanPayment(app.db.QueryRow("SELECT "+paymentColumns+" FROM payments WHERE provider = ? AND provider_payment_id = ?", provider, providerPaymentID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+func (app *App) getOrderPayments(orderID int64) ([]Payment, error) {
+	rows, err := app.db.Query("SELECT "+paymentColumns+" FROM payments WHERE order_id = ? ORDER BY id", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	payments := []Payment{}
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *p)
+	}
+	return payments, nil
+}
+
+// createPayment заводит платеж в БД и регистрирует его у провайдера
+func (app *App) createPayment(ctx context.Context, order *Order, purpose string, amount int64, capture bool) (*Payment, error) {
+	result, err := app.db.Exec(`INSERT INTO payments (order_id, provider, purpose, amount, capture) VALUES (?, ?, ?, ?, ?)`, order.ID, app.payments.Name(), purpose, amount, capture)
+	if err != nil {
+		return nil, err
+	}
+	paymentID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	providerPayment, err := app.payments.CreatePayment(ctx, PaymentRequest{
+		PaymentID:   paymentID,
+		OrderID:     order.ID,
+		Amount:      amount,
+		Description: fmt.Sprintf("Заказ №%d: %s", order.ID, TARIFFS[order.Category].Name),
+		Capture:     capture,
+	})
+	if err != nil {
+		app.db.Exec(`UPDATE payments SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, PaymentCanceled, paymentID)
+		return nil, fmt.Errorf("%w: %v", errPaymentProvider, err)
+	}
+	if _, err := app.db.Exec(`UPDATE payments SET provider_payment_id = ?, confirmation_url = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, providerPayment.ID, providerPayment.ConfirmationURL, paymentID); err != nil {
+		return nil, err
+	}
+	if _, err := app.setPaymentStatus(paymentID, providerPayment.Status); err != nil {
+		return nil, err
+	}
+	return app.getPayment(paymentID)
+}
+
+// setPaymentStatus меняет статус, если переход разрешен. Возвращает true, если статус изменился.
+func (app *App) setPaymentStatus(paymentID int64, status string) (bool, error) {
+	allowedFrom := []interface{}{}
+	for from, targets := range paymentTransitions {
+		for _, to := range targets {
+			if to == status {
+				allowedFrom = append(allowedFrom, from)
+			}
+		}
+	}
+	if len(allowedFrom) == 0 {
+		return false, nil
+	}
+	placeholders := "?"
+	for i := 1; i < len(allowedFrom); i++ {
+		placeholders += ", ?"
+	}
+	args := append([]interface{}{status, paymentID}, allowedFrom...)
+	result, err := app.db.Exec(fmt.Sprintf(`UPDATE payments SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status IN (%s)`, placeholders), args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// recordRefund сохраняет возврат (повторный с тем же ID игнорируется) и пересчитывает сумму возвратов
+func (app *App) recordRefund(ctx context.Context, payment *Payment, providerRefundID string, amount int64) error {
+	result, err := app.db.Exec(`INSERT OR IGNORE INTO payment_refunds (payment_id, provider_refund_id, amount) VALUES (?, ?, ?)`, payment.ID, providerRefundID, amount)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		if err := app.issueRefundReceipt(ctx, payment, providerRefundID, amount); err != nil {
+			log.Printf("Не удалось выпустить чек возврата по платежу %d: %v", payment.ID, err)
+		}
+	}
+	if _, err := app.db.Exec(`UPDATE payments SET refunded_amount = (SELECT COALESCE(SUM(amount), 0) FROM payment_refunds WHERE payment_id = ?), updated_at = CURRENT_TIMESTAMP WHERE id = ?`, payment.ID, payment.ID); err != nil {
+		return err
+	}
+	var refunded int64
+	if err := app.db.QueryRow(`SELECT refunded_amount FROM payments WHERE id = ?`, payment.ID).Scan(&refunded); err != nil {
+		return err
+	}
+	if refunded >= payment.Amount {
+		_, err := app.setPaymentStatus(payment.ID, PaymentRefunded)
+		return err
+	}
+	return nil
+}
+
+func (app *App) capturePayment(ctx context.Context, payment *Payment) error {
+	if payment.Status != PaymentWaitingForCapture || payment.ProviderPaymentID == nil {
+		return fmt.Errorf("платеж %d нельзя подтвердить в статусе %s", payment.ID, payment.Status)
+	}
+	result, err := app.payments.Capture(ctx, *payment.ProviderPaymentID, payment.Amount)
+	if err != nil {
+		return err
+	}
+	_, err = app.setPaymentStatus(payment.ID, result.Status)
+	return err
+}
+
+// refundPayment возвращает деньги: замороженные - отменой, списанные - возвратом
+func (app *App) refundPayment(ctx context.Context, payment *Payment, amount int64) error {
+	if payment.ProviderPaymentID == nil {
+		return fmt.Errorf("%w: платеж %d не зарегистрирован у провайдера", errPaymentNotRefundable, payment.ID)
+	}
+	switch payment.Status {
+	case PaymentWaitingForCapture, PaymentSucceeded:
+	default:
+		return fmt.Errorf("%w: платеж %d в статусе %s", errPaymentNotRefundable, payment.ID, payment.Status)
+	}
+	if amount <= 0 || amount > payment.Amount-payment.RefundedAmount {
+		return fmt.Errorf("%w: %d из %d копеек", errRefundAmount, amount, payment.Amount-payment.RefundedAmount)
+	}
+	refund, err := app.payments.Refund(ctx, *payment.ProviderPaymentID, amount)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPaymentProvider, err)
+	}
+	if payment.Status == PaymentWaitingForCapture {
+		// Замороженные средства просто разблокируются
+		_, err = app.setPaymentStatus(payment.ID, PaymentCanceled)
+		return err
+	}
+	if refund.Status != PaymentSucceeded {
+		return nil
+	}
+	return app.recordRefund(ctx, payment, refund.ID, amount)
+}
+
+// adminRefundPayment - возврат по запросу администратора. Удержанная
+// предоплата возвращается целиком через refundEscrow, чтобы вместе с деньгами
+// закрылось удержание и счет escrow:{order}. Предоплату, выплаченную
+// бригадиру, вернуть нельзя.
+func (app *App) adminRefundPayment(ctx context.Context, payment *Payment, amount int64) error {
+	if payment.Purpose == "prepayment" {
+		escrow, err := app.getEscrow(payment.OrderID)
+		if err != nil {
+			return err
+		}
+		if escrow != nil && escrow.PaymentID != nil && *escrow.PaymentID == payment.ID {
+			switch escrow.Status {
+			case EscrowHeld:
+				if amount != payment.Amount-payment.RefundedAmount {
+					return fmt.Errorf("%w: платеж %d", errPrepaymentPartial, payment.ID)
+				}
+				return app.refundEscrow(ctx, payment.OrderID)
+			case EscrowReleased:
+				return fmt.Errorf("%w: платеж %d", errPrepaymentReleased, payment.ID)
+			}
+		}
+	}
+	return app.refundPayment(ctx, payment, amount)
+}
+
+// processPaymentEvent применяет уведомление провайдера. Обработка идемпотентна:
+// статус меняется только по разрешенным переходам, а реакция на событие
+// (onPaymentStatusChanged) выполняется один раз на event_id.
+func (app *App) processPaymentEvent(ctx context.Context, provider string, event *PaymentEvent) error {
+	payment, err := app.getPaymentByProviderID(provider, event.PaymentID)
+	if err != nil {
+		return err
+	}
+	if payment == nil {
+		return fmt.Errorf("%w: платеж %s не найден", errUnknownPaymentEvent, event.PaymentID)
+	}
+
+	switch event.Type {
+	case "payment.waiting_for_capture":
+		_, err = app.setPaymentStatus(payment.ID, PaymentWaitingForCapture)
+	case "payment.succeeded":
+		_, err = app.setPaymentStatus(payment.ID, PaymentSucceeded)
+	case "payment.canceled":
+		_, err = app.setPaymentStatus(payment.ID, PaymentCanceled)
+	case "refund.succeeded":
+		err = app.recordRefund(ctx, payment, event.RefundID, event.Amount)
+	default:
+		return fmt.Errorf("%w: событие %s", errUnknownPaymentEvent, event.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := app.db.Exec(`INSERT OR IGNORE INTO payment_events (provider, event_id, payment_id, type) VALUES (?, ?, ?, ?)`, provider, event.EventID, payment.ID, event.Type)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil
+	}
+	updated, err := app.getPayment(payment.ID)
+	if err != nil {
+		return err
+	}
+	app.onPaymentStatusChanged(ctx, updated)
+	return nil
+}
+
+// onPaymentStatusChanged - реакция на смену статуса платежа
+func (app *App) onPaymentStatusChanged(ctx context.Context, payment *Payment) {
+	log.Printf("Платеж %d по заказу %d: %s", payment.ID, payment.OrderID, payment.Status)
+	if payment.Status == PaymentSucceeded {
+		if err := app.issuePaymentReceipt(ctx, payment); err != nil {
+			log.Printf("Не удалось выпустить чек по платежу %d: %v", payment.ID, err)
+		}
+	}
+	if payment.Purpose == "prepayment" {
+		if err := app.onPrepaymentStatusChanged(ctx, payment); err != nil {
+			log.Printf("Не удалось обновить предоплату по заказу %d: %v", payment.OrderID, err)
+		}
+	}
+}
+
+func (app *App) handleCreatePayment(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.ParseInt(mux.Vars(r)["orderId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
+		return
+	}
+	user := callerUser(w, r)
+	if user == nil {
+		return
+	}
+	order, err := app.getOrder(orderID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if order == nil {
+		writeError(w, http.StatusNotFound, CodeOrderNotFound, "Заказ не найден")
+		return
+	}
+	if orderParty(order, user) != "client" {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Оплатить заказ может только клиент")
+		return
+	}
+	if order.Status == "cancelled" {
+		writeError(w, http.StatusConflict, CodeOrderState, "Заказ отменен")
+		return
+	}
+
+	// Сумму считает сервер: верхняя граница стоимости заказа за вычетом уже оплаченного
+	amount, err := app.orderAmountDue(order)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if amount <= 0 {
+		writeError(w, http.StatusConflict, CodeConflict, "Заказ уже оплачен")
+		return
+	}
+
+	payment, err := app.createPayment(r.Context(), order, "order", amount, true)
+	if err != nil {
+		writePaymentError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"payment": payment})
+}
+
+// orderAmountDue - сколько осталось оплатить по заказу: стоимость заказа минус внесенная предоплата и платежи, которые прошли или ждут
+// подтверждения
+func (app *App) orderAmountDue(order *Order) (int64, error) {
+	var paid int64
+	err := app.db.QueryRow(`SELECT COALESCE(SUM(amount - refunded_amount), 0) FROM payments WHERE order_id = ? AND status IN (?, ?)`,
+		order.ID, PaymentWaitingForCapture, PaymentSucceeded).Scan(&paid)
+	return orderPrice(order) - paid, err
+}
+
+func (app *App) handleGetOrderPayments(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.ParseInt(mux.Vars(r)["orderId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
+		return
+	}
+	user := callerUser(w, r)
+	if user == nil {
+		return
+	}
+	order, err := app.getOrder(orderID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if order == nil {
+		writeError(w, http.StatusNotFound, CodeOrderNotFound, "Заказ не найден")
+		return
+	}
+	if orderParty(order, user) == "" && !isAdmin(user) {
+		writeError(w, http.StatusForbidden, CodeOrderAccessDenied, "Нет доступа к заказу")
+		return
+	}
+	payments, err := app.getOrderPayments(orderID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"payments": payments})
+}
+
+// handlePaymentCallback принимает уведомления провайдера. На повтор уже
+// обработанного уведомления тоже отвечаем 200, чтобы провайдер не слал его снова.
+func (app *App) handlePaymentCallback(w http.ResponseWriter, r *http.Request) {
+	provider := mux.Vars(r)["provider"]
+	if provider != app.payments.Name() {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Неизвестный провайдер")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	event, err := app.payments.VerifyWebhook(r, body)
+	if errors.Is(err, errWebhookSignature) {
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Неверная подпись уведомления")
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] уведомление %s не разобрано: %v", w.Header().Get("X-Request-ID"), provider, err)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Уведомление не разобрано")
+		return
+	}
+	// На ошибку БД отвечаем 500, чтобы провайдер повторил уведомление
+	if err := app.processPaymentEvent(r.Context(), provider, event); errors.Is(err, errUnknownPaymentEvent) {
+		log.Printf("[%s] уведомление %s: %v", w.Header().Get("X-Request-ID"), provider, err)
+		writeError(w, http.StatusUnprocessableEntity, CodeUnprocessable, "Уведомление не относится к известному платежу")
+		return
+	} else if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// handleRefundPayment - возврат платежа администратором
+func (app *App) handleRefundPayment(w http.ResponseWriter, r *http.Request) {
+	paymentID, err := strconv.ParseInt(mux.Vars(r)["paymentId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный payment ID")
+		return
+	}
+	var req struct {
+		Amount *int64 `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	payment, err := app.getPayment(paymentID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if payment == nil {
+		writeError(w, http.StatusNotFound, CodePaymentNotFound, "Платеж не найден")
+		return
+	}
+	amount := payment.Amount - payment.RefundedAmount
+	if req.Amount != nil {
+		amount = *req.Amount
+	}
+	if err := app.adminRefundPayment(r.Context(), payment, amount); errors.Is(err, errRefundAmount) {
+		writeErrorDetails(w, http.StatusConflict, CodeConflict, "Сумма возврата должна быть от 1 копейки до остатка платежа",
+			map[string]interface{}{"max_amount": payment.Amount - payment.RefundedAmount})
+		return
+	} else if err != nil {
+		writePaymentError(w, err)
+		return
+	}
+	app.audit(r.Context(), "payment.refund", "payment", payment.ID, nil, map[string]int64{"amount": amount})
+	payment, err = app.getPayment(paymentID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"payment": payment})
+}
+
+// writePaymentError отвечает на ошибку создания или возврата платежа. Текст
+// ошибки провайдера и БД остается в логе.
+func writePaymentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errPaymentNotRefundable):
+		writeError(w, http.StatusConflict, CodeConflict, "Платеж нельзя вернуть в текущем статусе")
+	case errors.Is(err, errPrepaymentPartial):
+		writeError(w, http.StatusConflict, CodeConflict, "Удержанную предоплату можно вернуть только целиком")
+	case errors.Is(err, errPrepaymentReleased):
+		writeError(w, http.StatusConflict, CodeConflict, "Предоплата уже выплачена бригадиру")
+	case errors.Is(err, errPaymentProvider):
+		upstreamError(w, err)
+	default:
+		internalError(w, err)
+	}
+}
@@ -0,0 +1,278 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Задержка подтверждения для сценария "delayed"
+const sandboxDefaultDelay = 5 * time.Second
+
+// SandboxProvider - встроенная песочница платежей для локальной разработки.
+// Платеж "оплачивается" на странице /api/payments/sandbox/{id}, где выбирается
+// сценарий: успех, отказ или подтверждение с задержкой. Уведомления подписываются
+// так же, как у настоящего провайдера, и проходят через VerifyWebhook.
+//
+// Отложенное уведомление сохраняется в sandbox_events со сроком доставки: на
+// Vercel функция завершается вместе с запросом, и таймер в памяти не сработает.
+// Наступившие уведомления доставляет /api/cron/payments, а также страница
+// оплаты при обновлении.
+type SandboxProvider struct {
+	Secret []byte
+	Delay  time.Duration
+	// deliver доставляет уведомление в обработчик платежей приложения
+	deliver func(ctx context.Context, body []byte, signature string) error
+	// schedule сохраняет уведомление для доставки не раньше due
+	schedule func(ctx context.Context, paymentID int64, body []byte, due time.Time) error
+}
+
+func newSandboxProvider(app *App) *SandboxProvider {
+	secret := []byte(os.Getenv("PAYMENT_SANDBOX_SECRET"))
+	if len(secret) == 0 {
+		secret = blobSigningKey()
+	}
+	delay := sandboxDefaultDelay
+	if d, err := time.ParseDuration(os.Getenv("PAYMENT_SANDBOX_DELAY")); err == nil {
+		delay = d
+	}
+	sandbox := &SandboxProvider{Secret: secret, Delay: delay}
+	sandbox.deliver = func(ctx context.Context, body []byte, signature string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/api/payments/callback/sandbox", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Sandbox-Signature", signature)
+		event, err := sandbox.VerifyWebhook(req, body)
+		if err != nil {
+			return err
+		}
+		return app.processPaymentEvent(ctx, sandbox.Name(), event)
+	}
+	sandbox.schedule = func(ctx context.Context, paymentID int64, body []byte, due time.Time) error {
+		_, err := app.db.ExecContext(ctx, `INSERT INTO sandbox_events (payment_id, body, due_at) VALUES (?, ?, ?)`,
+			paymentID, string(body), due.UTC().Format("2006-01-02 15:04:05"))
+		return err
+	}
+	return sandbox
+}
+
+func (s *SandboxProvider) Name() string {
+	return sandboxProviderName
+}
+
+func (s *SandboxProvider) CreatePayment(ctx context.Context, req PaymentRequest) (*ProviderPayment, error) {
+	id := "sandbox_" + randomHex(12)
+	return &ProviderPayment{
+		ID:              id,
+		Status:          PaymentPending,
+		ConfirmationURL: "/api/payments/sandbox/" + id,
+	}, nil
+}
+
+func (s *SandboxProvider) Capture(ctx context.Context, providerPaymentID string, amount int64) (*ProviderPayment, error) {
+	return &ProviderPayment{ID: providerPaymentID, Status: PaymentSucceeded}, nil
+}
+
+func (s *SandboxProvider) Refund(ctx context.Context, providerPaymentID string, amount int64) (*ProviderRefund, error) {
+	return &ProviderRefund{ID: "sandbox_refund_" + randomHex(12), Status: PaymentSucceeded}, nil
+}
+
+func (s *SandboxProvider) sign(body []byte) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *SandboxProvider) VerifyWebhook(r *http.Request, body []byte) (*PaymentEvent, error) {
+	if !hmac.Equal([]byte(r.Header.Get("X-Sandbox-Signature")), []byte(s.sign(body))) {
+		return nil, errWebhookSignature
+	}
+	var event PaymentEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", errWebhookMalformed, err)
+	}
+	if event.EventID == "" || event.PaymentID == "" {
+		return nil, fmt.Errorf("%w: нет event_id или payment_id", errWebhookMalformed)
+	}
+	return &event, nil
+}
+
+var errSandboxOutcome = errors.New("неизвестный сценарий")
+
+// Simulate отправляет уведомление по выбранному сценарию: success, failure или delayed
+func (s *SandboxProvider) Simulate(ctx context.Context, payment *Payment, outcome string) error {
+	eventType := "payment.succeeded"
+	if !payment.Capture {
+		eventType = "payment.waiting_for_capture"
+	}
+	if outcome == "failure" {
+		eventType = "payment.canceled"
+	}
+	body, _ := json.Marshal(PaymentEvent{
+		EventID:   "evt_" + randomHex(12),
+		Type:      eventType,
+		PaymentID: *payment.ProviderPaymentID,
+		Amount:    payment.Amount,
+	})
+
+	switch outcome {
+	case "success", "failure":
+		return s.deliver(ctx, body, s.sign(body))
+	case "delayed":
+		return s.schedule(ctx, payment.ID, body, time.Now().Add(s.Delay))
+	default:
+		return fmt.Errorf("%w: %s", errSandboxOutcome, outcome)
+	}
+}
+
+// deliverSandboxEvents доставляет отложенные уведомления песочницы, срок
+// которых наступил. Уведомление удаляется до доставки, поэтому одновременные
+// вызовы не доставят его дважды; ошибка доставки только логируется, как у
+// настоящего провайдера, который не повторяет отклоненное уведомление.
+func (app *App) deliverSandboxEvents(ctx context.Context) (int, error) {
+	sandbox, ok := app.payments.(*SandboxProvider)
+	if !ok {
+		return 0, nil
+	}
+	type pendingEvent struct {
+		id, paymentID int64
+		body          string
+	}
+	rows, err := app.db.QueryContext(ctx, `SELECT id, payment_id, body FROM sandbox_events WHERE due_at <= ? ORDER BY due_at, id`,
+		time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	var events []pendingEvent
+	for rows.Next() {
+		var e pendingEvent
+		if err := rows.Scan(&e.id, &e.paymentID, &e.body); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+
+	delivered := 0
+	for _, e := range events {
+		result, err := app.db.ExecContext(ctx, `DELETE FROM sandbox_events WHERE id = ?`, e.id)
+		if err != nil {
+			return delivered, err
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			continue
+		}
+		body := []byte(e.body)
+		if err := sandbox.deliver(ctx, body, sandbox.sign(body)); err != nil {
+			log.Printf("Песочница: не удалось доставить уведомление по платежу %d: %v", e.paymentID, err)
+			continue
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+// handlePaymentsCron - доставка отложенных уведомлений песочницы (Vercel Cron)
+func (app *App) handlePaymentsCron(w http.ResponseWriter, r *http.Request) {
+	if !cronAuthorized(w, r) {
+		return
+	}
+	delivered, err := app.deliverSandboxEvents(r.Context())
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"delivered": delivered})
+}
+
+var sandboxPage = template.Must(template.New("sandbox").Parse(`<!DOCTYPE html>
+<html lang="ru"><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Песочница оплаты</title></head>
+<body style="font-family: sans-serif; max-width: 420px; margin: 40px auto;">
+<h2>Тестовая оплата</h2>
+<p>Заказ №{{.OrderID}}, сумма {{.Rubles}} ₽, статус: <b>{{.Status}}</b></p>
+{{range .Outcomes}}<form method="post" style="margin-bottom: 8px;">
+<input type="hidden" name="outcome" value="{{.Value}}"><button type="submit" style="width: 100%; padding: 12px;">{{.Label}}</button>
+</form>{{end}}
+</body></html>`))
+
+// handleSandboxCheckout - страница оплаты песочницы (GET) и выбор сценария (POST)
+func (app *App) handleSandboxCheckout(w http.ResponseWriter, r *http.Request) {
+	sandbox, ok := app.payments.(*SandboxProvider)
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Песочница отключена")
+		return
+	}
+	payment, err := app.getPaymentByProviderID(sandbox.Name(), mux.Vars(r)["providerPaymentId"])
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if payment == nil {
+		writeError(w, http.StatusNotFound, CodePaymentNotFound, "Платеж не найден")
+		return
+	}
+	// Обновление страницы доставляет наступившие отложенные уведомления
+	if delivered, err := app.deliverSandboxEvents(r.Context()); err != nil {
+		internalError(w, err)
+		return
+	} else if delivered > 0 {
+		if payment, err = app.getPayment(payment.ID); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
+	if r.Method == http.MethodPost {
+		outcome := r.FormValue("outcome")
+		if outcome == "" {
+			var req struct {
+				Outcome string `json:"outcome"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			outcome = req.Outcome
+		}
+		if err := sandbox.Simulate(r.Context(), payment, outcome); errors.Is(err, errSandboxOutcome) {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Неизвестный сценарий: success, failure или delayed")
+			return
+		} else if err != nil {
+			internalError(w, err)
+			return
+		}
+		if payment, err = app.getPayment(payment.ID); err != nil {
+			internalError(w, err)
+			return
+		}
+		if r.Header.Get("Content-Type") == "application/json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"payment": payment})
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	sandboxPage.Execute(w, map[string]interface{}{
+		"OrderID": payment.OrderID,
+		"Rubles":  fmt.Sprintf("%.2f", float64(payment.Amount)/100),
+		"Status":  payment.Status,
+		"Outcomes": []struct{ Value, Label string }{
+			{"success", "Оплатить успешно"},
+			{"failure", "Отказ банка"},
+			{"delayed", fmt.Sprintf("Подтвердить через %s", sandbox.Delay)},
+		},
+	})
+}
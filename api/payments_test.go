@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewPaymentProviderFromEnv(t *testing.T) {
+	for _, value := range []string{"", "yookassa-typo"} {
+		t.Setenv("PAYMENT_PROVIDER", value)
+		if provider, err := newPaymentProviderFromEnv(&App{}); err == nil {
+			t.Errorf("PAYMENT_PROVIDER=%q: получили %v, want ошибку", value, provider)
+		}
+	}
+	t.Setenv("PAYMENT_PROVIDER", "sandbox")
+	t.Setenv("PAYMENT_SANDBOX_SECRET", "secret")
+	if _, err := newPaymentProviderFromEnv(&App{}); err != nil {
+		t.Errorf("sandbox: %v", err)
+	}
+}
+
+// payOrder создает платеж клиента и возвращает его
+func payOrder(t *testing.T, order *Order, clientTelegramID int64, body interface{}) *Payment {
+	t.Helper()
+	var resp struct {
+		Payment *Payment `json:"payment"`
+	}
+	if code := doJSON(t, "POST", fmt.Sprintf("/api/orders/%d/payments?telegram_id=%d", order.ID, clientTelegramID), body, &resp); code != http.StatusOK {
+		t.Fatalf("создание платежа: статус %d", code)
+	}
+	return resp.Payment
+}
+
+// sendSandboxEvent отправляет подписанное уведомление песочницы в callback
+func sendSandboxEvent(t *testing.T, a *App, event PaymentEvent) int {
+	t.Helper()
+	body, _ := json.Marshal(event)
+	r := httptest.NewRequest("POST", "/api/payments/callback/sandbox", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Sandbox-Signature", a.payments.(*SandboxProvider).sign(body))
+	w := httptest.NewRecorder()
+	handleAPI(w, r)
+	return w.Code
+}
+
+func TestCreatePaymentAmountFromOrder(t *testing.T) {
+	a := newTestApp(t)
+	clientID := testUser(t, a, 111, "client")
+	testUser(t, a, 222, "client")
+	order := testOrder(t, a, clientID, 20)
+	want := orderQuote(order).Max
+
+	// Сумму из запроса сервер не принимает
+	payment := payOrder(t, order, 111, map[string]interface{}{"amount": 100})
+	if payment.Amount != want {
+		t.Fatalf("сумма платежа %d, want %d", payment.Amount, want)
+	}
+	if payment.Status != PaymentPending || payment.ConfirmationURL == nil {
+		t.Fatalf("новый платеж: %+v", payment)
+	}
+	if code := doJSON(t, "POST", fmt.Sprintf("/api/orders/%d/payments?telegram_id=222", order.ID), nil, nil); code != http.StatusForbidden {
+		t.Errorf("чужой клиент: статус %d, want 403", code)
+	}
+
+	if err := a.payments.(*SandboxProvider).Simulate(context.Background(), payment, "success"); err != nil {
+		t.Fatal(err)
+	}
+	if code := doJSON(t, "POST", fmt.Sprintf("/api/orders/%d/payments?telegram_id=111", order.ID), nil, nil); code != http.StatusConflict {
+		t.Errorf("повторная оплата оплаченного заказа: статус %d, want 409", code)
+	}
+}
+
+func TestPaymentWebhookIdempotent(t *testing.T) {
+	a := newTestApp(t)
+	clientID := testUser(t, a, 111, "client")
+	payment := payOrder(t, testOrder(t, a, clientID, 20), 111, nil)
+
+	event := PaymentEvent{EventID: "evt_1", Type: "payment.succeeded", PaymentID: *payment.ProviderPaymentID, Amount: payment.Amount}
+	for i := 0; i < 2; i++ {
+		if code := sendSandboxEvent(t, a, event); code != http.StatusOK {
+			t.Fatalf("уведомление %d: статус %d", i+1, code)
+		}
+	}
+	var events, receipts int
+	a.db.QueryRow(`SELECT COUNT(*) FROM payment_events WHERE payment_id = ?`, payment.ID).Scan(&events)
+	a.db.QueryRow(`SELECT COUNT(*) FROM receipts WHERE payment_id = ?`, payment.ID).Scan(&receipts)
+	if events != 1 || receipts != 1 {
+		t.Errorf("повтор уведомления: событий %d, чеков %d; want 1 и 1", events, receipts)
+	}
+
+	// Запоздавшее уведомление не откатывает статус
+	late := PaymentEvent{EventID: "evt_0", Type: "payment.waiting_for_capture", PaymentID: *payment.ProviderPaymentID}
+	if code := sendSandboxEvent(t, a, late); code != http.StatusOK {
+		t.Fatalf("запоздавшее уведомление: статус %d", code)
+	}
+	if got, _ := a.getPayment(payment.ID); got.Status != PaymentSucceeded {
+		t.Errorf("статус после запоздавшего уведомления %s, want %s", got.Status, PaymentSucceeded)
+	}
+
+	unknown := PaymentEvent{EventID: "evt_2", Type: "payment.succeeded", PaymentID: "sandbox_unknown"}
+	if code := sendSandboxEvent(t, a, unknown); code != http.StatusUnprocessableEntity {
+		t.Errorf("уведомление по неизвестному платежу: статус %d, want 422", code)
+	}
+
+	body, _ := json.Marshal(event)
+	r := httptest.NewRequest("POST", "/api/payments/callback/sandbox", bytes.NewReader(body))
+	r.Header.Set("X-Sandbox-Signature", "00")
+	w := httptest.NewRecorder()
+	handleAPI(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("неверная подпись: статус %d, want 401", w.Code)
+	}
+}
+
+func TestRefundPayment(t *testing.T) {
+	a := newTestApp(t)
+	sum := sha256.Sum256([]byte("test-admin-key"))
+	t.Setenv("ADMIN_API_KEY_HASHES", hex.EncodeToString(sum[:]))
+	clientID := testUser(t, a, 111, "client")
+	payment := payOrder(t, testOrder(t, a, clientID, 20), 111, nil)
+	if err := a.payments.(*SandboxProvider).Simulate(context.Background(), payment, "success"); err != nil {
+		t.Fatal(err)
+	}
+
+	refund := func(amount int64) (int, *Payment) {
+		var resp struct {
+			Payment *Payment `json:"payment"`
+		}
+		code := doJSON(t, "POST", fmt.Sprintf("/api/admin/payments/%d/refund", payment.ID), map[string]int64{"amount": amount}, &resp, "X-API-Key", "test-admin-key")
+		return code, resp.Payment
+	}
+	code, got := refund(1000)
+	if code != http.StatusOK || got.RefundedAmount != 1000 || got.Status != PaymentSucceeded {
+		t.Fatalf("частичный возврат: %d %+v", code, got)
+	}
+	if code, _ := refund(payment.Amount); code != http.StatusConflict {
+		t.Errorf("возврат больше остатка: статус %d, want 409", code)
+	}
+	code, got = refund(payment.Amount - 1000)
+	if code != http.StatusOK || got.RefundedAmount != payment.Amount || got.Status != PaymentRefunded {
+		t.Fatalf("возврат остатка: %d %+v", code, got)
+	}
+	// Ответ на возврат без остатка - сообщение из каталога, а не текст внутренней ошибки
+	var resp struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if code := doJSON(t, "POST", fmt.Sprintf("/api/admin/payments/%d/refund", payment.ID), map[string]int64{"amount": 1}, &resp, "X-API-Key", "test-admin-key"); code != http.StatusConflict || resp.Error.Message != "Платеж нельзя вернуть в текущем статусе" {
+		t.Errorf("возврат возвращенного платежа: статус %d, %q", code, resp.Error.Message)
+	}
+	if code := doJSON(t, "POST", fmt.Sprintf("/api/admin/payments/%d/refund", payment.ID), map[string]int64{"amount": 1}, nil); code != http.StatusUnauthorized {
+		t.Errorf("возврат без ключа: статус %d, want 401", code)
+	}
+}
+
+func TestSandboxDelayedConfirmation(t *testing.T) {
+	a := newTestApp(t)
+	t.Setenv("CRON_SECRET", "test-cron-secret")
+	sandbox := a.payments.(*SandboxProvider)
+	sandbox.Delay = time.Hour
+	clientID := testUser(t, a, 111, "client")
+	payment := payOrder(t, testOrder(t, a, clientID, 20), 111, nil)
+
+	var resp struct {
+		Payment *Payment `json:"payment"`
+	}
+	if code := doJSON(t, "POST", "/api/payments/sandbox/"+*payment.ProviderPaymentID, map[string]string{"outcome": "delayed"}, &resp); code != http.StatusOK {
+		t.Fatalf("сценарий delayed: статус %d", code)
+	}
+	if resp.Payment.Status != PaymentPending {
+		t.Fatalf("до подтверждения статус %s, want %s", resp.Payment.Status, PaymentPending)
+	}
+	cron := func() int {
+		t.Helper()
+		var out struct {
+			Delivered int `json:"delivered"`
+		}
+		if code := doJSON(t, "GET", "/api/cron/payments", nil, &out, "Authorization", "Bearer test-cron-secret"); code != http.StatusOK {
+			t.Fatalf("cron: статус %d", code)
+		}
+		return out.Delivered
+	}
+	// Срок не наступил - уведомление ждет в базе, а не в таймере
+	if n := cron(); n != 0 {
+		t.Fatalf("доставлено до срока: %d", n)
+	}
+	if got, _ := a.getPayment(payment.ID); got.Status != PaymentPending {
+		t.Fatalf("до срока статус %s, want %s", got.Status, PaymentPending)
+	}
+	if _, err := a.db.Exec(`UPDATE sandbox_events SET due_at = '2000-01-01 00:00:00'`); err != nil {
+		t.Fatal(err)
+	}
+	if n := cron(); n != 1 {
+		t.Fatalf("доставлено %d, want 1", n)
+	}
+	if got, _ := a.getPayment(payment.ID); got.Status != PaymentSucceeded {
+		t.Fatalf("после доставки статус %s, want %s", got.Status, PaymentSucceeded)
+	}
+	if n := cron(); n != 0 {
+		t.Errorf("повторная доставка: %d", n)
+	}
+	if code := doJSON(t, "GET", "/api/cron/payments", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("cron без секрета: статус %d, want 401", code)
+	}
+}
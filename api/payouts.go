@@ -0,0 +1,755 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Комиссия платформы по умолчанию, если не задано правило
+const defaultCommissionPercent = 10.0
+
+// Счета учета комиссии и выплат, см. ledger.go
+const (
+	commissionRevenueAccount = "revenue:commission"
+	payoutCashAccount        = "cash:payouts"
+)
+
+// Статусы начислений и выплат
+const (
+	AccrualAccrued  = "accrued"
+	AccrualInPayout = "in_payout"
+	AccrualPaid     = "paid"
+
+	PayoutBatchDraft  = "draft"
+	PayoutBatchPaid   = "paid"
+	PayoutBatchFailed = "failed"
+)
+
+// CommissionRule - процент комиссии. Пустые ContractorID и Category означают "любой";
+// применяется самое точное правило: бригадир+тариф, бригадир, тариф.
+type CommissionRule struct {
+	ID           int64     `json:"id"`
+	ContractorID *int64    `json:"contractor_id"`
+	Category     *string   `json:"category"`
+	Percent      float64   `json:"percent"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Accrual - начисление бригадиру по выполненному заказу, суммы в копейках.
+// Gross - стоимость заказа, зафиксированная при принятии, комиссия считается
+// от нее. Если клиент внес предоплату через безопасную сделку, бригадиру
+// причитается предоплата минус комиссия, остаток клиент платит напрямую.
+// Иначе клиент рассчитался с бригадиром напрямую и Net отрицательный:
+// комиссия удерживается из следующих выплат. Предоплату могут внести и после
+// завершения заказа, поэтому ViaEscrow и Net окончательно пересчитываются
+// при сборке реестра выплат.
+type Accrual struct {
+	ID                int64     `json:"id"`
+	OrderID           int64     `json:"order_id"`
+	ContractorID      int64     `json:"contractor_id"`
+	Category          string    `json:"category"`
+	Gross             int64     `json:"gross"`
+	CommissionPercent float64   `json:"commission_percent"`
+	Commission        int64     `json:"commission"`
+	Net               int64     `json:"net"`
+	ViaEscrow         bool      `json:"via_escrow"`
+	Status            string    `json:"status"`
+	PayoutID          *int64    `json:"payout_id"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+type PayoutBatch struct {
+	ID        int64     `json:"id"`
+	Status    string    `json:"status"`
+	Total     int64     `json:"total"`
+	Payouts   []Payout  `json:"payouts"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Payout struct {
+	ID           int64   `json:"id"`
+	BatchID      int64   `json:"batch_id"`
+	ContractorID int64   `json:"contractor_id"`
+	Amount       int64   `json:"amount"`
+	Status       string  `json:"status"`
+	Name         *string `json:"recipient_name"`
+	INN          *string `json:"inn"`
+	Account      *string `json:"account"`
+	BIK          *string `json:"bik"`
+}
+
+func defaultCommission() float64 {
+	if p, err := strconv.ParseFloat(os.Getenv("COMMISSION_PERCENT"), 64); err == nil && p >= 0 && p <= 100 {
+		return p
+	}
+	return defaultCommissionPercent
+}
+
+// commissionPercent подбирает правило для бригадира и тарифа
+func (app *App) commissionPercent(contractorID int64, category string) (float64, error) {
+	var percent float64
+	err := app.db.QueryRow(`SELECT percent FROM commission_rules
+		WHERE (contractor_id = ? OR contractor_id IS NULL) AND (category = ? OR category IS NULL)
+		ORDER BY contractor_id IS NULL, category IS NULL, id DESC LIMIT 1`, contractorID, category).Scan(&percent)
+	if err == sql.ErrNoRows {
+		return defaultCommission(), nil
+	}
+	return percent, err
+}
+
+// accrueOrder начисляет бригадиру вознаграждение за выполненный заказ и
+// проводит комиссию. Повторный вызов для того же заказа ничего не меняет.
+func (app *App) accrueOrder(orderID int64) error {
+	order, err := app.getOrder(orderID)
+	if err != nil {
+		return err
+	}
+	if order == nil || order.ContractorID == nil {
+		return nil
+	}
+	contractorID := *order.ContractorID
+
+	if err := app.fixOrderPrice(order); err != nil {
+		return err
+	}
+	gross := orderPrice(order)
+	if gross <= 0 {
+		return nil
+	}
+	escrow, err := app.getEscrow(orderID)
+	if err != nil {
+		return err
+	}
+	if escrow != nil && escrow.Payment != nil && escrow.Payment.Provider == sandboxProviderName {
+		// Предоплата внесена в песочнице - платить бригадиру не из чего
+		return nil
+	}
+	// Предоплата - только часть стоимости (ESCROW_PREPAYMENT_PERCENT)
+	viaEscrow := escrow != nil && (escrow.Status == EscrowHeld || escrow.Status == EscrowReleased)
+
+	percent, err := app.commissionPercent(contractorID, order.Category)
+	if err != nil {
+		return err
+	}
+	commission := int64(math.Round(float64(gross) * percent / 100))
+	net := -commission
+	if viaEscrow {
+		net += escrow.Amount
+	}
+
+	result, err := app.db.Exec(`INSERT OR IGNORE INTO contractor_accruals (order_id, contractor_id, category, gross, commission_percent, commission, net, via_escrow) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		orderID, contractorID, order.Category, gross, percent, commission, net, viaEscrow)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 || commission <= 0 {
+		return nil
+	}
+	// Начисление могли отменить и создать заново (admin.go), поэтому у
+	// проводки комиссии номер начисления, а не только заказа
+	accrualID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return app.postLedger(fmt.Sprintf("commission:%d:%d", orderID, accrualID), contractorAccount(contractorID), commissionRevenueAccount, commission, &orderID, nil, fmt.Sprintf("Комиссия платформы %.2f%%", percent))
+}
+
+var errAccrualInPayout = errors.New("вознаграждение по заказу уже в реестре выплат")
+
+// cancelAccrualTx отменяет начисление по заказу, который перестал быть
+// выполненным, и сторнирует комиссию. Начисление в реестре выплат не трогается.
+func cancelAccrualTx(tx *sql.Tx, orderID int64) error {
+	var id, contractorID, commission int64
+	var status string
+	err := tx.QueryRow(`SELECT id, contractor_id, commission, status FROM contractor_accruals WHERE order_id = ?`, orderID).Scan(&id, &contractorID, &commission, &status)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if status != AccrualAccrued {
+		return fmt.Errorf("%w: заказ %d", errAccrualInPayout, orderID)
+	}
+	if _, err := tx.Exec(`DELETE FROM contractor_accruals WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if commission <= 0 {
+		return nil
+	}
+	return postLedgerExec(tx, fmt.Sprintf("commission_reversal:%d", id), commissionRevenueAccount, contractorAccount(contractorID), commission, &orderID, nil, "Сторно комиссии: заказ больше не выполнен")
+}
+
+const accrualColumns = `id, order_id, contractor_id, category, gross, commission_percent, commission, net, via_escrow, status, payout_id, created_at`
+
+func scanAccrual(row interface{ Scan(...interface{}) error }) (*Accrual, error) {
+	var a Accrual
+	var createdAt string
+	if err := row.Scan(&a.ID, &a.OrderID, &a.ContractorID, &a.Category, &a.Gross, &a.CommissionPercent, &a.Commission, &a.Net, &a.ViaEscrow, &a.Status, &a.PayoutID, &createdAt); err != nil {
+		return nil, err
+	}
+	a.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt)
+	return &a, nil
+}
+
+func (app *App) getContractorAccruals(contractorID int64, from, to time.Time) ([]Accrual, error) {
+	rows, err := app.db.Query(`SELECT `+accrualColumns+` FROM contractor_accruals WHERE contractor_id = ? AND created_at >= ? AND created_at < ? ORDER BY id DESC`,
+		contractorID, from.Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	accruals := []Accrual{}
+	for rows.Next() {
+		a, err := scanAccrual(rows)
+		if err != nil {
+			return nil, err
+		}
+		accruals = append(accruals, *a)
+	}
+	return accruals, nil
+}
+
+// handleContractorEarnings - начисления и итоги бригадира за период.
+// from и to - даты YYYY-MM-DD (to включительно), по умолчанию текущий месяц.
+func (app *App) handleContractorEarnings(w http.ResponseWriter, r *http.Request) {
+	user := callerUser(w, r)
+	if user == nil {
+		return
+	}
+	if user.Role != "contractor" {
+		writeError(w, http.StatusBadRequest, CodeNotContractor, "Пользователь не является бригадиром")
+		return
+	}
+
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	var err error
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Неверная дата from")
+			return
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		day, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Неверная дата to")
+			return
+		}
+		to = day.AddDate(0, 0, 1)
+	}
+	if !to.After(from) {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Дата to раньше from")
+		return
+	}
+
+	accruals, err := app.getContractorAccruals(user.ID, from, to)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	type totals struct {
+		Orders     int   `json:"orders"`
+		Gross      int64 `json:"gross"`
+		Commission int64 `json:"commission"`
+		Net        int64 `json:"net"`
+		Paid       int64 `json:"paid"`
+		Pending    int64 `json:"pending"`
+	}
+	var period totals
+	for _, a := range accruals {
+		period.Orders++
+		period.Gross += a.Gross
+		period.Commission += a.Commission
+		period.Net += a.Net
+		if a.Status == AccrualPaid {
+			period.Paid += a.Net
+		} else {
+			period.Pending += a.Net
+		}
+	}
+	// Остаток к выплате по учету: полученные предоплаты минус комиссии и выплаты
+	balance, err := app.accountBalance(contractorAccount(user.ID))
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":     from.Format("2006-01-02"),
+		"to":       to.AddDate(0, 0, -1).Format("2006-01-02"),
+		"totals":   period,
+		"balance":  balance,
+		"accruals": accruals,
+	})
+}
+
+var (
+	innPattern     = regexp.MustCompile(`^(\d{10}|\d{12})$`)
+	accountPattern = regexp.MustCompile(`^\d{20}$`)
+	bikPattern     = regexp.MustCompile(`^\d{9}$`)
+)
+
+// handleUpdatePayoutDetails - реквизиты бригадира для выплат
+func (app *App) handleUpdatePayoutDetails(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RecipientName string `json:"recipient_name"`
+		INN           string `json:"inn"`
+		Account       string `json:"account"`
+		BIK           string `json:"bik"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	user := callerUser(w, r)
+	if user == nil {
+		return
+	}
+	if user.Role != "contractor" {
+		writeError(w, http.StatusBadRequest, CodeNotContractor, "Пользователь не является бригадиром")
+		return
+	}
+	if req.RecipientName == "" || !innPattern.MatchString(req.INN) || !accountPattern.MatchString(req.Account) || !bikPattern.MatchString(req.BIK) {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Укажите получателя, ИНН (10 или 12 цифр), счет (20 цифр) и БИК (9 цифр)")
+		return
+	}
+	result, err := app.db.Exec(`UPDATE contractor_profiles SET payout_name = ?, payout_inn = ?, payout_account = ?, payout_bik = ? WHERE user_id = ?`,
+		req.RecipientName, req.INN, req.Account, req.BIK, user.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, http.StatusConflict, CodeContractorProfile, "Сначала заполните профиль бригадира")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+func (app *App) handleGetCommissionRules(w http.ResponseWriter, r *http.Request) {
+	rows, err := app.db.Query(`SELECT id, contractor_id, category, percent, created_at FROM commission_rules ORDER BY id`)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer rows.Close()
+	rules := []CommissionRule{}
+	for rows.Next() {
+		var rule CommissionRule
+		var createdAt string
+		if err := rows.Scan(&rule.ID, &rule.ContractorID, &rule.Category, &rule.Percent, &createdAt); err != nil {
+			internalError(w, err)
+			return
+		}
+		rule.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt)
+		rules = append(rules, rule)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"rules": rules, "default_percent": defaultCommission()})
+}
+
+// handleSetCommissionRule добавляет правило или меняет процент у существующего с теми же условиями
+func (app *App) handleSetCommissionRule(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ContractorID *int64  `json:"contractor_id"`
+		Category     *string `json:"category"`
+		Percent      float64 `json:"percent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	if req.Percent < 0 || req.Percent > 100 {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Процент комиссии должен быть от 0 до 100")
+		return
+	}
+	if req.Category != nil {
+		if _, ok := TARIFFS[*req.Category]; !ok {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Неизвестный тариф")
+			return
+		}
+	}
+	if _, err := app.db.Exec(`DELETE FROM commission_rules WHERE contractor_id IS ? AND category IS ?`, req.ContractorID, req.Category); err != nil {
+		internalError(w, err)
+		return
+	}
+	if _, err := app.db.Exec(`INSERT INTO commission_rules (contractor_id, category, percent) VALUES (?, ?, ?)`, req.ContractorID, req.Category, req.Percent); err != nil {
+		internalError(w, err)
+		return
+	}
+	app.audit(r.Context(), "commission_rule.save", "commission_rule", 0, nil, req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// Начисления по заказам, оплаченным в песочнице, в реестр не попадают
+const accrualNotSandboxSQL = `NOT EXISTS (SELECT 1 FROM payments p WHERE p.order_id = a.order_id AND p.provider = '` + sandboxProviderName + `')`
+
+// accrualPayableSQL - начисление a готово к выплате: еще не в реестре, а
+// предоплата по заказу уже не изменится - выплачена бригадиру, возвращена,
+// отменена или не выставлялась. Пока предоплату ждут или удерживают,
+// начисление ждет следующего реестра.
+const accrualPayableSQL = `a.status = '` + AccrualAccrued + `'
+	AND NOT EXISTS (SELECT 1 FROM escrows e WHERE e.order_id = a.order_id AND e.status IN ('` + EscrowAwaitingPayment + `', '` + EscrowPaymentFailed + `', '` + EscrowHeld + `'))
+	AND ` + accrualNotSandboxSQL
+
+// createPayoutBatch собирает в реестр все начисления, готовые к выплате: по
+// безопасной сделке - после выплаты предоплаты бригадиру, остальные - сразу.
+// Net каждого начисления пересчитывается по текущему состоянию предоплаты.
+// Бригадиры с неположительной суммой и без реквизитов ждут следующего реестра.
+// Реестр собирается одной транзакцией: начисления сначала помечаются выплатой,
+// а сумма выплаты считается по помеченным строкам, поэтому одновременные
+// реестры не заплатят за одно начисление дважды.
+func (app *App) createPayoutBatch() (*PayoutBatch, error) {
+	tx, err := app.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// Запись первой блокирует базу до конца транзакции
+	result, err := tx.Exec(`INSERT INTO payout_batches (status) VALUES (?)`, PayoutBatchDraft)
+	if err != nil {
+		return nil, err
+	}
+	batchID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	// Предоплату могли внести или вернуть уже после завершения заказа
+	if _, err := tx.Exec(`UPDATE contractor_accruals AS a SET
+			via_escrow = EXISTS (SELECT 1 FROM escrows e WHERE e.order_id = a.order_id AND e.status = ?),
+			net = COALESCE((SELECT e.amount FROM escrows e WHERE e.order_id = a.order_id AND e.status = ?), 0) - a.commission
+		WHERE `+accrualPayableSQL, EscrowReleased, EscrowReleased); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT a.contractor_id FROM contractor_accruals a
+		JOIN contractor_profiles cp ON cp.user_id = a.contractor_id
+		WHERE ` + accrualPayableSQL + ` AND cp.payout_account IS NOT NULL AND cp.payout_bik IS NOT NULL
+		GROUP BY a.contractor_id HAVING SUM(a.net) > 0`)
+	if err != nil {
+		return nil, err
+	}
+	var contractorIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		contractorIDs = append(contractorIDs, id)
+	}
+	rows.Close()
+
+	var total int64
+	for _, contractorID := range contractorIDs {
+		result, err := tx.Exec(`INSERT INTO payouts (batch_id, contractor_id, amount) VALUES (?, ?, 0)`, batchID, contractorID)
+		if err != nil {
+			return nil, err
+		}
+		payoutID, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`UPDATE contractor_accruals AS a SET status = ?, payout_id = ? WHERE a.contractor_id = ? AND `+accrualPayableSQL,
+			AccrualInPayout, payoutID, contractorID); err != nil {
+			return nil, err
+		}
+		var amount int64
+		if err := tx.QueryRow(`SELECT COALESCE(SUM(net), 0) FROM contractor_accruals WHERE payout_id = ?`, payoutID).Scan(&amount); err != nil {
+			return nil, err
+		}
+		if amount <= 0 {
+			return nil, fmt.Errorf("сумма выплаты бригадиру %d: %d", contractorID, amount)
+		}
+		if _, err := tx.Exec(`UPDATE payouts SET amount = ? WHERE id = ?`, amount, payoutID); err != nil {
+			return nil, err
+		}
+		total += amount
+	}
+	if len(contractorIDs) == 0 {
+		return nil, nil
+	}
+	if _, err := tx.Exec(`UPDATE payout_batches SET total = ? WHERE id = ?`, total, batchID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return app.getPayoutBatch(batchID)
+}
+
+func (app *App) getPayoutBatch(batchID int64) (*PayoutBatch, error) {
+	var b PayoutBatch
+	var createdAt, updatedAt string
+	err := app.db.QueryRow(`SELECT id, status, total, created_at, updated_at FROM payout_batches WHERE id = ?`, batchID).Scan(&b.ID, &b.Status, &b.Total, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt)
+	b.UpdatedAt, _ = time.Parse("2006-01-02 15:04:05", updatedAt)
+
+	rows, err := app.db.Query(`SELECT p.id, p.batch_id, p.contractor_id, p.amount, p.status, cp.payout_name, cp.payout_inn, cp.payout_account, cp.payout_bik
+		FROM payouts p LEFT JOIN contractor_profiles cp ON cp.user_id = p.contractor_id
+		WHERE p.batch_id = ? ORDER BY p.id`, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	b.Payouts = []Payout{}
+	for rows.Next() {
+		var p Payout
+		if err := rows.Scan(&p.ID, &p.BatchID, &p.ContractorID, &p.Amount, &p.Status, &p.Name, &p.INN, &p.Account, &p.BIK); err != nil {
+			return nil, err
+		}
+		b.Payouts = append(b.Payouts, p)
+	}
+	return &b, nil
+}
+
+var errPayoutBatchClosed = errors.New("реестр уже закрыт")
+
+// setPayoutBatchStatus закрывает реестр по ответу банка. При успехе выплаты
+// проводятся по учету, при отказе начисления возвращаются в очередь. Статус,
+// начисления и проводки меняются одной транзакцией: при ошибке реестр
+// остается черновиком и его можно закрыть повторно.
+func (app *App) setPayoutBatchStatus(batch *PayoutBatch, status string) error {
+	tx, err := app.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`UPDATE payout_batches SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`, status, batch.ID, PayoutBatchDraft)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return errPayoutBatchClosed
+	}
+	for _, p := range batch.Payouts {
+		if _, err := tx.Exec(`UPDATE payouts SET status = ? WHERE id = ?`, status, p.ID); err != nil {
+			return err
+		}
+		if status == PayoutBatchFailed {
+			if _, err := tx.Exec(`UPDATE contractor_accruals SET status = ?, payout_id = NULL WHERE payout_id = ?`, AccrualAccrued, p.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE contractor_accruals SET status = ? WHERE payout_id = ?`, AccrualPaid, p.ID); err != nil {
+			return err
+		}
+		if err := postLedgerExec(tx, fmt.Sprintf("payout:%d", p.ID), contractorAccount(p.ContractorID), payoutCashAccount, p.Amount, nil, nil, fmt.Sprintf("Выплата по реестру №%d", batch.ID)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (app *App) handleCreatePayoutBatch(w http.ResponseWriter, r *http.Request) {
+	batch, err := app.createPayoutBatch()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if batch == nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Нет начислений к выплате")
+		return
+	}
+	app.audit(r.Context(), "payout_batch.create", "payout_batch", batch.ID, nil, nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"batch": batch})
+}
+
+// payoutBatchFromRequest загружает реестр из пути
+func (app *App) payoutBatchFromRequest(w http.ResponseWriter, r *http.Request) *PayoutBatch {
+	batchID, err := strconv.ParseInt(mux.Vars(r)["batchId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный batch ID")
+		return nil
+	}
+	batch, err := app.getPayoutBatch(batchID)
+	if err != nil {
+		internalError(w, err)
+		return nil
+	}
+	if batch == nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Реестр не найден")
+		return nil
+	}
+	return batch
+}
+
+func (app *App) handleSetPayoutBatchStatus(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	if req.Status != PayoutBatchPaid && req.Status != PayoutBatchFailed {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Статус должен быть paid или failed")
+		return
+	}
+	batch := app.payoutBatchFromRequest(w, r)
+	if batch == nil {
+		return
+	}
+	if err := app.setPayoutBatchStatus(batch, req.Status); err == errPayoutBatchClosed {
+		writeError(w, http.StatusConflict, CodeConflict, "Реестр уже закрыт")
+		return
+	} else if err != nil {
+		internalError(w, err)
+		return
+	}
+	app.audit(r.Context(), "payout_batch."+req.Status, "payout_batch", batch.ID, nil, nil)
+	batch, err := app.getPayoutBatch(batch.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"batch": batch})
+}
+
+// handleExportPayoutBatch выгружает реестр: format=csv или format=1c
+// (формат обмена 1CClientBankExchange для загрузки в банк-клиент)
+func (app *App) handleExportPayoutBatch(w http.ResponseWriter, r *http.Request) {
+	batch := app.payoutBatchFromRequest(w, r)
+	if batch == nil {
+		return
+	}
+	switch r.URL.Query().Get("format") {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="payouts_%d.csv"`, batch.ID))
+		w.Write(payoutsCSV(batch))
+	case "1c":
+		w.Header().Set("Content-Type", "text/plain; charset=windows-1251")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="payouts_%d.txt"`, batch.ID))
+		w.Write(encodeWindows1251(payouts1C(batch, time.Now())))
+	default:
+		writeError(w, http.StatusBadRequest, CodeValidation, "Неизвестный формат, доступны csv и 1c")
+	}
+}
+
+func rubles(kopecks int64) string {
+	return fmt.Sprintf("%d.%02d", kopecks/100, kopecks%100)
+}
+
+func payoutPurpose(batch *PayoutBatch, p Payout) string {
+	return fmt.Sprintf("Выплата вознаграждения по реестру №%d (выплата %d). Без НДС", batch.ID, p.ID)
+}
+
+func payoutsCSV(batch *PayoutBatch) []byte {
+	var buf bytes.Buffer
+	// BOM, чтобы Excel открыл файл в UTF-8
+	buf.WriteString("\ufeff")
+	writer := csv.NewWriter(&buf)
+	writer.Comma = ';'
+	writer.Write([]string{"payout_id", "contractor_id", "recipient_name", "inn", "account", "bik", "amount", "purpose"})
+	for _, p := range batch.Payouts {
+		writer.Write([]string{
+			strconv.FormatInt(p.ID, 10), strconv.FormatInt(p.ContractorID, 10),
+			derefString(p.Name), derefString(p.INN), derefString(p.Account), derefString(p.BIK),
+			rubles(p.Amount), payoutPurpose(batch, p),
+		})
+	}
+	writer.Flush()
+	return buf.Bytes()
+}
+
+// payouts1C формирует платежные поручения в формате 1CClientBankExchange 1.03.
+// Реквизиты плательщика задаются переменными PAYOUT_PAYER_*.
+func payouts1C(batch *PayoutBatch, now time.Time) string {
+	payerName := os.Getenv("PAYOUT_PAYER_NAME")
+	payerINN := os.Getenv("PAYOUT_PAYER_INN")
+	payerAccount := os.Getenv("PAYOUT_PAYER_ACCOUNT")
+	payerBIK := os.Getenv("PAYOUT_PAYER_BIK")
+	date := now.Format("02.01.2006")
+
+	var buf bytes.Buffer
+	line := func(key, value string) { fmt.Fprintf(&buf, "%s=%s\r\n", key, value) }
+	buf.WriteString("1CClientBankExchange\r\n")
+	line("ВерсияФормата", "1.03")
+	line("Кодировка", "Windows")
+	line("Отправитель", "Пол страны")
+	line("ДатаСоздания", date)
+	line("ВремяСоздания", now.Format("15:04:05"))
+	line("ДатаНачала", date)
+	line("ДатаКонца", date)
+	line("РасчСчет", payerAccount)
+	line("Документ", "Платежное поручение")
+	for i, p := range batch.Payouts {
+		line("СекцияДокумент", "Платежное поручение")
+		line("Номер", strconv.Itoa(i+1))
+		line("Дата", date)
+		line("Сумма", rubles(p.Amount))
+		line("ПлательщикСчет", payerAccount)
+		line("Плательщик", payerName)
+		line("ПлательщикИНН", payerINN)
+		line("ПлательщикБИК", payerBIK)
+		line("ПолучательСчет", derefString(p.Account))
+		line("Получатель", derefString(p.Name))
+		line("ПолучательИНН", derefString(p.INN))
+		line("ПолучательБИК", derefString(p.BIK))
+		line("ВидОплаты", "01")
+		line("Очередность", "5")
+		line("НазначениеПлатежа", payoutPurpose(batch, p))
+		buf.WriteString("КонецДокумента\r\n")
+	}
+	buf.WriteString("КонецФайла\r\n")
+	return buf.String()
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// encodeWindows1251 перекодирует текст для банк-клиентов, которые не читают UTF-8.
+// Символы вне кириллицы и ASCII заменяются на "?".
+func encodeWindows1251(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			out = append(out, byte(r))
+		case r >= 'А' && r <= 'я':
+			out = append(out, byte(r-'А'+0xC0))
+		case r == 'Ё':
+			out = append(out, 0xA8)
+		case r == 'ё':
+			out = append(out, 0xB8)
+		case r == '№':
+			out = append(out, 0xB9)
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+)
+
+// testPayoutContractor - проверенный бригадир с реквизитами для выплат
+func testPayoutContractor(t *testing.T, a *App, telegramID int64) int64 {
+	t.Helper()
+	contractorID := testUser(t, a, telegramID, "contractor")
+	if _, err := a.db.Exec(`INSERT INTO contractor_profiles (user_id, categories, verification_status, payout_name, payout_inn, payout_account, payout_bik) VALUES (?, '[]', ?, 'ИП Петров', '7707083893', '40802810000000000001', '044525225')`,
+		contractorID, VerificationVerified); err != nil {
+		t.Fatal(err)
+	}
+	return contractorID
+}
+
+func TestPayoutBatchWaitsForPrepayment(t *testing.T) {
+	a := newTestApp(t)
+	t.Setenv("COMMISSION_PERCENT", "10")
+	clientID := testUser(t, a, 111, "client")
+	contractorID := testPayoutContractor(t, a, 333)
+	order := testOrder(t, a, clientID, 20)
+	if err := a.acceptOrder(order.ID, contractorID, nil); err != nil {
+		t.Fatal(err)
+	}
+	order, _ = a.getOrder(order.ID)
+	if err := a.fixOrderPrice(order); err != nil || order.Price == nil {
+		t.Fatalf("цена заказа не зафиксирована: %v", err)
+	}
+	price := *order.Price
+	prepayment := price * 30 / 100
+	if _, err := a.db.Exec(`INSERT INTO escrows (order_id, amount) VALUES (?, ?)`, order.ID, prepayment); err != nil {
+		t.Fatal(err)
+	}
+	// Цены тарифа после принятия заказа на начисление не влияют
+	if _, err := a.db.Exec(`UPDATE orders SET price_max = price_max * 2 WHERE id = ?`, order.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Заказ завершен, а предоплату еще не внесли - начисление ждет
+	if err := a.completeOrder(order.ID); err != nil {
+		t.Fatal(err)
+	}
+	if batch, err := a.createPayoutBatch(); err != nil || batch != nil {
+		t.Fatalf("реестр до оплаты предоплаты: %+v, %v", batch, err)
+	}
+
+	// Предоплату внесли после завершения и выплатили бригадиру
+	if _, err := a.db.Exec(`UPDATE escrows SET status = ? WHERE order_id = ?`, EscrowReleased, order.ID); err != nil {
+		t.Fatal(err)
+	}
+	batch, err := a.createPayoutBatch()
+	if err != nil || batch == nil {
+		t.Fatalf("реестр: %+v, %v", batch, err)
+	}
+	commission := int64(math.Round(float64(price) * 0.1))
+	if len(batch.Payouts) != 1 || batch.Payouts[0].Amount != prepayment-commission {
+		t.Errorf("выплаты %+v, want одна на %d", batch.Payouts, prepayment-commission)
+	}
+	accrual, err := scanAccrual(a.db.QueryRow(`SELECT `+accrualColumns+` FROM contractor_accruals WHERE order_id = ?`, order.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accrual.Gross != price || accrual.Commission != commission || !accrual.ViaEscrow || accrual.Status != AccrualInPayout {
+		t.Errorf("начисление %+v, want gross %d, комиссия %d по безопасной сделке", accrual, price, commission)
+	}
+}
+
+// completedOrder заводит выполненный заказ бригадира. prepayment > 0 -
+// предоплата по безопасной сделке, уже выплаченная бригадиру.
+func completedOrder(t *testing.T, a *App, clientID, contractorID, prepayment int64) *Order {
+	t.Helper()
+	order := testOrder(t, a, clientID, 20)
+	if _, err := a.db.Exec(`UPDATE orders SET contractor_id = ?, status = 'accepted' WHERE id = ?`, contractorID, order.ID); err != nil {
+		t.Fatal(err)
+	}
+	if prepayment > 0 {
+		if _, err := a.db.Exec(`INSERT INTO escrows (order_id, amount, status) VALUES (?, ?, ?)`, order.ID, prepayment, EscrowReleased); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.completeOrder(order.ID); err != nil {
+		t.Fatal(err)
+	}
+	order, _ = a.getOrder(order.ID)
+	return order
+}
+
+func orderAccrual(t *testing.T, a *App, orderID int64) *Accrual {
+	t.Helper()
+	accrual, err := scanAccrual(a.db.QueryRow(`SELECT `+accrualColumns+` FROM contractor_accruals WHERE order_id = ?`, orderID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accrual
+}
+
+func TestCommissionRules(t *testing.T) {
+	a := newTestApp(t)
+	t.Setenv("COMMISSION_PERCENT", "10")
+	t.Setenv("ADMIN_API_KEY_HASHES", hashAPIKey("test-admin-key"))
+	clientID := testUser(t, a, 111, "client")
+	first := testPayoutContractor(t, a, 333)
+	second := testPayoutContractor(t, a, 444)
+
+	econom := "econom"
+	for _, rule := range []map[string]interface{}{
+		{"category": econom, "percent": 7.5},
+		{"contractor_id": first, "percent": 5},
+		{"contractor_id": first, "category": econom, "percent": 3},
+	} {
+		if code := doJSON(t, "POST", "/api/admin/commission/rules", rule, nil, "X-API-Key", "test-admin-key"); code != http.StatusOK {
+			t.Fatalf("правило %v: статус %d", rule, code)
+		}
+	}
+	if code := doJSON(t, "POST", "/api/admin/commission/rules", map[string]interface{}{"percent": 101}, nil, "X-API-Key", "test-admin-key"); code != http.StatusBadRequest {
+		t.Errorf("процент больше 100: статус %d, want 400", code)
+	}
+	// Применяется самое точное правило
+	for _, c := range []struct {
+		contractorID int64
+		category     string
+		want         float64
+	}{
+		{first, econom, 3},
+		{first, "comfort", 5},
+		{second, econom, 7.5},
+		{second, "comfort", 10},
+	} {
+		if got, err := a.commissionPercent(c.contractorID, c.category); err != nil || got != c.want {
+			t.Errorf("бригадир %d, тариф %s: %v%% (%v), want %v%%", c.contractorID, c.category, got, err, c.want)
+		}
+	}
+
+	// Заказ оплачен напрямую: комиссия удерживается из следующих выплат
+	direct := completedOrder(t, a, clientID, second, 0)
+	accrual := orderAccrual(t, a, direct.ID)
+	commission := int64(math.Round(float64(orderPrice(direct)) * 7.5 / 100))
+	if accrual.Gross != orderPrice(direct) || accrual.CommissionPercent != 7.5 || accrual.Commission != commission || accrual.Net != -commission || accrual.ViaEscrow {
+		t.Errorf("начисление без предоплаты: %+v, want комиссию %d", accrual, commission)
+	}
+	// Через безопасную сделку бригадиру причитается предоплата минус комиссия
+	prepayment := orderPrice(direct) / 2
+	viaEscrow := completedOrder(t, a, clientID, first, prepayment)
+	accrual = orderAccrual(t, a, viaEscrow.ID)
+	commission = int64(math.Round(float64(orderPrice(viaEscrow)) * 3 / 100))
+	if accrual.Commission != commission || accrual.Net != prepayment-commission || !accrual.ViaEscrow {
+		t.Errorf("начисление по безопасной сделке: %+v, want net %d", accrual, prepayment-commission)
+	}
+	if got := balanceOf(t, a, commissionRevenueAccount); got != accrual.Commission+orderAccrual(t, a, direct.ID).Commission {
+		t.Errorf("комиссия платформы %d", got)
+	}
+	// Повторное завершение не начисляет второй раз
+	if err := a.accrueOrder(viaEscrow.ID); err != nil {
+		t.Fatal(err)
+	}
+	var accruals int
+	a.db.QueryRow(`SELECT COUNT(*) FROM contractor_accruals`).Scan(&accruals)
+	if accruals != 2 {
+		t.Errorf("начислений %d, want 2", accruals)
+	}
+	assertLedgerBalanced(t, a)
+}
+
+func TestPayoutBatchLifecycle(t *testing.T) {
+	a := newTestApp(t)
+	t.Setenv("COMMISSION_PERCENT", "10")
+	t.Setenv("ADMIN_API_KEY_HASHES", hashAPIKey("test-admin-key"))
+	clientID := testUser(t, a, 111, "client")
+	paid := testPayoutContractor(t, a, 333)
+	owing := testPayoutContractor(t, a, 444)
+	noDetails := testUser(t, a, 555, "contractor")
+	if _, err := a.db.Exec(`INSERT INTO contractor_profiles (user_id, categories, verification_status) VALUES (?, '[]', ?)`, noDetails, VerificationVerified); err != nil {
+		t.Fatal(err)
+	}
+
+	price := orderPrice(testOrder(t, a, clientID, 20))
+	first := completedOrder(t, a, clientID, paid, price)
+	second := completedOrder(t, a, clientID, paid, price/2)
+	// Комиссия по заказу, оплаченному напрямую, вычитается из той же выплаты
+	direct := completedOrder(t, a, clientID, paid, 0)
+	completedOrder(t, a, clientID, owing, 0)
+	completedOrder(t, a, clientID, noDetails, price)
+	want := orderAccrual(t, a, first.ID).Net + orderAccrual(t, a, second.ID).Net + orderAccrual(t, a, direct.ID).Net
+
+	type batchResponse struct {
+		Batch *PayoutBatch `json:"batch"`
+	}
+	createBatch := func() (int, *PayoutBatch) {
+		t.Helper()
+		var resp batchResponse
+		code := doJSON(t, "POST", "/api/admin/payouts/batches", nil, &resp, "X-API-Key", "test-admin-key")
+		return code, resp.Batch
+	}
+	setStatus := func(batch *PayoutBatch, status string) int {
+		t.Helper()
+		return doJSON(t, "POST", fmt.Sprintf("/api/admin/payouts/batches/%d/status", batch.ID), map[string]string{"status": status}, nil, "X-API-Key", "test-admin-key")
+	}
+
+	// В реестр попадает только бригадир с реквизитами и положительной суммой
+	code, batch := createBatch()
+	if code != http.StatusOK {
+		t.Fatalf("создание реестра: статус %d", code)
+	}
+	if len(batch.Payouts) != 1 || batch.Payouts[0].ContractorID != paid || batch.Payouts[0].Amount != want || batch.Total != want || batch.Status != PayoutBatchDraft {
+		t.Fatalf("реестр %+v, want одну выплату на %d", batch, want)
+	}
+	if code, _ := createBatch(); code != http.StatusNotFound {
+		t.Errorf("повторный реестр: статус %d, want 404", code)
+	}
+
+	// Отказ банка возвращает начисления в очередь без проводок
+	if code := setStatus(batch, "cancelled"); code != http.StatusBadRequest {
+		t.Errorf("неизвестный статус: %d, want 400", code)
+	}
+	if code := setStatus(batch, PayoutBatchFailed); code != http.StatusOK {
+		t.Fatalf("отказ: статус %d", code)
+	}
+	if code := setStatus(batch, PayoutBatchPaid); code != http.StatusConflict {
+		t.Errorf("закрытый реестр: статус %d, want 409", code)
+	}
+	if accrual := orderAccrual(t, a, first.ID); accrual.Status != AccrualAccrued || accrual.PayoutID != nil {
+		t.Errorf("начисление после отказа: %+v", accrual)
+	}
+	if got := balanceOf(t, a, payoutCashAccount); got != 0 {
+		t.Errorf("после отказа проведено выплат на %d", -got)
+	}
+
+	// Новый реестр с теми же начислениями оплачивается
+	code, batch = createBatch()
+	if code != http.StatusOK || batch.Total != want {
+		t.Fatalf("реестр после отказа: %d %+v", code, batch)
+	}
+	before := balanceOf(t, a, contractorAccount(paid))
+	if code := setStatus(batch, PayoutBatchPaid); code != http.StatusOK {
+		t.Fatalf("оплата: статус %d", code)
+	}
+	for _, order := range []*Order{first, second, direct} {
+		if accrual := orderAccrual(t, a, order.ID); accrual.Status != AccrualPaid || accrual.PayoutID == nil || *accrual.PayoutID != batch.Payouts[0].ID {
+			t.Errorf("начисление заказа %d после оплаты: %+v", order.ID, accrual)
+		}
+	}
+	if got := balanceOf(t, a, contractorAccount(paid)); got != before-want {
+		t.Errorf("счет бригадира %d, want %d", got, before-want)
+	}
+	if got := balanceOf(t, a, payoutCashAccount); got != want {
+		t.Errorf("выплачено %d, want %d", got, want)
+	}
+	if code := setStatus(batch, PayoutBatchFailed); code != http.StatusConflict {
+		t.Errorf("отказ по оплаченному реестру: статус %d, want 409", code)
+	}
+	if code, _ := createBatch(); code != http.StatusNotFound {
+		t.Errorf("реестр после оплаты: статус %d, want 404", code)
+	}
+	assertLedgerBalanced(t, a)
+}
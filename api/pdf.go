@@ -0,0 +1,535 @@
+package handler
+
+import (
+	"bytes"
+	"compress/zlib"
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// Шрифт с кириллицей встраивается в каждый документ: на сервере нет системных
+// шрифтов, а у клиента их набор неизвестен. Лицензия - fonts/LICENSE.
+//
+//go:embed fonts/DejaVuSans.ttf
+var dejaVuSans []byte
+
+var (
+	documentFontOnce sync.Once
+	documentFont     *ttfFont
+	documentFontErr  error
+)
+
+func loadDocumentFont() (*ttfFont, error) {
+	documentFontOnce.Do(func() {
+		documentFont, documentFontErr = parseTTF(dejaVuSans)
+	})
+	return documentFont, documentFontErr
+}
+
+// ttfFont - минимальный разбор TrueType: карта символов, ширины глифов и
+// таблицы, нужные для встраивания подмножества шрифта в PDF
+type ttfFont struct {
+	tables     map[string][]byte
+	unitsPerEm int
+	ascent     int
+	descent    int
+	bbox       [4]int
+	advances   []uint16
+	glyphs     map[rune]uint16
+	numGlyphs  int
+}
+
+func parseTTF(data []byte) (*ttfFont, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("шрифт поврежден")
+	}
+	font := &ttfFont{tables: map[string][]byte{}}
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		record := data[12+16*i:]
+		offset := binary.BigEndian.Uint32(record[8:])
+		length := binary.BigEndian.Uint32(record[12:])
+		if uint64(offset)+uint64(length) > uint64(len(data)) {
+			return nil, fmt.Errorf("шрифт поврежден: таблица %s", record[:4])
+		}
+		font.tables[string(record[:4])] = data[offset : offset+length]
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "maxp", "loca", "glyf", "cmap"} {
+		if font.tables[tag] == nil {
+			return nil, fmt.Errorf("в шрифте нет таблицы %s", tag)
+		}
+	}
+
+	head := font.tables["head"]
+	font.unitsPerEm = int(binary.BigEndian.Uint16(head[18:]))
+	for i := range font.bbox {
+		font.bbox[i] = int(int16(binary.BigEndian.Uint16(head[36+2*i:])))
+	}
+	hhea := font.tables["hhea"]
+	font.ascent = int(int16(binary.BigEndian.Uint16(hhea[4:])))
+	font.descent = int(int16(binary.BigEndian.Uint16(hhea[6:])))
+	numHMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	font.numGlyphs = int(binary.BigEndian.Uint16(font.tables["maxp"][4:]))
+
+	hmtx := font.tables["hmtx"]
+	font.advances = make([]uint16, font.numGlyphs)
+	for i := range font.advances {
+		if i < numHMetrics {
+			font.advances[i] = binary.BigEndian.Uint16(hmtx[4*i:])
+		} else {
+			font.advances[i] = font.advances[numHMetrics-1]
+		}
+	}
+
+	glyphs, err := parseCmap(font.tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+	font.glyphs = glyphs
+	return font, nil
+}
+
+// parseCmap читает подтаблицу Unicode BMP (платформа 3, кодировка 1, формат 4)
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	numTables := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < numTables; i++ {
+		record := cmap[4+8*i:]
+		platform, encoding := binary.BigEndian.Uint16(record), binary.BigEndian.Uint16(record[2:])
+		sub := cmap[binary.BigEndian.Uint32(record[4:]):]
+		if platform != 3 || encoding != 1 || binary.BigEndian.Uint16(sub) != 4 {
+			continue
+		}
+		segCount := int(binary.BigEndian.Uint16(sub[6:])) / 2
+		endCodes := sub[14:]
+		startCodes := sub[16+2*segCount:]
+		deltas := sub[16+4*segCount:]
+		rangeOffsets := sub[16+6*segCount:]
+
+		glyphs := map[rune]uint16{}
+		for s := 0; s < segCount; s++ {
+			start := int(binary.BigEndian.Uint16(startCodes[2*s:]))
+			end := int(binary.BigEndian.Uint16(endCodes[2*s:]))
+			delta := binary.BigEndian.Uint16(deltas[2*s:])
+			rangeOffset := int(binary.BigEndian.Uint16(rangeOffsets[2*s:]))
+			for c := start; c <= end && c != 0xFFFF; c++ {
+				var glyph uint16
+				if rangeOffset == 0 {
+					glyph = uint16(c) + delta
+				} else {
+					// Смещение считается от позиции самого idRangeOffset
+					pos := 2*s + rangeOffset + 2*(c-start)
+					if pos+2 > len(rangeOffsets) {
+						continue
+					}
+					if glyph = binary.BigEndian.Uint16(rangeOffsets[pos:]); glyph != 0 {
+						glyph += delta
+					}
+				}
+				if glyph != 0 {
+					glyphs[rune(c)] = glyph
+				}
+			}
+		}
+		return glyphs, nil
+	}
+	return nil, fmt.Errorf("в шрифте нет карты символов Unicode")
+}
+
+// glyphOffsets - границы глифов из таблицы loca
+func (f *ttfFont) glyphOffsets() []uint32 {
+	loca := f.tables["loca"]
+	long := binary.BigEndian.Uint16(f.tables["head"][50:]) == 1
+	offsets := make([]uint32, f.numGlyphs+1)
+	for i := range offsets {
+		if long {
+			offsets[i] = binary.BigEndian.Uint32(loca[4*i:])
+		} else {
+			offsets[i] = uint32(binary.BigEndian.Uint16(loca[2*i:])) * 2
+		}
+	}
+	return offsets
+}
+
+// subset собирает шрифт, в котором оставлены только использованные глифы
+// (и составные части составных глифов). Номера глифов не меняются, поэтому
+// текст в PDF можно кодировать исходными номерами.
+func (f *ttfFont) subset(used map[uint16]bool) []byte {
+	offsets := f.glyphOffsets()
+	glyf := f.tables["glyf"]
+	keep := map[uint16]bool{}
+	var visit func(gid uint16)
+	visit = func(gid uint16) {
+		if keep[gid] || int(gid) >= f.numGlyphs {
+			return
+		}
+		keep[gid] = true
+		data := glyf[offsets[gid]:offsets[gid+1]]
+		if len(data) < 10 || int16(binary.BigEndian.Uint16(data)) >= 0 {
+			return
+		}
+		// Составной глиф: обходим компоненты
+		for pos := 10; pos+4 <= len(data); {
+			flags := binary.BigEndian.Uint16(data[pos:])
+			visit(binary.BigEndian.Uint16(data[pos+2:]))
+			pos += 4
+			if flags&0x0001 != 0 {
+				pos += 4
+			} else {
+				pos += 2
+			}
+			switch {
+			case flags&0x0008 != 0:
+				pos += 2
+			case flags&0x0040 != 0:
+				pos += 4
+			case flags&0x0080 != 0:
+				pos += 8
+			}
+			if flags&0x0020 == 0 {
+				break
+			}
+		}
+	}
+	visit(0)
+	for gid := range used {
+		visit(gid)
+	}
+
+	var newGlyf bytes.Buffer
+	newLoca := make([]byte, 4*(f.numGlyphs+1))
+	for gid := 0; gid < f.numGlyphs; gid++ {
+		binary.BigEndian.PutUint32(newLoca[4*gid:], uint32(newGlyf.Len()))
+		if keep[uint16(gid)] {
+			newGlyf.Write(glyf[offsets[gid]:offsets[gid+1]])
+			for newGlyf.Len()%4 != 0 {
+				newGlyf.WriteByte(0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(newLoca[4*f.numGlyphs:], uint32(newGlyf.Len()))
+
+	head := append([]byte(nil), f.tables["head"]...)
+	binary.BigEndian.PutUint32(head[8:], 0)  // checkSumAdjustment
+	binary.BigEndian.PutUint16(head[50:], 1) // loca в длинном формате
+
+	tables := map[string][]byte{
+		"head": head, "hhea": f.tables["hhea"], "hmtx": f.tables["hmtx"], "maxp": f.tables["maxp"],
+		"loca": newLoca, "glyf": newGlyf.Bytes(),
+	}
+	// Хинтинг нужен для отрисовки мелкого текста, cmap и OS/2 - для строгих просмотрщиков
+	for _, tag := range []string{"cvt ", "fpgm", "prep", "cmap", "OS/2"} {
+		if f.tables[tag] != nil {
+			tables[tag] = f.tables[tag]
+		}
+	}
+	return buildTTF(tables)
+}
+
+func buildTTF(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	var out bytes.Buffer
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header, 0x00010000)
+	binary.BigEndian.PutUint16(header[4:], uint16(len(tags)))
+	out.Write(header)
+	offset := 12 + 16*len(tags)
+	for _, tag := range tags {
+		data := tables[tag]
+		record := make([]byte, 16)
+		copy(record, tag)
+		binary.BigEndian.PutUint32(record[4:], ttfChecksum(data))
+		binary.BigEndian.PutUint32(record[8:], uint32(offset))
+		binary.BigEndian.PutUint32(record[12:], uint32(len(data)))
+		out.Write(record)
+		offset += (len(data) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		out.Write(tables[tag])
+		for out.Len()%4 != 0 {
+			out.WriteByte(0)
+		}
+	}
+	return out.Bytes()
+}
+
+func ttfChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
+
+// Размер страницы A4 и поля, в пунктах
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 56.0
+)
+
+// pdfDocument - простой генератор PDF: текст одним встроенным шрифтом,
+// переносы по словам и линии. Хватает для договоров и актов.
+type pdfDocument struct {
+	font  *ttfFont
+	pages []*bytes.Buffer
+	used  map[uint16]bool
+	// Текущая позиция вывода сверху вниз
+	y float64
+}
+
+func newPDFDocument() (*pdfDocument, error) {
+	font, err := loadDocumentFont()
+	if err != nil {
+		return nil, err
+	}
+	d := &pdfDocument{font: font, used: map[uint16]bool{}}
+	d.addPage()
+	return d, nil
+}
+
+func (d *pdfDocument) addPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfMargin
+}
+
+func (d *pdfDocument) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+func (d *pdfDocument) textWidth(s string, size float64) float64 {
+	var width float64
+	for _, r := range s {
+		width += float64(d.font.advances[d.font.glyphs[r]])
+	}
+	return width * size / float64(d.font.unitsPerEm)
+}
+
+// text выводит строку с левого нижнего угла (x, y) без переносов
+func (d *pdfDocument) text(x, y, size float64, s string) {
+	var hex strings.Builder
+	for _, r := range s {
+		gid := d.font.glyphs[r]
+		d.used[gid] = true
+		fmt.Fprintf(&hex, "%04X", gid)
+	}
+	fmt.Fprintf(d.page(), "BT /F1 %.1f Tf %.2f %.2f Td <%s> Tj ET\n", size, x, y, hex.String())
+}
+
+func (d *pdfDocument) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.page(), "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// ensure начинает новую страницу, если до нижнего поля осталось меньше height
+func (d *pdfDocument) ensure(height float64) {
+	if d.y-height < pdfMargin {
+		d.addPage()
+	}
+}
+
+func (d *pdfDocument) space(height float64) {
+	d.y -= height
+}
+
+// wrap разбивает текст на строки не шире width
+func (d *pdfDocument) wrap(s string, size, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(s, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if line != "" && d.textWidth(candidate, size) > width {
+				lines = append(lines, line)
+				candidate = word
+			}
+			line = candidate
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// paragraph выводит текст с переносами по словам от левого поля
+func (d *pdfDocument) paragraph(s string, size float64) {
+	leading := size * 1.4
+	for _, line := range d.wrap(s, size, pdfPageWidth-2*pdfMargin) {
+		d.ensure(leading)
+		d.y -= leading
+		d.text(pdfMargin, d.y, size, line)
+	}
+}
+
+// heading - строка по центру страницы
+func (d *pdfDocument) heading(s string, size float64) {
+	leading := size * 1.5
+	d.ensure(leading)
+	d.y -= leading
+	d.text((pdfPageWidth-d.textWidth(s, size))/2, d.y, size, s)
+}
+
+// tableRow выводит строку таблицы с рамкой; ширины колонок - доли ширины страницы
+func (d *pdfDocument) tableRow(cells []string, widths []float64, size float64) {
+	const padding = 4.0
+	total := pdfPageWidth - 2*pdfMargin
+	leading := size * 1.3
+	wrapped := make([][]string, len(cells))
+	rows := 1
+	for i, cell := range cells {
+		wrapped[i] = d.wrap(cell, size, widths[i]*total-2*padding)
+		rows = max(rows, len(wrapped[i]))
+	}
+	height := float64(rows)*leading + 2*padding
+	d.ensure(height)
+	top := d.y
+	x := pdfMargin
+	for i, lines := range wrapped {
+		for j, line := range lines {
+			if line == "" {
+				continue
+			}
+			d.text(x+padding, top-padding-float64(j+1)*leading+size*0.3, size, line)
+		}
+		d.line(x, top, x, top-height)
+		x += widths[i] * total
+	}
+	d.line(x, top, x, top-height)
+	d.line(pdfMargin, top, x, top)
+	d.line(pdfMargin, top-height, x, top-height)
+	d.y = top - height
+}
+
+// bytes собирает файл PDF
+func (d *pdfDocument) bytes() ([]byte, error) {
+	var out bytes.Buffer
+	var offsets []int
+	// Номера объектов: 1 - каталог, 2 - дерево страниц, затем шрифт, затем страницы
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	stream := func(dict string, data []byte) error {
+		compressed, err := deflate(data)
+		if err != nil {
+			return err
+		}
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n<< %s /Filter /FlateDecode /Length %d >>\nstream\n", len(offsets), dict, len(compressed))
+		out.Write(compressed)
+		out.WriteString("\nendstream\nendobj\n")
+		return nil
+	}
+
+	const fontObjects = 5
+	firstPage := 3 + fontObjects
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	// Шрифт: Type0 -> CIDFontType2 -> дескриптор -> файл шрифта, плюс ToUnicode для поиска и копирования текста
+	font := d.font
+	scale := func(v int) int { return v * 1000 / font.unitsPerEm }
+	gids := make([]int, 0, len(d.used))
+	for gid := range d.used {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+	var widths strings.Builder
+	for _, gid := range gids {
+		fmt.Fprintf(&widths, "%d [%d] ", gid, scale(int(font.advances[gid])))
+	}
+	object("<< /Type /Font /Subtype /Type0 /BaseFont /PLSTRN+DejaVuSans /Encoding /Identity-H /DescendantFonts [4 0 R] /ToUnicode 7 0 R >>")
+	object(fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /PLSTRN+DejaVuSans /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor 5 0 R /CIDToGIDMap /Identity /DW %d /W [%s] >>",
+		scale(int(font.advances[0])), widths.String()))
+	object(fmt.Sprintf("<< /Type /FontDescriptor /FontName /PLSTRN+DejaVuSans /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 6 0 R >>",
+		scale(font.bbox[0]), scale(font.bbox[1]), scale(font.bbox[2]), scale(font.bbox[3]), scale(font.ascent), scale(font.descent), scale(font.ascent)))
+	fontFile := font.subset(d.used)
+	if err := stream(fmt.Sprintf("/Length1 %d", len(fontFile)), fontFile); err != nil {
+		return nil, err
+	}
+	if err := stream("", d.toUnicode(gids)); err != nil {
+		return nil, err
+	}
+
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, firstPage+2*i+1))
+		if err := stream("", content.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes(), nil
+}
+
+// toUnicode - CMap соответствия глифов символам
+func (d *pdfDocument) toUnicode(gids []int) []byte {
+	runes := map[uint16]rune{}
+	for r, gid := range d.font.glyphs {
+		if d.used[gid] {
+			if prev, ok := runes[gid]; !ok || r < prev {
+				runes[gid] = r
+			}
+		}
+	}
+	var cmap bytes.Buffer
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	var entries []string
+	for _, gid := range gids {
+		r, ok := runes[uint16(gid)]
+		if !ok {
+			continue
+		}
+		var hex strings.Builder
+		for _, unit := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&hex, "%04X", unit)
+		}
+		entries = append(entries, fmt.Sprintf("<%04X> <%s>", gid, hex.String()))
+	}
+	// Не больше 100 записей в одном блоке
+	for start := 0; start < len(entries); start += 100 {
+		end := min(start+100, len(entries))
+		fmt.Fprintf(&cmap, "%d beginbfchar\n%s\nendbfchar\n", end-start, strings.Join(entries[start:end], "\n"))
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return cmap.Bytes()
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
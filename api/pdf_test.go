@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTTFSubset(t *testing.T) {
+	font, err := loadDocumentFont()
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := map[uint16]bool{}
+	for _, r := range "Акт №1 Ёё" {
+		gid, ok := font.glyphs[r]
+		if !ok {
+			t.Fatalf("в шрифте нет символа %q", r)
+		}
+		used[gid] = true
+	}
+	// Составной глиф: его компоненты должны попасть в подмножество без явного запроса
+	offsets := font.glyphOffsets()
+	glyf := font.tables["glyf"]
+	composite := -1
+	for gid := 0; gid < font.numGlyphs && composite < 0; gid++ {
+		data := glyf[offsets[gid]:offsets[gid+1]]
+		if len(data) >= 10 && int16(binary.BigEndian.Uint16(data)) < 0 {
+			composite = gid
+		}
+	}
+	if composite < 0 {
+		t.Fatal("в шрифте нет составных глифов")
+	}
+	used[uint16(composite)] = true
+	firstComponent := binary.BigEndian.Uint16(glyf[offsets[composite]+12:])
+
+	data := font.subset(used)
+	sub, err := parseTTF(data)
+	if err != nil {
+		t.Fatalf("подмножество не читается: %v", err)
+	}
+	if sub.numGlyphs != font.numGlyphs || len(sub.glyphs) != len(font.glyphs) {
+		t.Fatalf("номера глифов изменились: %d глифов, %d символов", sub.numGlyphs, len(sub.glyphs))
+	}
+
+	// Оглавление: выравнивание и контрольные суммы таблиц
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		record := data[12+16*i:]
+		offset, length := binary.BigEndian.Uint32(record[8:]), binary.BigEndian.Uint32(record[12:])
+		if offset%4 != 0 {
+			t.Errorf("таблица %s не выровнена: %d", record[:4], offset)
+		}
+		if got := ttfChecksum(data[offset : offset+length]); got != binary.BigEndian.Uint32(record[4:]) {
+			t.Errorf("контрольная сумма таблицы %s не сошлась", record[:4])
+		}
+	}
+
+	subOffsets := sub.glyphOffsets()
+	subGlyf := sub.tables["glyf"]
+	glyph := func(offs []uint32, table []byte, gid uint16) []byte {
+		return bytes.TrimRight(table[offs[gid]:offs[gid+1]], "\x00")
+	}
+	for _, gid := range []uint16{0, font.glyphs['А'], font.glyphs['№'], uint16(composite), firstComponent} {
+		if !bytes.Equal(glyph(subOffsets, subGlyf, gid), glyph(offsets, glyf, gid)) {
+			t.Errorf("глиф %d изменился или пропал", gid)
+		}
+	}
+	if gid := font.glyphs['Z']; subOffsets[gid] != subOffsets[gid+1] {
+		t.Errorf("неиспользованный глиф %d остался в подмножестве", gid)
+	}
+	if len(data) >= len(dejaVuSans)/4 {
+		t.Errorf("подмножество %d байт, исходный шрифт %d", len(data), len(dejaVuSans))
+	}
+}
+
+func TestPDFDocument(t *testing.T) {
+	d, err := newPDFDocument()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.heading("АКТ выполненных работ", 14)
+	d.tableRow([]string{"№", "Наименование работ", "Сумма"}, []float64{0.1, 0.6, 0.3}, 10)
+	// Достаточно текста, чтобы перенестись на вторую страницу
+	for i := 0; i < 80; i++ {
+		d.paragraph(fmt.Sprintf("%d. Подготовка основания пола, выравнивание стяжки и укладка покрытия.", i+1), 10)
+	}
+	if len(d.pages) < 2 {
+		t.Fatalf("страниц %d, want не меньше 2", len(d.pages))
+	}
+	data, err := d.bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("нет заголовка или конца файла PDF")
+	}
+
+	// Таблица xref указывает на начала объектов
+	tail := data[bytes.LastIndex(data, []byte("startxref\n"))+len("startxref\n"):]
+	xref, err := strconv.Atoi(string(tail[:bytes.IndexByte(tail, '\n')]))
+	if err != nil || !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d не указывает на xref", xref)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[xref:], -1)
+	if want := 2 + 5 + 2*len(d.pages); len(entries) != want {
+		t.Fatalf("объектов в xref %d, want %d", len(entries), want)
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if !bytes.HasPrefix(data[offset:], []byte(fmt.Sprintf("%d 0 obj\n", i+1))) {
+			t.Errorf("смещение объекта %d в xref неверное", i+1)
+		}
+	}
+	if !bytes.Contains(data, []byte(fmt.Sprintf("/Count %d", len(d.pages)))) {
+		t.Error("число страниц в дереве страниц не совпадает")
+	}
+
+	// Потоки распаковываются, текст закодирован номерами глифов,
+	// ToUnicode возвращает из них исходные символы
+	streams := regexp.MustCompile(`(?s)/Length (\d+) >>\nstream\n`).FindAllSubmatchIndex(data, -1)
+	var contents []string
+	for _, m := range streams {
+		length, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+		r, err := zlib.NewReader(bytes.NewReader(data[m[1] : m[1]+length]))
+		if err != nil {
+			t.Fatalf("поток не распаковывается: %v", err)
+		}
+		plain, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("поток не распаковывается: %v", err)
+		}
+		contents = append(contents, string(plain))
+	}
+	if len(streams) != 2+len(d.pages) {
+		t.Fatalf("потоков %d, want %d", len(streams), 2+len(d.pages))
+	}
+	if _, err := parseTTF([]byte(contents[0])); err != nil {
+		t.Errorf("встроенный шрифт не читается: %v", err)
+	}
+	akt := fmt.Sprintf("<%04X%04X%04X", d.font.glyphs['А'], d.font.glyphs['К'], d.font.glyphs['Т'])
+	if !strings.Contains(contents[2], akt) {
+		t.Errorf("на первой странице нет заголовка %s", akt)
+	}
+	if entry := fmt.Sprintf("<%04X> <%04X>", d.font.glyphs['Ж'], 'Ж'); strings.Contains(contents[1], entry) {
+		t.Error("в ToUnicode попал неиспользованный символ")
+	}
+	if entry := fmt.Sprintf("<%04X> <%04X>", d.font.glyphs['№'], '№'); !strings.Contains(contents[1], entry) {
+		t.Errorf("в ToUnicode нет записи %s", entry)
+	}
+}
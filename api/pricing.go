@@ -0,0 +1,132 @@
+package handler
+
+import "math"
+
+// Quote - стоимость заказа по тарифу, в копейках
+type Quote struct {
+	Category string  `json:"category"`
+	Area     float64 `json:"area"`
+	Min      int64   `json:"min"`
+	Max      int64   `json:"max"`
+	// Скидка по промокоду от верхней границы, уже учтена в Min и Max
+	Discount  int64  `json:"discount,omitempty"`
+	PromoCode string `json:"promo_code,omitempty"`
+}
+
+// quoteOrder считает вилку стоимости работ: площадь × цена за м² из тарифа
+func quoteOrder(category string, area float64) *Quote {
+	tariff, ok := TARIFFS[category]
+	if !ok {
+		return nil
+	}
+	return quoteByPrices(category, area, tariff.PriceRange)
+}
+
+// quoteByPrices - то же по заданной цене за м² (региональной или зафиксированной в заказе)
+func quoteByPrices(category string, area float64, prices PriceRange) *Quote {
+	if _, ok := TARIFFS[category]; !ok || area <= 0 {
+		return nil
+	}
+	return &Quote{
+		Category: category,
+		Area:     area,
+		Min:      int64(math.Round(area * float64(prices.Min) * 100)),
+		Max:      int64(math.Round(area * float64(prices.Max) * 100)),
+	}
+}
+
+// applyDiscount уменьшает вилку на скидку: процент - от каждой границы,
+// фиксированная сумма - от обеих, но не ниже нуля
+func (q *Quote) applyDiscount(d *PromoDiscount) {
+	if d == nil {
+		return
+	}
+	before := q.Max
+	if d.Percent > 0 {
+		q.Min -= int64(math.Round(float64(q.Min) * d.Percent / 100))
+		q.Max -= int64(math.Round(float64(q.Max) * d.Percent / 100))
+	}
+	if d.Amount > 0 {
+		q.Min = max(q.Min-d.Amount, 0)
+		q.Max = max(q.Max-d.Amount, 0)
+	}
+	q.Discount = before - q.Max
+	q.PromoCode = d.Code
+}
+
+// orderPrices - цена за м², зафиксированная в заказе, или текущая национальная цена
+func orderPrices(order *Order) PriceRange {
+	if order.Prices != nil {
+		return *order.Prices
+	}
+	return TARIFFS[order.Category].PriceRange
+}
+
+// OrderAddon - дополнительные работы к заказу (тариф с IsAddon) по цене за м²,
+// зафиксированной при создании заказа. Выбор предложения в торгах меняет
+// только цену основного тарифа.
+type OrderAddon struct {
+	Category string     `json:"category"`
+	Prices   PriceRange `json:"prices"`
+}
+
+// orderAddons проверяет дополнительные работы к тарифу category и фиксирует их
+// цены в регионе. Дополнения добавляются только к основному тарифу и не повторяются.
+func orderAddons(category string, keys []string, region *Region) ([]OrderAddon, bool) {
+	if len(keys) > 0 && TARIFFS[category].IsAddon {
+		return nil, false
+	}
+	var addons []OrderAddon
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if !TARIFFS[key].IsAddon || seen[key] {
+			return nil, false
+		}
+		seen[key] = true
+		addons = append(addons, OrderAddon{Category: key, Prices: region.priceRange(key)})
+	}
+	return addons, true
+}
+
+// addAddons прибавляет к вилке стоимость дополнительных работ на той же площади
+func (q *Quote) addAddons(addons []OrderAddon) {
+	for _, addon := range addons {
+		if extra := quoteByPrices(addon.Category, q.Area, addon.Prices); extra != nil {
+			q.Min += extra.Min
+			q.Max += extra.Max
+		}
+	}
+}
+
+// quoteWithAddons - вилка по основному тарифу и дополнениям со скидкой промокода
+func quoteWithAddons(category string, area float64, prices PriceRange, addons []OrderAddon, promo *PromoDiscount) *Quote {
+	quote := quoteByPrices(category, area, prices)
+	if quote != nil {
+		quote.addAddons(addons)
+		quote.applyDiscount(promo)
+	}
+	return quote
+}
+
+// orderQuote - стоимость заказа по ценам на момент создания с учетом
+// дополнительных работ и промокода. У заказов, созданных до фиксации цен,
+// используется текущая национальная цена.
+func orderQuote(order *Order) *Quote {
+	if order.Area == nil {
+		return nil
+	}
+	return quoteWithAddons(order.Category, *order.Area, orderPrices(order), order.Addons, order.Promo)
+}
+
+// orderPrice - стоимость заказа в копейках. При принятии заказа она
+// фиксируется (fixOrderPrice) и дальше не пересчитывается, до этого -
+// верхняя граница вилки с учетом скидки.
+func orderPrice(order *Order) int64 {
+	if order.Price != nil {
+		return *order.Price
+	}
+	if quote := orderQuote(order); quote != nil {
+		return quote.Max
+	}
+	return 0
+}
@@ -0,0 +1,75 @@
+package handler
+
+import "strings"
+
+// contactsVisible - контакты сторон открываются только после принятия заказа
+func contactsVisible(status string) bool {
+	return status == "accepted" || status == "in_progress" || status == "completed"
+}
+
+// maskPhone оставляет код страны и оператора и две последние цифры: +7 (999) ***-**-33
+func maskPhone(phone string) string {
+	total := 0
+	for _, c := range phone {
+		if c >= '0' && c <= '9' {
+			total++
+		}
+	}
+	var b strings.Builder
+	seen := 0
+	for _, c := range phone {
+		if c >= '0' && c <= '9' {
+			seen++
+			if seen > 4 && seen <= total-2 {
+				b.WriteRune('*')
+				continue
+			}
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func maskPhonePtr(phone *string) *string {
+	if phone == nil {
+		return nil
+	}
+	masked := maskPhone(*phone)
+	return &masked
+}
+
+// redactOrderContacts скрывает телефон и Telegram ID второй стороны, пока заказ
+// не принят, и от всех, кто не участвует в заказе. Если у заказа есть подменный
+// номер, вместо настоящего телефона собеседника показывается он.
+func redactOrderContacts(order *Order, viewer *User) {
+	if isAdmin(viewer) {
+		return
+	}
+	party := ""
+	if viewer != nil {
+		party = orderParty(order, viewer)
+	}
+	visible := contactsVisible(order.Status)
+
+	if party != "client" && !(party == "contractor" && visible) {
+		order.ClientPhone = maskPhonePtr(order.ClientPhone)
+		order.ClientTelegramID = nil
+	} else if party == "contractor" && order.ProxyPhone != nil {
+		order.ClientPhone = nil
+	}
+	if party != "contractor" && !(party == "client" && visible) {
+		order.ContractorPhone = maskPhonePtr(order.ContractorPhone)
+		order.ContractorTelegramID = nil
+	} else if party == "client" && order.ProxyPhone != nil {
+		order.ContractorPhone = nil
+	}
+	if party == "" || !visible {
+		order.ProxyPhone = nil
+	}
+}
+
+func redactOrdersContacts(orders []Order, viewer *User) {
+	for i := range orders {
+		redactOrderContacts(&orders[i], viewer)
+	}
+}
@@ -0,0 +1,417 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Сроки действия промокодов задаются датами по московскому времени
+var promoZone = time.FixedZone("MSK", 3*60*60)
+
+// PromoCode - промокод на скидку: процент (Percent) или фиксированная сумма в
+// копейках (Amount). Пустые ограничения означают "без ограничений".
+type PromoCode struct {
+	ID             int64      `json:"id"`
+	Code           string     `json:"code"`
+	Description    *string    `json:"description"`
+	Percent        *float64   `json:"percent"`
+	Amount         *int64     `json:"amount"`
+	Categories     []string   `json:"categories"`
+	StartsAt       *time.Time `json:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at"`
+	MaxUses        *int       `json:"max_uses"`
+	MaxUsesPerUser *int       `json:"max_uses_per_user"`
+	FirstOrderOnly bool       `json:"first_order_only"`
+	IsActive       bool       `json:"is_active"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// PromoDiscount - скидка, примененная к заказу. Условия копируются в момент
+// применения, чтобы последующая правка промокода не меняла цену заказа.
+type PromoDiscount struct {
+	Code    string  `json:"code"`
+	Percent float64 `json:"percent,omitempty"`
+	Amount  int64   `json:"amount,omitempty"`
+}
+
+func (p *PromoCode) discount() *PromoDiscount {
+	d := &PromoDiscount{Code: p.Code}
+	if p.Percent != nil {
+		d.Percent = *p.Percent
+	}
+	if p.Amount != nil {
+		d.Amount = *p.Amount
+	}
+	return d
+}
+
+// errPromoInvalid - промокод нельзя применить; текст ошибки показывается пользователю
+var errPromoInvalid = errors.New("промокод недействителен")
+
+func promoError(format string, args ...interface{}) error {
+	return &userError{kind: errPromoInvalid, format: format, args: args}
+}
+
+// tariffNames - названия тарифов в тексте ошибки, переводятся вместе с ним
+type tariffNames []string
+
+func (t tariffNames) message(locale string) string {
+	names := make([]string, len(t))
+	for i, category := range t {
+		names[i] = tr(locale, TARIFFS[category].Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+const promoColumns = `id, code, description, percent, amount, categories, starts_at, ends_at, max_uses, max_uses_per_user, first_order_only, is_active, created_at`
+
+func scanPromoCode(row interface{ Scan(...interface{}) error }) (*PromoCode, error) {
+	var p PromoCode
+	var categories, startsAt, endsAt sql.NullString
+	var createdAt string
+	if err := row.Scan(&p.ID, &p.Code, &p.Description, &p.Percent, &p.Amount, &categories, &startsAt, &endsAt, &p.MaxUses, &p.MaxUsesPerUser, &p.FirstOrderOnly, &p.IsActive, &createdAt); err != nil {
+		return nil, err
+	}
+	if categories.Valid && categories.String != "" {
+		json.Unmarshal([]byte(categories.String), &p.Categories)
+	}
+	if startsAt.Valid {
+		if t, err := time.Parse("2006-01-02 15:04:05", startsAt.String); err == nil {
+			p.StartsAt = &t
+		}
+	}
+	if endsAt.Valid {
+		if t, err := time.Parse("2006-01-02 15:04:05", endsAt.String); err == nil {
+			p.EndsAt = &t
+		}
+	}
+	p.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt)
+	return &p, nil
+}
+
+func (app *App) getPromoCode(code string) (*PromoCode, error) {
+	p, err := scanPromoCode(app.db.QueryRow(`SELECT `+promoColumns+` FROM promo_codes WHERE code = ? COLLATE NOCASE`, strings.TrimSpace(code)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// Использования считаются по неотмененным заказам: отмена заказа возвращает промокод
+const promoUsesQuery = `SELECT COUNT(*) FROM promo_redemptions r JOIN orders o ON o.id = r.order_id WHERE r.promo_id = ? AND o.status != 'cancelled'`
+
+// checkPromo проверяет, что промокод можно применить к заказу пользователя.
+// user может быть nil, если пользователь еще не зарегистрирован.
+func (app *App) checkPromo(code string, user *User, category string, now time.Time) (*PromoCode, error) {
+	promo, err := app.getPromoCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if promo == nil || !promo.IsActive {
+		return nil, promoError("промокод не найден")
+	}
+	if promo.StartsAt != nil && now.Before(*promo.StartsAt) {
+		return nil, promoError("промокод начнет действовать %s", promo.StartsAt.In(promoZone).Format("02.01.2006"))
+	}
+	if promo.EndsAt != nil && !now.Before(*promo.EndsAt) {
+		return nil, promoError("срок действия промокода истек")
+	}
+	if len(promo.Categories) > 0 {
+		allowed := false
+		for _, c := range promo.Categories {
+			allowed = allowed || c == category
+		}
+		if !allowed {
+			return nil, promoError("промокод действует только на тарифы %s", tariffNames(promo.Categories))
+		}
+	}
+	if promo.MaxUses != nil {
+		var uses int
+		if err := app.db.QueryRow(promoUsesQuery, promo.ID).Scan(&uses); err != nil {
+			return nil, err
+		}
+		if uses >= *promo.MaxUses {
+			return nil, promoError("промокод закончился")
+		}
+	}
+	if user == nil {
+		return promo, nil
+	}
+	if promo.MaxUsesPerUser != nil {
+		var uses int
+		if err := app.db.QueryRow(promoUsesQuery+` AND r.user_id = ?`, promo.ID, user.ID).Scan(&uses); err != nil {
+			return nil, err
+		}
+		if uses >= *promo.MaxUsesPerUser {
+			return nil, promoError("вы уже использовали этот промокод")
+		}
+	}
+	if promo.FirstOrderOnly {
+		var orders int
+		if err := app.db.QueryRow(`SELECT COUNT(*) FROM orders WHERE client_id = ? AND status != 'cancelled'`, user.ID).Scan(&orders); err != nil {
+			return nil, err
+		}
+		if orders > 0 {
+			return nil, promoError("промокод действует только на первый заказ")
+		}
+	}
+	return promo, nil
+}
+
+// promoEarlierOrdersQuery - незавершенные заказы клиента, созданные раньше данного
+const promoEarlierOrdersQuery = `SELECT 1 FROM orders WHERE client_id = ? AND status != 'cancelled' AND id < ?`
+
+// redeemPromo закрепляет скидку за заказом. Лимиты и условие первого заказа
+// проверяются в том же запросе, поэтому одновременные заказы не обойдут их.
+// Если скидку закрепить нельзя, возвращает ошибку errPromoInvalid.
+func (app *App) redeemPromo(promo *PromoCode, userID, orderID int64, quote *Quote) error {
+	d := promo.discount()
+	var discount int64
+	if quote != nil {
+		discount = quote.Discount
+	}
+	result, err := app.db.Exec(`INSERT INTO promo_redemptions (promo_id, user_id, order_id, code, percent, amount, discount)
+		SELECT ?, ?, ?, ?, ?, ?, ?
+		WHERE (? IS NULL OR (`+promoUsesQuery+`) < ?)
+			AND (? IS NULL OR (`+promoUsesQuery+` AND r.user_id = ?) < ?)
+			AND (? = 0 OR NOT EXISTS (`+promoEarlierOrdersQuery+`))`,
+		promo.ID, userID, orderID, promo.Code, d.Percent, d.Amount, discount,
+		promo.MaxUses, promo.ID, promo.MaxUses,
+		promo.MaxUsesPerUser, promo.ID, userID, promo.MaxUsesPerUser,
+		promo.FirstOrderOnly, userID, orderID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected > 0 {
+		return err
+	}
+	if promo.FirstOrderOnly {
+		var earlier bool
+		if err := app.db.QueryRow(`SELECT EXISTS (`+promoEarlierOrdersQuery+`)`, userID, orderID).Scan(&earlier); err != nil {
+			return err
+		}
+		if earlier {
+			return promoError("промокод действует только на первый заказ")
+		}
+	}
+	return promoError("промокод закончился")
+}
+
+// getOrderPromo - скидка, примененная к заказу, или nil
+func (app *App) getOrderPromo(orderID int64) (*PromoDiscount, error) {
+	var d PromoDiscount
+	err := app.db.QueryRow(`SELECT code, percent, amount FROM promo_redemptions WHERE order_id = ?`, orderID).Scan(&d.Code, &d.Percent, &d.Amount)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// handleValidatePromo - проверка промокода и расчет стоимости со скидкой до создания заказа
+func (app *App) handleValidatePromo(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code     string   `json:"code"`
+		Category string   `json:"category"`
+		Area     *float64 `json:"area"`
+		Region   *string  `json:"region"`
+		Addons   []string `json:"addons"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	if _, ok := TARIFFS[req.Category]; !ok {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Неизвестный тариф")
+		return
+	}
+	// Пользователь необязателен: без него не проверяются ограничения на пользователя
+	user := requestCaller(r).user
+	if req.Region == nil && user != nil {
+		req.Region = user.Region
+	}
+	region, err := app.resolveRegion(req.Region)
+	if err != nil {
+		writeUserError(w, http.StatusBadRequest, CodeInvalidRegion, err)
+		return
+	}
+	addons, ok := orderAddons(req.Category, req.Addons, region)
+	if !ok {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Неверные дополнительные работы")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	promo, err := app.checkPromo(req.Code, user, req.Category, time.Now().UTC())
+	if message, ok := userErrorMessage(w, err); ok && errors.Is(err, errPromoInvalid) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"valid": false, "error": message})
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	response := map[string]interface{}{"valid": true, "discount": promo.discount()}
+	if req.Area != nil {
+		if quote := quoteWithAddons(req.Category, *req.Area, region.priceRange(req.Category), addons, promo.discount()); quote != nil {
+			response["quote"] = quote
+		}
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// parsePromoDate разбирает дату YYYY-MM-DD по московскому времени; endOfDay сдвигает на начало следующих суток
+func parsePromoDate(value *string, endOfDay bool) (interface{}, error) {
+	if value == nil || *value == "" {
+		return nil, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", *value, promoZone)
+	if err != nil {
+		return nil, newUserError("неверная дата %s, ожидается YYYY-MM-DD", *value)
+	}
+	if endOfDay {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day.UTC().Format("2006-01-02 15:04:05"), nil
+}
+
+// handleSavePromo создает промокод или обновляет существующий с тем же кодом (только администратор)
+func (app *App) handleSavePromo(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code           string   `json:"code"`
+		Description    *string  `json:"description"`
+		Percent        *float64 `json:"percent"`
+		Amount         *int64   `json:"amount"`
+		Categories     []string `json:"categories"`
+		ValidFrom      *string  `json:"valid_from"`
+		ValidTo        *string  `json:"valid_to"`
+		MaxUses        *int     `json:"max_uses"`
+		MaxUsesPerUser *int     `json:"max_uses_per_user"`
+		FirstOrderOnly bool     `json:"first_order_only"`
+		IsActive       *bool    `json:"is_active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	req.Code = strings.TrimSpace(req.Code)
+	if req.Code == "" {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Не указан код")
+		return
+	}
+	if (req.Percent == nil) == (req.Amount == nil) {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Укажите скидку в процентах (percent) или суммой в копейках (amount)")
+		return
+	}
+	if req.Percent != nil && (*req.Percent <= 0 || *req.Percent > 100) {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Процент скидки должен быть от 0 до 100")
+		return
+	}
+	if req.Amount != nil && *req.Amount <= 0 {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Сумма скидки должна быть положительной")
+		return
+	}
+	for _, c := range req.Categories {
+		if _, ok := TARIFFS[c]; !ok {
+			writeError(w, http.StatusBadRequest, CodeValidation, trf(responseLocale(w), "Неизвестный тариф: %s", c))
+			return
+		}
+	}
+	startsAt, err := parsePromoDate(req.ValidFrom, false)
+	if err != nil {
+		writeUserError(w, http.StatusBadRequest, CodeValidation, err)
+		return
+	}
+	endsAt, err := parsePromoDate(req.ValidTo, true)
+	if err != nil {
+		writeUserError(w, http.StatusBadRequest, CodeValidation, err)
+		return
+	}
+	var categories interface{}
+	if len(req.Categories) > 0 {
+		data, _ := json.Marshal(req.Categories)
+		categories = string(data)
+	}
+	isActive := req.IsActive == nil || *req.IsActive
+
+	_, err = app.db.Exec(`INSERT INTO promo_codes (code, description, percent, amount, categories, starts_at, ends_at, max_uses, max_uses_per_user, first_order_only, is_active)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(code) DO UPDATE SET description = excluded.description, percent = excluded.percent, amount = excluded.amount,
+			categories = excluded.categories, starts_at = excluded.starts_at, ends_at = excluded.ends_at, max_uses = excluded.max_uses,
+			max_uses_per_user = excluded.max_uses_per_user, first_order_only = excluded.first_order_only, is_active = excluded.is_active`,
+		req.Code, req.Description, req.Percent, req.Amount, categories, startsAt, endsAt, req.MaxUses, req.MaxUsesPerUser, req.FirstOrderOnly, isActive)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	promo, err := app.getPromoCode(req.Code)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	app.audit(r.Context(), "promo.save", "promo", promo.ID, nil, req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"promo": promo})
+}
+
+// handleGetPromoReport - промокоды со статистикой использования (только администратор)
+func (app *App) handleGetPromoReport(w http.ResponseWriter, r *http.Request) {
+	type promoStats struct {
+		*PromoCode
+		Redemptions     int   `json:"redemptions"`
+		ActiveOrders    int   `json:"active_orders"`
+		CompletedOrders int   `json:"completed_orders"`
+		DiscountTotal   int64 `json:"discount_total"`
+	}
+	rows, err := app.db.Query(`SELECT ` + promoColumns + ` FROM promo_codes ORDER BY id DESC`)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	report := []*promoStats{}
+	byID := map[int64]*promoStats{}
+	for rows.Next() {
+		promo, err := scanPromoCode(rows)
+		if err != nil {
+			rows.Close()
+			internalError(w, err)
+			return
+		}
+		s := &promoStats{PromoCode: promo}
+		report = append(report, s)
+		byID[promo.ID] = s
+	}
+	rows.Close()
+
+	rows, err = app.db.Query(`SELECT r.promo_id, COUNT(*),
+			SUM(CASE WHEN o.status != 'cancelled' THEN 1 ELSE 0 END),
+			SUM(CASE WHEN o.status = 'completed' THEN 1 ELSE 0 END),
+			SUM(CASE WHEN o.status != 'cancelled' THEN r.discount ELSE 0 END)
+		FROM promo_redemptions r JOIN orders o ON o.id = r.order_id
+		GROUP BY r.promo_id`)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var promoID int64
+		var stats promoStats
+		if err := rows.Scan(&promoID, &stats.Redemptions, &stats.ActiveOrders, &stats.CompletedOrders, &stats.DiscountTotal); err != nil {
+			internalError(w, err)
+			return
+		}
+		if s, ok := byID[promoID]; ok {
+			stats.PromoCode = s.PromoCode
+			*s = stats
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"promo_codes": report})
+}
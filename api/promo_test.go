@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRedeemFirstOrderOnlyPromo(t *testing.T) {
+	a := newTestApp(t)
+	if _, err := a.db.Exec(`INSERT INTO promo_codes (code, percent, first_order_only) VALUES ('FIRST', 10, 1)`); err != nil {
+		t.Fatal(err)
+	}
+	promo, err := a.getPromoCode("FIRST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID := testUser(t, a, 111, "client")
+	// Два заказа созданы одновременно: оба прошли checkPromo до появления другого
+	first := testOrder(t, a, clientID, 20)
+	second := testOrder(t, a, clientID, 20)
+
+	err = a.redeemPromo(promo, clientID, second.ID, nil)
+	if !errors.Is(err, errPromoInvalid) || err.Error() != "промокод действует только на первый заказ" {
+		t.Fatalf("второй заказ: %v, want отказ по первому заказу", err)
+	}
+	if err := a.redeemPromo(promo, clientID, first.ID, nil); err != nil {
+		t.Fatalf("первый заказ: %v", err)
+	}
+
+	// Отмененные заказы не считаются
+	other := testUser(t, a, 222, "client")
+	cancelled := testOrder(t, a, other, 20)
+	if _, err := a.db.Exec(`UPDATE orders SET status = 'cancelled' WHERE id = ?`, cancelled.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.redeemPromo(promo, other, testOrder(t, a, other, 20).ID, nil); err != nil {
+		t.Errorf("заказ после отмененного: %v", err)
+	}
+}
@@ -0,0 +1,549 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Чеки по 54-ФЗ. Чек формируется из позиций заказа, регистрируется у
+// провайдера фискализации (облачной кассы), а его реквизиты сохраняются и
+// отправляются клиенту ботом.
+//
+// Предоплата оформляется чеком прихода с признаком "предоплата 100%" (или
+// "предоплата", если вносится только часть стоимости), а после выполнения
+// работ - чеком полного расчета на всю стоимость заказа с зачетом аванса.
+// Возврат денег оформляется чеком возврата прихода.
+
+// Вид чека
+const (
+	ReceiptIncome       = "income"
+	ReceiptIncomeReturn = "income_return"
+)
+
+// Статусы регистрации чека
+const (
+	ReceiptPending    = "pending"
+	ReceiptRegistered = "registered"
+	ReceiptFailed     = "failed"
+)
+
+// Признак способа расчета (тег 1214)
+const (
+	PaymentMethodFullPrepayment = "full_prepayment"
+	PaymentMethodPrepayment     = "prepayment"
+	PaymentMethodFullPayment    = "full_payment"
+)
+
+// isPrepayment - расчет авансом: предмет расчета - платеж, ставка НДС расчетная
+func isPrepayment(paymentMethod string) bool {
+	return paymentMethod == PaymentMethodFullPrepayment || paymentMethod == PaymentMethodPrepayment
+}
+
+// Форма оплаты: деньги поступили сейчас или засчитывается ранее внесенный аванс
+const (
+	SettlementElectronic    = "electronic"
+	SettlementAdvanceOffset = "advance_offset"
+)
+
+// FiscalProvider - облачная касса. Регистрация может быть асинхронной:
+// тогда Register возвращает ReceiptPending, а итог узнается через Check.
+type FiscalProvider interface {
+	Name() string
+	Register(ctx context.Context, receipt *Receipt) (*FiscalResult, error)
+	Check(ctx context.Context, providerReceiptID string) (*FiscalResult, error)
+}
+
+type FiscalResult struct {
+	ID         string
+	Status     string
+	Attributes *FiscalAttributes
+	Error      string
+}
+
+// FiscalAttributes - фискальные реквизиты зарегистрированного чека
+type FiscalAttributes struct {
+	FNNumber     string    `json:"fn_number"`   // номер фискального накопителя
+	FDNumber     string    `json:"fd_number"`   // номер фискального документа
+	FiscalSign   string    `json:"fiscal_sign"` // фискальный признак документа
+	RegisteredAt time.Time `json:"registered_at"`
+	OFDURL       string    `json:"ofd_url,omitempty"`
+}
+
+type ReceiptItem struct {
+	Name          string  `json:"name"`
+	Quantity      float64 `json:"quantity"`
+	Measure       string  `json:"measure"`
+	Price         int64   `json:"price"`
+	Sum           int64   `json:"sum"`
+	VAT           string  `json:"vat"`
+	PaymentMethod string  `json:"payment_method"`
+	PaymentObject string  `json:"payment_object"`
+	// Скидка по промокоду, уже учтенная в цене позиции
+	Discount  int64  `json:"discount,omitempty"`
+	PromoCode string `json:"promo_code,omitempty"`
+}
+
+type Receipt struct {
+	ID                int64             `json:"id"`
+	OrderID           int64             `json:"order_id"`
+	PaymentID         *int64            `json:"payment_id"`
+	ExternalID        string            `json:"external_id"`
+	Kind              string            `json:"kind"`
+	Settlement        string            `json:"settlement"`
+	Amount            int64             `json:"amount"`
+	AdvanceOffset     int64             `json:"advance_offset"` // зачтено из предоплаты, остаток - постоплата
+	Items             []ReceiptItem     `json:"items"`
+	Customer          string            `json:"customer"`
+	TaxSystem         string            `json:"tax_system"`
+	Status            string            `json:"status"`
+	Provider          string            `json:"provider"`
+	ProviderReceiptID *string           `json:"provider_receipt_id"`
+	Fiscal            *FiscalAttributes `json:"fiscal"`
+	Error             *string           `json:"error"`
+	SentAt            *time.Time        `json:"sent_at"`
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at"`
+}
+
+const fiscalStubName = "stub"
+
+// newFiscalProviderFromEnv выбирает кассу по FISCAL_PROVIDER. Заглушка
+// включается только явно: без настоящей кассы чеки не уходят в ОФД.
+func newFiscalProviderFromEnv() (FiscalProvider, error) {
+	switch provider := os.Getenv("FISCAL_PROVIDER"); provider {
+	case fiscalStubName:
+		return newFiscalStub(), nil
+	case "":
+		return nil, fmt.Errorf("FISCAL_PROVIDER не задан (для разработки - stub)")
+	default:
+		return nil, fmt.Errorf("неизвестный FISCAL_PROVIDER=%s", provider)
+	}
+}
+
+// receiptVAT - ставка НДС из FISCAL_VAT (none, vat0, vat10, vat20).
+// При предоплате ставка указывается расчетная: 10/110 или 20/120.
+func receiptVAT(paymentMethod string) string {
+	vat := envOrDefault("FISCAL_VAT", "none")
+	if isPrepayment(paymentMethod) {
+		switch vat {
+		case "vat10":
+			return "vat110"
+		case "vat20":
+			return "vat120"
+		}
+	}
+	return vat
+}
+
+// receiptLine - позиция заказа до распределения суммы чека
+type receiptLine struct {
+	name     string
+	gross    int64 // стоимость по верхней границе вилки без скидки
+	discount int64 // часть скидки по промокоду, приходящаяся на позицию
+}
+
+// receiptLines - позиции заказа: работы по основному тарифу и каждое
+// дополнение отдельно. Скидка по промокоду делится между позициями
+// пропорционально стоимости.
+func receiptLines(order *Order) []receiptLine {
+	tariff := TARIFFS[order.Category]
+	name := fmt.Sprintf("Устройство стяжки пола, тариф «%s» (%s)", tariff.Name, tariff.Description)
+	if tariff.IsAddon {
+		name = fmt.Sprintf("Дополнительные работы к стяжке пола: «%s» (%s)", tariff.Name, tariff.Description)
+	}
+	quote := orderQuote(order)
+	if quote == nil {
+		return []receiptLine{{name: name}}
+	}
+	area := strconv.FormatFloat(math.Round(quote.Area*100)/100, 'f', -1, 64)
+	base := quoteByPrices(order.Category, quote.Area, orderPrices(order))
+	lines := []receiptLine{{name: fmt.Sprintf("%s, %s м2", name, area), gross: base.Max}}
+	for _, addon := range order.Addons {
+		extra := quoteByPrices(addon.Category, quote.Area, addon.Prices)
+		if extra == nil {
+			continue
+		}
+		addonTariff := TARIFFS[addon.Category]
+		lines = append(lines, receiptLine{
+			name:  fmt.Sprintf("Дополнительные работы: «%s» (%s), %s м2", addonTariff.Name, addonTariff.Description, area),
+			gross: extra.Max,
+		})
+	}
+	gross := make([]int64, len(lines))
+	for i, line := range lines {
+		gross[i] = line.gross
+	}
+	for i, discount := range splitProportionally(quote.Discount, gross) {
+		lines[i].discount = discount
+	}
+	return lines
+}
+
+// splitProportionally делит total пропорционально весам; остаток от
+// округления достается последней доле, так что сумма долей равна total
+func splitProportionally(total int64, weights []int64) []int64 {
+	var sum int64
+	for _, w := range weights {
+		sum += w
+	}
+	parts := make([]int64, len(weights))
+	if sum <= 0 {
+		parts[len(parts)-1] = total
+		return parts
+	}
+	rest := total
+	for i := 0; i < len(weights)-1; i++ {
+		parts[i] = int64(float64(total) * float64(weights[i]) / float64(sum))
+		rest -= parts[i]
+	}
+	parts[len(parts)-1] = rest
+	return parts
+}
+
+// buildReceiptItems раскладывает сумму чека по позициям заказа пропорционально
+// их стоимости со скидкой. Каждая позиция - одна услуга (количество 1, цена
+// равна сумме), поэтому цена × количество всегда сходится с суммой, а сумма
+// позиций - с amount. Скидка по промокоду показывается в позиции в той же доле.
+func buildReceiptItems(order *Order, amount int64, paymentMethod string) []ReceiptItem {
+	lines := receiptLines(order)
+	net := make([]int64, len(lines))
+	var total int64
+	for i, line := range lines {
+		net[i] = line.gross - line.discount
+		total += net[i]
+	}
+	sums := splitProportionally(amount, net)
+	paymentObject := "service"
+	if isPrepayment(paymentMethod) {
+		// При предоплате предмет расчета - платеж
+		paymentObject = "payment"
+	}
+	items := make([]ReceiptItem, 0, len(lines))
+	for i, line := range lines {
+		item := ReceiptItem{
+			Name:          line.name,
+			Quantity:      1,
+			Measure:       "усл",
+			Price:         sums[i],
+			Sum:           sums[i],
+			VAT:           receiptVAT(paymentMethod),
+			PaymentMethod: paymentMethod,
+			PaymentObject: paymentObject,
+		}
+		if line.discount > 0 && total > 0 {
+			item.Discount = int64(math.Round(float64(line.discount) * float64(amount) / float64(total)))
+			item.PromoCode = order.Promo.Code
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+const receiptColumns = `id, order_id, payment_id, external_id, kind, settlement, amount, advance_offset, items, customer, tax_system, status, provider, provider_receipt_id, fiscal, error, sent_at, created_at, updated_at`
+
+func scanReceipt(row interface{ Scan(...interface{}) error }) (*Receipt, error) {
+	var rc Receipt
+	var items string
+	var fiscal, sentAt sql.NullString
+	var createdAt, updatedAt string
+	if err := row.Scan(&rc.ID, &rc.OrderID, &rc.PaymentID, &rc.ExternalID, &rc.Kind, &rc.Settlement, &rc.Amount, &rc.AdvanceOffset, &items, &rc.Customer, &rc.TaxSystem, &rc.Status, &rc.Provider, &rc.ProviderReceiptID, &fiscal, &rc.Error, &sentAt, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(items), &rc.Items)
+	if fiscal.Valid {
+		rc.Fiscal = &FiscalAttributes{}
+		json.Unmarshal([]byte(fiscal.String), rc.Fiscal)
+	}
+	if sentAt.Valid {
+		if t, err := time.Parse("2006-01-02 15:04:05", sentAt.String); err == nil {
+			rc.SentAt = &t
+		}
+	}
+	rc.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAt)
+	rc.UpdatedAt, _ = time.Parse("2006-01-02 15:04:05", updatedAt)
+	return &rc, nil
+}
+
+func (app *App) getReceipt(receiptID int64) (*Receipt, error) {
+	rc, err := scanReceipt(app.db.QueryRow(`SELECT `+receiptColumns+` FROM receipts WHERE id = ?`, receiptID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rc, err
+}
+
+func (app *App) getOrderReceipts(orderID int64) ([]Receipt, error) {
+	rows, err := app.db.Query(`SELECT `+receiptColumns+` FROM receipts WHERE order_id = ? ORDER BY id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	receipts := []Receipt{}
+	for rows.Next() {
+		rc, err := scanReceipt(rows)
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, *rc)
+	}
+	return receipts, nil
+}
+
+// issueReceipt сохраняет чек и регистрирует его. externalID делает выпуск
+// идемпотентным: повторный вызов для того же платежа чек не дублирует.
+func (app *App) issueReceipt(ctx context.Context, order *Order, paymentID *int64, externalID, kind, settlement, paymentMethod string, amount, advance int64) (*Receipt, error) {
+	if amount <= 0 {
+		return nil, nil
+	}
+	customer := ""
+	if order.ClientPhone != nil {
+		customer = *order.ClientPhone
+	}
+	if customer == "" {
+		// Без телефона чек отправляется на адрес из настроек
+		customer = os.Getenv("FISCAL_DEFAULT_EMAIL")
+	}
+	items, _ := json.Marshal(buildReceiptItems(order, amount, paymentMethod))
+	if _, err := app.db.Exec(`INSERT OR IGNORE INTO receipts (order_id, payment_id, external_id, kind, settlement, amount, advance_offset, items, customer, tax_system, provider) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		order.ID, paymentID, externalID, kind, settlement, amount, advance, string(items), customer, envOrDefault("FISCAL_TAX_SYSTEM", "usn_income"), app.fiscal.Name()); err != nil {
+		return nil, err
+	}
+	var receiptID int64
+	if err := app.db.QueryRow(`SELECT id FROM receipts WHERE external_id = ?`, externalID).Scan(&receiptID); err != nil {
+		return nil, err
+	}
+	receipt, err := app.getReceipt(receiptID)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Status == ReceiptRegistered {
+		return receipt, nil
+	}
+	return app.registerReceipt(ctx, receipt)
+}
+
+// registerReceipt отправляет чек в кассу (или спрашивает статус, если чек уже
+// принят) и после регистрации отправляет его клиенту
+func (app *App) registerReceipt(ctx context.Context, receipt *Receipt) (*Receipt, error) {
+	var result *FiscalResult
+	var err error
+	if receipt.Status == ReceiptPending && receipt.ProviderReceiptID != nil {
+		result, err = app.fiscal.Check(ctx, *receipt.ProviderReceiptID)
+	} else {
+		result, err = app.fiscal.Register(ctx, receipt)
+	}
+	if err != nil {
+		result = &FiscalResult{Status: ReceiptFailed, Error: err.Error()}
+	}
+
+	var fiscal, errText interface{}
+	if result.Attributes != nil {
+		data, _ := json.Marshal(result.Attributes)
+		fiscal = string(data)
+	}
+	if result.Error != "" {
+		errText = result.Error
+	}
+	var providerID interface{}
+	if result.ID != "" {
+		providerID = result.ID
+	}
+	if _, err := app.db.Exec(`UPDATE receipts SET status = ?, provider_receipt_id = COALESCE(?, provider_receipt_id), fiscal = COALESCE(?, fiscal), error = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		result.Status, providerID, fiscal, errText, receipt.ID); err != nil {
+		return nil, err
+	}
+	if receipt, err = app.getReceipt(receipt.ID); err != nil {
+		return nil, err
+	}
+	if receipt.Status == ReceiptRegistered && receipt.SentAt == nil {
+		if err := app.sendReceipt(ctx, receipt); err != nil {
+			log.Printf("Не удалось отправить чек %d клиенту: %v", receipt.ID, err)
+		}
+	}
+	return app.getReceipt(receipt.ID)
+}
+
+// receiptText - текст чека для Telegram. Подписи переводятся, позиции и
+// фискальные реквизиты остаются как в чеке.
+func receiptText(receipt *Receipt, locale string) string {
+	title := "Кассовый чек"
+	switch {
+	case receipt.Kind == ReceiptIncomeReturn:
+		title = "Чек возврата"
+	case receipt.Settlement == SettlementAdvanceOffset:
+		title = "Чек полного расчета (зачет предоплаты)"
+	case len(receipt.Items) > 0 && isPrepayment(receipt.Items[0].PaymentMethod):
+		title = "Чек предоплаты"
+	}
+	var b strings.Builder
+	b.WriteString(trf(locale, "%s по заказу №%d\n", tr(locale, title), receipt.OrderID))
+	for _, item := range receipt.Items {
+		fmt.Fprintf(&b, "%s: %g %s × %s ₽ = %s ₽\n", item.Name, item.Quantity, item.Measure, rubles(item.Price), rubles(item.Sum))
+		if item.Discount > 0 {
+			b.WriteString(trf(locale, "  скидка по промокоду %s: %s ₽\n", item.PromoCode, rubles(item.Discount)))
+		}
+	}
+	b.WriteString(trf(locale, "Итого: %s ₽\n", rubles(receipt.Amount)))
+	if receipt.AdvanceOffset > 0 {
+		b.WriteString(trf(locale, "Зачтена предоплата: %s ₽\n", rubles(receipt.AdvanceOffset)))
+		if rest := receipt.Amount - receipt.AdvanceOffset; rest > 0 {
+			b.WriteString(trf(locale, "Постоплата: %s ₽\n", rubles(rest)))
+		}
+	}
+	if receipt.Fiscal != nil {
+		fmt.Fprintf(&b, "ФН %s, ФД %s, ФП %s, %s", receipt.Fiscal.FNNumber, receipt.Fiscal.FDNumber, receipt.Fiscal.FiscalSign, receipt.Fiscal.RegisteredAt.Format("02.01.2006 15:04"))
+		if receipt.Fiscal.OFDURL != "" {
+			b.WriteString(trf(locale, "\nПроверить чек: %s", receipt.Fiscal.OFDURL))
+		}
+	}
+	return b.String()
+}
+
+// sendReceipt отправляет реквизиты чека клиенту в Telegram
+func (app *App) sendReceipt(ctx context.Context, receipt *Receipt) error {
+	if app.telegram == nil {
+		return fmt.Errorf("бот не настроен")
+	}
+	order, err := app.getOrder(receipt.OrderID)
+	if err != nil {
+		return err
+	}
+	if order == nil || order.ClientTelegramID == nil {
+		return fmt.Errorf("не найден клиент заказа %d", receipt.OrderID)
+	}
+	if err := app.telegram.SendMessage(ctx, *order.ClientTelegramID, receiptText(receipt, app.telegramLocale(*order.ClientTelegramID))); err != nil {
+		return err
+	}
+	_, err = app.db.Exec(`UPDATE receipts SET sent_at = CURRENT_TIMESTAMP WHERE id = ?`, receipt.ID)
+	return err
+}
+
+// paymentReceiptMethod - признак расчета для платежа. Предоплата на всю
+// стоимость заказа - "предоплата 100%", на ее часть (ESCROW_PREPAYMENT_PERCENT
+// меньше 100) - "предоплата". Доля считается по сумме самого платежа, чтобы
+// чек возврата получил тот же признак, даже если процент с тех пор изменили.
+func paymentReceiptMethod(order *Order, payment *Payment) string {
+	if payment.Purpose != "prepayment" {
+		return PaymentMethodFullPayment
+	}
+	if payment.Amount < orderPrice(order) {
+		return PaymentMethodPrepayment
+	}
+	return PaymentMethodFullPrepayment
+}
+
+// issuePaymentReceipt - чек на поступивший платеж
+func (app *App) issuePaymentReceipt(ctx context.Context, payment *Payment) error {
+	order, err := app.getOrder(payment.OrderID)
+	if err != nil || order == nil {
+		return err
+	}
+	_, err = app.issueReceipt(ctx, order, &payment.ID, fmt.Sprintf("payment:%d", payment.ID), ReceiptIncome, SettlementElectronic, paymentReceiptMethod(order, payment), payment.Amount, 0)
+	return err
+}
+
+// issueRefundReceipt - чек возврата прихода с тем же признаком расчета, что и у платежа
+func (app *App) issueRefundReceipt(ctx context.Context, payment *Payment, providerRefundID string, amount int64) error {
+	order, err := app.getOrder(payment.OrderID)
+	if err != nil || order == nil {
+		return err
+	}
+	_, err = app.issueReceipt(ctx, order, &payment.ID, "refund:"+providerRefundID, ReceiptIncomeReturn, SettlementElectronic, paymentReceiptMethod(order, payment), amount, 0)
+	return err
+}
+
+// issueSettlementReceipt - чек полного расчета после выполнения работ на всю
+// стоимость заказа: услуга оказана, внесенная предоплата (advance)
+// засчитывается, остаток клиент платит бригадиру напрямую (постоплата).
+// Платежи на остаток через платформу уже оформлены чеками полного расчета и
+// в этот чек не входят.
+func (app *App) issueSettlementReceipt(ctx context.Context, orderID int64, paymentID *int64, advance int64) error {
+	order, err := app.getOrder(orderID)
+	if err != nil || order == nil {
+		return err
+	}
+	var paid int64
+	if err := app.db.QueryRow(`SELECT COALESCE(SUM(amount - refunded_amount), 0) FROM payments WHERE order_id = ? AND purpose <> 'prepayment' AND status = ?`,
+		orderID, PaymentSucceeded).Scan(&paid); err != nil {
+		return err
+	}
+	amount := orderPrice(order) - paid
+	_, err = app.issueReceipt(ctx, order, paymentID, fmt.Sprintf("settlement:%d", orderID), ReceiptIncome, SettlementAdvanceOffset, PaymentMethodFullPayment, amount, min(max(advance, 0), amount))
+	return err
+}
+
+// handleGetOrderReceipts - чеки заказа (клиент, бригадир или администратор)
+func (app *App) handleGetOrderReceipts(w http.ResponseWriter, r *http.Request) {
+	order := app.escrowAccess(w, mux.Vars(r)["orderId"], callerUser(w, r))
+	if order == nil {
+		return
+	}
+	receipts, err := app.getOrderReceipts(order.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"receipts": receipts})
+}
+
+// handleResendReceipt повторно отправляет чек клиенту. Если чек еще не
+// зарегистрирован, сначала повторяется регистрация.
+func (app *App) handleResendReceipt(w http.ResponseWriter, r *http.Request) {
+	receiptID, err := strconv.ParseInt(mux.Vars(r)["receiptId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный receipt ID")
+		return
+	}
+	receipt, err := app.getReceipt(receiptID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if receipt == nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Чек не найден")
+		return
+	}
+	user := callerUser(w, r)
+	order := app.escrowAccess(w, strconv.FormatInt(receipt.OrderID, 10), user)
+	if order == nil {
+		return
+	}
+	if orderParty(order, user) != "client" && !isAdmin(user) {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Чек может запросить только клиент")
+		return
+	}
+
+	if receipt.Status != ReceiptRegistered {
+		if receipt, err = app.registerReceipt(r.Context(), receipt); err != nil {
+			internalError(w, err)
+			return
+		}
+		if receipt.Status != ReceiptRegistered {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]interface{}{"receipt": receipt})
+			return
+		}
+	} else if err := app.sendReceipt(r.Context(), receipt); err != nil {
+		upstreamError(w, err)
+		return
+	}
+	if receipt, err = app.getReceipt(receipt.ID); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"receipt": receipt})
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNewFiscalProviderFromEnv(t *testing.T) {
+	for _, value := range []string{"", "atol-typo"} {
+		t.Setenv("FISCAL_PROVIDER", value)
+		if provider, err := newFiscalProviderFromEnv(); err == nil {
+			t.Errorf("FISCAL_PROVIDER=%q: получили %v, want ошибку", value, provider)
+		}
+	}
+	t.Setenv("FISCAL_PROVIDER", "stub")
+	if _, err := newFiscalProviderFromEnv(); err != nil {
+		t.Errorf("stub: %v", err)
+	}
+}
+
+func TestBuildReceiptItems(t *testing.T) {
+	area := 17.37
+	order := &Order{
+		ID:       7,
+		Category: "comfort",
+		Area:     &area,
+		Addons:   []OrderAddon{{Category: "business", Prices: TARIFFS["business"].PriceRange}},
+		Promo:    &PromoDiscount{Code: "NOV10", Percent: 10},
+	}
+	quote := orderQuote(order)
+	// Полная оплата, предоплата 30% и возврат на произвольную сумму
+	for _, amount := range []int64{quote.Max, quote.Max * 30 / 100, 12345} {
+		items := buildReceiptItems(order, amount, PaymentMethodFullPayment)
+		if len(items) != 2 {
+			t.Fatalf("позиций %d, want 2 (тариф и дополнение)", len(items))
+		}
+		if !strings.Contains(items[1].Name, TARIFFS["business"].Name) {
+			t.Errorf("вторая позиция %q, want дополнение «%s»", items[1].Name, TARIFFS["business"].Name)
+		}
+		var sum, discount int64
+		for _, item := range items {
+			if int64(math.Round(float64(item.Price)*item.Quantity)) != item.Sum {
+				t.Errorf("%q: %d × %g != %d", item.Name, item.Price, item.Quantity, item.Sum)
+			}
+			if item.PromoCode != "NOV10" || item.Discount <= 0 {
+				t.Errorf("%q: скидка %d по %q не указана", item.Name, item.Discount, item.PromoCode)
+			}
+			sum += item.Sum
+			discount += item.Discount
+		}
+		if sum != amount {
+			t.Errorf("сумма позиций %d, want %d", sum, amount)
+		}
+		// Доля скидки - в той же пропорции, что и сумма чека
+		if want := float64(quote.Discount) * float64(amount) / float64(quote.Max); math.Abs(float64(discount)-want) > 1 {
+			t.Errorf("скидка в чеке на %d: %d, want %.0f", amount, discount, want)
+		}
+	}
+
+	// Без площади - одна позиция на всю сумму
+	items := buildReceiptItems(&Order{Category: "econom"}, 5000, PaymentMethodFullPrepayment)
+	if len(items) != 1 || items[0].Sum != 5000 || items[0].Price != 5000 || items[0].PaymentObject != "payment" {
+		t.Errorf("заказ без площади: %+v", items)
+	}
+}
+
+func TestCreateOrderWithAddons(t *testing.T) {
+	a := newTestApp(t)
+	t.Setenv("FISCAL_DEFAULT_EMAIL", "receipts@example.com")
+	testUser(t, a, 111, "client")
+	var resp struct {
+		Order *Order `json:"order"`
+	}
+	code := doJSON(t, "POST", "/api/orders?telegram_id=111", map[string]interface{}{
+		"category": "comfort", "area": 20.5, "addons": []string{"universal"},
+	}, &resp)
+	if code != http.StatusOK {
+		t.Fatalf("создание заказа с дополнением: статус %d", code)
+	}
+	base := quoteOrder("comfort", 20.5)
+	extra := quoteOrder("universal", 20.5)
+	if resp.Order.Quote == nil || resp.Order.Quote.Max != base.Max+extra.Max {
+		t.Errorf("вилка %+v, want max %d", resp.Order.Quote, base.Max+extra.Max)
+	}
+
+	// Чек по оплате заказа раскладывается на тариф и дополнение
+	payment := payOrder(t, resp.Order, 111, nil)
+	if err := a.payments.(*SandboxProvider).Simulate(context.Background(), payment, "success"); err != nil {
+		t.Fatal(err)
+	}
+	receipts, err := a.getOrderReceipts(resp.Order.ID)
+	if err != nil || len(receipts) != 1 || len(receipts[0].Items) != 2 || receipts[0].Status != ReceiptRegistered {
+		t.Fatalf("чеки заказа: %+v, %v", receipts, err)
+	}
+
+	for _, addons := range [][]string{{"econom"}, {"universal", "universal"}} {
+		if code := doJSON(t, "POST", "/api/orders?telegram_id=111", map[string]interface{}{"category": "comfort", "area": 20, "addons": addons}, nil); code != http.StatusBadRequest {
+			t.Errorf("addons %v: статус %d, want 400", addons, code)
+		}
+	}
+	if code := doJSON(t, "POST", "/api/orders?telegram_id=111", map[string]interface{}{"category": "business", "area": 20, "addons": []string{"universal"}}, nil); code != http.StatusBadRequest {
+		t.Errorf("дополнение к дополнению: статус %d, want 400", code)
+	}
+}
+
+func TestPartialPrepaymentReceipts(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	t.Setenv("FISCAL_DEFAULT_EMAIL", "receipts@example.com")
+	t.Setenv("ESCROW_PREPAYMENT_PERCENT", "30")
+	clientID := testUser(t, a, 111, "client")
+	contractorID := testUser(t, a, 333, "contractor")
+	order := testOrder(t, a, clientID, 20)
+	if _, err := a.db.Exec(`UPDATE orders SET contractor_id = ?, status = 'accepted' WHERE id = ?`, contractorID, order.ID); err != nil {
+		t.Fatal(err)
+	}
+	order, _ = a.getOrder(order.ID)
+	escrow, err := a.openEscrow(ctx, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.payments.(*SandboxProvider).Simulate(ctx, escrow.Payment, "success"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.releaseEscrow(ctx, order.ID, "Работы приняты"); err != nil {
+		t.Fatal(err)
+	}
+
+	receipts, err := a.getOrderReceipts(order.ID)
+	if err != nil || len(receipts) != 2 {
+		t.Fatalf("чеки заказа: %+v, %v", receipts, err)
+	}
+	// Предоплата 30% - признак "предоплата", а не "предоплата 100%"
+	prepayment := receipts[0]
+	if prepayment.Amount != escrow.Amount || prepayment.Items[0].PaymentMethod != PaymentMethodPrepayment || prepayment.Items[0].PaymentObject != "payment" {
+		t.Errorf("чек предоплаты: %+v", prepayment)
+	}
+	// Чек полного расчета - на всю стоимость, предоплата зачтена, остаток - постоплата
+	settlement := receipts[1]
+	if want := orderQuote(order).Max; settlement.Amount != want || settlement.AdvanceOffset != escrow.Amount || settlement.Settlement != SettlementAdvanceOffset {
+		t.Errorf("чек полного расчета: сумма %d, зачет %d, want %d и %d", settlement.Amount, settlement.AdvanceOffset, want, escrow.Amount)
+	}
+	if text := receiptText(&settlement, "ru"); !strings.Contains(text, "Постоплата: "+rubles(settlement.Amount-escrow.Amount)) {
+		t.Errorf("в тексте чека нет постоплаты:\n%s", text)
+	}
+}
@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"regexp"
+)
+
+// Region - город или регион со своими ценами. Цена тарифа берется из явного
+// переопределения (Prices), иначе национальная цена умножается на Multiplier.
+type Region struct {
+	Code       string                `json:"code"`
+	Name       string                `json:"name"`
+	Multiplier float64               `json:"multiplier"`
+	Prices     map[string]PriceRange `json:"prices,omitempty"`
+	IsActive   bool                  `json:"is_active"`
+}
+
+var regionCodePattern = regexp.MustCompile(`^[a-z0-9-]{2,32}$`)
+
+// priceRange - цена за м² по тарифу в регионе. Для nil - национальная цена.
+// Цены с коэффициентом округляются до 10 ₽.
+func (r *Region) priceRange(category string) PriceRange {
+	base := TARIFFS[category].PriceRange
+	if r == nil {
+		return base
+	}
+	if prices, ok := r.Prices[category]; ok {
+		return prices
+	}
+	scale := func(price int) int {
+		return int(math.Round(float64(price)*r.Multiplier/10)) * 10
+	}
+	return PriceRange{Min: scale(base.Min), Max: scale(base.Max)}
+}
+
+// localizedTariffs - тарифы с ценами региона и текстами на языке locale
+func localizedTariffs(region *Region, locale string) map[string]Tariff {
+	tariffs := make(map[string]Tariff, len(TARIFFS))
+	for key, tariff := range TARIFFS {
+		tariff = translateTariff(locale, tariff)
+		tariff.PriceRange = region.priceRange(key)
+		tariffs[key] = tariff
+	}
+	return tariffs
+}
+
+func (app *App) loadRegionPrices(region *Region) error {
+	rows, err := app.db.Query(`SELECT category, price_min, price_max FROM region_prices WHERE region_code = ?`, region.Code)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	region.Prices = map[string]PriceRange{}
+	for rows.Next() {
+		var category string
+		var prices PriceRange
+		if err := rows.Scan(&category, &prices.Min, &prices.Max); err != nil {
+			return err
+		}
+		region.Prices[category] = prices
+	}
+	return nil
+}
+
+func (app *App) getRegion(code string) (*Region, error) {
+	var region Region
+	err := app.db.QueryRow(`SELECT code, name, multiplier, is_active FROM regions WHERE code = ?`, code).Scan(&region.Code, &region.Name, &region.Multiplier, &region.IsActive)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := app.loadRegionPrices(&region); err != nil {
+		return nil, err
+	}
+	return &region, nil
+}
+
+// resolveRegion проверяет код региона из запроса. Пустой код - национальные цены.
+func (app *App) resolveRegion(code *string) (*Region, error) {
+	if code == nil || *code == "" {
+		return nil, nil
+	}
+	region, err := app.getRegion(*code)
+	if err != nil {
+		return nil, err
+	}
+	if region == nil || !region.IsActive {
+		return nil, newUserError("неизвестный регион: %s", *code)
+	}
+	return region, nil
+}
+
+func (app *App) getRegions(activeOnly bool) ([]Region, error) {
+	rows, err := app.db.Query(`SELECT code, name, multiplier, is_active FROM regions WHERE is_active = 1 OR ? = 0 ORDER BY name`, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	regions := []Region{}
+	for rows.Next() {
+		var region Region
+		if err := rows.Scan(&region.Code, &region.Name, &region.Multiplier, &region.IsActive); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		regions = append(regions, region)
+	}
+	rows.Close()
+	for i := range regions {
+		if err := app.loadRegionPrices(&regions[i]); err != nil {
+			return nil, err
+		}
+	}
+	return regions, nil
+}
+
+func (app *App) handleGetRegions(w http.ResponseWriter, r *http.Request) {
+	regions, err := app.getRegions(true)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"regions": regions})
+}
+
+// handleSaveRegion создает или изменяет регион (только администратор).
+// prices заменяет все переопределения цен региона.
+func (app *App) handleSaveRegion(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code       string                `json:"code"`
+		Name       string                `json:"name"`
+		Multiplier *float64              `json:"multiplier"`
+		Prices     map[string]PriceRange `json:"prices"`
+		IsActive   *bool                 `json:"is_active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	if !regionCodePattern.MatchString(req.Code) || req.Name == "" {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Укажите код региона (латиница, цифры, дефис) и название")
+		return
+	}
+	multiplier := 1.0
+	if req.Multiplier != nil {
+		multiplier = *req.Multiplier
+	}
+	if multiplier <= 0 || multiplier > 10 {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Коэффициент должен быть больше 0 и не больше 10")
+		return
+	}
+	for category, prices := range req.Prices {
+		if _, ok := TARIFFS[category]; !ok {
+			writeError(w, http.StatusBadRequest, CodeValidation, trf(responseLocale(w), "Неизвестный тариф: %s", category))
+			return
+		}
+		if prices.Min <= 0 || prices.Max < prices.Min {
+			writeError(w, http.StatusBadRequest, CodeValidation, trf(responseLocale(w), "Неверная цена тарифа %s", category))
+			return
+		}
+	}
+	isActive := req.IsActive == nil || *req.IsActive
+
+	if _, err := app.db.Exec(`INSERT INTO regions (code, name, multiplier, is_active) VALUES (?, ?, ?, ?)
+		ON CONFLICT(code) DO UPDATE SET name = excluded.name, multiplier = excluded.multiplier, is_active = excluded.is_active`,
+		req.Code, req.Name, multiplier, isActive); err != nil {
+		internalError(w, err)
+		return
+	}
+	if req.Prices != nil {
+		if _, err := app.db.Exec(`DELETE FROM region_prices WHERE region_code = ?`, req.Code); err != nil {
+			internalError(w, err)
+			return
+		}
+		for category, prices := range req.Prices {
+			if _, err := app.db.Exec(`INSERT INTO region_prices (region_code, category, price_min, price_max) VALUES (?, ?, ?, ?)`, req.Code, category, prices.Min, prices.Max); err != nil {
+				internalError(w, err)
+				return
+			}
+		}
+	}
+	region, err := app.getRegion(req.Code)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	app.audit(r.Context(), "region.save", "region", 0, nil, req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"region": region})
+}
+
+// regionFilter - условие "заказ или бригадир в регионе". Записи без региона
+// видны во всех регионах: их создали до появления регионов.
+func regionFilter(column string, region *string) (string, []interface{}) {
+	if region == nil || *region == "" {
+		return "", nil
+	}
+	return " AND (" + column + " = ? OR " + column + " IS NULL)", []interface{}{*region}
+}
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Один пользователь Telegram может быть и клиентом, и бригадиром: выданные роли
+// хранятся в user_roles, профиль бригадира - отдельно в contractor_profiles.
+// Активная роль выбирается на сессию: Mini App передает ее в заголовке
+// X-Active-Role с каждым запросом, поэтому два устройства пользователя могут
+// работать в разных ролях. users.role - роль по умолчанию для запросов без
+// заголовка. Проверки в обработчиках смотрят на активную роль (User.Role).
+
+const activeRoleHeader = "X-Active-Role"
+
+var (
+	errRoleBusy    = errors.New("в текущей роли есть незавершенные заказы")
+	errInvalidRole = errors.New("роль должна быть client или contractor")
+)
+
+// roleBusyError - errRoleBusy с числом заказов, которые держат пользователя в роли
+type roleBusyError struct {
+	orders int
+}
+
+func (e *roleBusyError) Error() string {
+	return fmt.Sprintf("%v (%d)", errRoleBusy, e.orders)
+}
+
+func (e *roleBusyError) Unwrap() error {
+	return errRoleBusy
+}
+
+func validRole(role string) bool {
+	return role == "client" || role == "contractor"
+}
+
+// hasRole - выдана ли пользователю роль
+func (u *User) hasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *App) grantRole(userID int64, role string) error {
+	_, err := app.db.Exec(`INSERT OR IGNORE INTO user_roles (user_id, role) VALUES (?, ?)`, userID, role)
+	return err
+}
+
+// addRole выдает роль и делает ее активной в текущем запросе, не меняя роль по умолчанию
+func (app *App) addRole(user *User, role string) error {
+	if err := app.grantRole(user.ID, role); err != nil {
+		return err
+	}
+	user.Role = role
+	if !user.hasRole(role) {
+		user.Roles = append(user.Roles, role)
+	}
+	return nil
+}
+
+// applyActiveRole делает активной роль сессии из заголовка X-Active-Role. Роль
+// должна быть уже выдана пользователю. Если ответ с ошибкой уже отправлен,
+// возвращает false.
+func applyActiveRole(w http.ResponseWriter, r *http.Request, user *User) bool {
+	role := r.Header.Get(activeRoleHeader)
+	if role == "" || user == nil {
+		return true
+	}
+	switch {
+	case !validRole(role):
+		writeError(w, http.StatusBadRequest, CodeInvalidRole, "роль должна быть client или contractor")
+		return false
+	case !user.hasRole(role):
+		writeError(w, http.StatusForbidden, CodeForbidden, "Роль не выдана пользователю")
+		return false
+	}
+	user.Role = role
+	return true
+}
+
+// activeRoleOrders - сколько незавершенных заказов держат пользователя в роли:
+// у клиента - ожидающие и принятые заказы, у бригадира - принятые заказы и
+// предложения по открытым торгам.
+func (app *App) activeRoleOrders(user *User, role string) (int, error) {
+	var count int
+	var err error
+	switch role {
+	case "client":
+		err = app.db.QueryRow(`SELECT COUNT(*) FROM orders WHERE client_id = ? AND status IN ('pending', 'accepted')`, user.ID).Scan(&count)
+	case "contractor":
+		err = app.db.QueryRow(`SELECT (SELECT COUNT(*) FROM orders WHERE contractor_id = ? AND status = 'accepted')
+			+ (SELECT COUNT(*) FROM order_bids b JOIN orders o ON o.id = b.order_id WHERE b.contractor_id = ? AND b.status = 'active' AND o.status = 'pending')`,
+			user.ID, user.ID).Scan(&count)
+	}
+	return count, err
+}
+
+// setActiveRole делает роль ролью по умолчанию и активной в текущем запросе,
+// выдавая ее, если ее еще нет. Без force нельзя сменить роль по умолчанию, пока
+// в ней есть незавершенные заказы: иначе обработчики перестанут пускать к этим
+// заказам сессии, которые не передают X-Active-Role.
+func (app *App) setActiveRole(user *User, role string, force bool) error {
+	if !validRole(role) {
+		return errInvalidRole
+	}
+	if user.defaultRole == role {
+		return app.addRole(user, role)
+	}
+	if !force {
+		count, err := app.activeRoleOrders(user, user.defaultRole)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return &roleBusyError{orders: count}
+		}
+	}
+	if err := app.addRole(user, role); err != nil {
+		return err
+	}
+	if _, err := app.db.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, user.ID); err != nil {
+		return err
+	}
+	user.defaultRole = role
+	return nil
+}
+
+// roleError отвечает на ошибку смены роли
+func roleError(w http.ResponseWriter, err error) {
+	var busy *roleBusyError
+	switch {
+	case errors.As(err, &busy):
+		writeErrorDetails(w, http.StatusConflict, CodeRoleBusy, trf(responseLocale(w), "Нельзя сменить роль: в текущей роли есть незавершенные заказы (%d)", busy.orders),
+			map[string]interface{}{"active_orders": busy.orders})
+	case errors.Is(err, errInvalidRole):
+		writeError(w, http.StatusBadRequest, CodeInvalidRole, "роль должна быть client или contractor")
+	default:
+		internalError(w, err)
+	}
+}
+
+// handleSwitchRole - смена роли по умолчанию {role}. Новая роль выдается
+// автоматически; чтобы сменить роль только в своей сессии, Mini App передает
+// ее в X-Active-Role.
+func (app *App) handleSwitchRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	user := callerUser(w, r)
+	if user == nil {
+		return
+	}
+	if err := app.setActiveRole(user, req.Role, false); err != nil {
+		roleError(w, err)
+		return
+	}
+	profile, err := app.getContractorProfile(user.ID)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": user, "profile": profile})
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestActiveRolePerSession(t *testing.T) {
+	a := newTestApp(t)
+	clientID := testUser(t, a, 111, "client")
+	testOrder(t, a, clientID, 20)
+
+	// Первая сессия выдает себе роль бригадира; у клиента незавершенный заказ,
+	// поэтому роль по умолчанию остается client
+	if code := doJSON(t, "POST", "/api/user/role?telegram_id=111", map[string]string{"role": "contractor"}, nil); code != http.StatusConflict {
+		t.Fatalf("смена роли по умолчанию при незавершенном заказе: статус %d, want 409", code)
+	}
+	if err := a.grantRole(clientID, "contractor"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.db.Exec(`INSERT INTO contractor_profiles (user_id, categories, verification_status) VALUES (?, '[]', ?)`, clientID, VerificationVerified); err != nil {
+		t.Fatal(err)
+	}
+
+	pending := func(header ...string) int {
+		return doJSON(t, "GET", "/api/contractor/pending-orders/111", nil, nil, header...)
+	}
+	if code := pending("X-Active-Role", "contractor"); code != http.StatusOK {
+		t.Errorf("сессия бригадира: статус %d, want 200", code)
+	}
+	// Другая сессия без заголовка работает в роли по умолчанию
+	if code := pending(); code != http.StatusBadRequest {
+		t.Errorf("сессия без X-Active-Role: статус %d, want 400 (клиент)", code)
+	}
+	if code := pending("X-Active-Role", "client"); code != http.StatusBadRequest {
+		t.Errorf("сессия клиента: статус %d, want 400", code)
+	}
+	if user, _ := a.getUserByTelegramID(111); user.Role != "client" {
+		t.Errorf("роль по умолчанию %s, want client", user.Role)
+	}
+
+	var resp struct {
+		User *User `json:"user"`
+	}
+	if code := doJSON(t, "GET", "/api/user/111", nil, &resp, "X-Active-Role", "contractor"); code != http.StatusOK || resp.User.Role != "contractor" {
+		t.Errorf("профиль в сессии бригадира: статус %d, роль %+v", code, resp.User)
+	}
+
+	testUser(t, a, 222, "client")
+	if code := doJSON(t, "GET", "/api/user/222", nil, nil, "X-Active-Role", "contractor"); code != http.StatusForbidden {
+		t.Errorf("невыданная роль: статус %d, want 403", code)
+	}
+	if code := doJSON(t, "GET", "/api/user/222", nil, nil, "X-Active-Role", "admin"); code != http.StatusBadRequest {
+		t.Errorf("неизвестная роль: статус %d, want 400", code)
+	}
+}
+
+func TestCreateOrderKeepsDefaultRole(t *testing.T) {
+	a := newTestApp(t)
+	contractorID := testUser(t, a, 333, "contractor")
+	clientID := testUser(t, a, 111, "client")
+	order := testOrder(t, a, clientID, 20)
+	if _, err := a.db.Exec(`UPDATE orders SET contractor_id = ?, status = 'accepted' WHERE id = ?`, contractorID, order.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Бригадир с принятым заказом заказывает работы как клиент в этой сессии
+	if code := doJSON(t, "POST", "/api/orders?telegram_id=333", map[string]interface{}{"category": "econom", "area": 15}, nil); code != http.StatusOK {
+		t.Fatalf("заказ от бригадира: статус %d", code)
+	}
+	user, _ := a.getUserByTelegramID(333)
+	if user.Role != "contractor" || !user.hasRole("client") {
+		t.Errorf("после заказа роль по умолчанию %s, роли %v; want contractor и выданную роль client", user.Role, user.Roles)
+	}
+}
@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+)
+
+// Разумные пределы площадей, м²
+const (
+	minRoomArea      = 0.5
+	maxRoomArea      = 500
+	maxOrderArea     = 5000
+	maxWallLength    = 100
+	closureTolerance = 0.05 // допустимое расхождение контура, м
+	angleTolerance   = 1.0  // допустимое расхождение суммы углов, градусы
+)
+
+// Wall - стена многоугольной комнаты: длина в метрах и внутренний угол
+// в градусах между этой стеной и следующей (90 - прямой угол)
+type Wall struct {
+	Length float64 `json:"length"`
+	Angle  float64 `json:"angle"`
+}
+
+// Room - комната заказа: прямоугольник (width × length) или многоугольник по стенам
+type Room struct {
+	ID     int64    `json:"id,omitempty"`
+	Name   *string  `json:"name"`
+	Shape  string   `json:"shape"`
+	Width  *float64 `json:"width,omitempty"`
+	Length *float64 `json:"length,omitempty"`
+	Walls  []Wall   `json:"walls,omitempty"`
+	Area   float64  `json:"area"`
+}
+
+// calculateRoomArea считает площадь комнаты и проверяет размеры
+func calculateRoomArea(room Room) (float64, error) {
+	var area float64
+	switch room.Shape {
+	case "rectangle", "":
+		if room.Width == nil || room.Length == nil {
+			return 0, newUserError("для прямоугольной комнаты нужны ширина и длина")
+		}
+		if *room.Width <= 0 || *room.Length <= 0 || *room.Width > maxWallLength || *room.Length > maxWallLength {
+			return 0, newUserError("размеры комнаты должны быть от 0 до %d м", maxWallLength)
+		}
+		area = *room.Width * *room.Length
+	case "polygon":
+		var err error
+		area, err = polygonArea(room.Walls)
+		if err != nil {
+			return 0, err
+		}
+	default:
+		return 0, newUserError("неизвестная форма комнаты: %s", room.Shape)
+	}
+
+	if area < minRoomArea || area > maxRoomArea {
+		return 0, newUserError("площадь комнаты %.2f м² вне допустимых пределов (%g–%d м²)", area, minRoomArea, maxRoomArea)
+	}
+	return math.Round(area*100) / 100, nil
+}
+
+// polygonArea строит контур обходом стен и считает площадь по формуле Гаусса.
+// Контур должен замыкаться: конец последней стены совпадает с началом первой.
+// Сумма внутренних углов должна быть (n-2)·180°, а стены не должны
+// пересекаться: для самопересекающегося контура формула дает неверную площадь.
+func polygonArea(walls []Wall) (float64, error) {
+	if len(walls) < 3 {
+		return 0, newUserError("у многоугольной комнаты должно быть не меньше 3 стен")
+	}
+
+	points := make([][2]float64, 0, len(walls)+1)
+	var x, y, heading, area, angles float64
+	points = append(points, [2]float64{x, y})
+	for _, wall := range walls {
+		if wall.Length <= 0 || wall.Length > maxWallLength {
+			return 0, newUserError("длина стены должна быть от 0 до %d м", maxWallLength)
+		}
+		if wall.Angle <= 0 || wall.Angle >= 360 {
+			return 0, newUserError("угол между стенами должен быть от 0 до 360 градусов")
+		}
+		nx := x + wall.Length*math.Cos(heading)
+		ny := y + wall.Length*math.Sin(heading)
+		area += x*ny - nx*y
+		x, y = nx, ny
+		points = append(points, [2]float64{x, y})
+		angles += wall.Angle
+		// Поворот на внешний угол
+		heading += (180 - wall.Angle) * math.Pi / 180
+	}
+
+	if math.Hypot(x, y) > closureTolerance {
+		return 0, newUserError("контур комнаты не замыкается (расхождение %.2f м), проверьте длины стен и углы", math.Hypot(x, y))
+	}
+	if want := float64(len(walls)-2) * 180; math.Abs(angles-want) > angleTolerance {
+		return 0, newUserError("сумма углов комнаты %.1f°, а для %d стен должна быть %g°", angles, len(walls), want)
+	}
+	// Контур замкнут с точностью closureTolerance, последняя точка - это первая
+	points[len(points)-1] = points[0]
+	if selfIntersecting(points) {
+		return 0, newUserError("стены комнаты пересекаются, проверьте длины стен и углы")
+	}
+	return math.Abs(area) / 2, nil
+}
+
+// selfIntersecting проверяет, пересекаются ли несмежные стены замкнутого
+// контура points (последняя точка совпадает с первой)
+func selfIntersecting(points [][2]float64) bool {
+	n := len(points) - 1
+	for i := 0; i < n; i++ {
+		for j := i + 2; j < n; j++ {
+			if i == 0 && j == n-1 {
+				// Первая и последняя стены смежные
+				continue
+			}
+			if segmentsIntersect(points[i], points[i+1], points[j], points[j+1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// segmentsIntersect - пересекаются ли отрезки ab и cd (включая касание)
+func segmentsIntersect(a, b, c, d [2]float64) bool {
+	const eps = 1e-9
+	cross := func(o, p, q [2]float64) float64 {
+		return (p[0]-o[0])*(q[1]-o[1]) - (p[1]-o[1])*(q[0]-o[0])
+	}
+	onSegment := func(p, q, r [2]float64) bool {
+		return math.Min(p[0], q[0])-eps <= r[0] && r[0] <= math.Max(p[0], q[0])+eps &&
+			math.Min(p[1], q[1])-eps <= r[1] && r[1] <= math.Max(p[1], q[1])+eps
+	}
+	d1, d2 := cross(c, d, a), cross(c, d, b)
+	d3, d4 := cross(a, b, c), cross(a, b, d)
+	if ((d1 > eps && d2 < -eps) || (d1 < -eps && d2 > eps)) && ((d3 > eps && d4 < -eps) || (d3 < -eps && d4 > eps)) {
+		return true
+	}
+	return (math.Abs(d1) <= eps && onSegment(c, d, a)) || (math.Abs(d2) <= eps && onSegment(c, d, b)) ||
+		(math.Abs(d3) <= eps && onSegment(a, b, c)) || (math.Abs(d4) <= eps && onSegment(a, b, d))
+}
+
+// calculateRoomsArea считает площадь каждой комнаты и общую площадь заказа
+func calculateRoomsArea(rooms []Room) (float64, error) {
+	var total float64
+	for i := range rooms {
+		area, err := calculateRoomArea(rooms[i])
+		if err != nil {
+			return 0, newUserError("комната %d: %s", i+1, err)
+		}
+		rooms[i].Area = area
+		total += area
+	}
+	if err := validateOrderArea(total); err != nil {
+		return 0, err
+	}
+	return math.Round(total*100) / 100, nil
+}
+
+func validateOrderArea(area float64) error {
+	if area <= 0 || area > maxOrderArea {
+		return newUserError("площадь должна быть от 0 до %d м²", maxOrderArea)
+	}
+	return nil
+}
+
+// saveOrderRooms сохраняет комнаты заказа в транзакции его создания
+func saveOrderRooms(tx *sql.Tx, orderID int64, rooms []Room) error {
+	for _, room := range rooms {
+		shape := room.Shape
+		if shape == "" {
+			shape = "rectangle"
+		}
+		var walls *string
+		if len(room.Walls) > 0 {
+			wallsJSON, _ := json.Marshal(room.Walls)
+			w := string(wallsJSON)
+			walls = &w
+		}
+		if _, err := tx.Exec(`INSERT INTO order_rooms (order_id, name, shape, width, length, walls, area) VALUES (?, ?, ?, ?, ?, ?, ?)`, orderID, room.Name, shape, room.Width, room.Length, walls, room.Area); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *App) getOrderRooms(orderID int64) ([]Room, error) {
+	rows, err := app.db.Query(`SELECT id, name, shape, width, length, walls, area FROM order_rooms WHERE order_id = ? ORDER BY id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rooms []Room
+	for rows.Next() {
+		var room Room
+		var walls *string
+		if err := rows.Scan(&room.ID, &room.Name, &room.Shape, &room.Width, &room.Length, &walls, &room.Area); err != nil {
+			return nil, err
+		}
+		if walls != nil {
+			json.Unmarshal([]byte(*walls), &room.Walls)
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, nil
+}
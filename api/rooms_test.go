@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCalculateRoomArea(t *testing.T) {
+	size := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name string
+		room Room
+		area float64
+		err  string // часть текста ошибки, "" - ошибки нет
+	}{
+		{"прямоугольник", Room{Width: size(3), Length: size(4)}, 12, ""},
+		{"без ширины", Room{Shape: "rectangle", Length: size(4)}, 0, "нужны ширина и длина"},
+		{"нулевая стена", Room{Width: size(0), Length: size(4)}, 0, "размеры комнаты"},
+		{"слишком длинная стена", Room{Width: size(101), Length: size(2)}, 0, "размеры комнаты"},
+		{"слишком маленькая", Room{Width: size(0.5), Length: size(0.5)}, 0, "вне допустимых пределов"},
+		{"слишком большая", Room{Width: size(30), Length: size(30)}, 0, "вне допустимых пределов"},
+		{"неизвестная форма", Room{Shape: "circle"}, 0, "неизвестная форма"},
+		{"Г-образная", Room{Shape: "polygon", Walls: []Wall{
+			{4, 90}, {2, 90}, {2, 270}, {1, 90}, {2, 90}, {3, 90},
+		}}, 10, ""},
+		{"треугольник 3-4-5", Room{Shape: "polygon", Walls: []Wall{
+			{3, 90}, {4, 36.8699}, {5, 53.1301},
+		}}, 6, ""},
+		{"две стены", Room{Shape: "polygon", Walls: []Wall{{3, 90}, {3, 90}}}, 0, "не меньше 3 стен"},
+		{"нулевой угол", Room{Shape: "polygon", Walls: []Wall{{3, 0}, {3, 90}, {3, 90}}}, 0, "угол между стенами"},
+		{"длина стены", Room{Shape: "polygon", Walls: []Wall{{3, 90}, {-1, 90}, {3, 90}}}, 0, "длина стены"},
+		{"незамкнутый контур", Room{Shape: "polygon", Walls: []Wall{
+			{4, 90}, {3, 90}, {4, 90}, {2.5, 90},
+		}}, 0, "не замыкается"},
+		// Пентаграмма замыкается, но обходит центр дважды
+		{"пентаграмма", Room{Shape: "polygon", Walls: []Wall{
+			{5, 36}, {5, 36}, {5, 36}, {5, 36}, {5, 36},
+		}}, 0, "сумма углов"},
+		// Сумма углов верная, но стены пересекаются
+		{"самопересечение", Room{Shape: "polygon", Walls: []Wall{
+			{5, 349.6952}, {math.Sqrt(20), 63.4349}, {1, 90}, {3, 33.6901}, {math.Sqrt(13), 3.1798},
+		}}, 0, "пересекаются"},
+	}
+	for _, tt := range tests {
+		area, err := calculateRoomArea(tt.room)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: ошибка %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || math.Abs(area-tt.area) > 0.01 {
+			t.Errorf("%s: площадь %g, ошибка %v; want %g", tt.name, area, err, tt.area)
+		}
+	}
+}
+
+func TestCalculateRoomsArea(t *testing.T) {
+	size := func(v float64) *float64 { return &v }
+	rooms := []Room{{Width: size(3), Length: size(4)}, {Width: size(2), Length: size(2.5)}}
+	total, err := calculateRoomsArea(rooms)
+	if err != nil || total != 17 || rooms[0].Area != 12 || rooms[1].Area != 5 {
+		t.Errorf("площадь %g (%g, %g), ошибка %v; want 17 (12, 5)", total, rooms[0].Area, rooms[1].Area, err)
+	}
+	_, err = calculateRoomsArea([]Room{{Width: size(3), Length: size(4)}, {Width: size(3)}})
+	if err == nil || !strings.HasPrefix(err.Error(), "комната 2: ") {
+		t.Errorf("ошибка во второй комнате: %v", err)
+	}
+	// 11 комнат по 500 м² больше предела заказа
+	var big []Room
+	for range 11 {
+		big = append(big, Room{Width: size(20), Length: size(25)})
+	}
+	if _, err := calculateRoomsArea(big); err == nil || !strings.Contains(err.Error(), "площадь должна быть") {
+		t.Errorf("заказ больше %d м²: %v", maxOrderArea, err)
+	}
+}
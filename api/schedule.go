@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Тариф, сроки которого используются для надбавок (армирование, утепление),
+// если базовый тариф заказа неизвестен
+const defaultBaseTariff = "comfort"
+
+// DayRange - диапазон календарных дней
+type DayRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// TariffDuration - структурированные сроки работ по тарифу
+type TariffDuration struct {
+	// Рабочих дней бригады на 1 м² (0.01 = 100 м² в день)
+	WorkDaysPerM2 float64 `json:"workDaysPerM2"`
+	// Набор прочности / высыхание после окончания работ
+	CuringDays DayRange `json:"curingDays"`
+	// Через сколько дней после окончания работ можно укладывать плитку
+	TileReadyDays DayRange `json:"tileReadyDays"`
+	// Через сколько дней после окончания работ можно укладывать ламинат
+	LaminateReadyDays DayRange `json:"laminateReadyDays"`
+	// Надбавка: сроки как у базового тарифа
+	SameAsBase bool `json:"sameAsBase,omitempty"`
+}
+
+// OrderSchedule - расчетный график заказа
+type OrderSchedule struct {
+	StartDate         time.Time `json:"start_date"`
+	WorkDays          int       `json:"work_days"`
+	WorkEndDate       time.Time `json:"work_end_date"`
+	CompletionDate    time.Time `json:"completion_date"`
+	TileReadyDate     time.Time `json:"tile_ready_date"`
+	LaminateReadyDate time.Time `json:"laminate_ready_date"`
+}
+
+func init() {
+	// Текстовое описание сроков генерируется из структурированных данных
+	for key, tariff := range TARIFFS {
+		tariff.Days = tariff.Duration.Text()
+		TARIFFS[key] = tariff
+	}
+}
+
+func (r DayRange) Text() string {
+	return r.TextIn(defaultLocale)
+}
+
+func (r DayRange) TextIn(locale string) string {
+	if r.Min == r.Max {
+		return fmt.Sprintf("%d %s", r.Max, pluralDaysIn(locale, r.Max))
+	}
+	return fmt.Sprintf("%d-%d %s", r.Min, r.Max, pluralDaysIn(locale, r.Max))
+}
+
+// Text возвращает описание сроков в формате, который показывается клиенту,
+// например "5-7 дней (плитка — 2 дня, ламинат — 14-20 дней)"
+func (d TariffDuration) Text() string {
+	return d.TextIn(defaultLocale)
+}
+
+func (d TariffDuration) TextIn(locale string) string {
+	if d.SameAsBase {
+		return tr(locale, "Как у базового тарифа")
+	}
+	text := d.CuringDays.TextIn(locale)
+	if d.TileReadyDays != d.CuringDays || d.LaminateReadyDays != d.CuringDays {
+		text += trf(locale, " (плитка — %s, ламинат — %s)", d.TileReadyDays.TextIn(locale), d.LaminateReadyDays.TextIn(locale))
+	}
+	return text
+}
+
+func pluralDays(n int) string {
+	if n%10 == 1 && n%100 != 11 {
+		return "день"
+	}
+	if n%10 >= 2 && n%10 <= 4 && (n%100 < 10 || n%100 >= 20) {
+		return "дня"
+	}
+	return "дней"
+}
+
+// computeSchedule считает график работ по тарифу и площади.
+// Для сроков берется верхняя граница диапазонов, чтобы не обещать клиенту лишнего.
+func computeSchedule(category string, area float64, start time.Time) (*OrderSchedule, error) {
+	tariff, ok := TARIFFS[category]
+	if !ok {
+		return nil, fmt.Errorf("неизвестный тариф: %s", category)
+	}
+	duration := tariff.Duration
+	if duration.SameAsBase {
+		duration = TARIFFS[defaultBaseTariff].Duration
+	}
+
+	workDays := int(math.Ceil(area * duration.WorkDaysPerM2))
+	if workDays < 1 {
+		workDays = 1
+	}
+
+	workEnd := start.AddDate(0, 0, workDays)
+	return &OrderSchedule{
+		StartDate:         start,
+		WorkDays:          workDays,
+		WorkEndDate:       workEnd,
+		CompletionDate:    workEnd.AddDate(0, 0, duration.CuringDays.Max),
+		TileReadyDate:     workEnd.AddDate(0, 0, duration.TileReadyDays.Max),
+		LaminateReadyDate: workEnd.AddDate(0, 0, duration.LaminateReadyDays.Max),
+	}, nil
+}
+
+// orderSchedule считает график для заказа: от даты принятия, а пока заказ не принят - от даты создания
+func orderSchedule(order *Order) *OrderSchedule {
+	if order.Area == nil || *order.Area <= 0 {
+		return nil
+	}
+	start := order.CreatedAt
+	if order.AcceptedAt != nil {
+		start = *order.AcceptedAt
+	}
+	schedule, err := computeSchedule(order.Category, *order.Area, start)
+	if err != nil {
+		return nil
+	}
+	return schedule
+}
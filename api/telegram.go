@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// TelegramClient - минимальный клиент Telegram Bot API
+type TelegramClient struct {
+	Token   string
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// newTelegramClientFromEnv возвращает nil, если TELEGRAM_BOT_TOKEN не задан
+func newTelegramClientFromEnv() *TelegramClient {
+	token := os.Getenv("TELEGRAM_BOT_TOKEN")
+	if token == "" {
+		return nil
+	}
+	return &TelegramClient{
+		Token:   token,
+		BaseURL: envOrDefault("TELEGRAM_API_URL", "https://api.telegram.org"),
+		HTTP:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	Description string          `json:"description"`
+}
+
+type TelegramPhotoSize struct {
+	FileID   string `json:"file_id"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	FileSize int    `json:"file_size"`
+}
+
+type TelegramFile struct {
+	FileID   string `json:"file_id"`
+	FilePath string `json:"file_path"`
+}
+
+// call вызывает метод Bot API и раскладывает result в out
+func (c *TelegramClient) call(ctx context.Context, method string, params interface{}, out interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/bot%s/%s", c.BaseURL, c.Token, method), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result telegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	if !result.OK {
+		return fmt.Errorf("telegram %s: %s", method, result.Description)
+	}
+	if out != nil {
+		return json.Unmarshal(result.Result, out)
+	}
+	return nil
+}
+
+// GetUserProfilePhotos возвращает размеры последней фотографии профиля (пусто, если фото нет)
+func (c *TelegramClient) GetUserProfilePhotos(ctx context.Context, userID int64) ([]TelegramPhotoSize, error) {
+	var result struct {
+		TotalCount int                   `json:"total_count"`
+		Photos     [][]TelegramPhotoSize `json:"photos"`
+	}
+	if err := c.call(ctx, "getUserProfilePhotos", map[string]interface{}{"user_id": userID, "limit": 1}, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Photos) == 0 {
+		return nil, nil
+	}
+	return result.Photos[0], nil
+}
+
+func (c *TelegramClient) GetFile(ctx context.Context, fileID string) (*TelegramFile, error) {
+	var file TelegramFile
+	if err := c.call(ctx, "getFile", map[string]string{"file_id": fileID}, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// DownloadFile скачивает файл по file_path из GetFile
+func (c *TelegramClient) DownloadFile(ctx context.Context, filePath string, maxSize int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/file/bot%s/%s", c.BaseURL, c.Token, (&url.URL{Path: filePath}).EscapedPath()), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("telegram: не удалось скачать файл: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("telegram: файл больше %d байт", maxSize)
+	}
+	return data, nil
+}
+
+// SendMessage отправляет текстовое сообщение пользователю
+func (c *TelegramClient) SendMessage(ctx context.Context, chatID int64, text string) error {
+	return c.call(ctx, "sendMessage", map[string]interface{}{"chat_id": chatID, "text": text}, nil)
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// TelephonyProvider выдает подменные номера: звонок на номер соединяет клиента и бригадира,
+// не раскрывая их настоящие телефоны
+type TelephonyProvider interface {
+	AllocateProxy(ctx context.Context, orderID int64, clientPhone, contractorPhone string) (string, error)
+	ReleaseProxy(ctx context.Context, orderID int64, proxyPhone string) error
+}
+
+// newTelephonyFromEnv: TELEPHONY=fake - локальная заглушка, иначе подменные номера не используются
+func newTelephonyFromEnv() TelephonyProvider {
+	if os.Getenv("TELEPHONY") == "fake" {
+		return &FakeTelephony{active: map[string]int64{}}
+	}
+	return nil
+}
+
+// FakeTelephony - заглушка для локальной разработки: выдает номера из тестового диапазона
+// и только пишет в лог, куда был бы направлен звонок
+type FakeTelephony struct {
+	mu     sync.Mutex
+	next   int
+	active map[string]int64
+}
+
+func (f *FakeTelephony) AllocateProxy(ctx context.Context, orderID int64, clientPhone, contractorPhone string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.next++
+	proxy := fmt.Sprintf("+7 (800) 000-%02d-%02d", f.next/100%100, f.next%100)
+	f.active[proxy] = orderID
+	log.Printf("[telephony] заказ %d: %s соединяет %s и %s", orderID, proxy, clientPhone, contractorPhone)
+	return proxy, nil
+}
+
+func (f *FakeTelephony) ReleaseProxy(ctx context.Context, orderID int64, proxyPhone string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.active, proxyPhone)
+	log.Printf("[telephony] заказ %d: номер %s освобожден", orderID, proxyPhone)
+	return nil
+}
+
+// assignProxyPhone выдает заказу подменный номер, если телефония подключена и у обеих сторон есть телефоны
+func (app *App) assignProxyPhone(ctx context.Context, order *Order) error {
+	if app.telephony == nil || order.ClientPhone == nil || order.ContractorPhone == nil || order.ProxyPhone != nil {
+		return nil
+	}
+	proxy, err := app.telephony.AllocateProxy(ctx, order.ID, *order.ClientPhone, *order.ContractorPhone)
+	if err != nil {
+		return err
+	}
+	_, err = app.db.Exec("UPDATE orders SET proxy_phone = ? WHERE id = ?", proxy, order.ID)
+	return err
+}
+
+// releaseProxyPhone освобождает подменный номер после завершения или отмены заказа
+func (app *App) releaseProxyPhone(ctx context.Context, orderID int64) error {
+	if app.telephony == nil {
+		return nil
+	}
+	var proxy *string
+	if err := app.db.QueryRow("SELECT proxy_phone FROM orders WHERE id = ?", orderID).Scan(&proxy); err != nil || proxy == nil {
+		return err
+	}
+	if err := app.telephony.ReleaseProxy(ctx, orderID, *proxy); err != nil {
+		return err
+	}
+	_, err := app.db.Exec("UPDATE orders SET proxy_phone = NULL WHERE id = ?", orderID)
+	return err
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// /api/v2 - ресурсный API: пользователь определяется по подписи Telegram
+// (см. authenticate), а не по пути, статусы заказа меняются через PATCH. Обработчики v1 и v2 работают на одних функциях (updateUserFields,
+// listOrders, acceptOrderAs, completeOrderAs, cancelOrderAs), поэтому правила
+// доступа и побочные эффекты у версий общие.
+//
+// Маршруты v1 с заменой в v2 помечены в apiOperations полем successor: они
+// продолжают работать, но отвечают заголовками Deprecation и Link.
+
+// Статусы, в которые заказ переводится через PATCH /api/v2/orders/{orderId}
+var orderTransitions = []string{"accepted", "completed", "cancelled"}
+
+// registerV2Routes подключает подроутер /api/v2
+func (app *App) registerV2Routes(api *mux.Router) {
+	v2 := api.PathPrefix("/v2").Subrouter()
+	v2.HandleFunc("/me", app.handleV2GetMe).Methods("GET")
+	v2.HandleFunc("/me", app.handleV2UpdateMe).Methods("PATCH")
+	v2.HandleFunc("/orders", app.handleV2ListOrders).Methods("GET")
+	v2.HandleFunc("/orders", app.handleCreateOrder).Methods("POST")
+	v2.HandleFunc("/orders/{orderId}", app.handleV2GetOrder).Methods("GET")
+	v2.HandleFunc("/orders/{orderId}", app.handleV2UpdateOrder).Methods("PATCH")
+}
+
+// deprecateV1 - middleware /api: маршрут v1 с заменой в v2 отвечает заголовками
+// Deprecation и Link на новый адрес (rel="successor-version")
+func deprecateV1(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			template, _ := route.GetPathTemplate()
+			_, index := apiOperations()
+			if op := index[r.Method+" "+template]; op != nil && op.successor != "" {
+				w.Header().Set("Deprecation", "true")
+				w.Header().Set("Link", "<"+successorURL(op.successor, mux.Vars(r))+`>; rel="successor-version"`)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// successorURL подставляет параметры пути v1 в адрес v2: "PATCH /v2/orders/{orderId}" -> "/api/v2/orders/42"
+func successorURL(successor string, vars map[string]string) string {
+	_, path, _ := strings.Cut(successor, " ")
+	for name, value := range vars {
+		path = strings.ReplaceAll(path, "{"+name+"}", value)
+	}
+	return "/api" + path
+}
+
+func (app *App) handleV2GetMe(w http.ResponseWriter, r *http.Request) {
+	user := callerUser(w, r)
+	if user == nil {
+		return
+	}
+	profile, err := app.userProfile(user)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": user, "profile": profile})
+}
+
+// handleV2UpdateMe меняет профиль и активную роль. Регистрация остается в POST /api/user.
+func (app *App) handleV2UpdateMe(w http.ResponseWriter, r *http.Request) {
+	var req userFields
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	user := callerUser(w, r)
+	if user == nil {
+		return
+	}
+	if user = app.updateUserFields(w, user, req); user == nil {
+		return
+	}
+	applyUserLocale(w, r, user)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": user})
+}
+
+// handleV2ListOrders - заказы пользователя (scope=mine, по активной роли) или
+// открытые заказы для бригадира (scope=available); фильтры, сортировка и курсор
+// те же, что у списков v1 (см. orderListParams)
+func (app *App) handleV2ListOrders(w http.ResponseWriter, r *http.Request) {
+	user := callerUser(w, r)
+	if user == nil {
+		return
+	}
+	q, ok := orderListParams(w, r)
+	if !ok {
+		return
+	}
+	var orders []Order
+	var next *string
+	var err error
+	switch r.URL.Query().Get("scope") {
+	case "available":
+		if user.Role != "contractor" {
+			writeError(w, http.StatusBadRequest, CodeNotContractor, "Пользователь не является бригадиром")
+			return
+		}
+		if rejectInactive(w, user) || app.rejectUnverified(w, user) {
+			return
+		}
+		orders, next, err = app.getAllPendingOrders(user.Region, q)
+	default:
+		if user.Role == "contractor" {
+			orders, next, err = app.getContractorOrders(user.ID, q)
+		} else {
+			q.ClientID = user.ID
+			orders, next, err = app.listOrders(q)
+		}
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if orders == nil {
+		orders = []Order{}
+	}
+	redactOrdersContacts(orders, user)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"orders": orders, "next_cursor": next})
+}
+
+// orderAccess - заказ видят его стороны, администраторы и бригадиры, пока заказ открыт
+func (app *App) orderAccess(w http.ResponseWriter, orderIDParam string, user *User) *Order {
+	orderID, err := strconv.ParseInt(orderIDParam, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
+		return nil
+	}
+	order, err := app.getOrder(orderID)
+	if err != nil {
+		internalError(w, err)
+		return nil
+	}
+	if order == nil {
+		writeError(w, http.StatusNotFound, CodeOrderNotFound, "Заказ не найден")
+		return nil
+	}
+	if orderParty(order, user) == "" && !isAdmin(user) && (order.Status != "pending" || user.Role != "contractor") {
+		writeError(w, http.StatusForbidden, CodeOrderAccessDenied, "Нет доступа к заказу")
+		return nil
+	}
+	return order
+}
+
+func (app *App) handleV2GetOrder(w http.ResponseWriter, r *http.Request) {
+	user := callerUser(w, r)
+	if user == nil {
+		return
+	}
+	order := app.orderAccess(w, mux.Vars(r)["orderId"], user)
+	if order == nil {
+		return
+	}
+	redactOrderContacts(order, user)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"order": order})
+}
+
+// handleV2UpdateOrder переводит заказ в новый статус: accepted - бригадир
+// принимает, completed - бригадир завершает, cancelled - сторона заказа отменяет
+func (app *App) handleV2UpdateOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.ParseInt(mux.Vars(r)["orderId"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Неверный order ID")
+		return
+	}
+	var req struct {
+		Status string  `json:"status"`
+		Reason *string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+		return
+	}
+	user := callerUser(w, r)
+	if user == nil {
+		return
+	}
+	var order *Order
+	switch req.Status {
+	case "accepted":
+		order = app.acceptOrderAs(w, r, orderID, user)
+	case "completed":
+		order = app.completeOrderAs(w, r, orderID, user)
+	case "cancelled":
+		// Права на отмену и статус заказа проверяет cancelOrderAs
+		current := app.orderAccess(w, mux.Vars(r)["orderId"], user)
+		if current == nil {
+			return
+		}
+		if !app.cancelOrderAs(w, r, current, user, req.Reason) {
+			return
+		}
+		if order, err = app.getOrder(orderID); err != nil {
+			internalError(w, err)
+			return
+		}
+		redactOrderContacts(order, user)
+	default:
+		writeError(w, http.StatusBadRequest, CodeValidation, "Неизвестный статус заказа")
+		return
+	}
+	if order == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"order": order})
+}
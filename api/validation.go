@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Сколько байт JSON-тела читает validateRequest
+const maxValidatedBodySize = 1 << 20
+
+// FieldError - ошибка в одном поле запроса
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// schemaValidator проверяет значение по Schema и копит ошибки по полям
+type schemaValidator struct {
+	locale string
+	errors []FieldError
+}
+
+func (v *schemaValidator) fail(field, format string, args ...interface{}) {
+	if field == "" {
+		field = "body"
+	}
+	v.errors = append(v.errors, FieldError{Field: field, Message: trf(v.locale, format, args...)})
+}
+
+var (
+	patternCache sync.Map
+	components   = sync.OnceValue(componentSchemas)
+)
+
+func compiledPattern(pattern string) *regexp.Regexp {
+	if re, ok := patternCache.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(pattern)
+	patternCache.Store(pattern, re)
+	return re
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// validate проверяет value (результат json.Decode с UseNumber). null для
+// необязательных полей допустим: обработчики принимают его как "не задано".
+func (v *schemaValidator) validate(field string, schema *Schema, value interface{}) {
+	if schema.Ref != "" {
+		schema = components()[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if schema == nil {
+			return
+		}
+	}
+	if value == nil {
+		return
+	}
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(field, "Ожидается объект")
+			return
+		}
+		for _, name := range schema.Required {
+			if obj[name] == nil {
+				v.fail(joinField(field, name), "Обязательное поле")
+			}
+		}
+		// Поля обходятся по порядку имен, чтобы ошибки приходили в одном порядке
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := schema.Properties[name]; ok {
+				v.validate(joinField(field, name), prop, obj[name])
+			} else if schema.AdditionalProperties != nil {
+				v.validate(joinField(field, name), schema.AdditionalProperties, obj[name])
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			v.fail(field, "Ожидается массив")
+			return
+		}
+		if schema.Items != nil {
+			for i, item := range items {
+				v.validate(fmt.Sprintf("%s[%d]", field, i), schema.Items, item)
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			v.fail(field, "Ожидается строка")
+			return
+		}
+		v.validateString(field, schema, s)
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			v.fail(field, "Ожидается число")
+			return
+		}
+		f, err := n.Float64()
+		if err != nil {
+			v.fail(field, "Ожидается число")
+			return
+		}
+		if schema.Type == "integer" && f != math.Trunc(f) {
+			v.fail(field, "Ожидается целое число")
+			return
+		}
+		v.validateNumber(field, schema, f)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(field, "Ожидается true или false")
+		}
+	}
+}
+
+func (v *schemaValidator) validateString(field string, schema *Schema, s string) {
+	if len(schema.Enum) > 0 {
+		for _, allowed := range schema.Enum {
+			if s == allowed {
+				return
+			}
+		}
+		v.fail(field, "Допустимые значения: %s", strings.Join(schema.Enum, ", "))
+		return
+	}
+	length := len([]rune(s))
+	if schema.MinLength != nil && length < *schema.MinLength {
+		if *schema.MinLength == 1 {
+			v.fail(field, "Поле не может быть пустым")
+		} else {
+			v.fail(field, "Длина должна быть не меньше %d", *schema.MinLength)
+		}
+		return
+	}
+	if schema.MaxLength != nil && length > *schema.MaxLength {
+		v.fail(field, "Длина должна быть не больше %d", *schema.MaxLength)
+		return
+	}
+	if schema.Pattern != "" && !compiledPattern(schema.Pattern).MatchString(s) {
+		if schema.Format == "date" {
+			v.fail(field, "Ожидается дата в формате YYYY-MM-DD")
+		} else {
+			v.fail(field, "Неверный формат")
+		}
+	}
+}
+
+func (v *schemaValidator) validateNumber(field string, schema *Schema, f float64) {
+	switch {
+	case schema.ExclusiveMinimum != nil && f <= *schema.ExclusiveMinimum:
+		v.fail(field, "Должно быть больше %g", *schema.ExclusiveMinimum)
+	case schema.Minimum != nil && f < *schema.Minimum:
+		v.fail(field, "Должно быть не меньше %g", *schema.Minimum)
+	case schema.Maximum != nil && f > *schema.Maximum:
+		v.fail(field, "Должно быть не больше %g", *schema.Maximum)
+	}
+}
+
+// validateParam проверяет параметр пути или строки запроса: строка приводится к типу схемы
+func (v *schemaValidator) validateParam(p apiParam, raw string, present bool) {
+	if !present || raw == "" {
+		if p.required {
+			v.fail(p.name, "Обязательный параметр")
+		}
+		return
+	}
+	switch p.schema.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(raw, 64); err != nil {
+			v.fail(p.name, "Ожидается число")
+			return
+		}
+		v.validate(p.name, p.schema, json.Number(raw))
+	default:
+		v.validate(p.name, p.schema, raw)
+	}
+}
+
+// validationError отвечает 400 со списком ошибок по полям
+func validationError(w http.ResponseWriter, errs []FieldError) {
+	writeErrorDetails(w, http.StatusBadRequest, CodeValidation, "Запрос не прошел проверку",
+		map[string]interface{}{"fields": errs})
+}
+
+// validateRequest - middleware /api: параметры и JSON-тело проверяются по
+// операции из apiOperations. Маршруты без описания пропускаются, multipart
+// проверяют сами обработчики.
+func validateRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, _ := route.GetPathTemplate()
+		_, index := apiOperations()
+		op := index[r.Method+" "+template]
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v := &schemaValidator{locale: responseLocale(w)}
+		vars := mux.Vars(r)
+		for _, p := range op.pathParams() {
+			raw, ok := vars[p.name]
+			v.validateParam(p, raw, ok)
+		}
+		q := r.URL.Query()
+		for _, p := range op.params {
+			v.validateParam(p, q.Get(p.name), q.Has(p.name))
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if op.body != nil && (mediaType == "application/json" || mediaType == "") {
+			data, err := io.ReadAll(io.LimitReader(r.Body, maxValidatedBodySize+1))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, CodeBadRequest, "Не удалось прочитать запрос")
+				return
+			}
+			if len(data) > maxValidatedBodySize {
+				writeError(w, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "Запрос слишком большой")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(data))
+
+			var body interface{} = map[string]interface{}{}
+			if len(bytes.TrimSpace(data)) > 0 {
+				decoder := json.NewDecoder(bytes.NewReader(data))
+				decoder.UseNumber()
+				if err := decoder.Decode(&body); err != nil {
+					writeError(w, http.StatusBadRequest, CodeInvalidJSON, "Неверный формат данных")
+					return
+				}
+			}
+			v.validate("", op.body, body)
+		}
+
+		if len(v.errors) > 0 {
+			validationError(w, v.errors)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -8,6 +8,20 @@ if (tg) {
 // API базовый URL
 const API_URL = window.location.origin;
 
+// Запрос к API с подписанными initData Telegram: по ним сервер определяет пользователя.
+// Приложение работает от имени клиента: роль передается в X-Active-Role и не
+// меняет роль, с которой пользователь работает на других устройствах
+function apiFetch(url, options = {}) {
+  const headers = { ...(options.headers || {}) };
+  if (tg?.initData) {
+    headers['X-Telegram-Init-Data'] = tg.initData;
+  }
+  if (currentUser?.roles?.includes('client')) {
+    headers['X-Active-Role'] = 'client';
+  }
+  return fetch(url, { ...options, headers });
+}
+
 // Состояние приложения
 let currentUser = null;
 let tariffs = {};
@@ -40,12 +54,12 @@ async function initApp() {
     
     // Проверяем, есть ли пользователь в БД
     try {
-      const response = await fetch(`${API_URL}/api/user/${telegramId}`);
+      const response = await apiFetch(`${API_URL}/api/user/${telegramId}`);
       if (response.ok) {
         const data = await response.json();
         currentUser = data.user;
-        if (!currentUser || currentUser.role !== 'client') {
-          // Создаем или обновляем как клиента
+        if (!currentUser || !currentUser.roles?.includes('client')) {
+          // Создаем или выдаем роль клиента
           await saveUserRole('client');
         }
       } else {
@@ -74,14 +88,15 @@ async function saveUserRole(role) {
   }
 
   try {
-    const response = await fetch(`${API_URL}/api/user`, {
+    const response = await apiFetch(`${API_URL}/api/user`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({
         telegram_id: telegramUser.id,
         role: role,
         name: `${telegramUser.first_name} ${telegramUser.last_name || ''}`.trim(),
-        avatar_url: telegramUser.photo_url
+        avatar_url: telegramUser.photo_url,
+        language_code: telegramUser.language_code
       })
     });
 
@@ -97,7 +112,7 @@ async function saveUserRole(role) {
 // Загрузка тарифов
 async function loadTariffs() {
   try {
-    const response = await fetch(`${API_URL}/api/tariffs`);
+    const response = await apiFetch(`${API_URL}/api/tariffs?lang=${tg?.initDataUnsafe?.user?.language_code || ''}`);
     if (response.ok) {
       tariffs = await response.json();
       populateTariffSelect();
@@ -217,6 +232,30 @@ function createMapBackground() {
   mapImage.style.backgroundPosition = 'center';
 }
 
+// POST с Idempotency-Key: при обрыве связи запрос повторяется с тем же ключом,
+// и сервер не создает дубль, а возвращает первый ответ
+async function postIdempotent(url, body, attempts = 3) {
+  const key = window.crypto?.randomUUID ? crypto.randomUUID() : `${Date.now()}-${Math.random().toString(16).slice(2)}`;
+  for (let attempt = 1; ; attempt++) {
+    try {
+      const response = await apiFetch(url, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', 'Idempotency-Key': key },
+        body: JSON.stringify(body)
+      });
+      // 409 - первая попытка еще выполняется
+      if (response.status !== 409 || attempt >= attempts) {
+        return response;
+      }
+    } catch (error) {
+      if (attempt >= attempts) {
+        throw error;
+      }
+    }
+    await new Promise(resolve => setTimeout(resolve, 1000 * attempt));
+  }
+}
+
 // Поиск бригад
 async function searchContractors() {
   const tariff = document.getElementById('tariff-select').value;
@@ -241,15 +280,11 @@ async function searchContractors() {
     const telegramId = telegramUser?.id || currentUser?.telegram_id || 123456789;
 
     // Создаем заказ
-    const orderResponse = await fetch(`${API_URL}/api/orders`, {
-      method: 'POST',
-      headers: { 'Content-Type': 'application/json' },
-      body: JSON.stringify({
-        telegram_id: telegramId,
-        category: tariff,
-        area: parseFloat(area),
-        address: null
-      })
+    const orderResponse = await postIdempotent(`${API_URL}/api/orders`, {
+      telegram_id: telegramId,
+      category: tariff,
+      area: parseFloat(area),
+      address: null
     });
 
     if (!orderResponse.ok) {
@@ -257,7 +292,7 @@ async function searchContractors() {
     }
 
     // Ищем бригадиров
-    const searchResponse = await fetch(`${API_URL}/api/contractors/search?category=${tariff}`);
+    const searchResponse = await apiFetch(`${API_URL}/api/contractors/search?category=${tariff}`);
     
     if (searchResponse.ok) {
       const data = await searchResponse.json();
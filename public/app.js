@@ -8,6 +8,20 @@ if (tg) {
 // API базовый URL
 const API_URL = window.location.origin;
 
+// Запрос к API с подписанными initData Telegram: по ним сервер определяет пользователя.
+// Активная роль передается в X-Active-Role: она своя у каждой сессии, и смена роли
+// здесь не переключает приложение на других устройствах
+function apiFetch(url, options = {}) {
+  const headers = { ...(options.headers || {}) };
+  if (tg?.initData) {
+    headers['X-Telegram-Init-Data'] = tg.initData;
+  }
+  if (currentRole && currentUser?.roles?.includes(currentRole)) {
+    headers['X-Active-Role'] = currentRole;
+  }
+  return fetch(url, { ...options, headers });
+}
+
 // Состояние приложения
 let currentUser = null;
 let currentRole = null;
@@ -30,7 +44,7 @@ async function initApp() {
     
     // Проверяем, есть ли пользователь в БД
     try {
-      const response = await fetch(`${API_URL}/api/user/${telegramId}`);
+      const response = await apiFetch(`${API_URL}/api/user/${telegramId}`);
       if (response.ok) {
         const data = await response.json();
         currentUser = data.user;
@@ -61,7 +75,7 @@ async function initApp() {
 // Загрузка тарифов
 async function loadTariffs() {
   try {
-    const response = await fetch(`${API_URL}/api/tariffs`);
+    const response = await apiFetch(`${API_URL}/api/tariffs?lang=${tg?.initDataUnsafe?.user?.language_code || ''}`);
     if (response.ok) {
       tariffs = await response.json();
       renderTariffs();
@@ -153,7 +167,10 @@ function setupEventListeners() {
   document.querySelectorAll('.role-btn').forEach(btn => {
     btn.addEventListener('click', async (e) => {
       const role = e.currentTarget.dataset.role;
-      await saveUserRole(role);
+      // Уже выданная роль переключается только в этой сессии, новую выдает сервер
+      if (!currentUser?.roles?.includes(role)) {
+        await saveUserRole(role);
+      }
       showRoleScreen(role);
     });
   });
@@ -213,14 +230,15 @@ async function saveUserRole(role) {
   }
 
   try {
-    const response = await fetch(`${API_URL}/api/user`, {
+    const response = await apiFetch(`${API_URL}/api/user`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({
         telegram_id: telegramUser.id,
         role: role,
         name: `${telegramUser.first_name} ${telegramUser.last_name || ''}`.trim(),
-        avatar_url: telegramUser.photo_url
+        avatar_url: telegramUser.photo_url,
+        language_code: telegramUser.language_code
       })
     });
 
@@ -233,6 +251,30 @@ async function saveUserRole(role) {
   }
 }
 
+// POST с Idempotency-Key: при обрыве связи запрос повторяется с тем же ключом,
+// и сервер не создает дубль, а возвращает первый ответ
+async function postIdempotent(url, body, attempts = 3) {
+  const key = window.crypto?.randomUUID ? crypto.randomUUID() : `${Date.now()}-${Math.random().toString(16).slice(2)}`;
+  for (let attempt = 1; ; attempt++) {
+    try {
+      const response = await apiFetch(url, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', 'Idempotency-Key': key },
+        body: JSON.stringify(body)
+      });
+      // 409 - первая попытка еще выполняется
+      if (response.status !== 409 || attempt >= attempts) {
+        return response;
+      }
+    } catch (error) {
+      if (attempt >= attempts) {
+        throw error;
+      }
+    }
+    await new Promise(resolve => setTimeout(resolve, 1000 * attempt));
+  }
+}
+
 // Поиск бригадира
 async function searchContractor() {
   const tariff = document.getElementById('tariff-select').value;
@@ -260,15 +302,11 @@ async function searchContractor() {
     const telegramId = telegramUser?.id || currentUser?.telegram_id || 123456789;
 
     // Создаем заказ
-    const orderResponse = await fetch(`${API_URL}/api/orders`, {
-      method: 'POST',
-      headers: { 'Content-Type': 'application/json' },
-      body: JSON.stringify({
-        telegram_id: telegramId,
-        category: tariff,
-        area: parseFloat(area),
-        address: address || null
-      })
+    const orderResponse = await postIdempotent(`${API_URL}/api/orders`, {
+      telegram_id: telegramId,
+      category: tariff,
+      area: parseFloat(area),
+      address: address || null
     });
 
     if (!orderResponse.ok) {
@@ -278,7 +316,7 @@ async function searchContractor() {
     // Ищем бригадиров
     await new Promise(resolve => setTimeout(resolve, 2000)); // Имитация поиска
 
-    const searchResponse = await fetch(`${API_URL}/api/contractors/search?category=${tariff}`);
+    const searchResponse = await apiFetch(`${API_URL}/api/contractors/search?category=${tariff}`);
     
     if (searchResponse.ok) {
       const data = await searchResponse.json();
@@ -380,7 +418,7 @@ async function loadContractorData() {
   const telegramId = telegramUser?.id || currentUser?.telegram_id || 123456789;
 
   try {
-    const response = await fetch(`${API_URL}/api/user/${telegramId}`);
+    const response = await apiFetch(`${API_URL}/api/user/${telegramId}`);
     if (response.ok) {
       const data = await response.json();
       if (data.profile) {
@@ -455,7 +493,7 @@ async function saveContractorProfile() {
     const telegramId = telegramUser?.id || currentUser?.telegram_id || 123456789;
 
     // Обновляем пользователя
-    await fetch(`${API_URL}/api/user`, {
+    await apiFetch(`${API_URL}/api/user`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({
@@ -467,7 +505,7 @@ async function saveContractorProfile() {
     });
 
     // Сохраняем профиль
-    const response = await fetch(`${API_URL}/api/contractor/profile`, {
+    const response = await apiFetch(`${API_URL}/api/contractor/profile`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({
@@ -495,7 +533,7 @@ async function saveContractorProfile() {
 // Загрузка заказов бригадира
 async function loadContractorOrders(telegramId) {
   try {
-    const response = await fetch(`${API_URL}/api/contractor/orders/${telegramId}`);
+    const response = await apiFetch(`${API_URL}/api/contractor/orders/${telegramId}`);
     if (response.ok) {
       const data = await response.json();
       renderOrders(data.orders);
@@ -512,7 +550,7 @@ async function loadContractorOrders(telegramId) {
 async function loadPendingOrders(telegramId) {
   try {
     // Упрощенная версия - получаем все pending заказы
-    const response = await fetch(`${API_URL}/api/contractor/pending-orders/${telegramId}`);
+    const response = await apiFetch(`${API_URL}/api/contractor/pending-orders/${telegramId}`);
     if (response.ok) {
       const data = await response.json();
       renderPendingOrders(data.orders || []);
@@ -611,7 +649,7 @@ async function acceptOrder(orderId) {
     const telegramUser = tg?.initDataUnsafe?.user;
     const telegramId = telegramUser?.id || currentUser?.telegram_id || 123456789;
 
-    const response = await fetch(`${API_URL}/api/orders/${orderId}/accept`, {
+    const response = await apiFetch(`${API_URL}/api/orders/${orderId}/accept`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({ telegram_id: telegramId })
@@ -636,9 +674,13 @@ async function rejectOrder(orderId) {
   }
 
   try {
-    const response = await fetch(`${API_URL}/api/orders/${orderId}/reject`, {
+    const telegramUser = tg?.initDataUnsafe?.user;
+    const telegramId = telegramUser?.id || currentUser?.telegram_id || 123456789;
+
+    const response = await apiFetch(`${API_URL}/api/orders/${orderId}/reject`, {
       method: 'POST',
-      headers: { 'Content-Type': 'application/json' }
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ telegram_id: telegramId })
     });
 
     if (response.ok) {
@@ -663,7 +705,7 @@ async function completeOrder(orderId) {
     const telegramUser = tg?.initDataUnsafe?.user;
     const telegramId = telegramUser?.id || currentUser?.telegram_id || 123456789;
 
-    const response = await fetch(`${API_URL}/api/orders/${orderId}/complete`, {
+    const response = await apiFetch(`${API_URL}/api/orders/${orderId}/complete`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({ telegram_id: telegramId })